@@ -0,0 +1,185 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dpeckett/objsync/provider"
+)
+
+// ErrAuditTrailTampered is returned when reading an audit trail whose
+// records have been modified, reordered, or removed.
+var ErrAuditTrailTampered = errors.New("audit trail tampered")
+
+// AuditEvent is the type of a lock transition recorded in an audit trail.
+type AuditEvent string
+
+const (
+	// AuditAcquired is recorded when the lock is acquired.
+	AuditAcquired AuditEvent = "acquired"
+	// AuditReleased is recorded when the lock is released.
+	AuditReleased AuditEvent = "released"
+	// AuditBroken is recorded when the lock is forcibly broken.
+	AuditBroken AuditEvent = "broken"
+)
+
+// AuditRecord is a lock transition recorded in an audit trail.
+type AuditRecord struct {
+	Event AuditEvent `json:"event"`
+	// The owner ID and fencing token of the hold.
+	Owner        string `json:"owner,omitempty"`
+	FencingToken int64  `json:"fencingToken,omitempty"`
+	// The process that made the transition (if recorded).
+	Hostname string    `json:"hostname,omitempty"`
+	PID      int       `json:"pid,omitempty"`
+	At       time.Time `json:"at"`
+	// The hash of this record, chained to the hash of the previous record.
+	Hash string `json:"hash"`
+}
+
+// The current schema version of the audit trail object.
+const auditTrailSchemaVersion = 1
+
+// The JSON content of the audit trail object.
+type auditTrailContent struct {
+	SchemaVersion int           `json:"schemaVersion,omitempty"`
+	Records       []AuditRecord `json:"records,omitempty"`
+}
+
+// WithAuditTrail appends a record of every acquisition, release, and forced
+// unlock of the mutex (with the owner, fencing token, and time) to the audit
+// trail object at the given key. Records are hash chained, so that edits to
+// the history are detected by ReadAuditTrail (the chain can't guard against
+// it being rewritten wholesale, pair it with object versioning or retention
+// where that matters).
+//
+// An acquisition that can't be recorded is rolled back. Audit trails grow
+// without bound, so should be rotated (eg. by a lifecycle rule) where locks
+// are acquired frequently.
+func WithAuditTrail(key string) MutexOption {
+	return func(mu *Mutex) {
+		mu.auditTrailKey = key
+	}
+}
+
+// audit appends a record to the audit trail of the mutex (if enabled).
+func (mu *Mutex) audit(ctx context.Context, event AuditEvent, owner string, fencingToken int64) error {
+	if mu.auditTrailKey == "" {
+		return nil
+	}
+
+	_, err := updateObject(ctx, mu.provider, mu.bucket, mu.auditTrailKey, func(_ string, currentData []byte) ([]byte, error) {
+		content, err := decodeAuditTrailContent(currentData)
+		if err != nil {
+			return nil, err
+		}
+
+		record := AuditRecord{
+			Event:        event,
+			Owner:        owner,
+			FencingToken: fencingToken,
+			At:           mu.now().UTC(),
+		}
+		if !mu.withoutHolder {
+			record.Hostname = currentHolder.Hostname
+			record.PID = currentHolder.PID
+		}
+
+		var prevHash string
+		if n := len(content.Records); n > 0 {
+			prevHash = content.Records[n-1].Hash
+		}
+
+		record.Hash, err = hashAuditRecord(prevHash, record)
+		if err != nil {
+			return nil, err
+		}
+
+		content.Records = append(content.Records, record)
+
+		return json.Marshal(content)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to append to audit trail: %w", err)
+	}
+
+	return nil
+}
+
+// ReadAuditTrail returns the records in the audit trail object at the given
+// key, oldest first. If the hash chain of the records doesn't verify,
+// ErrAuditTrailTampered is returned.
+func ReadAuditTrail(ctx context.Context, p provider.Provider, bucket, key string) ([]AuditRecord, error) {
+	data, err := readObject(ctx, p, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := decodeAuditTrailContent(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var prevHash string
+	for i, record := range content.Records {
+		hash, err := hashAuditRecord(prevHash, record)
+		if err != nil {
+			return nil, err
+		}
+
+		if hash != record.Hash {
+			return nil, fmt.Errorf("%w: record %d", ErrAuditTrailTampered, i)
+		}
+
+		prevHash = hash
+	}
+
+	return content.Records, nil
+}
+
+// hashAuditRecord computes the hash of a record, chained to the hash of the
+// previous record.
+func hashAuditRecord(prevHash string, record AuditRecord) (string, error) {
+	record.Hash = ""
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	h.Write([]byte(prevHash))
+	h.Write(data)
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// decodeAuditTrailContent decodes the audit trail object.
+func decodeAuditTrailContent(data []byte) (*auditTrailContent, error) {
+	var content auditTrailContent
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &content); err != nil {
+			return nil, err
+		}
+	}
+
+	if content.SchemaVersion == 0 {
+		content.SchemaVersion = auditTrailSchemaVersion
+	}
+
+	return &content, nil
+}
@@ -0,0 +1,86 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dpeckett/objsync"
+	"github.com/dpeckett/objsync/provider/s3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMutexAuditTrail(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	key := fmt.Sprintf("test-%d", time.Now().UnixNano())
+	auditKey := key + ".audit"
+
+	ctx := context.Background()
+	p, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	require.NoError(t, err)
+
+	mu := objsync.NewMutex(p, bucket, key+".lock", objsync.WithOwnerID("a"), objsync.WithAuditTrail(auditKey))
+
+	fencingToken, err := mu.Lock(ctx, time.Minute)
+	require.NoError(t, err)
+	require.NoError(t, mu.Unlock(ctx))
+
+	_, err = mu.Lock(ctx, time.Minute)
+	require.NoError(t, err)
+
+	other := objsync.NewMutex(p, bucket, key+".lock", objsync.WithOwnerID("b"), objsync.WithAuditTrail(auditKey))
+	require.NoError(t, other.ForceUnlock(ctx))
+
+	records, err := objsync.ReadAuditTrail(ctx, p, bucket, auditKey)
+	require.NoError(t, err)
+	require.Len(t, records, 4)
+
+	var events []objsync.AuditEvent
+	for _, record := range records {
+		events = append(events, record.Event)
+		require.Equal(t, "a", record.Owner)
+	}
+	require.Equal(t, []objsync.AuditEvent{
+		objsync.AuditAcquired,
+		objsync.AuditReleased,
+		objsync.AuditAcquired,
+		objsync.AuditBroken,
+	}, events)
+	require.Equal(t, fencingToken, records[0].FencingToken)
+	require.Equal(t, fencingToken+1, records[3].FencingToken)
+
+	// Rewriting history is detected.
+	_, err = p.AtomicUpdateObject(ctx, bucket, auditKey, func(_ string, currentData []byte) ([]byte, error) {
+		var content struct {
+			SchemaVersion int              `json:"schemaVersion"`
+			Records       []map[string]any `json:"records"`
+		}
+		if err := json.Unmarshal(currentData, &content); err != nil {
+			return nil, err
+		}
+
+		content.Records[1]["owner"] = "c"
+
+		return json.Marshal(content)
+	})
+	require.NoError(t, err)
+
+	_, err = objsync.ReadAuditTrail(ctx, p, bucket, auditKey)
+	require.ErrorIs(t, err, objsync.ErrAuditTrailTampered)
+}
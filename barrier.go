@@ -0,0 +1,205 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/avast/retry-go/v4"
+	"github.com/dpeckett/objsync/provider"
+	"github.com/google/uuid"
+)
+
+// BarrierOption is a functional option for configuring a barrier.
+type BarrierOption func(*Barrier)
+
+// Barrier is a distributed (cyclic) barrier. Participants block in Wait until
+// the expected number of participants have arrived, at which point they are
+// all released and the barrier resets for the next round.
+type Barrier struct {
+	provider provider.Provider
+	bucket   string
+	key      string
+	id       string
+	parties  int
+	ttl      time.Duration
+}
+
+// The current schema version of the barrier object.
+const barrierSchemaVersion = 1
+
+// The JSON content of the barrier object.
+type barrierContent struct {
+	SchemaVersion int                  `json:"schemaVersion,omitempty"`
+	Parties       int                  `json:"parties,omitempty"`
+	Generation    int64                `json:"generation,omitempty"`
+	Participants  map[string]time.Time `json:"participants,omitempty"`
+}
+
+// NewBarrier creates a new distributed barrier that releases waiters once the
+// given number of parties have arrived. Participants that stop waiting (eg.
+// because they crashed) are forgotten after the ttl.
+func NewBarrier(p provider.Provider, bucket, key string, parties int, ttl time.Duration, opts ...BarrierOption) *Barrier {
+	b := &Barrier{
+		provider: p,
+		bucket:   bucket,
+		key:      key,
+		id:       uuid.New().String(),
+		parties:  parties,
+		ttl:      ttl,
+	}
+
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	return b
+}
+
+// WithBarrierParticipantID sets a stable participant ID for the barrier,
+// instead of a randomly generated one.
+func WithBarrierParticipantID(id string) BarrierOption {
+	return func(b *Barrier) {
+		b.id = id
+	}
+}
+
+// Wait registers this participant with the barrier, and blocks until all the
+// parties have arrived, or ctx is done. If ctx is done before the barrier is
+// released, the participant's registration is withdrawn.
+func (b *Barrier) Wait(ctx context.Context) error {
+	var errReleased = errors.New("released")
+
+	if b.parties <= 0 {
+		return fmt.Errorf("invalid number of parties: %d", b.parties)
+	}
+
+	generation := int64(-1)
+	err := retry.Do(
+		func() error {
+			var released bool
+			var registeredGeneration int64
+			_, err := b.provider.AtomicUpdateObject(ctx, b.bucket, b.key, func(_ string, currentData []byte) ([]byte, error) {
+				released = false
+
+				content, err := b.decode(currentData)
+				if err != nil {
+					return nil, err
+				}
+
+				// The barrier was released since we registered.
+				if generation != -1 && content.Generation != generation {
+					return nil, errReleased
+				}
+				registeredGeneration = content.Generation
+
+				// Register (or refresh the registration of) this participant.
+				content.Participants[b.id] = time.Now().Add(b.ttl).UTC()
+
+				if len(content.Participants) >= content.Parties {
+					content.Generation++
+					content.Participants = nil
+					released = true
+				}
+
+				return json.Marshal(content)
+			})
+			if err != nil {
+				if errors.Is(err, errReleased) {
+					return nil
+				}
+
+				if errors.Is(err, provider.ErrConflict) {
+					return err
+				}
+
+				return retry.Unrecoverable(err)
+			}
+
+			generation = registeredGeneration
+			if released {
+				return nil
+			}
+
+			return fmt.Errorf("waiting for parties to arrive")
+		},
+		retry.Context(ctx),
+		retry.Attempts(0),
+		// Poll often enough to keep our registration from expiring.
+		retry.MaxDelay(b.ttl/3),
+	)
+	if err != nil {
+		if generation != -1 {
+			b.withdraw(context.WithoutCancel(ctx), generation)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// withdraw makes a best effort attempt to remove this participant's
+// registration, if the barrier hasn't been released in the meantime.
+func (b *Barrier) withdraw(ctx context.Context, generation int64) {
+	ctx, cancel := context.WithTimeout(ctx, b.ttl)
+	defer cancel()
+
+	_, _ = updateObject(ctx, b.provider, b.bucket, b.key, func(_ string, currentData []byte) ([]byte, error) {
+		content, err := b.decode(currentData)
+		if err != nil {
+			return nil, err
+		}
+
+		if content.Generation != generation {
+			return nil, errors.New("barrier has already been released")
+		}
+
+		delete(content.Participants, b.id)
+
+		return json.Marshal(content)
+	})
+}
+
+// decode decodes the barrier object, pruning any expired participants.
+func (b *Barrier) decode(data []byte) (*barrierContent, error) {
+	var content barrierContent
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &content); err != nil {
+			return nil, err
+		}
+	}
+
+	if content.SchemaVersion == 0 {
+		content.SchemaVersion = barrierSchemaVersion
+	}
+
+	// The first user of the barrier gets to decide the number of parties.
+	if content.Parties == 0 {
+		content.Parties = b.parties
+	}
+
+	if content.Participants == nil {
+		content.Participants = make(map[string]time.Time)
+	}
+
+	now := time.Now()
+	for id, expires := range content.Participants {
+		if now.After(expires) {
+			delete(content.Participants, id)
+		}
+	}
+
+	return &content, nil
+}
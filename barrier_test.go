@@ -0,0 +1,83 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dpeckett/objsync"
+	"github.com/dpeckett/objsync/provider/s3"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestBarrier(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	ctx := context.Background()
+	p, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	require.NoError(t, err)
+
+	const parties = 3
+
+	t.Run("Phases", func(t *testing.T) {
+		key := fmt.Sprintf("test-%d.barrier", time.Now().UnixNano())
+
+		var arrived [2]int32
+		g, ctx := errgroup.WithContext(ctx)
+		for i := 0; i < parties; i++ {
+			g.Go(func() error {
+				b := objsync.NewBarrier(p, bucket, key, parties, 5*time.Second)
+
+				// The barrier is reused for each phase.
+				for phase := 0; phase < len(arrived); phase++ {
+					atomic.AddInt32(&arrived[phase], 1)
+
+					if err := b.Wait(ctx); err != nil {
+						return fmt.Errorf("wait: %w", err)
+					}
+
+					if n := atomic.LoadInt32(&arrived[phase]); n != parties {
+						return fmt.Errorf("released after only %d parties arrived", n)
+					}
+				}
+
+				return nil
+			})
+		}
+
+		require.NoError(t, g.Wait())
+	})
+
+	t.Run("Cancelled", func(t *testing.T) {
+		key := fmt.Sprintf("test-%d.barrier", time.Now().UnixNano())
+
+		ctx, cancel := context.WithTimeout(ctx, 500*time.Millisecond)
+		defer cancel()
+
+		err := objsync.NewBarrier(p, bucket, key, 2, 5*time.Second).Wait(ctx)
+		require.Error(t, err)
+
+		// The cancelled participant should have been withdrawn.
+		ctx, cancel = context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+
+		err = objsync.NewBarrier(p, bucket, key, 2, 5*time.Second).Wait(ctx)
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,132 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/dpeckett/objsync/provider"
+)
+
+// A record of a lock being forcibly broken.
+type lockBreak struct {
+	// The owner ID and fencing token of the hold that was broken.
+	Owner string `json:"owner,omitempty"`
+	Fence int64  `json:"fence,omitempty"`
+	// The process that broke the lock.
+	By *holder   `json:"by,omitempty"`
+	At time.Time `json:"at"`
+}
+
+// BreakOption is a functional option for configuring BreakLock.
+type BreakOption func(*breakOptions)
+
+type breakOptions struct {
+	signingKey []byte
+}
+
+// WithBreakSigningKey signs the broken lock object with the signing key of
+// the mutexes for the key (see WithSigningKey). Lock objects that are
+// unsigned, or have been tampered with, are broken (and signed) even if they
+// aren't held.
+func WithBreakSigningKey(key []byte) BreakOption {
+	return func(opts *breakOptions) {
+		opts.signingKey = key
+	}
+}
+
+// BreakLock forcibly releases the lock object at the given key, regardless of
+// who holds it, for when a lock has wedged. The fencing token is bumped, so
+// that the broken holder's writes can be rejected, and a record of who broke
+// the lock is left in the lock object.
+func BreakLock(ctx context.Context, p provider.Provider, bucket, key string, opts ...BreakOption) error {
+	var options breakOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	_, err := breakLock(ctx, p, bucket, key, options.signingKey)
+	return err
+}
+
+// breakLock forcibly releases the lock object at the given key, returning a
+// record of the broken hold (or nil if the lock wasn't held).
+func breakLock(ctx context.Context, p provider.Provider, bucket, key string, signingKey []byte) (*lockBreak, error) {
+	var errNotLocked = errors.New("not locked")
+
+	var broken *lockBreak
+	_, err := updateObject(ctx, p, bucket, key, func(_ string, currentData []byte) ([]byte, error) {
+		tampered := verifyMutexContent(signingKey, bucket, key, currentData) != nil
+
+		content, err := decodeMutexContent(currentData)
+		if err != nil {
+			return nil, err
+		}
+
+		broken = nil
+		if content.ID == "" && !tampered {
+			return nil, errNotLocked
+		}
+
+		content.Broken = &lockBreak{
+			Owner: content.ID,
+			Fence: content.Fence,
+			By:    currentHolder,
+			At:    time.Now().UTC(),
+		}
+
+		content.ID = ""
+		content.Expires = nil
+		content.Holder = nil
+		content.Holds = 0
+		content.Lease = nil
+		content.Metadata = nil
+		content.Released = &content.Broken.At
+		content.Fence++
+		broken = content.Broken
+
+		return encodeSignedMutexContent(signingKey, bucket, key, content)
+	})
+	if err != nil {
+		if errors.Is(err, errNotLocked) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return broken, nil
+}
+
+// ForceUnlock forcibly releases the mutex, regardless of who holds it (see
+// BreakLock).
+func (mu *Mutex) ForceUnlock(ctx context.Context) error {
+	mu.stopKeepAlive()
+
+	broken, err := breakLock(ctx, mu.provider, mu.bucket, mu.key, mu.signingKey)
+	if err != nil {
+		return err
+	}
+
+	// Our own hold (if any) has been broken too.
+	if etag, _, _ := mu.holdState(); etag != "" {
+		mu.setETag("")
+		mu.detachLease()
+		mu.released()
+	}
+
+	if broken != nil {
+		return mu.audit(ctx, AuditBroken, broken.Owner, broken.Fence)
+	}
+
+	return nil
+}
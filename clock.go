@@ -0,0 +1,111 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/dpeckett/objsync/provider"
+)
+
+// Clock is a source of the current time.
+type Clock interface {
+	Now() time.Time
+}
+
+// WithClock sets the clock used to compute and check the expiry of locks
+// (eg. to inject a fake clock in tests, or a clock synchronized with the
+// storage provider, see NewProviderClock). Timers (eg. for lock-scoped
+// contexts) are scheduled relative to the clock, on the assumption that it
+// runs at the same rate as the system clock.
+func WithClock(clock Clock) MutexOption {
+	return func(mu *Mutex) {
+		mu.clock = clock
+	}
+}
+
+// WithSkewMargin treats locks as held for the given margin beyond their
+// expiry, so that a lock isn't taken over early from a holder whose clock is
+// behind ours. The margin should exceed the worst expected clock skew
+// between clients.
+func WithSkewMargin(margin time.Duration) MutexOption {
+	return func(mu *Mutex) {
+		mu.skewMargin = margin
+	}
+}
+
+// now returns the current time, according to the mutex's clock.
+func (mu *Mutex) now() time.Time {
+	if mu.clock == nil {
+		return time.Now()
+	}
+
+	return mu.clock.Now()
+}
+
+// until returns the duration until t, according to the mutex's clock.
+func (mu *Mutex) until(t time.Time) time.Duration {
+	return t.Sub(mu.now())
+}
+
+// ProviderClock is a clock synchronized with the storage backend of a
+// provider (see provider.Clock), so that lock expiry is checked against a
+// clock shared by all clients, rather than against each client's own clock.
+type ProviderClock struct {
+	provider provider.Provider
+	mu       sync.Mutex
+	offset   time.Duration
+}
+
+// NewProviderClock creates a clock synchronized with the given provider. If
+// the provider can't report the current time, provider.ErrNotSupported is
+// returned.
+func NewProviderClock(ctx context.Context, p provider.Provider) (*ProviderClock, error) {
+	if !provider.CapabilitiesOf(p).ServerTime {
+		return nil, provider.ErrNotSupported
+	}
+
+	c := &ProviderClock{provider: p}
+	if err := c.Sync(ctx); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Sync synchronizes the clock with the provider again (eg. periodically, to
+// correct for drift of the local clock).
+func (c *ProviderClock) Sync(ctx context.Context) error {
+	start := time.Now()
+	serverNow, err := provider.NowOf(ctx, c.provider)
+	if err != nil {
+		return err
+	}
+
+	// Assume the provider read its clock halfway through the request.
+	offset := serverNow.Sub(start.Add(time.Since(start) / 2))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.offset = offset
+
+	return nil
+}
+
+// Now returns the current time, according to the provider.
+func (c *ProviderClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return time.Now().Add(c.offset)
+}
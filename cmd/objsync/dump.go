@@ -0,0 +1,287 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/dpeckett/objsync"
+	"github.com/dpeckett/objsync/provider"
+)
+
+// The value that redacted fields are replaced with.
+const redacted = "REDACTED"
+
+// The fields holding potentially sensitive strings.
+var sensitiveFields = map[string]bool{
+	"hostname": true,
+	"binary":   true,
+}
+
+// stateDump is a snapshot of the coordination objects under a prefix.
+type stateDump struct {
+	Bucket string    `json:"bucket"`
+	Prefix string    `json:"prefix,omitempty"`
+	Time   time.Time `json:"time"`
+	// Whether sensitive fields were redacted (and so signatures won't
+	// verify).
+	Redacted bool           `json:"redacted,omitempty"`
+	Objects  []dumpedObject `json:"objects"`
+}
+
+// dumpedObject is a single object in a state dump.
+type dumpedObject struct {
+	Key string `json:"key"`
+	// The decoded content of the object (if it's JSON).
+	Content json.RawMessage `json:"content,omitempty"`
+	// The raw content of the object (if it isn't JSON).
+	Data []byte `json:"data,omitempty"`
+}
+
+func dump(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("dump", flag.ExitOnError)
+	openProvider := providerFlags(fs)
+	prefix := fs.String("prefix", "", "Only dump objects whose keys start with the prefix")
+	output := fs.String("o", "-", "File to write the dump to (- for stdout)")
+	redact := fs.Bool("redact", false, "Redact hostnames, binaries, and metadata values")
+	_ = fs.Parse(args)
+
+	p, bucket, err := openProvider(ctx)
+	if err != nil {
+		return err
+	}
+
+	state, err := dumpState(ctx, p, bucket, *prefix, *redact)
+	if err != nil {
+		return err
+	}
+
+	w := os.Stdout
+	if *output != "-" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("failed to create dump file: %w", err)
+		}
+		defer f.Close()
+
+		w = f
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(state); err != nil {
+		return fmt.Errorf("failed to write dump: %w", err)
+	}
+
+	if w != os.Stdout {
+		return w.Close()
+	}
+
+	return nil
+}
+
+func load(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("load", flag.ExitOnError)
+	openProvider := providerFlags(fs)
+	input := fs.String("i", "-", "File to read the dump from (- for stdin)")
+	force := fs.Bool("force", false, "Overwrite existing objects")
+	_ = fs.Parse(args)
+
+	p, bucket, err := openProvider(ctx)
+	if err != nil {
+		return err
+	}
+
+	r := io.Reader(os.Stdin)
+	if *input != "-" {
+		f, err := os.Open(*input)
+		if err != nil {
+			return fmt.Errorf("failed to open dump file: %w", err)
+		}
+		defer f.Close()
+
+		r = f
+	}
+
+	var state stateDump
+	if err := json.NewDecoder(r).Decode(&state); err != nil {
+		return fmt.Errorf("failed to read dump: %w", err)
+	}
+
+	return loadState(ctx, p, bucket, &state, *force, os.Stderr)
+}
+
+// dumpState reads the objects under a prefix.
+func dumpState(ctx context.Context, p provider.Provider, bucket, prefix string, redact bool) (*stateDump, error) {
+	lister, ok := p.(provider.Lister)
+	if !ok {
+		return nil, fmt.Errorf("listing objects: %w", provider.ErrNotSupported)
+	}
+
+	keys, err := lister.ListObjects(ctx, bucket, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects: %w", err)
+	}
+
+	state := &stateDump{
+		Bucket:   bucket,
+		Prefix:   prefix,
+		Time:     time.Now().UTC(),
+		Redacted: redact,
+		Objects:  []dumpedObject{},
+	}
+
+	for _, key := range keys {
+		data, err := objsync.ReadObject(ctx, p, bucket, key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read object %q: %w", key, err)
+		}
+
+		// Deleted in the meantime.
+		if len(data) == 0 {
+			continue
+		}
+
+		obj := dumpedObject{Key: key}
+
+		switch {
+		case !json.Valid(data):
+			obj.Data = data
+		case redact:
+			dec := json.NewDecoder(bytes.NewReader(data))
+			dec.UseNumber()
+
+			var content any
+			if err := dec.Decode(&content); err != nil {
+				return nil, fmt.Errorf("failed to decode object %q: %w", key, err)
+			}
+
+			if obj.Content, err = json.Marshal(redactValue(content)); err != nil {
+				return nil, err
+			}
+		default:
+			obj.Content = data
+		}
+
+		state.Objects = append(state.Objects, obj)
+	}
+
+	return state, nil
+}
+
+// loadState writes the objects of a dump. Overwritten objects keep their
+// current fence if it's ahead of the dumped one (so fencing tokens don't go
+// backwards), with a warning written to w.
+func loadState(ctx context.Context, p provider.Provider, bucket string, state *stateDump, force bool, w io.Writer) error {
+	for _, obj := range state.Objects {
+		data := obj.Data
+		if obj.Content != nil {
+			var buf bytes.Buffer
+			if err := json.Compact(&buf, obj.Content); err != nil {
+				return fmt.Errorf("invalid content for object %q: %w", obj.Key, err)
+			}
+
+			data = buf.Bytes()
+		}
+
+		var keptFence, dumpedFence int64
+		_, err := p.AtomicUpdateObject(ctx, bucket, obj.Key, func(_ string, currentData []byte) ([]byte, error) {
+			keptFence = 0
+
+			if len(currentData) == 0 {
+				return data, nil
+			}
+
+			if !force {
+				return nil, fmt.Errorf("object %q already exists (use -force to overwrite it)", obj.Key)
+			}
+
+			// Don't let the fencing tokens of locks go backwards.
+			currentFence := fenceOf(currentData)
+			dumpedFence = fenceOf(data)
+			if dumpedFence == 0 || currentFence <= dumpedFence {
+				return data, nil
+			}
+
+			keptFence = currentFence
+			return withFence(data, currentFence)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to load object %q: %w", obj.Key, err)
+		}
+
+		if keptFence != 0 {
+			fmt.Fprintf(w, "objsync load: object %q kept its current fence %d (the dump has %d)\n", obj.Key, keptFence, dumpedFence)
+		}
+	}
+
+	return nil
+}
+
+// fenceOf returns the fence recorded in an object (zero if it doesn't have
+// one).
+func fenceOf(data []byte) int64 {
+	var content struct {
+		Fence int64 `json:"fence"`
+	}
+	_ = json.Unmarshal(data, &content)
+
+	return content.Fence
+}
+
+// withFence replaces the fence recorded in an object.
+func withFence(data []byte, fence int64) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	fields["fence"] = json.RawMessage(strconv.FormatInt(fence, 10))
+
+	return json.Marshal(fields)
+}
+
+// redactValue replaces sensitive strings, and metadata values, in decoded
+// JSON.
+func redactValue(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for field, value := range v {
+			switch {
+			case field == "metadata":
+				if metadata, ok := value.(map[string]any); ok {
+					for k := range metadata {
+						metadata[k] = redacted
+					}
+				}
+			case sensitiveFields[field]:
+				if _, ok := value.(string); ok {
+					v[field] = redacted
+				}
+			default:
+				v[field] = redactValue(value)
+			}
+		}
+	case []any:
+		for i, value := range v {
+			v[i] = redactValue(value)
+		}
+	}
+
+	return v
+}
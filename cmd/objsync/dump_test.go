@@ -0,0 +1,120 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/dpeckett/objsync"
+	"github.com/dpeckett/objsync/provider/mem"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDumpState(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+
+	p := mem.NewProvider()
+
+	_, err := objsync.NewMutex(p, "bucket", "coord/lock", objsync.WithOwnerID("a"),
+		objsync.WithMetadata(map[string]string{"job": "secret"})).Lock(ctx, time.Minute)
+	require.NoError(t, err)
+
+	_, err = p.AtomicUpdateObject(ctx, "bucket", "coord/raw", func(_ string, _ []byte) ([]byte, error) {
+		return []byte("not json"), nil
+	})
+	require.NoError(t, err)
+
+	_, err = objsync.NewMutex(p, "bucket", "other/lock").Lock(ctx, time.Minute)
+	require.NoError(t, err)
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		state, err := dumpState(ctx, p, "bucket", "coord/", false)
+		require.NoError(t, err)
+		require.Len(t, state.Objects, 2)
+
+		// The dump survives being written out.
+		data, err := json.Marshal(state)
+		require.NoError(t, err)
+
+		var loaded stateDump
+		require.NoError(t, json.Unmarshal(data, &loaded))
+
+		restored := mem.NewProvider()
+		require.NoError(t, loadState(ctx, restored, "bucket", &loaded, false, io.Discard))
+
+		info, err := objsync.Inspect(ctx, restored, "bucket", "coord/lock")
+		require.NoError(t, err)
+		require.Equal(t, "a", info.Owner)
+		require.Equal(t, "secret", info.Metadata["job"])
+
+		raw, err := objsync.ReadObject(ctx, restored, "bucket", "coord/raw")
+		require.NoError(t, err)
+		require.Equal(t, "not json", string(raw))
+
+		// Existing objects aren't overwritten without force.
+		require.ErrorContains(t, loadState(ctx, restored, "bucket", &loaded, false, io.Discard), "already exists")
+		require.NoError(t, loadState(ctx, restored, "bucket", &loaded, true, io.Discard))
+	})
+
+	t.Run("FenceKept", func(t *testing.T) {
+		state, err := dumpState(ctx, p, "bucket", "coord/", false)
+		require.NoError(t, err)
+
+		restored := mem.NewProvider()
+		require.NoError(t, loadState(ctx, restored, "bucket", state, false, io.Discard))
+
+		// The lock moves on after the dump was taken.
+		mu := objsync.NewMutex(restored, "bucket", "coord/lock", objsync.WithOwnerID("b"))
+		require.NoError(t, mu.ForceUnlock(ctx))
+		fencingToken, err := mu.Lock(ctx, time.Minute)
+		require.NoError(t, err)
+
+		var warnings bytes.Buffer
+		require.NoError(t, loadState(ctx, restored, "bucket", state, true, &warnings))
+		require.Contains(t, warnings.String(), "kept its current fence")
+
+		info, err := objsync.Inspect(ctx, restored, "bucket", "coord/lock")
+		require.NoError(t, err)
+		require.Equal(t, "a", info.Owner)
+		require.Equal(t, fencingToken, info.FencingToken)
+
+		// Locks taken afterwards are still fenced off from the old holder.
+		require.NoError(t, mu.ForceUnlock(ctx))
+		newFencingToken, err := mu.Lock(ctx, time.Minute)
+		require.NoError(t, err)
+		require.Greater(t, newFencingToken, fencingToken)
+	})
+
+	t.Run("Redacted", func(t *testing.T) {
+		state, err := dumpState(ctx, p, "bucket", "coord/", true)
+		require.NoError(t, err)
+		require.True(t, state.Redacted)
+
+		var content struct {
+			ID       string            `json:"id"`
+			Metadata map[string]string `json:"metadata"`
+			Holder   struct {
+				Hostname string `json:"hostname"`
+			} `json:"holder"`
+		}
+		require.Equal(t, "coord/lock", state.Objects[0].Key)
+		require.NoError(t, json.Unmarshal(state.Objects[0].Content, &content))
+
+		require.Equal(t, "a", content.ID)
+		require.Equal(t, redacted, content.Metadata["job"])
+		require.Equal(t, redacted, content.Holder.Hostname)
+	})
+}
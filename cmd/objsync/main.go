@@ -0,0 +1,112 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// objsync is a command line tool for inspecting the coordination objects
+// stored by objsync, eg. for debugging lock contention.
+//
+// The provider is given by the -provider flag (a provider URL, see
+// provider.Open), or otherwise by the environment (see provider.FromEnv).
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/dpeckett/objsync/provider"
+	_ "github.com/dpeckett/objsync/provider/azblob"
+	_ "github.com/dpeckett/objsync/provider/b2"
+	_ "github.com/dpeckett/objsync/provider/dynamodb"
+	_ "github.com/dpeckett/objsync/provider/gcs"
+	_ "github.com/dpeckett/objsync/provider/minio"
+	_ "github.com/dpeckett/objsync/provider/s3"
+)
+
+// A subcommand of the tool.
+type command struct {
+	name  string
+	usage string
+	run   func(ctx context.Context, args []string) error
+}
+
+var commands = []command{
+	{name: "watch", usage: "Stream lock state changes under a prefix", run: watch},
+	{name: "dump", usage: "Write the coordination objects under a prefix to a file", run: dump},
+	{name: "load", usage: "Write the coordination objects from a dump back to storage", run: load},
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	for _, cmd := range commands {
+		if cmd.name != flag.Arg(0) {
+			continue
+		}
+
+		if err := cmd.run(ctx, flag.Args()[1:]); err != nil && ctx.Err() == nil {
+			fmt.Fprintf(os.Stderr, "objsync %s: %v\n", cmd.name, err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "objsync: unknown command %q\n", flag.Arg(0))
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: objsync <command> [flags]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", cmd.name, cmd.usage)
+	}
+}
+
+// providerFlags registers the flags selecting the provider (and bucket).
+func providerFlags(fs *flag.FlagSet) func(ctx context.Context) (provider.Provider, string, error) {
+	providerURL := fs.String("provider", os.Getenv("OBJSYNC_PROVIDER_URL"), "URL of the storage provider, eg. s3://bucket (default from the environment)")
+	bucket := fs.String("bucket", "", "Bucket to use (overrides the bucket of the provider)")
+
+	return func(ctx context.Context) (provider.Provider, string, error) {
+		var p provider.Provider
+		var providerBucket string
+		var err error
+		if *providerURL != "" {
+			p, providerBucket, err = provider.Open(ctx, *providerURL)
+		} else {
+			p, providerBucket, err = provider.FromEnv(ctx)
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to open provider: %w", err)
+		}
+
+		if *bucket != "" {
+			providerBucket = *bucket
+		}
+
+		if providerBucket == "" {
+			return nil, "", fmt.Errorf("no bucket given")
+		}
+
+		return p, providerBucket, nil
+	}
+}
@@ -0,0 +1,283 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/dpeckett/objsync"
+	"github.com/dpeckett/objsync/provider"
+)
+
+// The kinds of lock state changes.
+const (
+	// The lock was already held when watching started.
+	changeHeld     = "held"
+	changeAcquired = "acquired"
+	changeReleased = "released"
+	changeExpired  = "expired"
+	// The lock was acquired by another holder (or reacquired by the same
+	// one) without being seen to be free.
+	changeHolderChanged = "holder_changed"
+)
+
+// lockChange is a change in the state of a lock.
+type lockChange struct {
+	Time          time.Time  `json:"time"`
+	Key           string     `json:"key"`
+	Change        string     `json:"change"`
+	Owner         string     `json:"owner,omitempty"`
+	PreviousOwner string     `json:"previousOwner,omitempty"`
+	FencingToken  int64      `json:"fencingToken,omitempty"`
+	Expires       *time.Time `json:"expires,omitempty"`
+	// The process holding the lock (if recorded).
+	Hostname  string     `json:"hostname,omitempty"`
+	PID       int        `json:"pid,omitempty"`
+	Binary    string     `json:"binary,omitempty"`
+	Version   string     `json:"version,omitempty"`
+	StartTime *time.Time `json:"startTime,omitempty"`
+}
+
+func (c *lockChange) String() string {
+	s := fmt.Sprintf("%s %-14s %s", c.Time.Format(time.RFC3339), c.Change, c.Key)
+	if c.Owner != "" {
+		s += fmt.Sprintf(" owner=%s", c.Owner)
+	}
+	if c.PreviousOwner != "" {
+		s += fmt.Sprintf(" previousOwner=%s", c.PreviousOwner)
+	}
+	if c.FencingToken != 0 {
+		s += fmt.Sprintf(" fencingToken=%d", c.FencingToken)
+	}
+	if c.Expires != nil {
+		s += fmt.Sprintf(" expires=%s", c.Expires.Format(time.RFC3339))
+	}
+	if c.Hostname != "" {
+		s += fmt.Sprintf(" hostname=%s", c.Hostname)
+	}
+	if c.PID != 0 {
+		s += fmt.Sprintf(" pid=%d", c.PID)
+	}
+	if c.Binary != "" {
+		s += fmt.Sprintf(" binary=%s", c.Binary)
+	}
+	if c.Version != "" {
+		s += fmt.Sprintf(" version=%s", c.Version)
+	}
+	if c.StartTime != nil {
+		s += fmt.Sprintf(" startTime=%s", c.StartTime.Format(time.RFC3339))
+	}
+
+	return s
+}
+
+func watch(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	openProvider := providerFlags(fs)
+	prefix := fs.String("prefix", "", "Only watch locks whose keys start with the prefix")
+	interval := fs.Duration("interval", time.Second, "How often to poll the state of the locks")
+	jsonLines := fs.Bool("json", false, "Write changes as JSON lines")
+	_ = fs.Parse(args)
+
+	p, bucket, err := openProvider(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := newLockWatcher(p, bucket, *prefix)
+
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+
+	for {
+		changes, err := w.poll(ctx)
+		if err != nil {
+			// Keep watching through transient failures.
+			fmt.Fprintf(os.Stderr, "objsync watch: %v\n", err)
+		}
+
+		if err := writeChanges(os.Stdout, changes, *jsonLines); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// lockWatcher polls the state of the locks under a prefix.
+type lockWatcher struct {
+	provider provider.Provider
+	bucket   string
+	prefix   string
+	// The state of each lock as of the last poll (nil before the first).
+	locks map[string]*objsync.LockInfo
+}
+
+func newLockWatcher(p provider.Provider, bucket, prefix string) *lockWatcher {
+	return &lockWatcher{
+		provider: p,
+		bucket:   bucket,
+		prefix:   prefix,
+	}
+}
+
+// poll reads the current state of the locks, returning how it changed since
+// the last poll. Objects that aren't locks are ignored.
+func (w *lockWatcher) poll(ctx context.Context) ([]lockChange, error) {
+	lister, ok := w.provider.(provider.Lister)
+	if !ok {
+		return nil, fmt.Errorf("listing objects: %w", provider.ErrNotSupported)
+	}
+
+	keys, err := lister.ListObjects(ctx, w.bucket, w.prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	locks := make(map[string]*objsync.LockInfo, len(keys))
+	for _, key := range keys {
+		info, err := objsync.Inspect(ctx, w.provider, w.bucket, key)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+
+			if errors.Is(err, objsync.ErrNotMutex) {
+				continue
+			}
+
+			// Keep the last known state of locks that couldn't be read.
+			if prev, ok := w.locks[key]; ok {
+				locks[key] = prev
+			}
+			continue
+		}
+
+		locks[key] = info
+	}
+
+	now := time.Now().UTC()
+
+	var changes []lockChange
+	for _, key := range keys {
+		info, ok := locks[key]
+		if !ok {
+			continue
+		}
+
+		var prev *objsync.LockInfo
+		if w.locks != nil {
+			if prev, ok = w.locks[key]; !ok {
+				prev = &objsync.LockInfo{}
+			}
+		}
+
+		if change := diffLock(now, key, prev, info); change != nil {
+			changes = append(changes, *change)
+		}
+	}
+
+	// Deleted lock objects were released.
+	for key, prev := range w.locks {
+		if _, ok := locks[key]; !ok {
+			if change := diffLock(now, key, prev, &objsync.LockInfo{}); change != nil {
+				changes = append(changes, *change)
+			}
+		}
+	}
+
+	w.locks = locks
+
+	return changes, nil
+}
+
+// diffLock returns how the state of a lock changed, if it did. A nil previous
+// state means the lock hasn't been seen before.
+func diffLock(now time.Time, key string, prev, info *objsync.LockInfo) *lockChange {
+	change := &lockChange{
+		Time:         now,
+		Key:          key,
+		Owner:        info.Owner,
+		FencingToken: info.FencingToken,
+	}
+
+	if !info.Expires.IsZero() {
+		expires := info.Expires
+		change.Expires = &expires
+	}
+
+	if info.Owner != "" {
+		change.Hostname = info.Hostname
+		change.PID = info.PID
+		change.Binary = info.Binary
+		change.Version = info.Version
+		if !info.StartTime.IsZero() {
+			startTime := info.StartTime
+			change.StartTime = &startTime
+		}
+	}
+
+	switch {
+	case prev == nil:
+		if info.Owner == "" {
+			return nil
+		}
+
+		change.Change = changeHeld
+	case prev.Owner == "" && info.Owner != "":
+		change.Change = changeAcquired
+	case prev.Owner != "" && info.Owner == "":
+		change.Change = changeReleased
+		if !prev.Expires.IsZero() && !now.Before(prev.Expires) {
+			change.Change = changeExpired
+		}
+
+		change.PreviousOwner = prev.Owner
+		change.FencingToken = prev.FencingToken
+		change.Expires = nil
+	case prev.Owner != "" && (prev.Owner != info.Owner || prev.FencingToken != info.FencingToken):
+		change.Change = changeHolderChanged
+		change.PreviousOwner = prev.Owner
+	default:
+		return nil
+	}
+
+	return change
+}
+
+// writeChanges writes lock state changes, as text, or JSON lines.
+func writeChanges(w io.Writer, changes []lockChange, jsonLines bool) error {
+	enc := json.NewEncoder(w)
+
+	for _, change := range changes {
+		var err error
+		if jsonLines {
+			err = enc.Encode(&change)
+		} else {
+			_, err = fmt.Fprintln(w, change.String())
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,135 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dpeckett/objsync"
+	"github.com/dpeckett/objsync/provider/mem"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLockWatcher(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+
+	p := mem.NewProvider()
+
+	held := objsync.NewMutex(p, "bucket", "locks/held", objsync.WithOwnerID("a"))
+	_, err := held.Lock(ctx, time.Minute)
+	require.NoError(t, err)
+
+	// Not under the prefix.
+	_, err = objsync.NewMutex(p, "bucket", "other/lock").Lock(ctx, time.Minute)
+	require.NoError(t, err)
+
+	// Not locks.
+	require.NoError(t, objsync.NewSemaphore(p, "bucket", "locks/semaphore", 2, time.Minute).Acquire(ctx, 1))
+	_, err = p.AtomicUpdateObject(ctx, "bucket", "locks/config", func(_ string, _ []byte) ([]byte, error) {
+		return []byte(`{"replicas":3}`), nil
+	})
+	require.NoError(t, err)
+
+	w := newLockWatcher(p, "bucket", "locks/")
+
+	poll := func() []string {
+		changes, err := w.poll(ctx)
+		require.NoError(t, err)
+
+		var summary []string
+		for _, change := range changes {
+			summary = append(summary, change.Change+" "+change.Key+" "+change.Owner+" "+change.PreviousOwner)
+		}
+
+		return summary
+	}
+
+	changes, err := w.poll(ctx)
+	require.NoError(t, err)
+	require.Len(t, changes, 1)
+	require.Equal(t, changeHeld, changes[0].Change)
+	require.Equal(t, "a", changes[0].Owner)
+	require.Equal(t, os.Getpid(), changes[0].PID)
+	require.NotNil(t, changes[0].StartTime)
+
+	require.Empty(t, poll())
+
+	mu := objsync.NewMutex(p, "bucket", "locks/other", objsync.WithOwnerID("b"))
+	_, err = mu.Lock(ctx, time.Minute)
+	require.NoError(t, err)
+
+	require.Equal(t, []string{"acquired locks/other b "}, poll())
+
+	require.NoError(t, mu.Unlock(ctx))
+
+	require.Equal(t, []string{"released locks/other  b"}, poll())
+
+	_, err = mu.Lock(ctx, 100*time.Millisecond)
+	require.NoError(t, err)
+
+	require.Equal(t, []string{"acquired locks/other b "}, poll())
+
+	time.Sleep(200 * time.Millisecond)
+
+	require.Equal(t, []string{"expired locks/other  b"}, poll())
+
+	_, err = objsync.NewMutex(p, "bucket", "locks/other", objsync.WithOwnerID("c")).Lock(ctx, time.Minute)
+	require.NoError(t, err)
+
+	require.Equal(t, []string{"acquired locks/other c "}, poll())
+
+	// Taken over without being seen to be free.
+	mu = objsync.NewMutex(p, "bucket", "locks/other", objsync.WithOwnerID("d"))
+	_, err = p.AtomicUpdateObject(ctx, "bucket", "locks/other", func(_ string, _ []byte) ([]byte, error) {
+		return nil, nil
+	})
+	require.NoError(t, err)
+
+	_, err = mu.Lock(ctx, time.Minute)
+	require.NoError(t, err)
+
+	require.Equal(t, []string{"holder_changed locks/other d c"}, poll())
+}
+
+func TestWriteChanges(t *testing.T) {
+	expires := time.Date(2024, 1, 1, 0, 1, 0, 0, time.UTC)
+	startTime := time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC)
+	changes := []lockChange{{
+		Time:         time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		Key:          "locks/a",
+		Change:       changeAcquired,
+		Owner:        "a",
+		FencingToken: 3,
+		Expires:      &expires,
+		Hostname:     "worker-1",
+		PID:          42,
+		Binary:       "worker",
+		Version:      "v1.2.3",
+		StartTime:    &startTime,
+	}}
+
+	var buf bytes.Buffer
+	require.NoError(t, writeChanges(&buf, changes, false))
+	require.Equal(t, "2024-01-01T00:00:00Z acquired       locks/a owner=a fencingToken=3 expires=2024-01-01T00:01:00Z"+
+		" hostname=worker-1 pid=42 binary=worker version=v1.2.3 startTime=2023-12-31T00:00:00Z\n", buf.String())
+
+	buf.Reset()
+	require.NoError(t, writeChanges(&buf, changes, true))
+
+	var change lockChange
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &change))
+	require.Equal(t, changes[0], change)
+}
@@ -0,0 +1,145 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// objsyncd is a lock server that fronts a storage provider over gRPC (and
+// optionally REST), so that clients can use objsync without holding storage
+// credentials.
+//
+// Storage credentials are read from the environment (AWS_ENDPOINT_URL_S3,
+// AWS_REGION, AWS_ACCESS_KEY_ID, and AWS_SECRET_ACCESS_KEY for S3, or the
+// application default credentials for GCS).
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	nethttp "net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/dpeckett/objsync/provider"
+	"github.com/dpeckett/objsync/provider/gcs"
+	"github.com/dpeckett/objsync/provider/grpcproxy"
+	"github.com/dpeckett/objsync/provider/http"
+	"github.com/dpeckett/objsync/provider/s3"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+func main() {
+	listenAddr := flag.String("listen", ":7420", "Address to listen on")
+	httpListenAddr := flag.String("http-listen", "", "Address to serve the REST API on (default disabled)")
+	providerName := flag.String("provider", "s3", "Storage provider to use (s3 or gcs)")
+	allowedBuckets := flag.String("allowed-buckets", "", "Comma separated list of buckets clients are allowed to use (default all)")
+	tlsCertFile := flag.String("tls-cert", "", "Path to a TLS certificate file")
+	tlsKeyFile := flag.String("tls-key", "", "Path to a TLS private key file")
+	flag.Parse()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	if err := run(ctx, *listenAddr, *httpListenAddr, *providerName, *allowedBuckets, *tlsCertFile, *tlsKeyFile); err != nil {
+		slog.Error("Failed to run server", slog.Any("error", err))
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, listenAddr, httpListenAddr, providerName, allowedBuckets, tlsCertFile, tlsKeyFile string) error {
+	p, err := newProvider(ctx, providerName)
+	if err != nil {
+		return fmt.Errorf("failed to create provider: %w", err)
+	}
+
+	var serverOpts []grpcproxy.ServerOption
+	var handlerOpts []http.HandlerOption
+	if allowedBuckets != "" {
+		serverOpts = append(serverOpts, grpcproxy.WithAllowedBuckets(strings.Split(allowedBuckets, ",")...))
+		handlerOpts = append(handlerOpts, http.WithAllowedBuckets(strings.Split(allowedBuckets, ",")...))
+	}
+
+	var grpcOpts []grpc.ServerOption
+	if tlsCertFile != "" {
+		creds, err := credentials.NewServerTLSFromFile(tlsCertFile, tlsKeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load TLS credentials: %w", err)
+		}
+
+		grpcOpts = append(grpcOpts, grpc.Creds(creds))
+	}
+
+	s := grpc.NewServer(grpcOpts...)
+	grpcproxy.RegisterProviderServer(s, grpcproxy.NewServer(p, serverOpts...))
+
+	lis, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+
+	g, ctx := errgroup.WithContext(ctx)
+
+	g.Go(func() error {
+		slog.Info("Listening", slog.String("address", lis.Addr().String()))
+
+		return s.Serve(lis)
+	})
+
+	g.Go(func() error {
+		<-ctx.Done()
+		s.GracefulStop()
+		return nil
+	})
+
+	if httpListenAddr != "" {
+		httpServer := &nethttp.Server{
+			Addr:    httpListenAddr,
+			Handler: http.NewHandler(p, handlerOpts...),
+		}
+
+		g.Go(func() error {
+			slog.Info("Serving REST API", slog.String("address", httpListenAddr))
+
+			var err error
+			if tlsCertFile != "" {
+				err = httpServer.ListenAndServeTLS(tlsCertFile, tlsKeyFile)
+			} else {
+				err = httpServer.ListenAndServe()
+			}
+			if err != nil && !errors.Is(err, nethttp.ErrServerClosed) {
+				return err
+			}
+
+			return nil
+		})
+
+		g.Go(func() error {
+			<-ctx.Done()
+			return httpServer.Shutdown(context.WithoutCancel(ctx))
+		})
+	}
+
+	return g.Wait()
+}
+
+func newProvider(ctx context.Context, name string) (provider.Provider, error) {
+	switch name {
+	case "s3":
+		return s3.NewProviderWithDefaultCredentials(ctx, os.Getenv("AWS_ENDPOINT_URL_S3"), os.Getenv("AWS_REGION"))
+	case "gcs":
+		return gcs.NewProvider(ctx)
+	default:
+		return nil, fmt.Errorf("unknown provider %q", name)
+	}
+}
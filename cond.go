@@ -0,0 +1,173 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/dpeckett/objsync/provider"
+)
+
+// CondOption is a functional option for configuring a condition variable.
+type CondOption func(*Cond)
+
+// WithCondPollInterval sets how often waiters check whether the condition
+// variable has been broadcast, if the provider can't watch objects for
+// changes (see provider.Watcher).
+func WithCondPollInterval(interval time.Duration) CondOption {
+	return func(c *Cond) {
+		c.pollInterval = interval
+	}
+}
+
+// Cond is a distributed condition variable, a rendezvous point for processes
+// waiting for (or announcing) a change to some shared state. Each broadcast
+// bumps a version number stored in the condition variable object, which
+// waiters watch (or poll) for changes. Unlike sync.Cond, there is no Signal, as waiters
+// are not tracked individually.
+type Cond struct {
+	provider     provider.Provider
+	bucket       string
+	key          string
+	pollInterval time.Duration
+}
+
+// The current schema version of the condition variable object.
+const condSchemaVersion = 1
+
+// The JSON content of the condition variable object.
+type condContent struct {
+	SchemaVersion int   `json:"schemaVersion,omitempty"`
+	Version       int64 `json:"version,omitempty"`
+}
+
+// NewCond creates a new distributed condition variable.
+func NewCond(p provider.Provider, bucket, key string, opts ...CondOption) *Cond {
+	c := &Cond{
+		provider:     p,
+		bucket:       bucket,
+		key:          key,
+		pollInterval: 100 * time.Millisecond,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// Broadcast wakes all the processes waiting on the condition variable.
+func (c *Cond) Broadcast(ctx context.Context) error {
+	_, err := updateObject(ctx, c.provider, c.bucket, c.key, func(_ string, currentData []byte) ([]byte, error) {
+		content, err := decodeCondContent(currentData)
+		if err != nil {
+			return nil, err
+		}
+
+		content.Version++
+
+		return json.Marshal(content)
+	})
+	return err
+}
+
+// Version returns the current version of the condition variable, for use with
+// WaitVersion.
+func (c *Cond) Version(ctx context.Context) (int64, error) {
+	data, err := readObject(ctx, c.provider, c.bucket, c.key)
+	if err != nil {
+		return -1, err
+	}
+
+	content, err := decodeCondContent(data)
+	if err != nil {
+		return -1, err
+	}
+
+	return content.Version, nil
+}
+
+// WaitVersion blocks until the condition variable has been broadcast since
+// the given version was observed, or ctx is done. It returns the new version.
+func (c *Cond) WaitVersion(ctx context.Context, version int64) (int64, error) {
+	watchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// The first event is sent once the watch is established, so the version
+	// is always checked at least once.
+	events := watchObject(watchCtx, c.provider, c.bucket, c.key, c.pollInterval)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return -1, ctx.Err()
+		case event, ok := <-events:
+			if !ok {
+				return -1, ctx.Err()
+			}
+
+			if event.Err != nil {
+				return -1, event.Err
+			}
+		}
+
+		current, err := c.Version(ctx)
+		if err != nil {
+			return -1, err
+		}
+
+		if current != version {
+			return current, nil
+		}
+	}
+}
+
+// Wait unlocks mu, blocks until the condition variable has been broadcast,
+// then locks mu again before returning (with the new fencing token). As with
+// sync.Cond, the caller should hold mu, and check the condition in a loop.
+// Length is the maximum duration the lock will be held for once reacquired.
+func (c *Cond) Wait(ctx context.Context, mu *Mutex, length time.Duration) (int64, error) {
+	// Observed while holding the lock, so that a broadcast made after the
+	// condition was checked is not missed.
+	version, err := c.Version(ctx)
+	if err != nil {
+		return -1, err
+	}
+
+	if err := mu.Unlock(ctx); err != nil {
+		return -1, fmt.Errorf("failed to unlock: %w", err)
+	}
+
+	if _, err := c.WaitVersion(ctx, version); err != nil {
+		return -1, err
+	}
+
+	return mu.Lock(ctx, length)
+}
+
+// decodeCondContent decodes the condition variable object.
+func decodeCondContent(data []byte) (*condContent, error) {
+	var content condContent
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &content); err != nil {
+			return nil, err
+		}
+	}
+
+	if content.SchemaVersion == 0 {
+		content.SchemaVersion = condSchemaVersion
+	}
+
+	return &content, nil
+}
@@ -0,0 +1,88 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dpeckett/objsync"
+	"github.com/dpeckett/objsync/provider/s3"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestCond(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	prefix := fmt.Sprintf("test-%d", time.Now().UnixNano())
+	lockKey, condKey, counterKey := prefix+".lock", prefix+".cond", prefix+".counter"
+
+	ctx := context.Background()
+	p, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	require.NoError(t, err)
+
+	const target = 3
+
+	g, gctx := errgroup.WithContext(ctx)
+	for i := 0; i < 2; i++ {
+		g.Go(func() error {
+			mu := objsync.NewMutex(p, bucket, lockKey)
+			cond := objsync.NewCond(p, bucket, condKey)
+			counter := objsync.NewCounter(p, bucket, counterKey)
+
+			if _, err := mu.Lock(gctx, 5*time.Second); err != nil {
+				return fmt.Errorf("lock: %w", err)
+			}
+
+			for {
+				value, err := counter.Get(gctx)
+				if err != nil {
+					return fmt.Errorf("get: %w", err)
+				}
+
+				if value >= target {
+					break
+				}
+
+				if _, err := cond.Wait(gctx, mu, 5*time.Second); err != nil {
+					return fmt.Errorf("wait: %w", err)
+				}
+			}
+
+			return mu.Unlock(gctx)
+		})
+	}
+
+	mu := objsync.NewMutex(p, bucket, lockKey)
+	cond := objsync.NewCond(p, bucket, condKey)
+	counter := objsync.NewCounter(p, bucket, counterKey)
+
+	for i := 0; i < target; i++ {
+		time.Sleep(50 * time.Millisecond)
+
+		_, err := mu.Lock(ctx, 5*time.Second)
+		require.NoError(t, err)
+
+		_, err = counter.Add(ctx, 1)
+		require.NoError(t, err)
+
+		require.NoError(t, cond.Broadcast(ctx))
+		require.NoError(t, mu.Unlock(ctx))
+	}
+
+	require.NoError(t, g.Wait())
+}
@@ -0,0 +1,106 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+
+	"github.com/dpeckett/objsync/provider"
+)
+
+// ErrCounterOverflow is returned when adding to a counter would overflow (or
+// underflow) an int64. The counter is left unchanged.
+var ErrCounterOverflow = errors.New("counter overflow")
+
+// Counter is a distributed atomic counter.
+type Counter struct {
+	provider provider.Provider
+	bucket   string
+	key      string
+}
+
+// The current schema version of the counter object.
+const counterSchemaVersion = 1
+
+// The JSON content of the counter object.
+type counterContent struct {
+	SchemaVersion int   `json:"schemaVersion,omitempty"`
+	Value         int64 `json:"value,omitempty"`
+}
+
+// NewCounter creates a new distributed atomic counter, counters start from
+// zero.
+func NewCounter(p provider.Provider, bucket, key string) *Counter {
+	return &Counter{
+		provider: p,
+		bucket:   bucket,
+		key:      key,
+	}
+}
+
+// Add atomically adds delta to the counter and returns the new value.
+func (c *Counter) Add(ctx context.Context, delta int64) (int64, error) {
+	var value int64
+	_, err := updateObject(ctx, c.provider, c.bucket, c.key, func(_ string, currentData []byte) ([]byte, error) {
+		content, err := decodeCounterContent(currentData)
+		if err != nil {
+			return nil, err
+		}
+
+		if (delta > 0 && content.Value > math.MaxInt64-delta) ||
+			(delta < 0 && content.Value < math.MinInt64-delta) {
+			return nil, ErrCounterOverflow
+		}
+
+		content.Value += delta
+		value = content.Value
+
+		return json.Marshal(content)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return value, nil
+}
+
+// Get returns the current value of the counter.
+func (c *Counter) Get(ctx context.Context) (int64, error) {
+	data, err := readObject(ctx, c.provider, c.bucket, c.key)
+	if err != nil {
+		return 0, err
+	}
+
+	content, err := decodeCounterContent(data)
+	if err != nil {
+		return 0, err
+	}
+
+	return content.Value, nil
+}
+
+// decodeCounterContent decodes the counter object.
+func decodeCounterContent(data []byte) (*counterContent, error) {
+	var content counterContent
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &content); err != nil {
+			return nil, err
+		}
+	}
+
+	if content.SchemaVersion == 0 {
+		content.SchemaVersion = counterSchemaVersion
+	}
+
+	return &content, nil
+}
@@ -0,0 +1,77 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync_test
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dpeckett/objsync"
+	"github.com/dpeckett/objsync/provider/s3"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestCounter(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	ctx := context.Background()
+	p, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	require.NoError(t, err)
+
+	t.Run("Concurrent", func(t *testing.T) {
+		key := fmt.Sprintf("test-%d.counter", time.Now().UnixNano())
+
+		g, gctx := errgroup.WithContext(ctx)
+		for i := 0; i < 5; i++ {
+			g.Go(func() error {
+				counter := objsync.NewCounter(p, bucket, key)
+
+				for j := 0; j < 5; j++ {
+					if _, err := counter.Add(gctx, 1); err != nil {
+						return fmt.Errorf("add: %w", err)
+					}
+				}
+
+				return nil
+			})
+		}
+
+		require.NoError(t, g.Wait())
+
+		value, err := objsync.NewCounter(p, bucket, key).Get(ctx)
+		require.NoError(t, err)
+		require.Equal(t, int64(25), value)
+	})
+
+	t.Run("Overflow", func(t *testing.T) {
+		key := fmt.Sprintf("test-%d.counter", time.Now().UnixNano())
+
+		counter := objsync.NewCounter(p, bucket, key)
+
+		value, err := counter.Add(ctx, math.MaxInt64)
+		require.NoError(t, err)
+		require.Equal(t, int64(math.MaxInt64), value)
+
+		_, err = counter.Add(ctx, 1)
+		require.ErrorIs(t, err, objsync.ErrCounterOverflow)
+
+		value, err = counter.Add(ctx, -1)
+		require.NoError(t, err)
+		require.Equal(t, int64(math.MaxInt64-1), value)
+	})
+}
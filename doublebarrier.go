@@ -0,0 +1,360 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/avast/retry-go/v4"
+	"github.com/dpeckett/objsync/provider"
+	"github.com/google/uuid"
+)
+
+// DoubleBarrierOption is a functional option for configuring a double barrier.
+type DoubleBarrierOption func(*DoubleBarrier)
+
+// DoubleBarrier is a distributed double barrier, based on the ZooKeeper
+// recipe. It synchronizes both the start and the end of a computation, Enter
+// blocks until all the parties have entered, and Leave blocks until all the
+// parties have left.
+//
+// Participants that arrive once the computation has started wait for the
+// next round.
+type DoubleBarrier struct {
+	provider provider.Provider
+	bucket   string
+	key      string
+	id       string
+	parties  int
+	ttl      time.Duration
+
+	mu            sync.Mutex
+	generation    int64
+	entered       bool
+	keepAliveStop func()
+}
+
+// The current schema version of the double barrier object.
+const doubleBarrierSchemaVersion = 1
+
+// The JSON content of the double barrier object.
+type doubleBarrierContent struct {
+	SchemaVersion int   `json:"schemaVersion,omitempty"`
+	Parties       int   `json:"parties,omitempty"`
+	Generation    int64 `json:"generation,omitempty"`
+	// Whether all the parties have entered the barrier.
+	Ready        bool                 `json:"ready,omitempty"`
+	Participants map[string]time.Time `json:"participants,omitempty"`
+}
+
+// NewDoubleBarrier creates a new distributed double barrier for the given
+// number of parties. Participants are kept registered in the background
+// between entering and leaving, participants that crash are forgotten after
+// the ttl.
+func NewDoubleBarrier(p provider.Provider, bucket, key string, parties int, ttl time.Duration, opts ...DoubleBarrierOption) *DoubleBarrier {
+	b := &DoubleBarrier{
+		provider: p,
+		bucket:   bucket,
+		key:      key,
+		id:       uuid.New().String(),
+		parties:  parties,
+		ttl:      ttl,
+	}
+
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	return b
+}
+
+// WithDoubleBarrierParticipantID sets a stable participant ID for the double
+// barrier, instead of a randomly generated one.
+func WithDoubleBarrierParticipantID(id string) DoubleBarrierOption {
+	return func(b *DoubleBarrier) {
+		b.id = id
+	}
+}
+
+// Enter registers this participant with the barrier, and blocks until all the
+// parties have entered, or ctx is done.
+func (b *DoubleBarrier) Enter(ctx context.Context) error {
+	var errInProgress = errors.New("computation in progress")
+
+	if b.parties <= 0 {
+		return fmt.Errorf("invalid number of parties: %d", b.parties)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.entered {
+		return fmt.Errorf("already entered the barrier")
+	}
+
+	var generation int64
+	var registered bool
+	err := retry.Do(
+		func() error {
+			var ready, joined bool
+			_, err := b.provider.AtomicUpdateObject(ctx, b.bucket, b.key, func(_ string, currentData []byte) ([]byte, error) {
+				ready, joined = false, false
+
+				content, err := b.decode(currentData)
+				if err != nil {
+					return nil, err
+				}
+
+				generation = content.Generation
+
+				// Wait for the computation to finish, before joining the next round.
+				if _, ok := content.Participants[b.id]; content.Ready && !ok {
+					return nil, errInProgress
+				}
+
+				// Register (or refresh the registration of) this participant.
+				content.Participants[b.id] = time.Now().Add(b.ttl).UTC()
+				joined = true
+
+				if len(content.Participants) >= content.Parties {
+					content.Ready = true
+				}
+				ready = content.Ready
+
+				return json.Marshal(content)
+			})
+			if err != nil {
+				if errors.Is(err, errInProgress) || errors.Is(err, provider.ErrConflict) {
+					return err
+				}
+
+				return retry.Unrecoverable(err)
+			}
+
+			registered = joined
+			if ready {
+				return nil
+			}
+
+			return fmt.Errorf("waiting for parties to enter")
+		},
+		retry.Context(ctx),
+		retry.Attempts(0),
+		// Poll often enough to keep our registration from expiring.
+		retry.MaxDelay(b.ttl/3),
+	)
+	if err != nil {
+		if registered {
+			b.withdraw(context.WithoutCancel(ctx), generation)
+		}
+
+		return err
+	}
+
+	b.generation = generation
+	b.entered = true
+	b.startKeepAlive(context.WithoutCancel(ctx))
+
+	return nil
+}
+
+// Leave deregisters this participant from the barrier, and blocks until all
+// the parties have left, or ctx is done.
+func (b *DoubleBarrier) Leave(ctx context.Context) error {
+	var errWaiting = errors.New("waiting for parties to leave")
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.entered {
+		return fmt.Errorf("not entered the barrier")
+	}
+
+	b.stopKeepAlive()
+
+	var left bool
+	err := retry.Do(
+		func() error {
+			_, err := b.provider.AtomicUpdateObject(ctx, b.bucket, b.key, func(_ string, currentData []byte) ([]byte, error) {
+				content, err := b.decode(currentData)
+				if err != nil {
+					return nil, err
+				}
+
+				// Everyone has already left.
+				if content.Generation != b.generation {
+					left = true
+					return nil, errWaiting
+				}
+
+				_, ok := content.Participants[b.id]
+				if !ok && len(content.Participants) > 0 {
+					return nil, errWaiting
+				}
+
+				delete(content.Participants, b.id)
+
+				// We're the last to leave, so reset the barrier for the next round.
+				if len(content.Participants) == 0 {
+					content.Generation++
+					content.Ready = false
+					content.Participants = nil
+				}
+
+				return json.Marshal(content)
+			})
+			if err != nil {
+				if errors.Is(err, errWaiting) {
+					if left {
+						return nil
+					}
+
+					return err
+				}
+
+				if errors.Is(err, provider.ErrConflict) {
+					return err
+				}
+
+				return retry.Unrecoverable(err)
+			}
+
+			// Retry until everyone has left.
+			return errWaiting
+		},
+		retry.Context(ctx),
+		retry.Attempts(0),
+		retry.MaxDelay(maxRetryDelay),
+	)
+	if err != nil {
+		return err
+	}
+
+	b.entered = false
+
+	return nil
+}
+
+// withdraw makes a best effort attempt to remove this participant's
+// registration, if the computation hasn't started in the meantime.
+func (b *DoubleBarrier) withdraw(ctx context.Context, generation int64) {
+	ctx, cancel := context.WithTimeout(ctx, b.ttl)
+	defer cancel()
+
+	_, _ = updateObject(ctx, b.provider, b.bucket, b.key, func(_ string, currentData []byte) ([]byte, error) {
+		content, err := b.decode(currentData)
+		if err != nil {
+			return nil, err
+		}
+
+		if content.Generation != generation || content.Ready {
+			return nil, errors.New("computation has already started")
+		}
+
+		delete(content.Participants, b.id)
+
+		return json.Marshal(content)
+	})
+}
+
+// startKeepAlive starts a background goroutine that periodically refreshes
+// this participant's registration, until Leave is called.
+func (b *DoubleBarrier) startKeepAlive(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+
+	b.keepAliveStop = func() {
+		cancel()
+		<-done
+	}
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(b.ttl / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				// Transient errors are retried on the next tick.
+				_, _ = updateObject(ctx, b.provider, b.bucket, b.key, func(_ string, currentData []byte) ([]byte, error) {
+					content, err := b.decode(currentData)
+					if err != nil {
+						return nil, err
+					}
+
+					if _, ok := content.Participants[b.id]; !ok || content.Generation != b.generation {
+						return nil, errors.New("not registered")
+					}
+
+					content.Participants[b.id] = time.Now().Add(b.ttl).UTC()
+
+					return json.Marshal(content)
+				})
+			}
+		}
+	}()
+}
+
+// stopKeepAlive stops refreshing this participant's registration (if
+// running).
+func (b *DoubleBarrier) stopKeepAlive() {
+	if b.keepAliveStop != nil {
+		b.keepAliveStop()
+		b.keepAliveStop = nil
+	}
+}
+
+// decode decodes the double barrier object, pruning any expired
+// participants.
+func (b *DoubleBarrier) decode(data []byte) (*doubleBarrierContent, error) {
+	var content doubleBarrierContent
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &content); err != nil {
+			return nil, err
+		}
+	}
+
+	if content.SchemaVersion == 0 {
+		content.SchemaVersion = doubleBarrierSchemaVersion
+	}
+
+	// The first user of the barrier gets to decide the number of parties.
+	if content.Parties == 0 {
+		content.Parties = b.parties
+	}
+
+	if content.Participants == nil {
+		content.Participants = make(map[string]time.Time)
+	}
+
+	now := time.Now()
+	for id, expires := range content.Participants {
+		if now.After(expires) {
+			delete(content.Participants, id)
+		}
+	}
+
+	// Everyone who entered has since crashed, so reset the barrier.
+	if content.Ready && len(content.Participants) == 0 {
+		content.Generation++
+		content.Ready = false
+	}
+
+	return &content, nil
+}
@@ -0,0 +1,78 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync_test
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dpeckett/objsync"
+	"github.com/dpeckett/objsync/provider/s3"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestDoubleBarrier(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	key := fmt.Sprintf("test-%d.dbarrier", time.Now().UnixNano())
+
+	ctx := context.Background()
+	p, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	require.NoError(t, err)
+
+	const parties = 3
+
+	var entered, left int32
+	g, ctx := errgroup.WithContext(ctx)
+	for i := 0; i < parties; i++ {
+		g.Go(func() error {
+			b := objsync.NewDoubleBarrier(p, bucket, key, parties, 5*time.Second)
+
+			// Stagger the arrivals.
+			time.Sleep(time.Duration(rand.Intn(50)) * time.Millisecond)
+
+			atomic.AddInt32(&entered, 1)
+
+			if err := b.Enter(ctx); err != nil {
+				return fmt.Errorf("enter: %w", err)
+			}
+
+			if n := atomic.LoadInt32(&entered); n != parties {
+				return fmt.Errorf("entered after only %d parties arrived", n)
+			}
+
+			// Simulate some work.
+			time.Sleep(time.Duration(rand.Intn(50)) * time.Millisecond)
+
+			atomic.AddInt32(&left, 1)
+
+			if err := b.Leave(ctx); err != nil {
+				return fmt.Errorf("leave: %w", err)
+			}
+
+			if n := atomic.LoadInt32(&left); n != parties {
+				return fmt.Errorf("left after only %d parties finished", n)
+			}
+
+			return nil
+		})
+	}
+
+	require.NoError(t, g.Wait())
+}
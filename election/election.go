@@ -0,0 +1,244 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package election implements leader election on top of objsync mutexes, with
+// an API modelled after client-go's leaderelection package.
+package election
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dpeckett/objsync"
+	"github.com/dpeckett/objsync/provider"
+)
+
+// Config configures a leader election.
+type Config struct {
+	// Provider is the object storage provider used to store the election lock.
+	Provider provider.Provider
+	// Bucket is the bucket containing the election lock.
+	Bucket string
+	// Key is the key of the election lock object.
+	Key string
+	// Identity is the identity of this candidate (defaults to a random ID).
+	Identity string
+	// LeaseDuration is the duration that the leader holds the election lock
+	// for, before it must be renewed.
+	LeaseDuration time.Duration
+	// RetryPeriod is how often candidates check on the current leader
+	// (defaults to a quarter of the lease duration). If the provider can watch
+	// objects for changes (see provider.Watcher), leadership changes are
+	// observed as they happen instead.
+	RetryPeriod time.Duration
+	// Lease, if set, keeps the election lock held for as long as the lease is
+	// kept alive (see objsync.WithLease), rather than renewing it every lease
+	// duration, so that one heartbeat can maintain many locks and elections.
+	// The lease must be granted before campaigning.
+	Lease *objsync.Lease
+}
+
+// Callbacks are invoked on leadership changes.
+type Callbacks struct {
+	// OnStartedLeading is called (in its own goroutine) when this candidate
+	// becomes the leader. The context is cancelled when leadership is lost.
+	OnStartedLeading func(ctx context.Context)
+	// OnStoppedLeading is called when this candidate stops being the leader.
+	OnStoppedLeading func()
+	// OnNewLeader is called when a new leader is observed (including this
+	// candidate). Optional.
+	OnNewLeader func(identity string)
+}
+
+// Run campaigns for leadership, blocking until the context is cancelled or
+// leadership is lost. When leadership is lost, objsync.ErrLockLost is
+// returned so that the caller can decide whether to exit (as with client-go's
+// RunOrDie) or campaign again.
+func Run(ctx context.Context, config Config, callbacks Callbacks) error {
+	if config.Provider == nil || config.Key == "" {
+		return fmt.Errorf("provider and key must be specified")
+	}
+
+	if config.LeaseDuration <= 0 {
+		return fmt.Errorf("lease duration must be greater than zero")
+	}
+
+	if callbacks.OnStartedLeading == nil || callbacks.OnStoppedLeading == nil {
+		return fmt.Errorf("OnStartedLeading and OnStoppedLeading callbacks must be specified")
+	}
+
+	retryPeriod := config.RetryPeriod
+	if retryPeriod <= 0 {
+		retryPeriod = config.LeaseDuration / 4
+	}
+
+	// Candidates retry acquiring the election lock at least every retry
+	// period.
+	opts := []objsync.MutexOption{objsync.WithMaxRetryDelay(retryPeriod)}
+	if config.Identity != "" {
+		opts = append(opts, objsync.WithOwnerID(config.Identity))
+	}
+	if config.Lease != nil {
+		opts = append(opts, objsync.WithLease(config.Lease))
+	}
+
+	mu := objsync.NewMutex(config.Provider, config.Bucket, config.Key, opts...)
+
+	// Observe leadership changes while campaigning.
+	observeCtx, stopObserving := context.WithCancel(ctx)
+	defer stopObserving()
+
+	var lastLeader string
+	observerDone := make(chan struct{})
+	go func() {
+		defer close(observerDone)
+
+		for range changes(observeCtx, config, retryPeriod) {
+			if leader, err := mu.Owner(observeCtx); err == nil && leader != "" && leader != lastLeader {
+				lastLeader = leader
+
+				if callbacks.OnNewLeader != nil {
+					callbacks.OnNewLeader(leader)
+				}
+			}
+		}
+	}()
+
+	err := objsync.RunWithLock(ctx, mu, config.LeaseDuration, func(leaderCtx context.Context, _ int64) error {
+		stopObserving()
+		<-observerDone
+
+		if lastLeader != mu.ID() && callbacks.OnNewLeader != nil {
+			callbacks.OnNewLeader(mu.ID())
+		}
+
+		defer callbacks.OnStoppedLeading()
+
+		go callbacks.OnStartedLeading(leaderCtx)
+
+		<-leaderCtx.Done()
+
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, objsync.ErrLockLost) {
+			return err
+		}
+
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// LeaderInfo describes the current leader of an election.
+type LeaderInfo struct {
+	// Identity is the identity of the leader, or empty if there is currently
+	// no leader.
+	Identity string
+	// ObservedAt is when the change of leader was observed.
+	ObservedAt time.Time
+}
+
+// WatchLeader observes the election without campaigning, returning a channel
+// that receives the current leader, and then every change of leader (eg. so
+// that followers can route requests to the leader). The channel is closed
+// when the context is cancelled. Only the provider, bucket, key, and retry
+// period (or lease duration) of the config are used.
+func WatchLeader(ctx context.Context, config Config) (<-chan LeaderInfo, error) {
+	if config.Provider == nil || config.Key == "" {
+		return nil, fmt.Errorf("provider and key must be specified")
+	}
+
+	retryPeriod := config.RetryPeriod
+	if retryPeriod <= 0 {
+		retryPeriod = config.LeaseDuration / 4
+	}
+
+	if retryPeriod <= 0 {
+		return nil, fmt.Errorf("retry period or lease duration must be greater than zero")
+	}
+
+	mu := objsync.NewMutex(config.Provider, config.Bucket, config.Key)
+
+	ch := make(chan LeaderInfo)
+	go func() {
+		defer close(ch)
+
+		var lastLeader *string
+		for range changes(ctx, config, retryPeriod) {
+			// Transient errors are retried on the next change (or tick).
+			if leader, err := mu.Owner(ctx); err == nil && (lastLeader == nil || leader != *lastLeader) {
+				lastLeader = &leader
+
+				select {
+				case <-ctx.Done():
+					return
+				case ch <- LeaderInfo{Identity: leader, ObservedAt: time.Now()}:
+				}
+			}
+		}
+	}()
+
+	return ch, nil
+}
+
+// changes returns a channel that receives whenever the election lock object
+// may have changed, starting immediately. The lock object is watched for
+// changes if the provider supports it, otherwise it is checked every retry
+// period. The channel is closed when ctx is done.
+func changes(ctx context.Context, config Config, retryPeriod time.Duration) <-chan struct{} {
+	ch := make(chan struct{}, 1)
+
+	go func() {
+		defer close(ch)
+
+		interval := retryPeriod
+		var events <-chan provider.Event
+		if watcher, ok := config.Provider.(provider.Watcher); ok && provider.CapabilitiesOf(config.Provider).Watch {
+			events = watcher.WatchObject(ctx, config.Bucket, config.Key)
+
+			// Leaders that go away without stepping down don't change the
+			// lock object, their lock just expires, so still check now and
+			// then.
+			interval = max(retryPeriod, config.LeaseDuration)
+		}
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case ch <- struct{}{}:
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-events:
+				// Fallback to polling if the watch fails.
+				if !ok || event.Err != nil {
+					events = nil
+					ticker.Reset(retryPeriod)
+				}
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return ch
+}
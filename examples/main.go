@@ -3,23 +3,20 @@ package main
 import (
 	"context"
 	"fmt"
-	"os"
 	"time"
 
 	"github.com/dpeckett/objsync"
-	"github.com/dpeckett/objsync/provider/s3"
+	"github.com/dpeckett/objsync/provider"
+	_ "github.com/dpeckett/objsync/provider/s3"
 )
 
 func main() {
-	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
-	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
-	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
-	bucket := os.Getenv("BUCKET")
-
 	key := fmt.Sprintf("test-%d.lock", time.Now().UnixNano())
 
+	// Configure the provider (and bucket) from the environment, eg.
+	// AWS_ENDPOINT_URL_S3, AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and BUCKET.
 	ctx := context.Background()
-	p, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	p, bucket, err := provider.FromEnv(ctx)
 	if err != nil {
 		panic(err)
 	}
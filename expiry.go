@@ -0,0 +1,97 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync
+
+import (
+	"sync"
+	"time"
+)
+
+type expiryWarning struct {
+	before time.Duration
+	fn     func(expires time.Time)
+	ch     chan time.Time
+	mu     sync.Mutex
+	timer  *time.Timer
+}
+
+// WithExpiryWarning enables a warning that fires the given duration before
+// the current hold of the mutex expires (unless it has been released or
+// renewed in the meantime), so that work can be checkpointed and wound down
+// gracefully. The warning is delivered on the ExpiryWarning() channel, and
+// to the optional callback.
+func WithExpiryWarning(before time.Duration, fn func(expires time.Time)) MutexOption {
+	return func(mu *Mutex) {
+		mu.expiryWarning = &expiryWarning{
+			before: before,
+			fn:     fn,
+			ch:     make(chan time.Time, 1),
+		}
+	}
+}
+
+// ExpiryWarning returns a channel that receives the expiry time of the
+// current hold, shortly before it expires. It returns nil if expiry warnings
+// are not enabled.
+func (mu *Mutex) ExpiryWarning() <-chan time.Time {
+	if mu.expiryWarning == nil {
+		return nil
+	}
+
+	return mu.expiryWarning.ch
+}
+
+// scheduleExpiryWarning (re)schedules the expiry warning for a hold.
+func (mu *Mutex) scheduleExpiryWarning(expires time.Time) {
+	w := mu.expiryWarning
+	if w == nil {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+
+	// Drain any stale warning from a previous hold.
+	select {
+	case <-w.ch:
+	default:
+	}
+
+	w.timer = time.AfterFunc(mu.until(expires)-w.before, func() {
+		select {
+		case w.ch <- expires:
+		default:
+		}
+
+		if w.fn != nil {
+			w.fn(expires)
+		}
+	})
+}
+
+// stopExpiryWarning cancels any pending expiry warning.
+func (mu *Mutex) stopExpiryWarning() {
+	w := mu.expiryWarning
+	if w == nil {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+}
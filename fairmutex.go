@@ -0,0 +1,379 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/avast/retry-go/v4"
+	"github.com/dpeckett/objsync/provider"
+	"github.com/google/uuid"
+)
+
+// FairMutexOption is a functional option for configuring a fair mutex.
+type FairMutexOption func(*FairMutex)
+
+// WithFairMutexOwnerID sets a stable owner ID for the fair mutex, instead of
+// a randomly generated one.
+func WithFairMutexOwnerID(id string) FairMutexOption {
+	return func(mu *FairMutex) {
+		mu.id = id
+	}
+}
+
+// WithWaiterTTL sets how long a waiter remains in the queue without checking
+// on the lock, before it is assumed to have given up (defaults to 10
+// seconds).
+func WithWaiterTTL(ttl time.Duration) FairMutexOption {
+	return func(mu *FairMutex) {
+		mu.waiterTTL = ttl
+	}
+}
+
+// WithFairMutexPriority sets the priority of the fair mutex's waiters (defaults
+// to 0). Waiters with a higher priority are handed the lock first, subject to
+// priority aging (see WithPriorityAging).
+func WithFairMutexPriority(priority int) FairMutexOption {
+	return func(mu *FairMutex) {
+		mu.priority = priority
+	}
+}
+
+// WithPriorityAging sets how the priority of waiters increases the longer they
+// wait, so that low priority waiters eventually outrank newer high priority
+// ones, rather than being starved by them. Defaults to
+// LinearPriorityAging(10 * time.Second), a nil policy disables aging.
+//
+// The next holder is chosen by the waiters themselves, so every instance
+// sharing a lock should use the same policy.
+func WithPriorityAging(aging PriorityAging) FairMutexOption {
+	return func(mu *FairMutex) {
+		mu.aging = aging
+	}
+}
+
+// PriorityAging returns the effective priority of a waiter, given its
+// priority, and how long it has been waiting.
+type PriorityAging func(priority int, waited time.Duration) int
+
+// LinearPriorityAging raises the priority of waiters by one for every interval
+// they have been waiting.
+func LinearPriorityAging(interval time.Duration) PriorityAging {
+	return func(priority int, waited time.Duration) int {
+		if interval <= 0 {
+			return priority
+		}
+
+		return priority + int(waited/interval)
+	}
+}
+
+// FairMutex is a distributed mutex that is acquired in order of priority, and
+// then arrival. Waiters enqueue themselves in the lock object, and the lock is
+// only handed to the waiter with the highest (aged) priority, or the earliest
+// of them. It is slower than Mutex, as every waiter must periodically refresh
+// its place in the queue, but slow clients can't be starved under contention.
+type FairMutex struct {
+	provider  provider.Provider
+	bucket    string
+	key       string
+	id        string
+	etag      string
+	waiterTTL time.Duration
+	priority  int
+	aging     PriorityAging
+}
+
+// The current schema version of the fair mutex object.
+const fairMutexSchemaVersion = 1
+
+// The JSON content of the fair mutex object.
+type fairMutexContent struct {
+	SchemaVersion int                `json:"schemaVersion,omitempty"`
+	ID            string             `json:"id,omitempty"`
+	Expires       *time.Time         `json:"expires,omitempty"`
+	Fence         int64              `json:"fence,omitempty"`
+	Waiters       []*fairMutexWaiter `json:"waiters,omitempty"`
+}
+
+type fairMutexWaiter struct {
+	ID       string    `json:"id"`
+	Expires  time.Time `json:"expires"`
+	Priority int       `json:"priority,omitempty"`
+	Enqueued time.Time `json:"enqueued,omitempty"`
+}
+
+// NewFairMutex creates a new distributed fair mutex.
+func NewFairMutex(p provider.Provider, bucket, key string, opts ...FairMutexOption) *FairMutex {
+	mu := &FairMutex{
+		provider:  p,
+		bucket:    bucket,
+		key:       key,
+		id:        uuid.New().String(),
+		waiterTTL: 10 * time.Second,
+		aging:     LinearPriorityAging(10 * time.Second),
+	}
+
+	for _, opt := range opts {
+		opt(mu)
+	}
+
+	return mu
+}
+
+// Lock acquires the mutex, waiting in line behind any earlier waiters. It
+// blocks until the mutex is available, or ctx is done (in which case this
+// waiter leaves the queue). Length is the maximum duration the lock will be
+// held for.
+func (mu *FairMutex) Lock(ctx context.Context, length time.Duration) (int64, error) {
+	if length <= 0 {
+		return -1, fmt.Errorf("%w: %s", ErrInvalidTTL, length)
+	}
+
+	var fencingToken int64
+	err := retry.Do(
+		func() error {
+			var ok bool
+			var err error
+			ok, fencingToken, err = mu.tryLock(ctx, length, true)
+			if err != nil {
+				return retry.Unrecoverable(err)
+			}
+
+			if ok {
+				return nil
+			}
+
+			return &LockHeldError{}
+		},
+		retry.Context(ctx),
+		retry.Attempts(0),
+		// Poll often enough to keep our place in the queue.
+		retry.MaxDelay(mu.waiterTTL/3),
+		// Report that the lock was held if we give up.
+		retry.WrapContextErrorWithLastError(true),
+	)
+	if err != nil {
+		mu.leaveQueue(context.WithoutCancel(ctx))
+
+		return -1, err
+	}
+
+	return fencingToken, nil
+}
+
+// TryLock attempts to acquire the mutex without blocking. It only succeeds
+// if the mutex is not held, and no one waiting for it has a higher (aged)
+// priority, or the same priority.
+func (mu *FairMutex) TryLock(ctx context.Context, length time.Duration) (bool, int64, error) {
+	if length <= 0 {
+		return false, -1, fmt.Errorf("%w: %s", ErrInvalidTTL, length)
+	}
+
+	return mu.tryLock(ctx, length, false)
+}
+
+// Unlock releases the mutex (if held).
+func (mu *FairMutex) Unlock(ctx context.Context) error {
+	if mu.etag == "" {
+		return nil
+	}
+
+	_, err := updateObject(ctx, mu.provider, mu.bucket, mu.key, func(_ string, currentData []byte) ([]byte, error) {
+		content, err := mu.decode(currentData)
+		if err != nil {
+			return nil, err
+		}
+
+		// Someone else has acquired the lock in the meantime.
+		if content.ID != mu.id {
+			return nil, ErrLockLost
+		}
+
+		content.ID = ""
+		content.Expires = nil
+
+		return json.Marshal(content)
+	})
+	if err != nil && !errors.Is(err, ErrLockLost) {
+		return err
+	}
+
+	mu.etag = ""
+
+	return nil
+}
+
+// tryLock attempts to acquire the mutex. If enqueue is true, this waiter
+// joins the back of the queue (or refreshes its place in it) when the mutex
+// can't be acquired.
+func (mu *FairMutex) tryLock(ctx context.Context, length time.Duration, enqueue bool) (bool, int64, error) {
+	var errLockHeld = errors.New("lock is held")
+
+	var acquired bool
+	var fencingToken int64
+	newETag, err := mu.provider.AtomicUpdateObject(ctx, mu.bucket, mu.key, func(_ string, currentData []byte) ([]byte, error) {
+		acquired = false
+
+		content, err := mu.decode(currentData)
+		if err != nil {
+			return nil, err
+		}
+
+		i := slices.IndexFunc(content.Waiters, func(w *fairMutexWaiter) bool {
+			return w.ID == mu.id
+		})
+
+		if content.ID == "" && mu.isNext(content.Waiters, i) {
+			if i != -1 {
+				content.Waiters = slices.Delete(content.Waiters, i, i+1)
+			}
+
+			expires := time.Now().Add(length).UTC()
+			content.ID = mu.id
+			content.Expires = &expires
+			content.Fence++
+
+			acquired = true
+			fencingToken = content.Fence
+
+			return json.Marshal(content)
+		}
+
+		if !enqueue {
+			return nil, errLockHeld
+		}
+
+		now := time.Now().UTC()
+		expires := now.Add(mu.waiterTTL)
+		if i == -1 {
+			content.Waiters = append(content.Waiters, &fairMutexWaiter{
+				ID:       mu.id,
+				Expires:  expires,
+				Priority: mu.priority,
+				Enqueued: now,
+			})
+		} else {
+			content.Waiters[i].Expires = expires
+			content.Waiters[i].Priority = mu.priority
+		}
+
+		return json.Marshal(content)
+	})
+	if err != nil {
+		if errors.Is(err, errLockHeld) || errors.Is(err, provider.ErrConflict) {
+			return false, -1, nil
+		}
+
+		return false, -1, err
+	}
+
+	if !acquired {
+		return false, -1, nil
+	}
+
+	mu.etag = newETag
+
+	return true, fencingToken, nil
+}
+
+// isNext returns whether this instance is next in line for the mutex, given
+// the waiters, and its own index among them (-1 if it isn't waiting). It is
+// next if it has the highest effective priority, ties going to the earliest
+// waiter (those not waiting yet come last).
+func (mu *FairMutex) isNext(waiters []*fairMutexWaiter, i int) bool {
+	now := time.Now()
+
+	effectivePriority := func(w *fairMutexWaiter) int {
+		if mu.aging == nil {
+			return w.Priority
+		}
+
+		return mu.aging(w.Priority, now.Sub(w.Enqueued))
+	}
+
+	self := &fairMutexWaiter{ID: mu.id, Priority: mu.priority, Enqueued: now}
+	if i != -1 {
+		self = waiters[i]
+	}
+	priority := effectivePriority(self)
+
+	for j, w := range waiters {
+		if j == i {
+			continue
+		}
+
+		if p := effectivePriority(w); p > priority || (p == priority && (i == -1 || j < i)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// leaveQueue makes a best effort attempt to remove this waiter from the
+// queue.
+func (mu *FairMutex) leaveQueue(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, mu.waiterTTL)
+	defer cancel()
+
+	_, _ = updateObject(ctx, mu.provider, mu.bucket, mu.key, func(_ string, currentData []byte) ([]byte, error) {
+		content, err := mu.decode(currentData)
+		if err != nil {
+			return nil, err
+		}
+
+		content.Waiters = slices.DeleteFunc(content.Waiters, func(w *fairMutexWaiter) bool {
+			return w.ID == mu.id
+		})
+
+		return json.Marshal(content)
+	})
+}
+
+// decode decodes the fair mutex object, releasing expired locks and pruning
+// waiters that have given up.
+func (mu *FairMutex) decode(data []byte) (*fairMutexContent, error) {
+	var content fairMutexContent
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &content); err != nil {
+			return nil, err
+		}
+	}
+
+	if content.SchemaVersion == 0 {
+		content.SchemaVersion = fairMutexSchemaVersion
+	}
+
+	now := time.Now()
+	if content.Expires != nil && now.After(*content.Expires) {
+		content.ID = ""
+		content.Expires = nil
+	}
+
+	content.Waiters = slices.DeleteFunc(content.Waiters, func(w *fairMutexWaiter) bool {
+		return now.After(w.Expires)
+	})
+
+	// Waiters enqueued by earlier versions have no enqueue time, so age them
+	// from now on.
+	for _, w := range content.Waiters {
+		if w.Enqueued.IsZero() {
+			w.Enqueued = now.UTC()
+		}
+	}
+
+	return &content, nil
+}
@@ -0,0 +1,141 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dpeckett/objsync"
+	"github.com/dpeckett/objsync/provider/s3"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestFairMutex(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	key := fmt.Sprintf("test-%d.lock", time.Now().UnixNano())
+
+	ctx := context.Background()
+	p, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	require.NoError(t, err)
+
+	holder := objsync.NewFairMutex(p, bucket, key)
+	_, err = holder.Lock(ctx, 5*time.Second)
+	require.NoError(t, err)
+
+	var mu sync.Mutex
+	var order []int
+
+	g, gctx := errgroup.WithContext(ctx)
+	for i := 0; i < 3; i++ {
+		// Make sure the waiters arrive in order.
+		time.Sleep(100 * time.Millisecond)
+
+		g.Go(func() error {
+			fmu := objsync.NewFairMutex(p, bucket, key, objsync.WithWaiterTTL(time.Second))
+
+			if _, err := fmu.Lock(gctx, 5*time.Second); err != nil {
+				return fmt.Errorf("lock: %w", err)
+			}
+
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+
+			return fmu.Unlock(gctx)
+		})
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	// Barging is not allowed while there are waiters.
+	ok, _, err := objsync.NewFairMutex(p, bucket, key).TryLock(ctx, 5*time.Second)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	require.NoError(t, holder.Unlock(ctx))
+
+	require.NoError(t, g.Wait())
+	require.Equal(t, []int{0, 1, 2}, order)
+}
+
+func TestFairMutexPriority(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	ctx := context.Background()
+	p, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	require.NoError(t, err)
+
+	// lockInOrder has waiters with the given priorities queue up for a held
+	// lock (after the given delays), and returns the order they acquired it.
+	lockInOrder := func(t *testing.T, aging objsync.PriorityAging, priorities []int, delays []time.Duration) []int {
+		key := fmt.Sprintf("test-%d.lock", time.Now().UnixNano())
+
+		holder := objsync.NewFairMutex(p, bucket, key)
+		_, err := holder.Lock(ctx, 5*time.Second)
+		require.NoError(t, err)
+
+		var mu sync.Mutex
+		var order []int
+
+		g, gctx := errgroup.WithContext(ctx)
+		for i, priority := range priorities {
+			time.Sleep(delays[i])
+
+			g.Go(func() error {
+				fmu := objsync.NewFairMutex(p, bucket, key,
+					objsync.WithWaiterTTL(time.Second),
+					objsync.WithFairMutexPriority(priority),
+					objsync.WithPriorityAging(aging))
+
+				if _, err := fmu.Lock(gctx, 5*time.Second); err != nil {
+					return fmt.Errorf("lock: %w", err)
+				}
+
+				mu.Lock()
+				order = append(order, i)
+				mu.Unlock()
+
+				return fmu.Unlock(gctx)
+			})
+		}
+
+		time.Sleep(100 * time.Millisecond)
+
+		require.NoError(t, holder.Unlock(ctx))
+		require.NoError(t, g.Wait())
+
+		return order
+	}
+
+	t.Run("Priority", func(t *testing.T) {
+		order := lockInOrder(t, nil, []int{0, 0, 1}, []time.Duration{0, 100 * time.Millisecond, 100 * time.Millisecond})
+		require.Equal(t, []int{2, 0, 1}, order)
+	})
+
+	t.Run("Aging", func(t *testing.T) {
+		// The low priority waiter has waited long enough to outrank the
+		// newcomer.
+		order := lockInOrder(t, objsync.LinearPriorityAging(100*time.Millisecond), []int{0, 2}, []time.Duration{0, time.Second})
+		require.Equal(t, []int{0, 1}, order)
+	})
+}
@@ -0,0 +1,234 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dpeckett/objsync/provider"
+)
+
+// ErrFenceRegression is returned when an acquisition yields a fencing token
+// smaller than one previously returned for the same lock (see
+// WithFenceRegressionCheck and WithFenceSidecar). This typically means the
+// lock object was deleted or restored from a backup.
+var ErrFenceRegression = fmt.Errorf("fencing token regression")
+
+// WithFenceRegressionCheck remembers the highest fencing token returned for
+// the lock (by any mutex in the process using the option), so that an
+// acquisition yielding a smaller one fails with ErrFenceRegression. Only
+// tokens returned within the process are compared, use WithFenceSidecar to
+// detect regressions across processes.
+func WithFenceRegressionCheck() MutexOption {
+	return func(mu *Mutex) {
+		mu.fenceRegressionCheck = true
+	}
+}
+
+// WithFenceSidecar additionally records the highest fencing token ever
+// returned for the mutex in a separate object, so that regressions can be
+// detected across processes (and process restarts). Should the lock object
+// be deleted, fencing tokens will carry on from the recorded token.
+func WithFenceSidecar(key string) MutexOption {
+	return func(mu *Mutex) {
+		mu.fenceSidecarKey = key
+	}
+}
+
+// WithOnFenceRegression registers a callback that is invoked when a fencing
+// token regression is detected, before ErrFenceRegression is returned.
+func WithOnFenceRegression(fn func(key string, fence, highestFence int64)) MutexOption {
+	return func(mu *Mutex) {
+		mu.onFenceRegression = fn
+	}
+}
+
+// Fencing tokens are (epoch, counter) pairs, packed into an int64 with the
+// counter in the low 32 bits, so that tokens from a newer epoch always
+// compare greater.
+const fenceEpochShift = 32
+
+// SplitFencingToken splits a fencing token into its epoch and counter.
+func SplitFencingToken(fencingToken int64) (epoch, counter int64) {
+	return fencingToken >> fenceEpochShift, fencingToken & (1<<fenceEpochShift - 1)
+}
+
+// CompareFencingTokens compares two fencing tokens, first by epoch, and then
+// by counter. It returns -1 if a is older than b, 0 if they are equal, and +1
+// if a is newer than b.
+func CompareFencingTokens(a, b int64) int {
+	aEpoch, aCounter := SplitFencingToken(a)
+	bEpoch, bCounter := SplitFencingToken(b)
+
+	if aEpoch != bEpoch {
+		return cmp.Compare(aEpoch, bEpoch)
+	}
+
+	return cmp.Compare(aCounter, bCounter)
+}
+
+// WithFenceEpoch sets the fence epoch of the mutex, the fencing token moves
+// into the epoch the first time the mutex is acquired in a newer epoch. By
+// bumping the epoch after an event that may have reset the fencing token
+// (eg. the bucket being restored from a backup, along with any sidecar),
+// fencing tokens remain monotonic.
+func WithFenceEpoch(epoch int64) MutexOption {
+	return func(mu *Mutex) {
+		mu.fenceEpoch = epoch
+	}
+}
+
+// WithTimeFenceEpoch starts a new fence epoch, derived from the current time,
+// whenever the lock object is (re)created. This keeps fencing tokens
+// monotonic should the lock object be deleted (eg. by a lifecycle rule),
+// provided the lock object isn't recreated twice within a second.
+func WithTimeFenceEpoch() MutexOption {
+	return func(mu *Mutex) {
+		mu.timeFenceEpoch = true
+	}
+}
+
+// The origin of time derived fence epochs.
+var timeFenceEpochOrigin = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// applyFenceEpoch moves the lock object into the fence epoch of the mutex (if
+// newer).
+func (mu *Mutex) applyFenceEpoch(content *mutexContent, created bool) {
+	epoch := max(mu.fenceEpoch, provider.FenceEpochOf(mu.provider))
+	if created && mu.timeFenceEpoch {
+		epoch = max(epoch, int64(mu.now().Sub(timeFenceEpochOrigin)/time.Second))
+	}
+
+	if epoch <= content.Epoch {
+		return
+	}
+
+	content.Epoch = epoch
+	content.Fence = max(content.Fence, epoch<<fenceEpochShift)
+}
+
+// The highest fencing token returned for each lock in the process, keyed by
+// bucket and key (see WithFenceRegressionCheck).
+var (
+	highestFencesMu sync.Mutex
+	highestFences   = make(map[string]int64)
+)
+
+// observeFence records a fencing token returned for the lock, returning the
+// highest token previously returned.
+func (mu *Mutex) observeFence(fence int64) int64 {
+	highestFencesMu.Lock()
+	defer highestFencesMu.Unlock()
+
+	lockKey := mu.bucket + "/" + mu.key
+
+	highest := highestFences[lockKey]
+	highestFences[lockKey] = max(highest, fence)
+
+	return highest
+}
+
+// The JSON content of the fence sidecar object.
+type fenceSidecarContent struct {
+	Fence int64 `json:"fence,omitempty"`
+}
+
+// checkFence verifies that a newly acquired fencing token is greater than
+// any previously returned for the mutex (if enabled).
+func (mu *Mutex) checkFence(ctx context.Context, fence int64) error {
+	if !mu.fenceRegressionCheck && mu.fenceSidecarKey == "" {
+		return nil
+	}
+
+	var highest int64
+	if mu.fenceRegressionCheck {
+		highest = mu.observeFence(fence)
+	}
+
+	if mu.fenceSidecarKey != "" {
+		sidecarHighest, err := mu.updateFenceSidecar(ctx, fence)
+		if err != nil {
+			return fmt.Errorf("failed to update fence sidecar: %w", err)
+		}
+
+		highest = max(highest, sidecarHighest)
+	}
+
+	if fence <= highest {
+		if mu.onFenceRegression != nil {
+			mu.onFenceRegression(mu.key, fence, highest)
+		}
+
+		return fmt.Errorf("%w: %d <= %d", ErrFenceRegression, fence, highest)
+	}
+
+	return nil
+}
+
+// readFenceSidecar returns the highest fencing token recorded in the sidecar
+// object at the given key.
+func readFenceSidecar(ctx context.Context, p provider.Provider, bucket, key string) (int64, error) {
+	data, err := readObject(ctx, p, bucket, key)
+	if err != nil {
+		return -1, err
+	}
+
+	var content fenceSidecarContent
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &content); err != nil {
+			return -1, err
+		}
+	}
+
+	return content.Fence, nil
+}
+
+// updateFenceSidecar records the fencing token in the sidecar object (if it
+// is the highest seen), returning the previous highest fencing token.
+func (mu *Mutex) updateFenceSidecar(ctx context.Context, fence int64) (int64, error) {
+	return updateFenceSidecar(ctx, mu.provider, mu.bucket, mu.fenceSidecarKey, fence)
+}
+
+// updateFenceSidecar records the fencing token in the sidecar object at the
+// given key (if it is the highest seen), returning the previous highest
+// fencing token. The sidecar object is only written to if the fencing token
+// advances past the recorded one.
+func updateFenceSidecar(ctx context.Context, p provider.Provider, bucket, key string, fence int64) (int64, error) {
+	var errUpToDate = errors.New("up to date")
+
+	var highest int64
+	_, err := updateObject(ctx, p, bucket, key, func(_ string, currentData []byte) ([]byte, error) {
+		var content fenceSidecarContent
+		if len(currentData) > 0 {
+			if err := json.Unmarshal(currentData, &content); err != nil {
+				return nil, err
+			}
+		}
+
+		highest = content.Fence
+		if fence <= content.Fence {
+			return nil, errUpToDate
+		}
+		content.Fence = fence
+
+		return json.Marshal(content)
+	})
+	if err != nil && !errors.Is(err, errUpToDate) {
+		return -1, err
+	}
+
+	return highest, nil
+}
@@ -0,0 +1,71 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync
+
+import (
+	"context"
+	"time"
+
+	"github.com/dpeckett/objsync/provider"
+	"github.com/google/uuid"
+	"golang.org/x/sync/errgroup"
+)
+
+// LockedGroup is a collection of goroutines working on subtasks, where each
+// subtask runs under its own named lock. It combines the semantics of
+// golang.org/x/sync/errgroup with RunWithLock.
+type LockedGroup struct {
+	g        *errgroup.Group
+	ctx      context.Context
+	provider provider.Provider
+	bucket   string
+	length   time.Duration
+	opts     []MutexOption
+}
+
+// NewLockedGroup returns a new LockedGroup and an associated context derived
+// from ctx. The derived context is cancelled the first time a function passed
+// to Go returns a non-nil error or the first time Wait returns, whichever
+// occurs first. All of the group's mutexes share the same owner ID (unless
+// overridden by the provided options).
+func NewLockedGroup(ctx context.Context, p provider.Provider, bucket string, length time.Duration, opts ...MutexOption) (*LockedGroup, context.Context) {
+	g, ctx := errgroup.WithContext(ctx)
+
+	return &LockedGroup{
+		g:        g,
+		ctx:      ctx,
+		provider: p,
+		bucket:   bucket,
+		length:   length,
+		opts:     append([]MutexOption{WithOwnerID(uuid.New().String())}, opts...),
+	}, ctx
+}
+
+// Go calls the given function in a new goroutine, once the lock with the
+// given key has been acquired. The lock is kept alive while the function runs.
+func (g *LockedGroup) Go(key string, fn func(ctx context.Context, fencingToken int64) error) {
+	g.g.Go(func() error {
+		mu := NewMutex(g.provider, g.bucket, key, g.opts...)
+
+		return RunWithLock(g.ctx, mu, g.length, fn)
+	})
+}
+
+// SetLimit limits the number of active goroutines in this group to at most n.
+// A negative value indicates no limit.
+func (g *LockedGroup) SetLimit(n int) {
+	g.g.SetLimit(n)
+}
+
+// Wait blocks until all function calls from the Go method have returned,
+// then returns the first non-nil error (if any) from them.
+func (g *LockedGroup) Wait() error {
+	return g.g.Wait()
+}
@@ -0,0 +1,84 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dpeckett/objsync"
+	"github.com/dpeckett/objsync/provider/s3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLockedGroup(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	prefix := fmt.Sprintf("test-%d", time.Now().UnixNano())
+
+	ctx := context.Background()
+	p, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	require.NoError(t, err)
+
+	// Two workers competing for the same shards.
+	var processedMu sync.Mutex
+	processed := make(map[string]int)
+	active := make(map[string]int)
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			g, _ := objsync.NewLockedGroup(ctx, p, bucket, 5*time.Second)
+			for shard := 0; shard < 3; shard++ {
+				key := fmt.Sprintf("%s/shard-%d.lock", prefix, shard)
+
+				g.Go(key, func(ctx context.Context, _ int64) error {
+					processedMu.Lock()
+					active[key]++
+					n := active[key]
+					processedMu.Unlock()
+
+					// Verify each shard is processed by only one worker at a time.
+					if n > 1 {
+						return fmt.Errorf("shard is being processed by %d workers", n)
+					}
+
+					// Simulate some work.
+					time.Sleep(10 * time.Millisecond)
+
+					processedMu.Lock()
+					active[key]--
+					processed[key]++
+					processedMu.Unlock()
+
+					return nil
+				})
+			}
+
+			require.NoError(t, g.Wait())
+		}()
+	}
+
+	wg.Wait()
+
+	require.Len(t, processed, 3)
+	for _, n := range processed {
+		require.Equal(t, 2, n)
+	}
+}
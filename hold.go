@@ -0,0 +1,170 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/dpeckett/objsync/stats"
+)
+
+// ErrLockLost is the cause of a lock-scoped context being cancelled when the
+// hold on the lock has been lost (eg. it expired without being renewed).
+var ErrLockLost = errors.New("lock lost")
+
+// ErrLockTimeout is returned when the mutex could not be acquired within the
+// allotted time.
+var ErrLockTimeout = errors.New("timed out waiting for lock")
+
+// ErrNotHeld is returned when unlocking a mutex that is not (or is no longer)
+// held.
+var ErrNotHeld = errors.New("lock not held")
+
+// The state of the current hold on a mutex.
+type hold struct {
+	mu      sync.Mutex
+	timer   *time.Timer
+	cancels []context.CancelCauseFunc
+	done    chan struct{}
+}
+
+// A channel that is always closed.
+var closedChan = func() chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}()
+
+// Done returns a channel that is closed when the current hold on the mutex
+// ends, either because the mutex was unlocked, or because the hold was lost
+// (eg. it expired, or was observed to have been taken over by another owner).
+// If the mutex isn't held, the returned channel is already closed.
+func (mu *Mutex) Done() <-chan struct{} {
+	mu.hold.mu.Lock()
+	defer mu.hold.mu.Unlock()
+
+	if mu.hold.done == nil {
+		return closedChan
+	}
+
+	return mu.hold.done
+}
+
+// LockContext acquires the mutex, like Lock, but additionally returns a
+// context derived from ctx that is cancelled when the mutex is unlocked, or
+// with a cause of ErrLockLost, the moment the hold on the lock is lost.
+func (mu *Mutex) LockContext(ctx context.Context, length time.Duration) (context.Context, int64, error) {
+	fencingToken, err := mu.Lock(ctx, length)
+	if err != nil {
+		return nil, -1, err
+	}
+
+	lockCtx, cancel := context.WithCancelCause(ctx)
+
+	mu.hold.mu.Lock()
+	mu.hold.cancels = append(mu.hold.cancels, cancel)
+	mu.hold.mu.Unlock()
+
+	return lockCtx, fencingToken, nil
+}
+
+// acquired is called when a hold on the mutex has been acquired (or renewed).
+func (mu *Mutex) acquired(expires time.Time) {
+	mu.scheduleExpiryWarning(expires)
+
+	mu.hold.mu.Lock()
+	defer mu.hold.mu.Unlock()
+
+	if mu.hold.timer != nil {
+		mu.hold.timer.Stop()
+	}
+
+	if mu.hold.done == nil {
+		mu.hold.done = make(chan struct{})
+	}
+
+	cause := ErrLockLost
+	if mu.lease != nil {
+		cause = errLeaseLapsed
+	}
+
+	_, fencingToken, _ := mu.holdState()
+	mu.hold.timer = time.AfterFunc(mu.until(expires), func() {
+		mu.cancelHold(cause)
+		mu.emit(context.Background(), stats.Event{Type: stats.EventLost, FencingToken: fencingToken})
+	})
+}
+
+// holdState returns the ETag of the lock object (empty if the mutex isn't
+// held), the fencing token, and the acquisition time of the current hold.
+func (mu *Mutex) holdState() (string, int64, time.Time) {
+	mu.stateMu.Lock()
+	defer mu.stateMu.Unlock()
+
+	return mu.etag, mu.fencingToken, mu.acquiredAt
+}
+
+// lastError returns when the most recent error encountered by the mutex
+// occurred, and the error.
+func (mu *Mutex) lastError() (time.Time, error) {
+	mu.stateMu.Lock()
+	defer mu.stateMu.Unlock()
+
+	return mu.lastErrAt, mu.lastErr
+}
+
+// setHold records a newly acquired hold on the mutex.
+func (mu *Mutex) setHold(etag string, fencingToken int64) {
+	mu.stateMu.Lock()
+	defer mu.stateMu.Unlock()
+
+	mu.etag = etag
+	mu.fencingToken = fencingToken
+	mu.acquiredAt = mu.now()
+}
+
+// setETag records the ETag of the lock object after the current hold was
+// updated (or an empty ETag once it's released, or lost).
+func (mu *Mutex) setETag(etag string) {
+	mu.stateMu.Lock()
+	defer mu.stateMu.Unlock()
+
+	mu.etag = etag
+}
+
+// released is called when the hold on the mutex has been released.
+func (mu *Mutex) released() {
+	mu.stopExpiryWarning()
+	mu.cancelHold(nil)
+}
+
+// cancelHold cancels any lock-scoped contexts of the current hold.
+func (mu *Mutex) cancelHold(cause error) {
+	mu.hold.mu.Lock()
+	defer mu.hold.mu.Unlock()
+
+	if mu.hold.timer != nil {
+		mu.hold.timer.Stop()
+		mu.hold.timer = nil
+	}
+
+	for _, cancel := range mu.hold.cancels {
+		cancel(cause)
+	}
+	mu.hold.cancels = nil
+
+	if mu.hold.done != nil {
+		close(mu.hold.done)
+		mu.hold.done = nil
+	}
+}
@@ -0,0 +1,60 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync
+
+import (
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"time"
+)
+
+// Information about the process holding a lock.
+type holder struct {
+	Hostname  string     `json:"hostname,omitempty"`
+	PID       int        `json:"pid,omitempty"`
+	Binary    string     `json:"binary,omitempty"`
+	Version   string     `json:"version,omitempty"`
+	StartTime *time.Time `json:"startTime,omitempty"`
+}
+
+// The environment of the current process, captured at startup.
+var currentHolder = newHolder()
+
+func newHolder() *holder {
+	startTime := time.Now().UTC()
+
+	h := &holder{
+		PID:       os.Getpid(),
+		StartTime: &startTime,
+	}
+
+	if hostname, err := os.Hostname(); err == nil {
+		h.Hostname = hostname
+	}
+
+	if executable, err := os.Executable(); err == nil {
+		h.Binary = filepath.Base(executable)
+	}
+
+	if buildInfo, ok := debug.ReadBuildInfo(); ok {
+		h.Version = buildInfo.Main.Version
+	}
+
+	return h
+}
+
+// WithoutHolderInfo disables recording information about the holding
+// process (hostname, PID, binary version, and start time) in the lock object.
+func WithoutHolderInfo() MutexOption {
+	return func(mu *Mutex) {
+		mu.withoutHolder = true
+	}
+}
@@ -0,0 +1,167 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/dpeckett/objsync/stats"
+)
+
+// RenewalPolicy configures how the keepalive (see LockWithKeepAlive) reacts
+// to failed renewals of the lock.
+type RenewalPolicy struct {
+	// MaxFailures is the number of consecutive failed renewals tolerated
+	// (each is retried on the next renewal), before the hold is given up on.
+	// If zero, renewals are retried until the hold expires.
+	MaxFailures int
+	// Reacquire makes a last-ditch attempt to acquire the lock again, under
+	// the same owner ID, before giving up on the hold (whether the retry
+	// budget was exhausted, or the hold was lost). The current hold ends
+	// regardless (cancelling the lock scoped contexts, see LockContext), as
+	// the lock is reacquired under a new fencing token (reported to
+	// OnReacquired), after which the keepalive carries on. In reentrant mode
+	// the existing holds are kept, rather than taking another.
+	Reacquire bool
+	// OnReacquired is called with the new fencing token once the lock has
+	// been reacquired (see Reacquire).
+	OnReacquired func(fencingToken int64)
+	// OnLost is called once the hold has been given up on, after the lock
+	// scoped contexts (see LockContext) have been cancelled.
+	OnLost func(err error)
+}
+
+// WithRenewalPolicy sets the policy for failed renewals of locks kept alive
+// in the background (see RenewalPolicy).
+func WithRenewalPolicy(policy RenewalPolicy) MutexOption {
+	return func(mu *Mutex) {
+		mu.renewalPolicy = policy
+	}
+}
+
+// LockWithKeepAlive acquires the mutex, like Lock, but additionally keeps the
+// lock alive in the background (renewing it every ttl/3), until the mutex is
+// unlocked, or ctx is cancelled. Use LockContext (or RunWithLock) to find out
+// if the lock is lost despite the keepalive, and WithRenewalPolicy to
+// configure how failed renewals are handled.
+func (mu *Mutex) LockWithKeepAlive(ctx context.Context, ttl time.Duration) (int64, error) {
+	fencingToken, err := mu.Lock(ctx, ttl)
+	if err != nil {
+		return -1, err
+	}
+
+	mu.startKeepAlive(ctx, ttl)
+
+	return fencingToken, nil
+}
+
+// startKeepAlive starts a background goroutine that periodically renews the
+// current hold on the mutex, until the mutex is unlocked, the hold is lost,
+// or the context is cancelled. Locks attached to a lease are kept alive by
+// the lease instead.
+func (mu *Mutex) startKeepAlive(ctx context.Context, length time.Duration) {
+	mu.stopKeepAlive()
+
+	if mu.lease != nil {
+		return
+	}
+
+	length, err := mu.clampTTL(length)
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+
+	mu.keepAliveStop = func() {
+		cancel()
+		<-done
+	}
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(length / 3)
+		defer ticker.Stop()
+
+		policy := mu.renewalPolicy
+
+		var failures int
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				err := mu.renew(ctx, length)
+				if err == nil {
+					failures = 0
+					continue
+				}
+
+				// Stopped in the meantime.
+				if ctx.Err() != nil {
+					return
+				}
+
+				// Transient errors are retried on the next tick (within the
+				// retry budget), if the hold expires in the meantime, it will
+				// be reported as lost.
+				failures++
+				if !errors.Is(err, ErrLockLost) && (policy.MaxFailures == 0 || failures < policy.MaxFailures) {
+					continue
+				}
+
+				// The current hold ends either way, as a reacquired lock has
+				// a new fencing token.
+				mu.endHold(err)
+
+				if policy.Reacquire {
+					if fencingToken, reacquireErr := mu.acquire(ctx, length, true); reacquireErr == nil {
+						failures = 0
+						if policy.OnReacquired != nil {
+							policy.OnReacquired(fencingToken)
+						}
+						continue
+					}
+				}
+
+				if policy.OnLost != nil {
+					policy.OnLost(err)
+				}
+				return
+			}
+		}
+	}()
+}
+
+// endHold ends the current hold on the mutex, after its renewal failed,
+// cancelling the lock scoped contexts (unless the hold was lost, and so has
+// already ended).
+func (mu *Mutex) endHold(err error) {
+	if errors.Is(err, ErrLockLost) {
+		return
+	}
+
+	_, fencingToken, _ := mu.holdState()
+
+	mu.cancelHold(ErrLockLost)
+	mu.emit(context.Background(), stats.Event{Type: stats.EventLost, FencingToken: fencingToken, Err: err})
+}
+
+// stopKeepAlive stops renewing the current hold on the mutex (if running).
+func (mu *Mutex) stopKeepAlive() {
+	if mu.keepAliveStop != nil {
+		mu.keepAliveStop()
+		mu.keepAliveStop = nil
+	}
+}
@@ -0,0 +1,235 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+
+	"github.com/dpeckett/objsync/provider"
+)
+
+// ErrVersionMismatch is returned when a conditional write to a key-value map
+// fails because the key has been modified since the expected version.
+var ErrVersionMismatch = errors.New("version mismatch")
+
+// AnyVersion can be passed as the expected version to write to a key
+// unconditionally.
+const AnyVersion int64 = -1
+
+// KV is a small strongly consistent key-value map, stored in a single object
+// (eg. for configuration). Every write bumps the revision of the map, and the
+// version of a key is the revision at which it was last modified (zero if the
+// key doesn't exist), so that writes can be made conditional.
+type KV struct {
+	provider provider.Provider
+	bucket   string
+	key      string
+}
+
+// The current schema version of the key-value map object.
+const kvSchemaVersion = 1
+
+// The JSON content of the key-value map object.
+type kvContent struct {
+	SchemaVersion int                 `json:"schemaVersion,omitempty"`
+	Revision      int64               `json:"revision,omitempty"`
+	Entries       map[string]*kvEntry `json:"entries,omitempty"`
+}
+
+type kvEntry struct {
+	Value   []byte `json:"value"`
+	Version int64  `json:"version"`
+}
+
+// NewKV creates a new distributed key-value map.
+func NewKV(p provider.Provider, bucket, key string) *KV {
+	return &KV{
+		provider: p,
+		bucket:   bucket,
+		key:      key,
+	}
+}
+
+// Get returns the value and version of a key. If the key doesn't exist, the
+// value is nil and the version is zero.
+func (kv *KV) Get(ctx context.Context, name string) ([]byte, int64, error) {
+	data, err := readObject(ctx, kv.provider, kv.bucket, kv.key)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	content, err := decodeKVContent(data)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	entry, ok := content.Entries[name]
+	if !ok {
+		return nil, 0, nil
+	}
+
+	return entry.Value, entry.Version, nil
+}
+
+// Put sets the value of a key, if its current version is ifVersion (zero
+// meaning that the key must not exist, or AnyVersion to write
+// unconditionally). It returns the new version of the key.
+func (kv *KV) Put(ctx context.Context, name string, value []byte, ifVersion int64) (int64, error) {
+	return kv.Txn(ctx, func(txn *KVTxn) error {
+		if _, version := txn.Get(name); ifVersion != AnyVersion && version != ifVersion {
+			return ErrVersionMismatch
+		}
+
+		txn.Put(name, value)
+
+		return nil
+	})
+}
+
+// Delete removes a key, if its current version is ifVersion (or AnyVersion to
+// delete unconditionally).
+func (kv *KV) Delete(ctx context.Context, name string, ifVersion int64) error {
+	_, err := kv.Txn(ctx, func(txn *KVTxn) error {
+		if _, version := txn.Get(name); ifVersion != AnyVersion && version != ifVersion {
+			return ErrVersionMismatch
+		}
+
+		txn.Delete(name)
+
+		return nil
+	})
+	return err
+}
+
+// Txn atomically applies the changes made by fn to the map. Fn is passed a
+// consistent view of the map, and may be called more than once if the map is
+// modified concurrently, so it should not have side effects. If fn returns an
+// error, no changes are made. It returns the new revision of the map.
+func (kv *KV) Txn(ctx context.Context, fn func(txn *KVTxn) error) (int64, error) {
+	var errNoChanges = errors.New("no changes")
+
+	var revision int64
+	_, err := updateObject(ctx, kv.provider, kv.bucket, kv.key, func(_ string, currentData []byte) ([]byte, error) {
+		content, err := decodeKVContent(currentData)
+		if err != nil {
+			return nil, err
+		}
+
+		txn := &KVTxn{
+			entries: content.Entries,
+			changes: make(map[string]*kvEntry),
+		}
+
+		if err := fn(txn); err != nil {
+			return nil, err
+		}
+
+		revision = content.Revision
+		if len(txn.changes) == 0 {
+			return nil, errNoChanges
+		}
+
+		content.Revision++
+		for name, entry := range txn.changes {
+			if entry == nil {
+				delete(content.Entries, name)
+				continue
+			}
+
+			entry.Version = content.Revision
+			content.Entries[name] = entry
+		}
+		revision = content.Revision
+
+		return json.Marshal(content)
+	})
+	if err != nil && !errors.Is(err, errNoChanges) {
+		return 0, err
+	}
+
+	return revision, nil
+}
+
+// KVTxn is a view of a key-value map within a transaction.
+type KVTxn struct {
+	entries map[string]*kvEntry
+	// Pending changes, a nil entry is a deletion.
+	changes map[string]*kvEntry
+}
+
+// Get returns the value and version of a key, including any changes made
+// earlier in the transaction. If the key doesn't exist, the value is nil and
+// the version is zero.
+func (txn *KVTxn) Get(name string) ([]byte, int64) {
+	entry, ok := txn.changes[name]
+	if !ok {
+		entry = txn.entries[name]
+	}
+
+	if entry == nil {
+		return nil, 0
+	}
+
+	return entry.Value, entry.Version
+}
+
+// Put sets the value of a key.
+func (txn *KVTxn) Put(name string, value []byte) {
+	_, version := txn.Get(name)
+	txn.changes[name] = &kvEntry{Value: value, Version: version}
+}
+
+// Delete removes a key.
+func (txn *KVTxn) Delete(name string) {
+	txn.changes[name] = nil
+}
+
+// Keys returns the keys in the map, in sorted order.
+func (txn *KVTxn) Keys() []string {
+	var names []string
+	for name := range txn.entries {
+		if entry, ok := txn.changes[name]; !ok || entry != nil {
+			names = append(names, name)
+		}
+	}
+
+	for name, entry := range txn.changes {
+		if _, ok := txn.entries[name]; !ok && entry != nil {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// decodeKVContent decodes the key-value map object.
+func decodeKVContent(data []byte) (*kvContent, error) {
+	var content kvContent
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &content); err != nil {
+			return nil, err
+		}
+	}
+
+	if content.SchemaVersion == 0 {
+		content.SchemaVersion = kvSchemaVersion
+	}
+
+	if content.Entries == nil {
+		content.Entries = make(map[string]*kvEntry)
+	}
+
+	return &content, nil
+}
@@ -0,0 +1,104 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/dpeckett/objsync"
+	"github.com/dpeckett/objsync/provider/s3"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestKV(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	ctx := context.Background()
+	p, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	require.NoError(t, err)
+
+	t.Run("Put", func(t *testing.T) {
+		kv := objsync.NewKV(p, bucket, fmt.Sprintf("test-%d.kv", time.Now().UnixNano()))
+
+		value, version, err := kv.Get(ctx, "foo")
+		require.NoError(t, err)
+		require.Nil(t, value)
+		require.Zero(t, version)
+
+		version, err = kv.Put(ctx, "foo", []byte("bar"), 0)
+		require.NoError(t, err)
+
+		// The key already exists.
+		_, err = kv.Put(ctx, "foo", []byte("baz"), 0)
+		require.ErrorIs(t, err, objsync.ErrVersionMismatch)
+
+		_, err = kv.Put(ctx, "foo", []byte("baz"), version)
+		require.NoError(t, err)
+
+		value, _, err = kv.Get(ctx, "foo")
+		require.NoError(t, err)
+		require.Equal(t, "baz", string(value))
+
+		require.ErrorIs(t, kv.Delete(ctx, "foo", version), objsync.ErrVersionMismatch)
+		require.NoError(t, kv.Delete(ctx, "foo", objsync.AnyVersion))
+
+		value, _, err = kv.Get(ctx, "foo")
+		require.NoError(t, err)
+		require.Nil(t, value)
+	})
+
+	t.Run("Txn", func(t *testing.T) {
+		kv := objsync.NewKV(p, bucket, fmt.Sprintf("test-%d.kv", time.Now().UnixNano()))
+
+		g, gctx := errgroup.WithContext(ctx)
+		for i := 0; i < 5; i++ {
+			g.Go(func() error {
+				_, err := kv.Txn(gctx, func(txn *objsync.KVTxn) error {
+					value, _ := txn.Get("counter")
+
+					n := 0
+					if value != nil {
+						var err error
+						if n, err = strconv.Atoi(string(value)); err != nil {
+							return err
+						}
+					}
+
+					txn.Put("counter", []byte(strconv.Itoa(n+1)))
+					txn.Put(fmt.Sprintf("worker-%d", n), nil)
+
+					return nil
+				})
+				return err
+			})
+		}
+
+		require.NoError(t, g.Wait())
+
+		value, _, err := kv.Get(ctx, "counter")
+		require.NoError(t, err)
+		require.Equal(t, "5", string(value))
+
+		_, err = kv.Txn(ctx, func(txn *objsync.KVTxn) error {
+			require.Equal(t, []string{"counter", "worker-0", "worker-1", "worker-2", "worker-3", "worker-4"}, txn.Keys())
+			return nil
+		})
+		require.NoError(t, err)
+	})
+}
@@ -0,0 +1,149 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/dpeckett/objsync/provider"
+)
+
+// CountDownLatchOption is a functional option for configuring a countdown
+// latch.
+type CountDownLatchOption func(*CountDownLatch)
+
+// WithLatchPollInterval sets how often callers of Wait check whether the
+// latch has reached zero.
+func WithLatchPollInterval(interval time.Duration) CountDownLatchOption {
+	return func(l *CountDownLatch) {
+		l.pollInterval = interval
+	}
+}
+
+// CountDownLatch is a distributed countdown latch. It is initialized with a
+// count, which is decremented by calls to CountDown, and Wait blocks until
+// the count reaches zero. The latch can't be reset, use a new key instead.
+type CountDownLatch struct {
+	provider     provider.Provider
+	bucket       string
+	key          string
+	count        int64
+	pollInterval time.Duration
+}
+
+// The current schema version of the countdown latch object.
+const countDownLatchSchemaVersion = 1
+
+// The JSON content of the countdown latch object.
+type countDownLatchContent struct {
+	SchemaVersion int `json:"schemaVersion,omitempty"`
+	// Whether the count has been initialized (as it's omitted once it
+	// reaches zero).
+	Initialized bool  `json:"initialized,omitempty"`
+	Count       int64 `json:"count,omitempty"`
+}
+
+// NewCountDownLatch creates a new distributed countdown latch, initialized
+// with the given count.
+func NewCountDownLatch(p provider.Provider, bucket, key string, count int64, opts ...CountDownLatchOption) *CountDownLatch {
+	l := &CountDownLatch{
+		provider:     p,
+		bucket:       bucket,
+		key:          key,
+		count:        count,
+		pollInterval: 100 * time.Millisecond,
+	}
+
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	return l
+}
+
+// CountDown decrements the count of the latch, if the count is already zero
+// nothing happens.
+func (l *CountDownLatch) CountDown(ctx context.Context) error {
+	_, err := updateObject(ctx, l.provider, l.bucket, l.key, func(_ string, currentData []byte) ([]byte, error) {
+		content, err := l.decode(currentData)
+		if err != nil {
+			return nil, err
+		}
+
+		if content.Count > 0 {
+			content.Count--
+		}
+
+		return json.Marshal(content)
+	})
+	return err
+}
+
+// Count returns the current count of the latch.
+func (l *CountDownLatch) Count(ctx context.Context) (int64, error) {
+	data, err := readObject(ctx, l.provider, l.bucket, l.key)
+	if err != nil {
+		return -1, err
+	}
+
+	content, err := l.decode(data)
+	if err != nil {
+		return -1, err
+	}
+
+	return content.Count, nil
+}
+
+// Wait blocks until the count of the latch reaches zero, or ctx is done.
+func (l *CountDownLatch) Wait(ctx context.Context) error {
+	ticker := time.NewTicker(l.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		count, err := l.Count(ctx)
+		if err != nil {
+			return err
+		}
+
+		if count == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// decode decodes the countdown latch object.
+func (l *CountDownLatch) decode(data []byte) (*countDownLatchContent, error) {
+	var content countDownLatchContent
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &content); err != nil {
+			return nil, err
+		}
+	}
+
+	if content.SchemaVersion == 0 {
+		content.SchemaVersion = countDownLatchSchemaVersion
+	}
+
+	// The first user of the latch gets to decide its count.
+	if !content.Initialized {
+		content.Initialized = true
+		content.Count = l.count
+	}
+
+	return &content, nil
+}
@@ -0,0 +1,64 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dpeckett/objsync"
+	"github.com/dpeckett/objsync/provider/s3"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestCountDownLatch(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	key := fmt.Sprintf("test-%d.latch", time.Now().UnixNano())
+
+	ctx := context.Background()
+	p, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	require.NoError(t, err)
+
+	const workers = 3
+
+	var completed int32
+	g, gctx := errgroup.WithContext(ctx)
+	for i := 0; i < workers; i++ {
+		g.Go(func() error {
+			time.Sleep(time.Duration(i*20) * time.Millisecond)
+
+			atomic.AddInt32(&completed, 1)
+
+			return objsync.NewCountDownLatch(p, bucket, key, workers).CountDown(gctx)
+		})
+	}
+
+	latch := objsync.NewCountDownLatch(p, bucket, key, workers)
+	require.NoError(t, latch.Wait(ctx))
+	require.Equal(t, int32(workers), atomic.LoadInt32(&completed))
+
+	require.NoError(t, g.Wait())
+
+	// Counting down past zero has no effect.
+	require.NoError(t, latch.CountDown(ctx))
+
+	count, err := latch.Count(ctx)
+	require.NoError(t, err)
+	require.Zero(t, count)
+}
@@ -0,0 +1,547 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dpeckett/objsync/provider"
+	"github.com/google/uuid"
+)
+
+// ErrLeaseNotGranted is returned when using a lease that hasn't been granted,
+// or that has since been revoked or lost.
+var ErrLeaseNotGranted = errors.New("lease not granted")
+
+// ErrLeaseExpired is returned when using a lease that has expired, as it
+// wasn't kept alive in time. It matches ErrLeaseNotGranted, and the lock
+// scoped contexts of attached locks are cancelled with a cause matching both
+// it and ErrLockLost.
+var ErrLeaseExpired error = leaseExpiredError{}
+
+type leaseExpiredError struct{}
+
+func (leaseExpiredError) Error() string {
+	return "lease expired"
+}
+
+func (leaseExpiredError) Is(target error) bool {
+	return target == ErrLeaseNotGranted
+}
+
+// The cause of the hold on an attached lock ending as the lease expired.
+var errLeaseLapsed = fmt.Errorf("%w: %w", ErrLockLost, ErrLeaseExpired)
+
+// Lease is a distributed lease, modelled after etcd's leases. A lease is
+// granted with a ttl, and is kept alive by a single heartbeat. Mutexes can be
+// attached to a lease (see WithLease), in which case they are held for as long
+// as the lease is alive, so that one heartbeat can maintain many locks (and
+// leader elections, and group memberships, see WithMembershipLease).
+//
+// Each lease should have its own key (eg. "leases/$hostname/$pod").
+type Lease struct {
+	provider   provider.Provider
+	bucket     string
+	key        string
+	clock      Clock
+	skewMargin time.Duration
+
+	mu            sync.Mutex
+	id            string
+	ttl           time.Duration
+	expires       time.Time
+	done          chan struct{}
+	attached      map[*Mutex]struct{}
+	members       map[*Membership]struct{}
+	keepAliveStop func()
+}
+
+// The current schema version of the lease object.
+const leaseSchemaVersion = 1
+
+// The JSON content of the lease object.
+type leaseContent struct {
+	SchemaVersion int        `json:"schemaVersion,omitempty"`
+	ID            string     `json:"id,omitempty"`
+	Expires       *time.Time `json:"expires,omitempty"`
+}
+
+// A reference to the lease a lock is attached to.
+type leaseRef struct {
+	Bucket string `json:"bucket"`
+	Key    string `json:"key"`
+	ID     string `json:"id"`
+}
+
+// LeaseOption is a functional option for configuring a lease.
+type LeaseOption func(*Lease)
+
+// WithLeaseClock sets the clock used to compute and check the expiry of the
+// lease (see WithClock).
+func WithLeaseClock(clock Clock) LeaseOption {
+	return func(l *Lease) {
+		l.clock = clock
+	}
+}
+
+// WithLeaseSkewMargin treats a lease previously granted (under the same key)
+// as alive for the given margin beyond its expiry (see WithSkewMargin).
+func WithLeaseSkewMargin(margin time.Duration) LeaseOption {
+	return func(l *Lease) {
+		l.skewMargin = margin
+	}
+}
+
+// NewLease creates a new distributed lease.
+func NewLease(p provider.Provider, bucket, key string, opts ...LeaseOption) *Lease {
+	l := &Lease{
+		provider: p,
+		bucket:   bucket,
+		key:      key,
+	}
+
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	return l
+}
+
+// now returns the current time, according to the lease's clock.
+func (l *Lease) now() time.Time {
+	if l.clock == nil {
+		return time.Now()
+	}
+
+	return l.clock.Now()
+}
+
+// ID returns the ID of the current lease, or an empty string if the lease
+// hasn't been granted.
+func (l *Lease) ID() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.id
+}
+
+// Done returns a channel that is closed when the current lease is revoked or
+// lost. It returns nil if the lease hasn't been granted.
+func (l *Lease) Done() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.done
+}
+
+// Grant grants a new lease that expires after the ttl, unless it is kept
+// alive. Any previous lease granted by this instance is revoked.
+func (l *Lease) Grant(ctx context.Context, ttl time.Duration) error {
+	if ttl <= 0 {
+		return fmt.Errorf("%w: %s", ErrInvalidTTL, ttl)
+	}
+
+	if l.ID() != "" {
+		if err := l.Revoke(ctx); err != nil {
+			return err
+		}
+	}
+
+	id := uuid.New().String()
+	var expires time.Time
+	_, err := updateObject(ctx, l.provider, l.bucket, l.key, func(_ string, currentData []byte) ([]byte, error) {
+		content, err := decodeLeaseContent(currentData, l.now().Add(-l.skewMargin))
+		if err != nil {
+			return nil, err
+		}
+
+		if content.ID != "" {
+			return nil, fmt.Errorf("lease %q is already granted", content.ID)
+		}
+
+		expires = l.now().Add(ttl).UTC()
+		content.ID = id
+		content.Expires = &expires
+
+		return json.Marshal(content)
+	})
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.id = id
+	l.ttl = ttl
+	l.expires = expires
+	l.done = make(chan struct{})
+
+	return nil
+}
+
+// KeepAlive keeps the lease alive in the background, until it is revoked, it
+// is lost, or ctx is done. If the lease has already expired, ErrLeaseExpired
+// is returned.
+func (l *Lease) KeepAlive(ctx context.Context) error {
+	l.stopKeepAlive()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.id == "" {
+		return ErrLeaseNotGranted
+	}
+
+	if l.now().After(l.expires) {
+		return ErrLeaseExpired
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+
+	l.keepAliveStop = func() {
+		cancel()
+		<-done
+	}
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(l.ttl / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				// Transient errors are retried on the next tick, if the lease
+				// expires in the meantime, it will be reported as lost.
+				if err := l.renew(ctx); errors.Is(err, ErrLeaseNotGranted) {
+					return
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Revoke revokes the lease, releasing any attached locks.
+func (l *Lease) Revoke(ctx context.Context) error {
+	l.stopKeepAlive()
+
+	id := l.ID()
+	if id == "" {
+		return nil
+	}
+
+	_, err := updateObject(ctx, l.provider, l.bucket, l.key, func(_ string, currentData []byte) ([]byte, error) {
+		content, err := decodeLeaseContent(currentData, l.now())
+		if err != nil {
+			return nil, err
+		}
+
+		// The lease has already expired.
+		if content.ID != id {
+			return nil, ErrLeaseNotGranted
+		}
+
+		content.ID = ""
+		content.Expires = nil
+
+		return json.Marshal(content)
+	})
+	if err != nil && !errors.Is(err, ErrLeaseNotGranted) {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Lost in the meantime.
+	if l.id != id {
+		return nil
+	}
+
+	for mu := range l.attached {
+		mu.released()
+	}
+	l.end()
+
+	return nil
+}
+
+// renew extends the lease by its ttl. If the lease has expired,
+// ErrLeaseExpired is returned (or ErrLeaseNotGranted, if it was revoked).
+func (l *Lease) renew(ctx context.Context) error {
+	l.mu.Lock()
+	id, ttl := l.id, l.ttl
+	l.mu.Unlock()
+
+	if id == "" {
+		return ErrLeaseNotGranted
+	}
+
+	var expires time.Time
+	_, err := updateObject(ctx, l.provider, l.bucket, l.key, func(_ string, currentData []byte) ([]byte, error) {
+		content, err := decodeLeaseContent(currentData, l.now())
+		if err != nil {
+			return nil, err
+		}
+
+		if content.ID != id {
+			return nil, ErrLeaseExpired
+		}
+
+		expires = l.now().Add(ttl).UTC()
+		content.Expires = &expires
+
+		return json.Marshal(content)
+	})
+
+	l.mu.Lock()
+
+	// Revoked in the meantime.
+	if l.id != id {
+		l.mu.Unlock()
+		return ErrLeaseNotGranted
+	}
+
+	if err != nil {
+		if errors.Is(err, ErrLeaseNotGranted) {
+			for mu := range l.attached {
+				mu.cancelHold(errLeaseLapsed)
+			}
+			l.end()
+		}
+
+		l.mu.Unlock()
+		return err
+	}
+
+	l.expires = expires
+	attached := make([]*Mutex, 0, len(l.attached))
+	for mu := range l.attached {
+		mu.acquired(expires)
+		attached = append(attached, mu)
+	}
+
+	members := make([]*Membership, 0, len(l.members))
+	for m := range l.members {
+		members = append(members, m)
+	}
+
+	l.mu.Unlock()
+
+	for _, mu := range attached {
+		mu.mirrorLeaseExpiry(ctx, id, expires, ttl)
+	}
+
+	for _, m := range members {
+		m.leaseRenewed(ctx, l, expires)
+	}
+
+	return nil
+}
+
+// attach attaches a lock to the lease, returning the reference to record in
+// the lock object, and the current expiry of the lease.
+func (l *Lease) attach(mu *Mutex) (*leaseRef, time.Time, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.checkAlive(); err != nil {
+		return nil, time.Time{}, err
+	}
+
+	if l.attached == nil {
+		l.attached = make(map[*Mutex]struct{})
+	}
+	l.attached[mu] = struct{}{}
+
+	return &leaseRef{Bucket: l.bucket, Key: l.key, ID: l.id}, l.expires, nil
+}
+
+// detach detaches a lock from the lease.
+func (l *Lease) detach(mu *Mutex) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.attached, mu)
+}
+
+// attachMember attaches a group membership to the lease, returning the
+// current expiry of the lease.
+func (l *Lease) attachMember(m *Membership) (time.Time, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.checkAlive(); err != nil {
+		return time.Time{}, err
+	}
+
+	if l.members == nil {
+		l.members = make(map[*Membership]struct{})
+	}
+	l.members[m] = struct{}{}
+
+	return l.expires, nil
+}
+
+// detachMember detaches a group membership from the lease.
+func (l *Lease) detachMember(m *Membership) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.members, m)
+}
+
+// checkAlive checks that the lease is granted, and hasn't expired, l.mu must
+// be held.
+func (l *Lease) checkAlive() error {
+	if l.id == "" {
+		return ErrLeaseNotGranted
+	}
+
+	if l.now().After(l.expires) {
+		return ErrLeaseExpired
+	}
+
+	return nil
+}
+
+// end forgets the current lease, l.mu must be held.
+func (l *Lease) end() {
+	l.id = ""
+	l.attached = nil
+	l.members = nil
+	if l.done != nil {
+		close(l.done)
+	}
+}
+
+// stopKeepAlive stops keeping the lease alive (if running).
+func (l *Lease) stopKeepAlive() {
+	l.mu.Lock()
+	stop := l.keepAliveStop
+	l.keepAliveStop = nil
+	l.mu.Unlock()
+
+	// The keepalive goroutine takes l.mu, so it must not be held here.
+	if stop != nil {
+		stop()
+	}
+}
+
+// leaseAlive reports whether the referenced lease is still alive, as of now.
+func leaseAlive(ctx context.Context, p provider.Provider, ref *leaseRef, now time.Time) (bool, error) {
+	data, err := readObject(ctx, p, ref.Bucket, ref.Key)
+	if err != nil {
+		return false, err
+	}
+
+	content, err := decodeLeaseContent(data, now)
+	if err != nil {
+		return false, err
+	}
+
+	return content.ID == ref.ID, nil
+}
+
+// decodeLeaseContent decodes the lease object, clearing the lease if it has
+// expired as of now.
+func decodeLeaseContent(data []byte, now time.Time) (*leaseContent, error) {
+	var content leaseContent
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &content); err != nil {
+			return nil, err
+		}
+	}
+
+	if content.SchemaVersion == 0 {
+		content.SchemaVersion = leaseSchemaVersion
+	}
+
+	if content.Expires == nil || now.After(*content.Expires) {
+		content.ID = ""
+		content.Expires = nil
+	}
+
+	return &content, nil
+}
+
+// WithLease attaches the locks taken by the mutex to the given lease. Rather
+// than expiring after the requested length, the lock is held for as long as
+// the lease is kept alive (and is released when the lease is revoked). The
+// lease must have been granted before the mutex is locked.
+//
+// Clients that don't support leases only see the expiry recorded in the lock
+// object, so renewals of the lease also push out the recorded expiry (once it
+// gets within half a ttl of lapsing). Attaching a lease is not supported in
+// reentrant mode.
+func WithLease(l *Lease) MutexOption {
+	return func(mu *Mutex) {
+		mu.lease = l
+	}
+}
+
+// mirrorLeaseExpiry records the renewed expiry of the lease in the lock object
+// (if the recorded expiry is within half a ttl of lapsing), so that clients
+// that don't support leases don't take over the lock while the lease is
+// alive. This is best effort, failures are retried on the next renewal.
+func (mu *Mutex) mirrorLeaseExpiry(ctx context.Context, leaseID string, expires time.Time, ttl time.Duration) {
+	mu.renewMu.Lock()
+	defer mu.renewMu.Unlock()
+
+	if etag, _, _ := mu.holdState(); etag == "" {
+		return
+	}
+
+	errUpToDate := errors.New("up to date")
+
+	newETag, err := mu.provider.AtomicUpdateObject(ctx, mu.bucket, mu.key, func(currentETag string, currentData []byte) ([]byte, error) {
+		content, err := mu.decodeContent(currentData)
+		if err != nil {
+			return nil, err
+		}
+
+		// No longer held under the lease, which is caught by the lease checks.
+		if !mu.owns(currentETag, content) || content.Lease == nil || content.Lease.ID != leaseID {
+			return nil, errUpToDate
+		}
+
+		if content.Expires != nil && content.Expires.Sub(mu.now()) > ttl/2 {
+			return nil, errUpToDate
+		}
+
+		content.Expires = &expires
+
+		return mu.encodeContent(content)
+	})
+	if err != nil {
+		return
+	}
+
+	mu.setETag(newETag)
+}
+
+// detachLease detaches the mutex from its lease (if any).
+func (mu *Mutex) detachLease() {
+	if mu.lease != nil {
+		mu.lease.detach(mu)
+	}
+}
@@ -0,0 +1,189 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dpeckett/objsync"
+	"github.com/dpeckett/objsync/election"
+	"github.com/dpeckett/objsync/provider/s3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLease(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	ctx := context.Background()
+	p, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	require.NoError(t, err)
+
+	t.Run("KeepAlive", func(t *testing.T) {
+		key := fmt.Sprintf("test-%d", time.Now().UnixNano())
+
+		lease := objsync.NewLease(p, bucket, key+".lease")
+		require.NoError(t, lease.Grant(ctx, 300*time.Millisecond))
+		require.NoError(t, lease.KeepAlive(ctx))
+
+		// Several locks share the one lease.
+		var lockCtxs []context.Context
+		for i := 0; i < 2; i++ {
+			mu := objsync.NewMutex(p, bucket, fmt.Sprintf("%s-%d.lock", key, i), objsync.WithLease(lease))
+
+			lockCtx, _, err := mu.LockContext(ctx, time.Millisecond)
+			require.NoError(t, err)
+
+			lockCtxs = append(lockCtxs, lockCtx)
+		}
+
+		// The locks outlive the lease ttl, as the lease is kept alive.
+		time.Sleep(time.Second)
+
+		for i, lockCtx := range lockCtxs {
+			require.NoError(t, lockCtx.Err())
+
+			ok, _, err := objsync.NewMutex(p, bucket, fmt.Sprintf("%s-%d.lock", key, i)).TryLock(ctx, time.Second)
+			require.NoError(t, err)
+			require.False(t, ok)
+		}
+
+		// Revoking the lease releases the locks.
+		require.NoError(t, lease.Revoke(ctx))
+		<-lease.Done()
+
+		for i, lockCtx := range lockCtxs {
+			require.Error(t, lockCtx.Err())
+
+			ok, _, err := objsync.NewMutex(p, bucket, fmt.Sprintf("%s-%d.lock", key, i)).TryLock(ctx, time.Second)
+			require.NoError(t, err)
+			require.True(t, ok)
+		}
+	})
+
+	t.Run("MirrorExpiry", func(t *testing.T) {
+		key := fmt.Sprintf("test-%d", time.Now().UnixNano())
+
+		lease := objsync.NewLease(p, bucket, key+".lease")
+		require.NoError(t, lease.Grant(ctx, 300*time.Millisecond))
+		require.NoError(t, lease.KeepAlive(ctx))
+		t.Cleanup(func() {
+			_ = lease.Revoke(ctx)
+		})
+
+		mu := objsync.NewMutex(p, bucket, key+".lock", objsync.WithLease(lease))
+		_, err := mu.Lock(ctx, time.Minute)
+		require.NoError(t, err)
+
+		info, err := mu.GetLockInfo(ctx)
+		require.NoError(t, err)
+		acquiredExpiry := info.Expires
+
+		time.Sleep(time.Second)
+
+		// Renewals of the lease are mirrored into the lock object, so that
+		// clients that don't support leases don't take over the lock.
+		info, err = mu.GetLockInfo(ctx)
+		require.NoError(t, err)
+		require.True(t, info.Expires.After(acquiredExpiry))
+		require.True(t, info.Expires.After(time.Now()))
+
+		require.NoError(t, mu.Unlock(ctx))
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		key := fmt.Sprintf("test-%d", time.Now().UnixNano())
+
+		lease := objsync.NewLease(p, bucket, key+".lease")
+		require.NoError(t, lease.Grant(ctx, 300*time.Millisecond))
+
+		mu := objsync.NewMutex(p, bucket, key+".lock", objsync.WithLease(lease))
+		lockCtx, _, err := mu.LockContext(ctx, time.Minute)
+		require.NoError(t, err)
+
+		// Without a keepalive, the lease (and the lock) expires.
+		time.Sleep(500 * time.Millisecond)
+
+		require.ErrorIs(t, context.Cause(lockCtx), objsync.ErrLockLost)
+		require.ErrorIs(t, context.Cause(lockCtx), objsync.ErrLeaseExpired)
+
+		require.ErrorIs(t, lease.KeepAlive(ctx), objsync.ErrLeaseExpired)
+
+		_, _, err = objsync.NewMutex(p, bucket, key+".other.lock", objsync.WithLease(lease)).TryLock(ctx, time.Second)
+		require.ErrorIs(t, err, objsync.ErrLeaseExpired)
+		require.ErrorIs(t, err, objsync.ErrLeaseNotGranted)
+
+		ok, _, err := objsync.NewMutex(p, bucket, key+".lock").TryLock(ctx, time.Second)
+		require.NoError(t, err)
+		require.True(t, ok)
+	})
+
+	t.Run("Election", func(t *testing.T) {
+		key := fmt.Sprintf("test-%d", time.Now().UnixNano())
+
+		lease := objsync.NewLease(p, bucket, key+".lease")
+		require.NoError(t, lease.Grant(ctx, 300*time.Millisecond))
+		require.NoError(t, lease.KeepAlive(ctx))
+
+		leading := make(chan struct{})
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- election.Run(ctx, election.Config{
+				Provider:      p,
+				Bucket:        bucket,
+				Key:           key + ".lock",
+				LeaseDuration: 300 * time.Millisecond,
+				Lease:         lease,
+			}, election.Callbacks{
+				OnStartedLeading: func(context.Context) { close(leading) },
+				OnStoppedLeading: func() {},
+			})
+		}()
+
+		select {
+		case <-leading:
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected to become the leader")
+		}
+
+		// Leadership outlives the lease duration, as the lease is kept alive.
+		time.Sleep(time.Second)
+
+		info, err := objsync.Inspect(ctx, p, bucket, key+".lock")
+		require.NoError(t, err)
+		require.NotEmpty(t, info.Owner)
+
+		// Revoking the lease ends the leadership.
+		require.NoError(t, lease.Revoke(ctx))
+
+		select {
+		case <-errCh:
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected leadership to end")
+		}
+	})
+
+	t.Run("NotGranted", func(t *testing.T) {
+		key := fmt.Sprintf("test-%d", time.Now().UnixNano())
+
+		lease := objsync.NewLease(p, bucket, key+".lease")
+		require.ErrorIs(t, lease.KeepAlive(ctx), objsync.ErrLeaseNotGranted)
+
+		mu := objsync.NewMutex(p, bucket, key+".lock", objsync.WithLease(lease))
+		_, _, err := mu.TryLock(ctx, time.Second)
+		require.ErrorIs(t, err, objsync.ErrLeaseNotGranted)
+	})
+}
@@ -0,0 +1,279 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package loadtest drives configurable acquire/hold/release workloads against
+// a provider, so that backends and tuning changes can be compared
+// reproducibly.
+package loadtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dpeckett/objsync"
+	"github.com/dpeckett/objsync/provider"
+	"golang.org/x/sync/errgroup"
+)
+
+// Config configures a load test.
+type Config struct {
+	// Provider is the provider under test.
+	Provider provider.Provider
+	// Bucket is the bucket to create lock objects in.
+	Bucket string
+	// KeyPrefix is prepended to the keys of lock objects.
+	KeyPrefix string
+	// Keys is the number of distinct locks to contend for (defaults to 1).
+	Keys int
+	// Workers is the number of concurrent workers (defaults to 1).
+	Workers int
+	// Duration is how long to run the load test for. If zero, each worker
+	// performs Iterations acquisitions instead.
+	Duration time.Duration
+	// Iterations is the number of acquisitions performed by each worker, when
+	// no Duration is specified (defaults to 1).
+	Iterations int
+	// HoldTime is how long each lock is held for once acquired.
+	HoldTime time.Duration
+	// LockTTL is the duration of each lock (defaults to 30 seconds).
+	LockTTL time.Duration
+	// Seed seeds the random selection of keys, for reproducibility.
+	Seed int64
+}
+
+// Report is the result of a load test.
+type Report struct {
+	// Elapsed is the wall clock duration of the load test.
+	Elapsed time.Duration
+	// Acquisitions is the number of successful lock acquisitions.
+	Acquisitions int
+	// Errors is the number of failed lock operations.
+	Errors int
+	// Requests is the number of atomic update requests made to the provider.
+	Requests int64
+	// Conflicts is the number of requests that failed due to write conflicts.
+	Conflicts int64
+	// Violations is the number of correctness violations observed, ie. a lock
+	// being held by more than one worker, or a non-monotonic fencing token.
+	Violations int
+	// AcquireLatency is the distribution of lock acquisition latencies.
+	AcquireLatency LatencyDistribution
+}
+
+// ConflictRate returns the fraction of provider requests that failed due to
+// write conflicts.
+func (r *Report) ConflictRate() float64 {
+	if r.Requests == 0 {
+		return 0
+	}
+
+	return float64(r.Conflicts) / float64(r.Requests)
+}
+
+// Throughput returns the number of acquisitions per second.
+func (r *Report) Throughput() float64 {
+	if r.Elapsed == 0 {
+		return 0
+	}
+
+	return float64(r.Acquisitions) / r.Elapsed.Seconds()
+}
+
+func (r *Report) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "elapsed: %s\n", r.Elapsed)
+	fmt.Fprintf(&sb, "acquisitions: %d (%.2f/s)\n", r.Acquisitions, r.Throughput())
+	fmt.Fprintf(&sb, "errors: %d\n", r.Errors)
+	fmt.Fprintf(&sb, "requests: %d\n", r.Requests)
+	fmt.Fprintf(&sb, "conflicts: %d (%.2f%%)\n", r.Conflicts, 100*r.ConflictRate())
+	fmt.Fprintf(&sb, "violations: %d\n", r.Violations)
+	fmt.Fprintf(&sb, "acquire latency: %s\n", r.AcquireLatency)
+	return sb.String()
+}
+
+// LatencyDistribution summarizes a set of latency samples.
+type LatencyDistribution struct {
+	Min  time.Duration
+	Mean time.Duration
+	P50  time.Duration
+	P90  time.Duration
+	P99  time.Duration
+	Max  time.Duration
+}
+
+func (d LatencyDistribution) String() string {
+	return fmt.Sprintf("min=%s mean=%s p50=%s p90=%s p99=%s max=%s", d.Min, d.Mean, d.P50, d.P90, d.P99, d.Max)
+}
+
+// Run runs a load test.
+func Run(ctx context.Context, config Config) (*Report, error) {
+	if config.Provider == nil {
+		return nil, fmt.Errorf("provider must be specified")
+	}
+
+	keys := max(config.Keys, 1)
+	workers := max(config.Workers, 1)
+	iterations := max(config.Iterations, 1)
+
+	lockTTL := config.LockTTL
+	if lockTTL <= 0 {
+		lockTTL = 30 * time.Second
+	}
+
+	p := &countingProvider{next: config.Provider}
+
+	var deadline time.Time
+	if config.Duration > 0 {
+		deadline = time.Now().Add(config.Duration)
+	}
+
+	var (
+		mu         sync.Mutex
+		latencies  []time.Duration
+		errorCount int
+		violations int
+	)
+
+	holders := make([]atomic.Int32, keys)
+	lastFencingTokens := make([]int64, keys)
+
+	start := time.Now()
+
+	var g errgroup.Group
+	for i := 0; i < workers; i++ {
+		rng := rand.New(rand.NewSource(config.Seed + int64(i)))
+
+		mutexes := make([]*objsync.Mutex, keys)
+		for k := range mutexes {
+			mutexes[k] = objsync.NewMutex(p, config.Bucket, fmt.Sprintf("%slock-%d", config.KeyPrefix, k))
+		}
+
+		g.Go(func() error {
+			for n := 0; ; n++ {
+				if deadline.IsZero() && n >= iterations {
+					return nil
+				}
+
+				if !deadline.IsZero() && time.Now().After(deadline) {
+					return nil
+				}
+
+				k := rng.Intn(keys)
+
+				acquireStart := time.Now()
+				fencingToken, err := mutexes[k].Lock(ctx, lockTTL)
+				if err != nil {
+					if ctx.Err() != nil {
+						return ctx.Err()
+					}
+
+					mu.Lock()
+					errorCount++
+					mu.Unlock()
+					continue
+				}
+				latency := time.Since(acquireStart)
+
+				mu.Lock()
+				latencies = append(latencies, latency)
+				if holders[k].Add(1) > 1 {
+					violations++
+				}
+				if fencingToken <= lastFencingTokens[k] {
+					violations++
+				}
+				lastFencingTokens[k] = max(lastFencingTokens[k], fencingToken)
+				mu.Unlock()
+
+				time.Sleep(config.HoldTime)
+
+				holders[k].Add(-1)
+
+				if err := mutexes[k].Unlock(ctx); err != nil {
+					if ctx.Err() != nil {
+						return ctx.Err()
+					}
+
+					mu.Lock()
+					errorCount++
+					mu.Unlock()
+				}
+			}
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	return &Report{
+		Elapsed:        time.Since(start),
+		Acquisitions:   len(latencies),
+		Errors:         errorCount,
+		Requests:       p.requests.Load(),
+		Conflicts:      p.conflicts.Load(),
+		Violations:     violations,
+		AcquireLatency: summarize(latencies),
+	}, nil
+}
+
+// summarize computes the distribution of a set of latency samples.
+func summarize(latencies []time.Duration) LatencyDistribution {
+	if len(latencies) == 0 {
+		return LatencyDistribution{}
+	}
+
+	sorted := make([]time.Duration, len(latencies))
+	copy(sorted, latencies)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var total time.Duration
+	for _, latency := range sorted {
+		total += latency
+	}
+
+	percentile := func(p float64) time.Duration {
+		return sorted[int(p*float64(len(sorted)-1))]
+	}
+
+	return LatencyDistribution{
+		Min:  sorted[0],
+		Mean: total / time.Duration(len(sorted)),
+		P50:  percentile(0.5),
+		P90:  percentile(0.9),
+		P99:  percentile(0.99),
+		Max:  sorted[len(sorted)-1],
+	}
+}
+
+// countingProvider counts the requests made to, and conflicts returned by,
+// another provider.
+type countingProvider struct {
+	next      provider.Provider
+	requests  atomic.Int64
+	conflicts atomic.Int64
+}
+
+func (p *countingProvider) AtomicUpdateObject(ctx context.Context, bucket, key string, fn provider.UpdateObjectFunc) (string, error) {
+	p.requests.Add(1)
+
+	etag, err := p.next.AtomicUpdateObject(ctx, bucket, key, fn)
+	if errors.Is(err, provider.ErrConflict) {
+		p.conflicts.Add(1)
+	}
+
+	return etag, err
+}
@@ -0,0 +1,42 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Locker returns a sync.Locker backed by the mutex, for use with code that
+// accepts a sync.Locker (eg. sync.Cond). Lock blocks until the mutex has been
+// acquired (holding it for at most ttl), and as sync.Locker can't return
+// errors, both Lock and Unlock panic if the underlying operation fails.
+func (mu *Mutex) Locker(ttl time.Duration) sync.Locker {
+	return &locker{mu: mu, ttl: ttl}
+}
+
+type locker struct {
+	mu  *Mutex
+	ttl time.Duration
+}
+
+func (l *locker) Lock() {
+	if _, err := l.mu.Lock(context.Background(), l.ttl); err != nil {
+		panic(fmt.Sprintf("objsync: failed to lock mutex: %v", err))
+	}
+}
+
+func (l *locker) Unlock() {
+	if err := l.mu.Unlock(context.Background()); err != nil {
+		panic(fmt.Sprintf("objsync: failed to unlock mutex: %v", err))
+	}
+}
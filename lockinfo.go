@@ -0,0 +1,120 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"time"
+
+	"github.com/dpeckett/objsync/provider"
+)
+
+// ErrNotMutex is returned when inspecting an object that isn't a mutex
+// object (eg. a semaphore, or some other JSON object).
+var ErrNotMutex = errors.New("not a mutex object")
+
+// LockInfo describes the current state of a lock.
+type LockInfo struct {
+	// Owner is the owner ID of the current holder, or empty if the lock is
+	// not held.
+	Owner string
+	// Expires is when the current hold expires (for locks attached to a
+	// lease, as of the latest renewal of the lease that was mirrored into the
+	// lock).
+	Expires time.Time
+	// FencingToken is the fencing token of the current (or last) hold.
+	FencingToken int64
+	// Metadata is the metadata attached by the current holder.
+	Metadata map[string]string
+	// Information about the process holding the lock (if recorded, see
+	// WithoutHolderInfo).
+	Hostname string
+	PID      int
+	// Binary is the name of the executable of the holding process.
+	Binary string
+	// Version is the module version the holding process was built from.
+	Version string
+	// StartTime is when the holding process started.
+	StartTime time.Time
+}
+
+// WithMetadata attaches arbitrary metadata (eg. a job ID, or a human readable
+// note) to the locks taken by the mutex, so that operators can see who holds
+// a lock and why (see GetLockInfo).
+func WithMetadata(metadata map[string]string) MutexOption {
+	return func(mu *Mutex) {
+		mu.metadata = maps.Clone(metadata)
+	}
+}
+
+// GetLockInfo returns the current state of the lock, without attempting to
+// acquire it.
+func (mu *Mutex) GetLockInfo(ctx context.Context) (*LockInfo, error) {
+	return Inspect(ctx, mu.provider, mu.bucket, mu.key)
+}
+
+// Inspect returns the current state of the lock object at the given key,
+// without attempting to acquire it (eg. for monitoring and debugging tools).
+// ErrNotMutex is returned if the object exists, but isn't a lock object.
+func Inspect(ctx context.Context, p provider.Provider, bucket, key string) (*LockInfo, error) {
+	data, err := readObject(ctx, p, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every mutex object records an owner, or a fence.
+	if len(data) > 0 {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrNotMutex, err)
+		}
+
+		if _, ok := fields["id"]; !ok {
+			if _, ok := fields["fence"]; !ok {
+				return nil, ErrNotMutex
+			}
+		}
+	}
+
+	content, err := decodeMutexContent(data)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &LockInfo{
+		FencingToken: content.Fence,
+	}
+
+	held, err := isHeld(ctx, p, content, time.Now())
+	if err != nil || !held {
+		return info, err
+	}
+
+	info.Owner = content.ID
+	if content.Expires != nil {
+		info.Expires = *content.Expires
+	}
+	info.Metadata = content.Metadata
+	if content.Holder != nil {
+		info.Hostname = content.Holder.Hostname
+		info.PID = content.Holder.PID
+		info.Binary = content.Holder.Binary
+		info.Version = content.Holder.Version
+		if content.Holder.StartTime != nil {
+			info.StartTime = *content.Holder.StartTime
+		}
+	}
+
+	return info, nil
+}
@@ -0,0 +1,128 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/dpeckett/objsync/provider"
+)
+
+// errNeedsReads is returned from within an update of a lock object, when the
+// update depends on objects that haven't been read yet (see lockReads).
+var errNeedsReads = errors.New("update depends on unread objects")
+
+// lockReads holds the objects that an update of a lock object depends on (the
+// lease the lock is attached to, and the fence sidecar). These are read
+// before, rather than within, the update, as not all providers support reads
+// from within an update (eg. SQLite transactions). If the update turns out to
+// depend on an object that hasn't been read yet, it fails with errNeedsReads,
+// and is retried once the object has been read (see updateLockObject).
+type lockReads struct {
+	// The expiry of the referenced leases (nil if no longer granted).
+	leases map[leaseRef]*time.Time
+	// The highest fencing token recorded in the sidecar (if read).
+	sidecarFence *int64
+
+	pendingLease   *leaseRef
+	pendingSidecar string
+}
+
+// isHeld reports whether the lock object is held by anyone, as of now (see
+// isHeld).
+func (r *lockReads) isHeld(content *mutexContent, now time.Time) (bool, error) {
+	if content.ID == "" || content.Lease == nil {
+		return content.Expires != nil && !now.After(*content.Expires), nil
+	}
+
+	expires, ok := r.leases[*content.Lease]
+	if !ok {
+		ref := *content.Lease
+		r.pendingLease = &ref
+		return false, errNeedsReads
+	}
+
+	return expires != nil && !now.After(*expires), nil
+}
+
+// fenceSidecar returns the highest fencing token recorded in the sidecar
+// object at the given key.
+func (r *lockReads) fenceSidecar(key string) (int64, error) {
+	if r.sidecarFence == nil {
+		r.pendingSidecar = key
+		return -1, errNeedsReads
+	}
+
+	return *r.sidecarFence, nil
+}
+
+// read reads the objects the last update was found to depend on.
+func (r *lockReads) read(ctx context.Context, p provider.Provider, bucket string) error {
+	if ref := r.pendingLease; ref != nil {
+		r.pendingLease = nil
+
+		data, err := readObject(ctx, p, ref.Bucket, ref.Key)
+		if err != nil {
+			return err
+		}
+
+		// Expiry is checked as of the update, rather than now.
+		content, err := decodeLeaseContent(data, time.Time{})
+		if err != nil {
+			return err
+		}
+
+		if r.leases == nil {
+			r.leases = make(map[leaseRef]*time.Time)
+		}
+
+		r.leases[*ref] = nil
+		if content.ID == ref.ID {
+			r.leases[*ref] = content.Expires
+		}
+	}
+
+	if key := r.pendingSidecar; key != "" {
+		r.pendingSidecar = ""
+
+		fence, err := readFenceSidecar(ctx, p, bucket, key)
+		if err != nil {
+			return err
+		}
+
+		r.sidecarFence = &fence
+	}
+
+	return nil
+}
+
+// updateLockObject atomically updates the lock object at the given key (see
+// provider.Provider), reading the objects that the update depends on
+// beforehand (see lockReads).
+func updateLockObject(ctx context.Context, p provider.Provider, bucket, key string, fn func(reads *lockReads, currentETag string, currentData []byte) ([]byte, error)) (string, error) {
+	var reads lockReads
+	for {
+		var fnErr error
+		newETag, err := p.AtomicUpdateObject(ctx, bucket, key, func(currentETag string, currentData []byte) (_ []byte, err error) {
+			defer func() { fnErr = err }()
+
+			return fn(&reads, currentETag, currentData)
+		})
+		if err == nil || !errors.Is(fnErr, errNeedsReads) {
+			return newETag, err
+		}
+
+		if err := reads.read(ctx, p, bucket); err != nil {
+			return "", err
+		}
+	}
+}
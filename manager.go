@@ -0,0 +1,193 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dpeckett/objsync/provider"
+)
+
+// ManagerOption is a functional option for configuring a manager.
+type ManagerOption func(*Manager)
+
+// Manager hands out named mutexes that share a provider, bucket, key prefix,
+// and set of mutex options (eg. ttl bounds, a stats handler, or a logger), so
+// that these don't need to be plumbed through to every call site.
+type Manager struct {
+	provider provider.Provider
+	bucket   string
+	prefix   string
+	opts     []MutexOption
+	// The mutexes handed out by the manager, and its namespaces.
+	registry *mutexRegistry
+}
+
+// mutexRegistry holds the mutexes handed out by a manager (and its
+// namespaces), by key.
+type mutexRegistry struct {
+	mu      sync.Mutex
+	mutexes map[string]*Mutex
+}
+
+// NewManager creates a new mutex manager.
+func NewManager(p provider.Provider, bucket string, opts ...ManagerOption) *Manager {
+	m := &Manager{
+		provider: p,
+		bucket:   bucket,
+		registry: &mutexRegistry{mutexes: make(map[string]*Mutex)},
+	}
+
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	return m
+}
+
+// WithManagerPrefix prepends a prefix to the key of every mutex handed out by
+// the manager (eg. "team-a/locks/"), so that several applications can share a
+// bucket. To apply a prefix to every primitive using a provider, wrap it with
+// a key codec instead (see the keycodec package).
+func WithManagerPrefix(prefix string) ManagerOption {
+	return func(m *Manager) {
+		m.prefix = prefix
+	}
+}
+
+// WithManagerMutexOptions applies the given options to every mutex handed out
+// by the manager.
+func WithManagerMutexOptions(opts ...MutexOption) ManagerOption {
+	return func(m *Manager) {
+		m.opts = append(m.opts, opts...)
+	}
+}
+
+// Mutex returns the mutex with the given name, creating it on first use. The
+// same mutex is returned for subsequent calls with the same name, any
+// additional options (applied after those of the manager) are only used
+// when the mutex is created.
+func (m *Manager) Mutex(name string, opts ...MutexOption) *Mutex {
+	m.registry.mu.Lock()
+	defer m.registry.mu.Unlock()
+
+	key := m.prefix + name
+	if mu, ok := m.registry.mutexes[key]; ok {
+		return mu
+	}
+
+	mu := NewMutex(m.provider, m.bucket, key, slices.Concat(m.opts, opts)...)
+	m.registry.mutexes[key] = mu
+
+	return mu
+}
+
+// Namespace returns a manager for the child namespace with the given name
+// (eg. m.Namespace("jobs").Mutex("reindex") has the key "$prefix/jobs/reindex"),
+// sharing the mutex options, and the mutexes, of this manager.
+func (m *Manager) Namespace(name string) *Manager {
+	return &Manager{
+		provider: m.provider,
+		bucket:   m.bucket,
+		prefix:   m.prefix + name + "/",
+		opts:     m.opts,
+		registry: m.registry,
+	}
+}
+
+// List returns the names of the objects in the namespace of the manager (eg.
+// lock objects, including those of child namespaces), in sorted order. Other
+// objects stored under the prefix (eg. audit trails) are listed too. The
+// provider must support listing objects (see provider.Lister).
+func (m *Manager) List(ctx context.Context) ([]string, error) {
+	lister, ok := m.provider.(provider.Lister)
+	if !ok {
+		return nil, fmt.Errorf("listing objects: %w", provider.ErrNotSupported)
+	}
+
+	keys, err := lister.ListObjects(ctx, m.bucket, m.prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(keys))
+	for _, key := range keys {
+		names = append(names, strings.TrimPrefix(key, m.prefix))
+	}
+
+	return names, nil
+}
+
+// MutexStatus is the status of a mutex handed out by a manager.
+type MutexStatus struct {
+	// Name is the name of the mutex (relative to the namespace of the
+	// manager).
+	Name string
+	// Held is whether the lock is currently held by this mutex.
+	Held bool
+	// Info is the current state of the lock object (nil if it couldn't be
+	// read, see Err).
+	Info *LockInfo
+	// Err is the error reading the state of the lock object, if any.
+	Err error
+	// LastError is the most recent error encountered by the mutex (eg. a
+	// failed acquisition, or a lost hold), if any.
+	LastError error
+	// LastErrorTime is when the most recent error was encountered.
+	LastErrorTime time.Time
+}
+
+// Status returns the status of every mutex handed out by the manager (and its
+// namespaces), sorted by name, eg. for rendering on a status page. The state
+// of each lock object is read from the provider, errors doing so are reported
+// per mutex, rather than failing the whole status.
+func (m *Manager) Status(ctx context.Context) ([]MutexStatus, error) {
+	m.registry.mu.Lock()
+	mutexes := make(map[string]*Mutex)
+	for key, mu := range m.registry.mutexes {
+		if name, ok := strings.CutPrefix(key, m.prefix); ok {
+			mutexes[name] = mu
+		}
+	}
+	m.registry.mu.Unlock()
+
+	names := make([]string, 0, len(mutexes))
+	for name := range mutexes {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	statuses := make([]MutexStatus, 0, len(names))
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		mu := mutexes[name]
+
+		status := MutexStatus{Name: name}
+
+		status.Info, status.Err = mu.GetLockInfo(ctx)
+		status.LastErrorTime, status.LastError = mu.lastError()
+
+		// Our hold may have expired.
+		etag, fencingToken, _ := mu.holdState()
+		status.Held = etag != "" && status.Info != nil && status.Info.Owner == mu.id && status.Info.FencingToken == fencingToken
+
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
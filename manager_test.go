@@ -0,0 +1,160 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dpeckett/objsync"
+	"github.com/dpeckett/objsync/provider/s3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManager(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	prefix := fmt.Sprintf("test-%d/", time.Now().UnixNano())
+
+	ctx := context.Background()
+	p, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	require.NoError(t, err)
+
+	m := objsync.NewManager(p, bucket,
+		objsync.WithManagerPrefix(prefix),
+		objsync.WithManagerMutexOptions(objsync.WithOwnerID("manager")))
+
+	mu := m.Mutex("jobs/reindex")
+	require.Same(t, mu, m.Mutex("jobs/reindex"))
+	require.NotSame(t, mu, m.Mutex("jobs/compact"))
+	require.Equal(t, "manager", mu.ID())
+
+	_, err = mu.Lock(ctx, time.Minute)
+	require.NoError(t, err)
+
+	info, err := objsync.Inspect(ctx, p, bucket, prefix+"jobs/reindex")
+	require.NoError(t, err)
+	require.Equal(t, "manager", info.Owner)
+
+	require.NoError(t, mu.Unlock(ctx))
+}
+
+func TestManagerNamespace(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	prefix := fmt.Sprintf("test-%d/", time.Now().UnixNano())
+
+	ctx := context.Background()
+	p, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	require.NoError(t, err)
+
+	m := objsync.NewManager(p, bucket, objsync.WithManagerPrefix(prefix))
+	jobs := m.Namespace("jobs")
+
+	for _, mu := range []*objsync.Mutex{m.Mutex("leader"), jobs.Mutex("reindex"), jobs.Mutex("compact")} {
+		_, err := mu.Lock(ctx, time.Minute)
+		require.NoError(t, err)
+
+		t.Cleanup(func() {
+			require.NoError(t, mu.Unlock(ctx))
+		})
+	}
+
+	names, err := jobs.List(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"compact", "reindex"}, names)
+
+	names, err = m.List(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"jobs/compact", "jobs/reindex", "leader"}, names)
+}
+
+func TestManagerStatus(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	prefix := fmt.Sprintf("test-%d/", time.Now().UnixNano())
+
+	ctx := context.Background()
+	p, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	require.NoError(t, err)
+
+	m := objsync.NewManager(p, bucket, objsync.WithManagerPrefix(prefix))
+	jobs := m.Namespace("jobs")
+
+	leader := m.Mutex("leader")
+	_, err = leader.Lock(ctx, time.Minute)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, leader.Unlock(ctx))
+	})
+
+	// Held by someone else.
+	other := objsync.NewMutex(p, bucket, prefix+"jobs/reindex", objsync.WithOwnerID("other"))
+	_, err = other.Lock(ctx, time.Minute)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, other.Unlock(ctx))
+	})
+
+	ok, _, err := jobs.Mutex("reindex").TryLock(ctx, time.Minute)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	// Lost when it expired.
+	_, err = jobs.Mutex("compact").Lock(ctx, 100*time.Millisecond)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		statuses, err := jobs.Status(ctx)
+		return err == nil && statuses[0].LastError != nil
+	}, 5*time.Second, 50*time.Millisecond)
+
+	statuses, err := m.Status(ctx)
+	require.NoError(t, err)
+	require.Len(t, statuses, 3)
+
+	compact, reindex, leaderStatus := statuses[0], statuses[1], statuses[2]
+
+	require.Equal(t, "jobs/compact", compact.Name)
+	require.False(t, compact.Held)
+	require.NoError(t, compact.Err)
+	require.Empty(t, compact.Info.Owner)
+	require.ErrorIs(t, compact.LastError, objsync.ErrLockLost)
+	require.False(t, compact.LastErrorTime.IsZero())
+
+	require.Equal(t, "jobs/reindex", reindex.Name)
+	require.False(t, reindex.Held)
+	require.Equal(t, "other", reindex.Info.Owner)
+	require.NoError(t, reindex.LastError)
+
+	require.Equal(t, "leader", leaderStatus.Name)
+	require.True(t, leaderStatus.Held)
+	require.Equal(t, leader.ID(), leaderStatus.Info.Owner)
+	require.NoError(t, leaderStatus.LastError)
+
+	// Namespaces only report their own mutexes.
+	statuses, err = jobs.Status(ctx)
+	require.NoError(t, err)
+	require.Len(t, statuses, 2)
+	require.Equal(t, "compact", statuses[0].Name)
+	require.Equal(t, "reindex", statuses[1].Name)
+}
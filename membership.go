@@ -0,0 +1,302 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dpeckett/objsync/provider"
+	"github.com/google/uuid"
+)
+
+// MembershipOption is a functional option for configuring a membership.
+type MembershipOption func(*Membership)
+
+// WithMemberID sets a stable member ID (eg. "$hostname/$pod"), instead of a
+// randomly generated one.
+func WithMemberID(id string) MembershipOption {
+	return func(m *Membership) {
+		m.id = id
+	}
+}
+
+// WithMembershipLease keeps this member registered by renewals of the given
+// lease (see Lease), rather than by a heartbeat of its own, so that one
+// heartbeat can maintain many locks and memberships. The registration expires
+// along with the lease, which must have been granted before joining.
+func WithMembershipLease(l *Lease) MembershipOption {
+	return func(m *Membership) {
+		m.lease = l
+	}
+}
+
+// Membership is a registry of live processes. Members join with some
+// metadata, and are kept registered by a background heartbeat until they
+// leave. Members that stop heartbeating (eg. because they crashed) are pruned
+// after the ttl.
+type Membership struct {
+	provider provider.Provider
+	bucket   string
+	key      string
+	id       string
+	ttl      time.Duration
+	lease    *Lease
+
+	mu            sync.Mutex
+	joinedAt      time.Time
+	metadata      map[string]string
+	keepAliveStop func()
+}
+
+// Member is a live member of a group.
+type Member struct {
+	ID       string            `json:"-"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	JoinedAt time.Time         `json:"joinedAt"`
+	Expires  time.Time         `json:"expires"`
+}
+
+// The current schema version of the membership object.
+const membershipSchemaVersion = 1
+
+// The JSON content of the membership object.
+type membershipContent struct {
+	SchemaVersion int                `json:"schemaVersion,omitempty"`
+	Members       map[string]*Member `json:"members,omitempty"`
+}
+
+// NewMembership creates a new distributed membership registry.
+func NewMembership(p provider.Provider, bucket, key string, ttl time.Duration, opts ...MembershipOption) *Membership {
+	m := &Membership{
+		provider: p,
+		bucket:   bucket,
+		key:      key,
+		id:       uuid.New().String(),
+		ttl:      ttl,
+	}
+
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	return m
+}
+
+// ID returns the member ID.
+func (m *Membership) ID() string {
+	return m.id
+}
+
+// Join registers this process as a member with the given metadata, and starts
+// heartbeating in the background until Leave is called. Joining again
+// replaces the metadata.
+func (m *Membership) Join(ctx context.Context, metadata map[string]string) error {
+	if m.ttl <= 0 && m.lease == nil {
+		return fmt.Errorf("%w: %s", ErrInvalidTTL, m.ttl)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.stopKeepAlive()
+
+	if m.lease != nil {
+		expires, err := m.lease.attachMember(m)
+		if err != nil {
+			return err
+		}
+
+		m.setJoined(metadata)
+
+		if err := m.heartbeat(ctx, expires); err != nil {
+			m.lease.detachMember(m)
+			return err
+		}
+
+		return nil
+	}
+
+	m.setJoined(metadata)
+
+	if err := m.heartbeat(ctx, time.Now().Add(m.ttl)); err != nil {
+		return err
+	}
+
+	m.startKeepAlive(context.WithoutCancel(ctx))
+
+	return nil
+}
+
+// setJoined records the metadata this process joined with, m.mu must be held.
+func (m *Membership) setJoined(metadata map[string]string) {
+	if m.joinedAt.IsZero() {
+		m.joinedAt = time.Now().UTC()
+	}
+	m.metadata = metadata
+}
+
+// Leave deregisters this process, and stops heartbeating.
+func (m *Membership) Leave(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.stopKeepAlive()
+	if m.lease != nil {
+		m.lease.detachMember(m)
+	}
+
+	_, err := updateObject(ctx, m.provider, m.bucket, m.key, func(_ string, currentData []byte) ([]byte, error) {
+		content, err := decodeMembershipContent(currentData)
+		if err != nil {
+			return nil, err
+		}
+
+		delete(content.Members, m.id)
+
+		return json.Marshal(content)
+	})
+	if err != nil {
+		return err
+	}
+
+	m.joinedAt = time.Time{}
+
+	return nil
+}
+
+// List returns the live members of the group, ordered by ID.
+func (m *Membership) List(ctx context.Context) ([]Member, error) {
+	data, err := readObject(ctx, m.provider, m.bucket, m.key)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := decodeMembershipContent(data)
+	if err != nil {
+		return nil, err
+	}
+
+	members := make([]Member, 0, len(content.Members))
+	for id, member := range content.Members {
+		member.ID = id
+		members = append(members, *member)
+	}
+
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].ID < members[j].ID
+	})
+
+	return members, nil
+}
+
+// heartbeat refreshes (or recreates) this member's registration, until the
+// given expiry.
+func (m *Membership) heartbeat(ctx context.Context, expires time.Time) error {
+	_, err := updateObject(ctx, m.provider, m.bucket, m.key, func(_ string, currentData []byte) ([]byte, error) {
+		content, err := decodeMembershipContent(currentData)
+		if err != nil {
+			return nil, err
+		}
+
+		content.Members[m.id] = &Member{
+			Metadata: m.metadata,
+			JoinedAt: m.joinedAt,
+			Expires:  expires.UTC(),
+		}
+
+		return json.Marshal(content)
+	})
+	return err
+}
+
+// startKeepAlive starts a background goroutine that periodically refreshes
+// this member's registration, until Leave is called.
+func (m *Membership) startKeepAlive(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+
+	m.keepAliveStop = func() {
+		cancel()
+		<-done
+	}
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(m.ttl / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				// Transient errors are retried on the next tick.
+				_ = m.heartbeat(ctx, time.Now().Add(m.ttl))
+			}
+		}
+	}()
+}
+
+// leaseRenewed refreshes this member's registration after the lease it is
+// attached to was renewed (see WithMembershipLease). This is best effort,
+// failures are retried on the next renewal.
+func (m *Membership) leaseRenewed(ctx context.Context, l *Lease, expires time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Left in the meantime.
+	if m.lease != l || m.joinedAt.IsZero() {
+		return
+	}
+
+	_ = m.heartbeat(ctx, expires)
+}
+
+// stopKeepAlive stops refreshing this member's registration (if running).
+func (m *Membership) stopKeepAlive() {
+	if m.keepAliveStop != nil {
+		m.keepAliveStop()
+		m.keepAliveStop = nil
+	}
+}
+
+// decodeMembershipContent decodes the membership object, pruning any expired
+// members.
+func decodeMembershipContent(data []byte) (*membershipContent, error) {
+	var content membershipContent
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &content); err != nil {
+			return nil, err
+		}
+	}
+
+	if content.SchemaVersion == 0 {
+		content.SchemaVersion = membershipSchemaVersion
+	}
+
+	if content.Members == nil {
+		content.Members = make(map[string]*Member)
+	}
+
+	now := time.Now()
+	for id, member := range content.Members {
+		if now.After(member.Expires) {
+			delete(content.Members, id)
+		}
+	}
+
+	return &content, nil
+}
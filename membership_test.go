@@ -0,0 +1,98 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dpeckett/objsync"
+	"github.com/dpeckett/objsync/provider/s3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMembership(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	key := fmt.Sprintf("test-%d.members", time.Now().UnixNano())
+
+	ctx := context.Background()
+	p, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	require.NoError(t, err)
+
+	a := objsync.NewMembership(p, bucket, key, 300*time.Millisecond, objsync.WithMemberID("a"))
+	b := objsync.NewMembership(p, bucket, key, 300*time.Millisecond, objsync.WithMemberID("b"))
+
+	require.NoError(t, a.Join(ctx, map[string]string{"zone": "us-east-1a"}))
+	require.NoError(t, b.Join(ctx, nil))
+
+	// Members are kept alive by their heartbeats.
+	time.Sleep(time.Second)
+
+	members, err := a.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, members, 2)
+	require.Equal(t, "a", members[0].ID)
+	require.Equal(t, "us-east-1a", members[0].Metadata["zone"])
+	require.Equal(t, "b", members[1].ID)
+
+	require.NoError(t, b.Leave(ctx))
+
+	members, err = a.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, members, 1)
+	require.Equal(t, "a", members[0].ID)
+
+	require.NoError(t, a.Leave(ctx))
+}
+
+func TestMembershipLease(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	key := fmt.Sprintf("test-%d", time.Now().UnixNano())
+
+	ctx := context.Background()
+	p, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	require.NoError(t, err)
+
+	lease := objsync.NewLease(p, bucket, key+".lease")
+
+	m := objsync.NewMembership(p, bucket, key+".members", 0, objsync.WithMemberID("a"), objsync.WithMembershipLease(lease))
+	require.ErrorIs(t, m.Join(ctx, nil), objsync.ErrLeaseNotGranted)
+
+	require.NoError(t, lease.Grant(ctx, 300*time.Millisecond))
+	require.NoError(t, lease.KeepAlive(ctx))
+
+	require.NoError(t, m.Join(ctx, nil))
+
+	// The member is kept registered by the lease's heartbeat.
+	time.Sleep(time.Second)
+
+	members, err := m.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, members, 1)
+
+	// And expires along with the lease.
+	require.NoError(t, lease.Revoke(ctx))
+	time.Sleep(500 * time.Millisecond)
+
+	members, err = m.List(ctx)
+	require.NoError(t, err)
+	require.Empty(t, members)
+}
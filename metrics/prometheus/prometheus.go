@@ -0,0 +1,130 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package prometheus exports objsync events as Prometheus metrics.
+package prometheus
+
+import (
+	"context"
+
+	"github.com/dpeckett/objsync/stats"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Handler is a stats.Handler that records events as Prometheus metrics,
+// labelled by bucket and key (and for provider requests, by operation).
+type Handler struct {
+	acquireAttempts  *prometheus.CounterVec
+	contentions      *prometheus.CounterVec
+	acquireFailures  *prometheus.CounterVec
+	acquireLatency   *prometheus.HistogramVec
+	holdDuration     *prometheus.HistogramVec
+	renewals         *prometheus.CounterVec
+	locksLost        *prometheus.CounterVec
+	providerRequests *prometheus.CounterVec
+	providerErrors   *prometheus.CounterVec
+	providerLatency  *prometheus.HistogramVec
+}
+
+// NewHandler creates a new Prometheus stats handler, and registers its
+// metrics with the given registerer.
+func NewHandler(reg prometheus.Registerer) (*Handler, error) {
+	lockLabels := []string{"bucket", "key"}
+	providerLabels := []string{"bucket", "operation"}
+
+	h := &Handler{
+		acquireAttempts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "objsync_acquire_attempts_total",
+			Help: "The number of attempts made to acquire a lock.",
+		}, lockLabels),
+		contentions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "objsync_contentions_total",
+			Help: "The number of attempts to acquire a lock that failed because it was held by someone else.",
+		}, lockLabels),
+		acquireFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "objsync_acquire_failures_total",
+			Help: "The number of attempts to acquire a lock that failed due to an error.",
+		}, lockLabels),
+		acquireLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "objsync_acquire_duration_seconds",
+			Help:    "The latency of successful lock acquisition attempts.",
+			Buckets: prometheus.DefBuckets,
+		}, lockLabels),
+		holdDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "objsync_hold_duration_seconds",
+			Help:    "How long locks were held for before being released.",
+			Buckets: prometheus.ExponentialBuckets(0.01, 4, 10),
+		}, lockLabels),
+		renewals: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "objsync_renewals_total",
+			Help: "The number of times a hold on a lock was renewed.",
+		}, lockLabels),
+		locksLost: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "objsync_locks_lost_total",
+			Help: "The number of holds on locks that were lost (eg. expired without being renewed).",
+		}, lockLabels),
+		providerRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "objsync_provider_requests_total",
+			Help: "The number of requests made to the object store.",
+		}, providerLabels),
+		providerErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "objsync_provider_errors_total",
+			Help: "The number of requests to the object store that failed.",
+		}, providerLabels),
+		providerLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "objsync_provider_request_duration_seconds",
+			Help:    "The latency of requests made to the object store.",
+			Buckets: prometheus.DefBuckets,
+		}, providerLabels),
+	}
+
+	for _, c := range []prometheus.Collector{
+		h.acquireAttempts,
+		h.contentions,
+		h.acquireFailures,
+		h.acquireLatency,
+		h.holdDuration,
+		h.renewals,
+		h.locksLost,
+		h.providerRequests,
+		h.providerErrors,
+		h.providerLatency,
+	} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+
+	return h, nil
+}
+
+func (h *Handler) HandleEvent(_ context.Context, event stats.Event) {
+	switch event.Type {
+	case stats.EventAcquireAttempt:
+		h.acquireAttempts.WithLabelValues(event.Bucket, event.Key).Inc()
+	case stats.EventAcquired:
+		h.acquireLatency.WithLabelValues(event.Bucket, event.Key).Observe(event.Duration.Seconds())
+	case stats.EventContended:
+		h.contentions.WithLabelValues(event.Bucket, event.Key).Inc()
+	case stats.EventAcquireFailed:
+		h.acquireFailures.WithLabelValues(event.Bucket, event.Key).Inc()
+	case stats.EventReleased:
+		h.holdDuration.WithLabelValues(event.Bucket, event.Key).Observe(event.Duration.Seconds())
+	case stats.EventRenewed:
+		h.renewals.WithLabelValues(event.Bucket, event.Key).Inc()
+	case stats.EventLost:
+		h.locksLost.WithLabelValues(event.Bucket, event.Key).Inc()
+	case stats.EventProviderRequest:
+		h.providerRequests.WithLabelValues(event.Bucket, event.Operation).Inc()
+		h.providerLatency.WithLabelValues(event.Bucket, event.Operation).Observe(event.Duration.Seconds())
+		if event.Err != nil {
+			h.providerErrors.WithLabelValues(event.Bucket, event.Operation).Inc()
+		}
+	}
+}
@@ -0,0 +1,159 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/avast/retry-go/v4"
+	"github.com/dpeckett/objsync/provider"
+	"github.com/google/uuid"
+)
+
+// MultiLock acquires a set of mutexes with all-or-nothing semantics. The
+// mutexes are always acquired in a canonical (sorted) order, and if any of
+// them is held, those already acquired are released again, so that
+// processes locking overlapping sets of keys can't deadlock.
+type MultiLock struct {
+	keys          []string
+	mutexes       []*Mutex
+	fencingTokens []int64
+}
+
+// NewMultiLock creates a new multi-key lock over the given keys (duplicate
+// keys are ignored). All of the mutexes share the same owner ID (unless
+// overridden by the provided options).
+func NewMultiLock(p provider.Provider, bucket string, keys []string, opts ...MutexOption) *MultiLock {
+	keys = slices.Clone(keys)
+	slices.Sort(keys)
+	keys = slices.Compact(keys)
+
+	opts = append([]MutexOption{WithOwnerID(uuid.New().String())}, opts...)
+
+	ml := &MultiLock{
+		keys:    keys,
+		mutexes: make([]*Mutex, len(keys)),
+	}
+
+	for i, key := range keys {
+		ml.mutexes[i] = NewMutex(p, bucket, key, opts...)
+	}
+
+	return ml
+}
+
+// Lock acquires all of the mutexes. It blocks until all of the mutexes are
+// available at once. Length is the maximum duration the locks will be held
+// for. The returned fencing token is the sum of the fencing tokens of the
+// individual mutexes, and so strictly increases with every acquisition of the
+// same set of keys.
+func (ml *MultiLock) Lock(ctx context.Context, length time.Duration) (int64, error) {
+	var fencingToken int64
+
+	err := retry.Do(
+		func() error {
+			var ok bool
+			var err error
+			ok, fencingToken, err = ml.TryLock(ctx, length)
+			if err != nil {
+				return retry.Unrecoverable(err)
+			}
+
+			if ok {
+				return nil
+			}
+
+			return &LockHeldError{}
+		},
+		retry.Context(ctx),
+		retry.Attempts(0),
+		retry.MaxDelay(maxRetryDelay),
+		// Report that the lock was held if we give up.
+		retry.WrapContextErrorWithLastError(true),
+	)
+	if err != nil {
+		return -1, err
+	}
+
+	return fencingToken, nil
+}
+
+// TryLock attempts to acquire all of the mutexes without blocking. If any of
+// the mutexes is held, none of them are acquired.
+func (ml *MultiLock) TryLock(ctx context.Context, length time.Duration) (bool, int64, error) {
+	fencingTokens := make([]int64, 0, len(ml.mutexes))
+
+	for _, mu := range ml.mutexes {
+		ok, fencingToken, err := mu.TryLock(ctx, length)
+		if err != nil || !ok {
+			if unlockErr := ml.unlock(ctx, len(fencingTokens)); unlockErr != nil {
+				return false, -1, errors.Join(err, unlockErr)
+			}
+
+			return false, -1, err
+		}
+
+		fencingTokens = append(fencingTokens, fencingToken)
+	}
+
+	ml.fencingTokens = fencingTokens
+
+	var combined int64
+	for _, fencingToken := range fencingTokens {
+		combined += fencingToken
+	}
+
+	return true, combined, nil
+}
+
+// Unlock releases all of the mutexes, if any of them are not held, the
+// returned error wraps ErrNotHeld.
+func (ml *MultiLock) Unlock(ctx context.Context) error {
+	ml.fencingTokens = nil
+
+	return ml.unlock(ctx, len(ml.mutexes))
+}
+
+// Keys returns the keys of the mutexes, in the order they are acquired.
+func (ml *MultiLock) Keys() []string {
+	return slices.Clone(ml.keys)
+}
+
+// FencingTokens returns the fencing tokens of the individual mutexes, keyed
+// by the key of the mutex, or nil if the locks are not held.
+func (ml *MultiLock) FencingTokens() map[string]int64 {
+	if ml.fencingTokens == nil {
+		return nil
+	}
+
+	fencingTokens := make(map[string]int64, len(ml.keys))
+	for i, key := range ml.keys {
+		fencingTokens[key] = ml.fencingTokens[i]
+	}
+
+	return fencingTokens
+}
+
+// unlock releases the first n mutexes, in the reverse order they were
+// acquired.
+func (ml *MultiLock) unlock(ctx context.Context, n int) error {
+	var errs []error
+	for i := n - 1; i >= 0; i-- {
+		if err := ml.mutexes[i].Unlock(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("failed to unlock %q: %w", ml.keys[i], err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,109 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dpeckett/objsync"
+	"github.com/dpeckett/objsync/provider/s3"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestMultiLock(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	prefix := fmt.Sprintf("test-%d", time.Now().UnixNano())
+	keyA, keyB, keyC := prefix+"-a.lock", prefix+"-b.lock", prefix+"-c.lock"
+
+	ctx := context.Background()
+	p, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	require.NoError(t, err)
+
+	t.Run("Overlapping", func(t *testing.T) {
+		var held [3]int32
+		g, ctx := errgroup.WithContext(ctx)
+
+		// Lock overlapping sets of keys, in different orders.
+		for i, keys := range [][]string{{keyA, keyB}, {keyB, keyC}, {keyC, keyA}} {
+			g.Go(func() error {
+				ml := objsync.NewMultiLock(p, bucket, keys)
+
+				for j := 0; j < 3; j++ {
+					if _, err := ml.Lock(ctx, 5*time.Second); err != nil {
+						return fmt.Errorf("lock: %w", err)
+					}
+
+					for _, k := range []int{i, (i + 1) % 3} {
+						if n := atomic.AddInt32(&held[k], 1); n > 1 {
+							return fmt.Errorf("key %d is held by %d lockers", k, n)
+						}
+					}
+
+					time.Sleep(10 * time.Millisecond)
+
+					for _, k := range []int{i, (i + 1) % 3} {
+						atomic.AddInt32(&held[k], -1)
+					}
+
+					if err := ml.Unlock(ctx); err != nil {
+						return fmt.Errorf("unlock: %w", err)
+					}
+				}
+
+				return nil
+			})
+		}
+
+		require.NoError(t, g.Wait())
+	})
+
+	t.Run("AllOrNothing", func(t *testing.T) {
+		mu := objsync.NewMutex(p, bucket, keyB)
+		_, err := mu.Lock(ctx, 5*time.Second)
+		require.NoError(t, err)
+
+		ml := objsync.NewMultiLock(p, bucket, []string{keyC, keyB, keyA})
+		require.Equal(t, []string{keyA, keyB, keyC}, ml.Keys())
+
+		ok, _, err := ml.TryLock(ctx, 5*time.Second)
+		require.NoError(t, err)
+		require.False(t, ok)
+
+		// The lock on keyA should have been released again.
+		ok, _, err = objsync.NewMutex(p, bucket, keyA).TryLock(ctx, time.Millisecond)
+		require.NoError(t, err)
+		require.True(t, ok)
+
+		require.NoError(t, mu.Unlock(ctx))
+
+		time.Sleep(10 * time.Millisecond)
+
+		fencingToken, err := ml.Lock(ctx, 5*time.Second)
+		require.NoError(t, err)
+
+		var sum int64
+		for _, token := range ml.FencingTokens() {
+			sum += token
+		}
+		require.Equal(t, sum, fencingToken)
+
+		require.NoError(t, ml.Unlock(ctx))
+	})
+}
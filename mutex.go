@@ -14,61 +14,345 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
+	"reflect"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/avast/retry-go/v4"
 	"github.com/dpeckett/objsync/provider"
+	"github.com/dpeckett/objsync/stats"
 	"github.com/google/uuid"
 )
 
+// ErrLockHeld is returned when the mutex is held by someone else. The
+// returned error is a *LockHeldError, describing the current holder.
+var ErrLockHeld = errors.New("lock is held")
+
+// ErrProviderUnavailable is returned when the provider fails to read or
+// write the lock object (eg. due to a network error).
+var ErrProviderUnavailable = errors.New("provider unavailable")
+
+// LockHeldError is returned when the mutex is held by someone else.
+type LockHeldError struct {
+	// Owner is the owner ID of the current holder (empty if the lock was
+	// contended, but the holder is unknown).
+	Owner string
+	// Expires is when the current hold expires.
+	Expires time.Time
+	// FencingToken is the fencing token of the current hold.
+	FencingToken int64
+}
+
+func (e *LockHeldError) Error() string {
+	if e.Owner == "" {
+		return ErrLockHeld.Error()
+	}
+
+	return fmt.Sprintf("lock is held by %q", e.Owner)
+}
+
+func (e *LockHeldError) Is(target error) bool {
+	return target == ErrLockHeld
+}
+
+// MutexOption is a functional option for configuring a mutex.
+type MutexOption func(*Mutex)
+
 // Mutex is a distributed mutex.
 type Mutex struct {
-	provider provider.Provider
-	bucket   string
-	key      string
-	id       string
-	etag     string
+	provider          provider.Provider
+	bucket            string
+	key               string
+	id                string
+	minTTL            time.Duration
+	maxTTL            time.Duration
+	withoutHolder     bool
+	deleteOnUnlock    bool
+	reentrant         bool
+	reclaim           bool
+	clock             Clock
+	skewMargin        time.Duration
+	metadata          map[string]string
+	lease             *Lease
+	expiryWarning     *expiryWarning
+	hold              hold
+	keepAliveStop     func()
+	statsHandler      stats.Handler
+	logHandler        stats.Handler
+	fenceSidecarKey   string
+	auditTrailKey     string
+	fenceEpoch        int64
+	timeFenceEpoch    bool
+	onFenceRegression func(key string, fence, highestFence int64)
+	signingKey        []byte
+	maxRetryDelay     time.Duration
+	renewalPolicy     RenewalPolicy
+
+	// Check for fencing token regressions against the highest fencing token
+	// returned for the lock in this process (see WithFenceRegressionCheck).
+	fenceRegressionCheck bool
+
+	// The ETag of the lock object when it was last found to be held by
+	// someone else, and the resulting error (see stillHeld).
+	heldETag string
+	heldErr  *LockHeldError
+
+	// Guards the state of the current hold, which is shared with the
+	// keepalive (and lease) goroutines.
+	stateMu      sync.Mutex
+	etag         string
+	fencingToken int64
+	acquiredAt   time.Time
+	// The most recent error encountered by the mutex, and when (see
+	// Manager.Status).
+	lastErr   error
+	lastErrAt time.Time
+	// Serializes renewals (and the release) of the current hold, so that they
+	// don't race each other to update the lock object.
+	renewMu sync.Mutex
 }
 
+// The current schema version of the mutex object.
+const mutexSchemaVersion = 1
+
 // The JSON content of the mutex object.
 type mutexContent struct {
-	ID      string     `json:"id,omitempty"`
-	Expires *time.Time `json:"expires,omitempty"`
-	Fence   int64      `json:"fence,omitempty"`
+	SchemaVersion int        `json:"schemaVersion,omitempty"`
+	ID            string     `json:"id,omitempty"`
+	Expires       *time.Time `json:"expires,omitempty"`
+	Fence         int64      `json:"fence,omitempty"`
+	Holder        *holder    `json:"holder,omitempty"`
+	// The number of reentrant holds on the lock (if more than one).
+	Holds int `json:"holds,omitempty"`
+	// The lease the lock is attached to (if any), the lock is held for as
+	// long as the lease is alive.
+	Lease *leaseRef `json:"lease,omitempty"`
+	// Arbitrary metadata attached by the holder.
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// The last time the lock was forcibly broken (if ever).
+	Broken *lockBreak `json:"broken,omitempty"`
+	// The fence epoch of the lock (see WithFenceEpoch).
+	Epoch int64 `json:"epoch,omitempty"`
+	// When the lock was last released (used to garbage collect idle locks).
+	Released *time.Time `json:"released,omitempty"`
+	// The HMAC of the rest of the object (see WithSigningKey).
+	Signature string `json:"signature,omitempty"`
+	// Fields written by newer clients that we don't understand, these are
+	// preserved so that we don't clobber them when updating the object.
+	unknownFields map[string]json.RawMessage
+}
+
+func (content *mutexContent) UnmarshalJSON(data []byte) error {
+	type plainMutexContent mutexContent
+	if err := json.Unmarshal(data, (*plainMutexContent)(content)); err != nil {
+		return err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+
+	for name := range fields {
+		if knownMutexFields[name] {
+			delete(fields, name)
+		}
+	}
+
+	content.unknownFields = nil
+	if len(fields) > 0 {
+		content.unknownFields = fields
+	}
+
+	return nil
+}
+
+func (content mutexContent) MarshalJSON() ([]byte, error) {
+	type plainMutexContent mutexContent
+	data, err := json.Marshal(plainMutexContent(content))
+	if err != nil || len(content.unknownFields) == 0 {
+		return data, err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	for name, value := range content.unknownFields {
+		fields[name] = value
+	}
+
+	return json.Marshal(fields)
+}
+
+// The JSON field names understood by this version of the mutex.
+var knownMutexFields = jsonFieldNames(reflect.TypeOf(mutexContent{}))
+
+// mutexMigrations upgrade the mutex object from schema version i to i+1.
+// Migrations must only add fields, so that clients running older versions
+// can continue to interpret the object.
+var mutexMigrations = []func(content *mutexContent) error{
+	// v0 -> v1: introduced the schemaVersion field.
+	func(content *mutexContent) error { return nil },
+}
+
+// decodeMutexContent decodes the mutex object, upgrading it to the current
+// schema version if it was written by an older client.
+func decodeMutexContent(data []byte) (*mutexContent, error) {
+	var content mutexContent
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &content); err != nil {
+			return nil, err
+		}
+	}
+
+	// Written by a newer client, leave it as is.
+	if content.SchemaVersion > mutexSchemaVersion {
+		return &content, nil
+	}
+
+	for content.SchemaVersion < mutexSchemaVersion {
+		if err := mutexMigrations[content.SchemaVersion](&content); err != nil {
+			return nil, fmt.Errorf("failed to migrate mutex object from schema version %d: %w", content.SchemaVersion, err)
+		}
+		content.SchemaVersion++
+	}
+
+	return &content, nil
 }
 
 // NewMutex creates a new distributed mutex.
-func NewMutex(p provider.Provider, bucket, key string) *Mutex {
-	return &Mutex{
+func NewMutex(p provider.Provider, bucket, key string, opts ...MutexOption) *Mutex {
+	mu := &Mutex{
 		provider: p,
 		bucket:   bucket,
 		key:      key,
 		id:       uuid.New().String(),
+
+		maxRetryDelay: maxRetryDelay,
+	}
+
+	for _, opt := range opts {
+		opt(mu)
+	}
+
+	return mu
+}
+
+// WithMaxRetryDelay caps the delay between attempts to acquire the mutex
+// while it is held by someone else (see Lock), which otherwise backs off
+// exponentially up to a second.
+func WithMaxRetryDelay(delay time.Duration) MutexOption {
+	return func(mu *Mutex) {
+		mu.maxRetryDelay = delay
+	}
+}
+
+// WithOwnerID sets a stable owner ID for the mutex (eg. "$hostname/$pod"),
+// instead of a randomly generated one. This allows the holder of a lock to be
+// attributed, and recognized across restarts (see WithReclaim).
+func WithOwnerID(id string) MutexOption {
+	return func(mu *Mutex) {
+		mu.id = id
+	}
+}
+
+// WithReclaim allows a lock that is still held under this mutex's owner ID
+// (see WithOwnerID) to be reclaimed, rather than waiting for it to expire. This
+// lets a restarted process take back the lock held by its previous
+// incarnation. The fencing token is bumped, so that any writes still in
+// flight from the previous incarnation can be rejected.
+//
+// Only one live process may use a given owner ID at a time. Reclaiming is
+// ignored in reentrant mode, where the lock is acquired again instead.
+func WithReclaim() MutexOption {
+	return func(mu *Mutex) {
+		mu.reclaim = true
+	}
+}
+
+// ID returns the owner ID of the mutex.
+func (mu *Mutex) ID() string {
+	return mu.id
+}
+
+// Owner returns the owner ID of the current holder of the mutex, or an empty
+// string if the mutex is not held.
+func (mu *Mutex) Owner(ctx context.Context) (string, error) {
+	data, err := readObject(ctx, mu.provider, mu.bucket, mu.key)
+	if err != nil {
+		return "", err
 	}
+
+	content, err := mu.decodeContent(data)
+	if err != nil {
+		return "", err
+	}
+
+	held, err := mu.isHeld(ctx, content)
+	if err != nil || !held {
+		return "", err
+	}
+
+	return content.ID, nil
+}
+
+// isHeld reports whether the lock object is currently held by anyone.
+// Holds are considered to last for the skew margin beyond their expiry.
+func (mu *Mutex) isHeld(ctx context.Context, content *mutexContent) (bool, error) {
+	return isHeld(ctx, mu.provider, content, mu.now().Add(-mu.skewMargin))
+}
+
+// isHeld reports whether the lock object is held by anyone, as of now. Holds
+// attached to a lease last exactly as long as the lease (the expiry recorded
+// in the lock object is only for clients that don't support leases).
+func isHeld(ctx context.Context, p provider.Provider, content *mutexContent, now time.Time) (bool, error) {
+	if content.ID != "" && content.Lease != nil {
+		return leaseAlive(ctx, p, content.Lease, now)
+	}
+
+	return content.Expires != nil && !now.After(*content.Expires), nil
 }
 
 // Lock acquires the mutex. It blocks until the mutex is available.
-// Length is the maximum duration the lock will be held for.
+// Length is the maximum duration the lock will be held for. If ctx is done
+// first, the returned error also wraps the last *LockHeldError.
+//
+// If the provider can watch objects for changes (see provider.Watcher), the
+// lock object is watched while the mutex is held by someone else, rather than
+// retrying blindly.
 func (mu *Mutex) Lock(ctx context.Context, length time.Duration) (int64, error) {
+	if watcher, ok := mu.provider.(provider.Watcher); ok && provider.CapabilitiesOf(mu.provider).Watch {
+		fencingToken, err := mu.lockWatching(ctx, length, watcher)
+		if !errors.Is(err, errWatchFailed) {
+			return fencingToken, err
+		}
+	}
+
 	var fencingToken int64
 
 	err := retry.Do(
 		func() error {
-			var ok bool
 			var err error
-			ok, fencingToken, err = mu.TryLock(ctx, length)
+			fencingToken, err = mu.tryLock(ctx, length)
 			if err != nil {
-				return retry.Unrecoverable(err)
-			}
+				if errors.Is(err, ErrLockHeld) {
+					return err
+				}
 
-			if ok {
-				return nil
+				return retry.Unrecoverable(err)
 			}
 
-			return fmt.Errorf("failed to acquire lock")
+			return nil
 		},
 		retry.Context(ctx),
 		retry.Attempts(0),
+		retry.MaxDelay(mu.maxRetryDelay),
+		// Report who holds the lock if we give up.
+		retry.WrapContextErrorWithLastError(true),
 	)
 	if err != nil {
 		return -1, err
@@ -77,87 +361,700 @@ func (mu *Mutex) Lock(ctx context.Context, length time.Duration) (int64, error)
 	return fencingToken, nil
 }
 
-// Unlock releases the mutex (if held).
+// errWatchFailed is returned when the lock object can't be watched.
+var errWatchFailed = errors.New("watch failed")
+
+// How long to wait for the lock object to change before trying again anyway,
+// in case the hold lapsed without the lock object changing (eg. the lease it
+// was attached to expired).
+const watchRecheckInterval = time.Second
+
+// lockWatching acquires the mutex, like Lock, but waits for the lock object to
+// change (or the current hold to expire) between attempts. If the lock object
+// can't be watched, errWatchFailed is returned.
+func (mu *Mutex) lockWatching(ctx context.Context, length time.Duration, watcher provider.Watcher) (int64, error) {
+	fencingToken, err := mu.tryLock(ctx, length)
+	if !errors.Is(err, ErrLockHeld) {
+		return fencingToken, err
+	}
+
+	watchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	events := watcher.WatchObject(watchCtx, mu.bucket, mu.key)
+
+	// Wait for the watch to be established, any changes made before then
+	// will be seen by the next attempt.
+	select {
+	case <-ctx.Done():
+		return -1, retry.Error{ctx.Err(), err}
+	case event, ok := <-events:
+		if !ok || event.Err != nil {
+			return -1, errWatchFailed
+		}
+	}
+
+	for {
+		fencingToken, err = mu.tryLock(ctx, length)
+		if !errors.Is(err, ErrLockHeld) {
+			return fencingToken, err
+		}
+
+		delay := watchRecheckInterval
+		var heldErr *LockHeldError
+		if errors.As(err, &heldErr) && !heldErr.Expires.IsZero() {
+			if untilExpiry := heldErr.Expires.Sub(mu.now()) + mu.skewMargin; untilExpiry > 0 {
+				delay = untilExpiry
+			}
+		}
+
+		timer := time.NewTimer(delay)
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return -1, retry.Error{ctx.Err(), err}
+		case event, ok := <-events:
+			timer.Stop()
+			if !ok || event.Err != nil {
+				return -1, errWatchFailed
+			}
+		case <-timer.C:
+		}
+	}
+}
+
+// LockWithTimeout acquires the mutex, like Lock, but gives up with
+// ErrLockTimeout if the mutex could not be acquired within maxWait.
+func (mu *Mutex) LockWithTimeout(ctx context.Context, length, maxWait time.Duration) (int64, error) {
+	return mu.TryLockUntil(ctx, length, mu.now().Add(maxWait))
+}
+
+// TryLockUntil acquires the mutex, like Lock, but gives up with
+// ErrLockTimeout if the mutex could not be acquired by the deadline (according
+// to the mutex's clock, see WithClock). At least one attempt is made to
+// acquire the mutex, even if the deadline has passed.
+func (mu *Mutex) TryLockUntil(ctx context.Context, length time.Duration, deadline time.Time) (int64, error) {
+	ok, fencingToken, err := mu.TryLock(ctx, length)
+	if err != nil {
+		return -1, err
+	}
+
+	if ok {
+		return fencingToken, nil
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, mu.until(deadline))
+	defer cancel()
+
+	fencingToken, err = mu.Lock(waitCtx, length)
+	if err != nil {
+		if ctx.Err() == nil && waitCtx.Err() != nil {
+			var heldErr *LockHeldError
+			if errors.As(err, &heldErr) {
+				return -1, fmt.Errorf("%w: %w", ErrLockTimeout, heldErr)
+			}
+
+			return -1, ErrLockTimeout
+		}
+
+		return -1, err
+	}
+
+	return fencingToken, nil
+}
+
+// Unlock releases the mutex. If the mutex is not held (eg. because the hold
+// expired, and was taken over by another owner), ErrNotHeld is returned.
 func (mu *Mutex) Unlock(ctx context.Context) error {
-	if mu.etag != "" {
-		err := retry.Do(
-			func() error {
-				_, err := mu.provider.AtomicUpdateObject(ctx, mu.bucket, mu.key, func(currentETag string, currentData []byte) ([]byte, error) {
-					if currentETag != mu.etag {
-						return nil, provider.ErrConflict
-					}
+	_, fencingToken, _ := mu.holdState()
+	return mu.unlock(ctx, fencingToken)
+}
+
+// UnlockWithToken releases the mutex, like Unlock, but only if it is held
+// with the given fencing token.
+func (mu *Mutex) UnlockWithToken(ctx context.Context, fencingToken int64) error {
+	return mu.unlock(ctx, fencingToken)
+}
 
-					var content mutexContent
-					if len(currentData) > 0 {
-						if err := json.Unmarshal(currentData, &content); err != nil {
-							return nil, err
-						}
+func (mu *Mutex) unlock(ctx context.Context, fencingToken int64) error {
+	mu.stopKeepAlive()
 
-						// Clear the lock.
-						content.ID = ""
-						content.Expires = nil
-					}
+	return mu.release(ctx, fencingToken)
+}
+
+// release releases the hold on the mutex with the given fencing token,
+// without stopping the keepalive (which may be the caller, see
+// startKeepAlive).
+func (mu *Mutex) release(ctx context.Context, fencingToken int64) error {
+	// Wait for any renewal in progress (eg. a concurrent Extend).
+	mu.renewMu.Lock()
+	defer mu.renewMu.Unlock()
+
+	etag, heldFencingToken, acquiredAt := mu.holdState()
+	if etag == "" || fencingToken != heldFencingToken {
+		return ErrNotHeld
+	}
+
+	if mu.deleteOnUnlock && !mu.reentrant && mu.canDelete() {
+		if deleter, ok := mu.provider.(provider.Deleter); ok {
+			err := deleter.DeleteObject(ctx, mu.bucket, mu.key, etag)
+			if err == nil {
+				mu.setETag("")
+				mu.detachLease()
+				mu.released()
+				mu.emit(ctx, stats.Event{Type: stats.EventReleased, FencingToken: fencingToken, Duration: mu.now().Sub(acquiredAt)})
+				return mu.audit(ctx, AuditReleased, mu.id, fencingToken)
+			}
+
+			// Someone else acquired the lock in the meantime.
+			if errors.Is(err, provider.ErrConflict) {
+				mu.setETag("")
+				mu.detachLease()
+				mu.released()
+				return ErrNotHeld
+			}
+
+			// Fallback to clearing the lock.
+			if !errors.Is(err, provider.ErrNotSupported) {
+				return err
+			}
+		}
+	}
+
+	var stillHeld bool
+	err := retry.Do(
+		func() error {
+			var fnErr error
+			newETag, err := updateLockObject(ctx, mu.provider, mu.bucket, mu.key, func(reads *lockReads, currentETag string, currentData []byte) (_ []byte, err error) {
+				defer func() { fnErr = err }()
+
+				stillHeld = false
 
-					return json.Marshal(content)
-				})
+				content, err := mu.decodeContent(currentData)
 				if err != nil {
-					if errors.Is(err, provider.ErrConflict) {
-						return nil // someone else acquired the lock in the meantime.
-					}
+					return nil, err
+				}
+
+				if !mu.owns(currentETag, content) || content.ID != mu.id || content.Fence != fencingToken {
+					return nil, ErrNotHeld
+				}
 
+				held, err := reads.isHeld(content, mu.now().Add(-mu.skewMargin))
+				if err != nil {
+					return nil, err
+				}
+
+				// The hold expired, but no one else has acquired the lock yet.
+				if !held {
+					return nil, ErrNotHeld
+				}
+
+				// Release one of several reentrant holds.
+				if mu.reentrant && content.Holds > 1 {
+					content.Holds--
+					stillHeld = true
+
+					return mu.encodeContent(content)
+				}
+
+				// Clear the lock.
+				released := mu.now().UTC()
+				content.ID = ""
+				content.Expires = nil
+				content.Holder = nil
+				content.Holds = 0
+				content.Lease = nil
+				content.Metadata = nil
+				content.Released = &released
+
+				return mu.encodeContent(content)
+			})
+			if err != nil {
+				if errors.Is(err, ErrNotHeld) {
 					return retry.Unrecoverable(err)
 				}
 
-				return nil
-			},
-			retry.Context(ctx),
-			retry.Attempts(0),
-		)
-		if err != nil {
-			return err
+				// With a single owner, any write to the lock object means that
+				// someone else has acquired it. Reentrant holds share the lock
+				// object, so the update must be retried.
+				if errors.Is(err, provider.ErrConflict) {
+					if mu.reentrant {
+						return err
+					}
+
+					return retry.Unrecoverable(ErrNotHeld)
+				}
+
+				return retry.Unrecoverable(providerError(ctx, err, fnErr))
+			}
+
+			if stillHeld {
+				mu.setETag(newETag)
+			}
+
+			return nil
+		},
+		retry.Context(ctx),
+		retry.Attempts(0),
+		retry.MaxDelay(maxRetryDelay),
+	)
+	if err != nil {
+		if errors.Is(err, ErrNotHeld) {
+			mu.setETag("")
+			mu.detachLease()
+			mu.released()
 		}
 
-		mu.etag = ""
+		return err
 	}
 
-	return nil
+	if stillHeld {
+		return nil
+	}
+
+	mu.setETag("")
+	mu.detachLease()
+	mu.released()
+	mu.emit(ctx, stats.Event{Type: stats.EventReleased, FencingToken: fencingToken, Duration: mu.now().Sub(acquiredAt)})
+
+	return mu.audit(ctx, AuditReleased, mu.id, fencingToken)
+}
+
+// WithStatsHandler sets a handler that receives structured events about the
+// mutex (acquisitions, contention, releases, etc).
+func WithStatsHandler(h stats.Handler) MutexOption {
+	return func(mu *Mutex) {
+		mu.statsHandler = h
+	}
+}
+
+// WithLogger logs acquisition attempts, contention, renewals, releases, and
+// expiries to the given logger at debug level (in addition to any stats
+// handler).
+func WithLogger(logger *slog.Logger) MutexOption {
+	return func(mu *Mutex) {
+		mu.logHandler = stats.NewLogHandler(logger)
+	}
+}
+
+// emit emits an event to the stats handler (if configured), and records any
+// error it reports.
+func (mu *Mutex) emit(ctx context.Context, event stats.Event) {
+	if event.Err != nil || event.Type == stats.EventLost {
+		err := event.Err
+		if err == nil {
+			err = ErrLockLost
+		}
+
+		mu.stateMu.Lock()
+		mu.lastErr = err
+		mu.lastErrAt = mu.now()
+		mu.stateMu.Unlock()
+	}
+
+	if mu.statsHandler == nil && mu.logHandler == nil {
+		return
+	}
+
+	event.Bucket = mu.bucket
+	event.Key = mu.key
+	event.OwnerID = mu.id
+
+	if mu.statsHandler != nil {
+		mu.statsHandler.HandleEvent(ctx, event)
+	}
+
+	if mu.logHandler != nil {
+		mu.logHandler.HandleEvent(ctx, event)
+	}
 }
 
-// TryLock attempts to acquire the mutex without blocking.
+// WithDeleteOnUnlock releases the mutex by conditionally deleting the lock
+// object (if the provider supports conditional deletes and creates), rather
+// than leaving behind an empty lock object. As the fencing token would
+// otherwise restart from scratch, the highest fencing token is persisted in
+// the given sidecar object.
+func WithDeleteOnUnlock(fenceSidecarKey string) MutexOption {
+	return func(mu *Mutex) {
+		mu.deleteOnUnlock = true
+		mu.fenceSidecarKey = fenceSidecarKey
+	}
+}
+
+// canDelete reports whether the lock object can be safely deleted on unlock.
+// Every acquisition then recreates the lock object, so creates must also be
+// conditional, otherwise racing acquirers could clobber each other.
+func (mu *Mutex) canDelete() bool {
+	caps := provider.CapabilitiesOf(mu.provider)
+	return caps.ConditionalCreate && caps.ConditionalDelete
+}
+
+// TryLock attempts to acquire the mutex without blocking. Once the mutex has
+// been found to be held by someone else, later attempts only check whether
+// the lock object has changed (if supported by the provider, see
+// provider.Header, and its reads are strongly consistent), until it does, or
+// the hold expires.
 func (mu *Mutex) TryLock(ctx context.Context, expiresIn time.Duration) (bool, int64, error) {
-	var errLockHeld = fmt.Errorf("lock is held")
+	fencingToken, err := mu.tryLock(ctx, expiresIn)
+	if err != nil {
+		if errors.Is(err, ErrLockHeld) {
+			return false, -1, nil
+		}
+
+		return false, -1, err
+	}
+
+	return true, fencingToken, nil
+}
 
+// tryLock attempts to acquire the mutex without blocking, if the mutex is
+// held, a *LockHeldError is returned.
+func (mu *Mutex) tryLock(ctx context.Context, expiresIn time.Duration) (int64, error) {
+	// Reclaiming is ignored in reentrant mode (see WithReclaim).
+	return mu.acquire(ctx, expiresIn, mu.reclaim && !mu.reentrant)
+}
+
+// acquire attempts to acquire the mutex without blocking, like tryLock. If
+// reclaim is set, a hold still held under this mutex's owner ID is taken
+// over (see WithReclaim), in reentrant mode keeping the existing holds.
+func (mu *Mutex) acquire(ctx context.Context, expiresIn time.Duration, reclaim bool) (int64, error) {
+	var lease *leaseRef
+	var leaseExpires time.Time
+	if mu.lease != nil {
+		var err error
+		lease, leaseExpires, err = mu.lease.attach(mu)
+		if err != nil {
+			return -1, err
+		}
+	} else {
+		var err error
+		expiresIn, err = mu.clampTTL(expiresIn)
+		if err != nil {
+			return -1, err
+		}
+	}
+
+	start := mu.now()
+	mu.emit(ctx, stats.Event{Type: stats.EventAcquireAttempt})
+
+	if heldErr := mu.stillHeld(ctx); heldErr != nil {
+		mu.detachLease()
+		mu.emit(ctx, stats.Event{Type: stats.EventContended, Duration: mu.now().Sub(start)})
+		return -1, heldErr
+	}
+
+	var heldETag string
 	var newFencingToken int64
-	newETag, err := mu.provider.AtomicUpdateObject(ctx, mu.bucket, mu.key, func(_ string, currentData []byte) ([]byte, error) {
-		var content mutexContent
-		if len(currentData) > 0 {
-			if err := json.Unmarshal(currentData, &content); err != nil {
-				return nil, err
+	var newExpires time.Time
+	var reentered bool
+	var fnErr error
+	newETag, err := updateLockObject(ctx, mu.provider, mu.bucket, mu.key, func(reads *lockReads, currentETag string, currentData []byte) (_ []byte, err error) {
+		defer func() { fnErr = err }()
+
+		reentered = false
+		heldETag = ""
+
+		content, err := mu.decodeContent(currentData)
+		if err != nil {
+			return nil, err
+		}
+
+		held, err := reads.isHeld(content, mu.now().Add(-mu.skewMargin))
+		if err != nil {
+			return nil, err
+		}
+
+		// Take over a hold left behind by a previous incarnation of this owner.
+		reclaimed := held && reclaim && content.ID == mu.id
+
+		if held && !reclaimed {
+			if !mu.reentrant || content.ID != mu.id {
+				heldErr := &LockHeldError{Owner: content.ID, FencingToken: content.Fence}
+				if content.Expires != nil {
+					heldErr.Expires = *content.Expires
+				}
+				heldETag = currentETag
+
+				return nil, heldErr
 			}
 
-			if content.Expires != nil && !time.Now().After(*content.Expires) {
-				return nil, errLockHeld
+			// Already held by this owner, so take another hold.
+			expires := mu.now().Add(expiresIn).UTC()
+			if expires.Before(*content.Expires) {
+				expires = *content.Expires
 			}
+			content.Expires = &expires
+			content.Holds = max(content.Holds, 1) + 1
+
+			newFencingToken = content.Fence
+			newExpires = expires
+			reentered = true
+
+			return mu.encodeContent(content)
 		}
 
-		expires := time.Now().Add(expiresIn).UTC()
+		// The lock object doesn't exist (eg. it was deleted), so carry on from
+		// the highest fencing token recorded in the sidecar.
+		if len(currentData) == 0 && mu.fenceSidecarKey != "" {
+			content.Fence, err = reads.fenceSidecar(mu.fenceSidecarKey)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		mu.applyFenceEpoch(content, len(currentData) == 0)
+
+		expires := mu.now().Add(expiresIn).UTC()
+		if lease != nil {
+			expires = leaseExpires
+		}
 		content.Expires = &expires
 		content.ID = mu.id
+		content.Lease = lease
+		content.Metadata = mu.metadata
+		content.Holder = nil
+		if !mu.withoutHolder {
+			content.Holder = currentHolder
+		}
+		content.Released = nil
 		content.Fence++
+		if !mu.reentrant {
+			content.Holds = 0
+		} else if !reclaimed {
+			content.Holds = 1
+		}
 
 		newFencingToken = content.Fence
+		newExpires = expires
 
-		return json.Marshal(content)
+		return mu.encodeContent(content)
 	})
 	if err != nil {
-		if errors.Is(err, errLockHeld) || errors.Is(err, provider.ErrConflict) {
-			return false, -1, nil // Lock is held.
+		mu.detachLease()
+
+		if errors.Is(err, ErrLockHeld) {
+			var heldErr *LockHeldError
+			if heldETag != "" && errors.As(err, &heldErr) {
+				mu.heldETag, mu.heldErr = heldETag, heldErr
+			}
+
+			mu.emit(ctx, stats.Event{Type: stats.EventContended, Duration: mu.now().Sub(start)})
+			return -1, err
 		}
 
-		return false, -1, err
+		// Someone else updated the lock object in the meantime.
+		if errors.Is(err, provider.ErrConflict) {
+			mu.emit(ctx, stats.Event{Type: stats.EventContended, Duration: mu.now().Sub(start)})
+			return -1, &LockHeldError{}
+		}
+
+		err = providerError(ctx, err, fnErr)
+		mu.emit(ctx, stats.Event{Type: stats.EventAcquireFailed, Duration: mu.now().Sub(start), Err: err})
+		return -1, err
 	}
 
-	mu.etag = newETag
+	mu.setHold(newETag, newFencingToken)
+
+	// The fencing token is shared by all the holds, so has already been
+	// checked.
+	if reentered {
+		mu.emit(ctx, stats.Event{Type: stats.EventAcquired, FencingToken: newFencingToken, Duration: mu.now().Sub(start)})
+
+		mu.acquired(newExpires)
+
+		return newFencingToken, nil
+	}
+
+	if err := mu.checkFence(ctx, newFencingToken); err != nil {
+		mu.emit(ctx, stats.Event{Type: stats.EventAcquireFailed, Duration: mu.now().Sub(start), Err: err})
+
+		// Don't hold onto a lock with a bogus fencing token.
+		if err := mu.release(ctx, newFencingToken); err != nil {
+			return -1, err
+		}
+
+		return -1, err
+	}
+
+	if err := mu.audit(ctx, AuditAcquired, mu.id, newFencingToken); err != nil {
+		mu.emit(ctx, stats.Event{Type: stats.EventAcquireFailed, Duration: mu.now().Sub(start), Err: err})
+
+		// Don't hold onto a lock that hasn't been recorded.
+		if err := mu.release(ctx, newFencingToken); err != nil {
+			return -1, err
+		}
+
+		return -1, err
+	}
+
+	mu.emit(ctx, stats.Event{Type: stats.EventAcquired, FencingToken: newFencingToken, Duration: mu.now().Sub(start)})
+
+	mu.acquired(newExpires)
+
+	return newFencingToken, nil
+}
+
+// stillHeld cheaply checks whether the mutex is still held by whoever held it
+// when it was last found to be held (see tryLock), by comparing the ETag of the
+// lock object (if supported by the provider), rather than reading it. If so,
+// the previous *LockHeldError is returned, otherwise the lock object has to be
+// read to find out.
+func (mu *Mutex) stillHeld(ctx context.Context) *LockHeldError {
+	heldETag, heldErr := mu.heldETag, mu.heldErr
+	mu.heldETag, mu.heldErr = "", nil
+
+	if heldETag == "" || heldErr == nil || heldErr.Expires.IsZero() {
+		return nil
+	}
+
+	// The hold might have expired, without the lock object changing.
+	if mu.now().Add(-mu.skewMargin).After(heldErr.Expires) {
+		return nil
+	}
+
+	// A stale read would keep us waiting for the hold to expire.
+	header, ok := mu.provider.(provider.Header)
+	if !ok || !provider.CapabilitiesOf(mu.provider).StrongReadAfterWrite {
+		return nil
+	}
+
+	etag, err := header.HeadObject(ctx, mu.bucket, mu.key)
+	if err != nil || etag != heldETag {
+		return nil
+	}
+
+	mu.heldETag, mu.heldErr = heldETag, heldErr
+
+	return heldErr
+}
+
+// Extend pushes out the expiry of the current hold on the mutex by the given
+// duration, if it is still held (the expiry is still bounded by the maximum
+// ttl, see WithTTLBounds). If the hold has been lost, ErrLockLost is returned.
+func (mu *Mutex) Extend(ctx context.Context, additional time.Duration) error {
+	if additional <= 0 {
+		return fmt.Errorf("%w: %s", ErrInvalidTTL, additional)
+	}
+
+	if mu.lease != nil {
+		return fmt.Errorf("locks attached to a lease are extended by keeping the lease alive")
+	}
+
+	return mu.extend(ctx, func(expires time.Time) time.Time {
+		expires = expires.Add(additional)
+		if now := mu.now(); mu.maxTTL > 0 && expires.Sub(now) > mu.maxTTL {
+			expires = now.Add(mu.maxTTL)
+		}
+
+		return expires
+	})
+}
+
+// renew extends the current hold on the mutex. If the hold has been lost,
+// ErrLockLost is returned.
+func (mu *Mutex) renew(ctx context.Context, length time.Duration) error {
+	length, err := mu.clampTTL(length)
+	if err != nil {
+		return err
+	}
+
+	return mu.extend(ctx, func(expires time.Time) time.Time {
+		newExpires := mu.now().Add(length)
+		// Don't cut short another reentrant hold.
+		if mu.reentrant && newExpires.Before(expires) {
+			newExpires = expires
+		}
+
+		return newExpires
+	})
+}
+
+// extend updates the expiry of the current hold on the mutex, to that
+// computed by fn from the current expiry. If the hold has been lost,
+// ErrLockLost is returned.
+func (mu *Mutex) extend(ctx context.Context, fn func(expires time.Time) time.Time) error {
+	mu.renewMu.Lock()
+	defer mu.renewMu.Unlock()
+
+	etag, fencingToken, _ := mu.holdState()
+	if etag == "" {
+		return ErrLockLost
+	}
+
+	var newExpires time.Time
+	var fnErr error
+	newETag, err := mu.provider.AtomicUpdateObject(ctx, mu.bucket, mu.key, func(currentETag string, currentData []byte) (_ []byte, err error) {
+		defer func() { fnErr = err }()
+
+		content, err := mu.decodeContent(currentData)
+		if err != nil {
+			return nil, err
+		}
+
+		if !mu.owns(currentETag, content) || content.ID != mu.id || content.Expires == nil || mu.now().After(*content.Expires) {
+			return nil, ErrLockLost
+		}
+
+		newExpires = fn(*content.Expires).UTC()
+		content.Expires = &newExpires
+
+		return mu.encodeContent(content)
+	})
+	if err != nil {
+		// Another reentrant hold updated the lock object, retry on the next
+		// renewal.
+		if mu.reentrant && errors.Is(err, provider.ErrConflict) {
+			return err
+		}
+
+		if errors.Is(err, ErrLockLost) || errors.Is(err, provider.ErrConflict) {
+			mu.setETag("")
+			mu.cancelHold(ErrLockLost)
+			mu.emit(ctx, stats.Event{Type: stats.EventLost, FencingToken: fencingToken})
+
+			return ErrLockLost
+		}
+
+		return providerError(ctx, err, fnErr)
+	}
+
+	mu.setETag(newETag)
+	mu.acquired(newExpires)
+	mu.emit(ctx, stats.Event{Type: stats.EventRenewed, FencingToken: fencingToken})
+
+	return nil
+}
+
+// providerError marks errors returned by the provider itself (rather than by
+// the update function fnErr) as ErrProviderUnavailable.
+func providerError(ctx context.Context, err, fnErr error) error {
+	if err == nil || fnErr != nil || ctx.Err() != nil || errors.Is(err, provider.ErrConflict) {
+		return err
+	}
+
+	return fmt.Errorf("%w: %w", ErrProviderUnavailable, err)
+}
+
+// jsonFieldNames returns the set of JSON field names of a struct type.
+func jsonFieldNames(t reflect.Type) map[string]bool {
+	names := make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		names[name] = true
+	}
 
-	return true, newFencingToken, nil
+	return names
 }
@@ -0,0 +1,132 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// The corpus covers the objects written by each generation of releases:
+//
+//   - v0: releases prior to schema versioning.
+//   - v1: the first releases with schema versioning.
+//   - v1-holder: releases recording the holder environment, reentrant holds,
+//     and metadata (the current generation).
+//   - v2: a hypothetical future release.
+
+// The mutex object as understood by releases prior to schema versioning.
+type legacyMutexContent struct {
+	ID      string     `json:"id,omitempty"`
+	Expires *time.Time `json:"expires,omitempty"`
+	Fence   int64      `json:"fence,omitempty"`
+}
+
+// The mutex object as understood by the first releases with schema
+// versioning.
+type v1MutexContent struct {
+	SchemaVersion int        `json:"schemaVersion,omitempty"`
+	ID            string     `json:"id,omitempty"`
+	Expires       *time.Time `json:"expires,omitempty"`
+	Fence         int64      `json:"fence,omitempty"`
+}
+
+func TestMutexContentCompat(t *testing.T) {
+	expires := time.Date(2024, 2, 14, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		file          string
+		schemaVersion int
+		id            string
+		expires       *time.Time
+		fence         int64
+		hostname      string
+		holds         int
+	}{
+		{file: "v0-empty.json", schemaVersion: 1},
+		{file: "v0-unlocked.json", schemaVersion: 1, fence: 3},
+		{file: "v0-locked.json", schemaVersion: 1, id: "0b3b5a4e-8d6f-4f4e-9b9a-6c1f3f5e2a10", expires: &expires, fence: 4},
+		{file: "v1-unlocked.json", schemaVersion: 1, fence: 5},
+		{file: "v1-locked.json", schemaVersion: 1, id: "0b3b5a4e-8d6f-4f4e-9b9a-6c1f3f5e2a10", expires: &expires, fence: 6},
+		{file: "v1-holder-unlocked.json", schemaVersion: 1, fence: 9},
+		{file: "v1-holder-locked.json", schemaVersion: 1, id: "0b3b5a4e-8d6f-4f4e-9b9a-6c1f3f5e2a10", expires: &expires, fence: 8, hostname: "worker-1", holds: 2},
+		{file: "v2-locked.json", schemaVersion: 2, id: "0b3b5a4e-8d6f-4f4e-9b9a-6c1f3f5e2a10", expires: &expires, fence: 7},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", "compat", tt.file))
+			require.NoError(t, err)
+
+			// Can we read objects written by this (or another) version?
+			content, err := decodeMutexContent(data)
+			require.NoError(t, err)
+
+			require.Equal(t, tt.schemaVersion, content.SchemaVersion)
+			require.Equal(t, tt.id, content.ID)
+			require.Equal(t, tt.expires, content.Expires)
+			require.Equal(t, tt.fence, content.Fence)
+			require.Equal(t, tt.holds, content.Holds)
+			if tt.hostname != "" {
+				require.NotNil(t, content.Holder)
+				require.Equal(t, tt.hostname, content.Holder.Hostname)
+			}
+
+			// Can releases prior to schema versioning read what we write?
+			updatedData, err := json.Marshal(content)
+			require.NoError(t, err)
+
+			var legacyContent legacyMutexContent
+			require.NoError(t, json.Unmarshal(updatedData, &legacyContent))
+
+			require.Equal(t, tt.id, legacyContent.ID)
+			require.Equal(t, tt.expires, legacyContent.Expires)
+			require.Equal(t, tt.fence, legacyContent.Fence)
+
+			// And the first releases with schema versioning?
+			var v1Content v1MutexContent
+			require.NoError(t, json.Unmarshal(updatedData, &v1Content))
+
+			require.Equal(t, tt.schemaVersion, v1Content.SchemaVersion)
+			require.Equal(t, tt.id, v1Content.ID)
+			require.Equal(t, tt.expires, v1Content.Expires)
+			require.Equal(t, tt.fence, v1Content.Fence)
+		})
+	}
+}
+
+func TestMutexContentPreservesUnknownFields(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "compat", "v2-locked.json"))
+	require.NoError(t, err)
+
+	content, err := decodeMutexContent(data)
+	require.NoError(t, err)
+
+	// Release the lock, as an older client would.
+	content.ID = ""
+	content.Expires = nil
+
+	updatedData, err := json.Marshal(content)
+	require.NoError(t, err)
+
+	var fields map[string]json.RawMessage
+	require.NoError(t, json.Unmarshal(updatedData, &fields))
+
+	require.JSONEq(t, `2`, string(fields["schemaVersion"]))
+	require.JSONEq(t, `"f1d2c3b4"`, string(fields["futureField"]))
+	require.JSONEq(t, `{"hostname":"worker-1"}`, string(fields["futureObject"]))
+	require.NotContains(t, fields, "id")
+	require.NotContains(t, fields, "expires")
+}
@@ -10,16 +10,22 @@
 package objsync_test
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"math/rand"
 	"os"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/dpeckett/objsync"
+	"github.com/dpeckett/objsync/provider"
 	"github.com/dpeckett/objsync/provider/s3"
+	"github.com/dpeckett/objsync/stats"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/sync/errgroup"
 )
@@ -83,3 +89,1320 @@ func TestMutex(t *testing.T) {
 
 	require.NoError(t, g.Wait())
 }
+
+func TestMutexFenceRegression(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	key := fmt.Sprintf("test-%d.lock", time.Now().UnixNano())
+
+	ctx := context.Background()
+	p, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	require.NoError(t, err)
+
+	var regressions int
+	mu := objsync.NewMutex(p, bucket, key,
+		objsync.WithFenceSidecar(key+".fence"),
+		objsync.WithOnFenceRegression(func(_ string, _, _ int64) {
+			regressions++
+		}))
+
+	for i := 0; i < 2; i++ {
+		_, err := mu.Lock(ctx, 5*time.Second)
+		require.NoError(t, err)
+
+		require.NoError(t, mu.Unlock(ctx))
+	}
+
+	// Simulate the lock object being reset.
+	_, err = p.AtomicUpdateObject(ctx, bucket, key, func(_ string, _ []byte) ([]byte, error) {
+		return []byte("{}"), nil
+	})
+	require.NoError(t, err)
+
+	_, err = mu.Lock(ctx, 5*time.Second)
+	require.ErrorIs(t, err, objsync.ErrFenceRegression)
+	require.Equal(t, 1, regressions)
+
+	// The lock should have been released.
+	data, err := objsync.ReadObject(ctx, p, bucket, key)
+	require.NoError(t, err)
+	require.NotContains(t, string(data), `"id"`)
+}
+
+func TestMutexFenceRegressionCheck(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	key := fmt.Sprintf("test-%d.lock", time.Now().UnixNano())
+
+	ctx := context.Background()
+	p, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	require.NoError(t, err)
+
+	mu := objsync.NewMutex(p, bucket, key, objsync.WithFenceRegressionCheck())
+
+	for i := 0; i < 2; i++ {
+		_, err := mu.Lock(ctx, 5*time.Second)
+		require.NoError(t, err)
+
+		require.NoError(t, mu.Unlock(ctx))
+	}
+
+	// Simulate the lock object being reset.
+	_, err = p.AtomicUpdateObject(ctx, bucket, key, func(_ string, _ []byte) ([]byte, error) {
+		return []byte("{}"), nil
+	})
+	require.NoError(t, err)
+
+	// Mutexes without the check don't compare against the fencing tokens
+	// returned for the lock.
+	other := objsync.NewMutex(p, bucket, key)
+	_, err = other.Lock(ctx, 5*time.Second)
+	require.NoError(t, err)
+	require.NoError(t, other.Unlock(ctx))
+
+	// Whereas a new mutex for the same lock (eg. created per request) does.
+	mu = objsync.NewMutex(p, bucket, key, objsync.WithFenceRegressionCheck())
+
+	_, err = mu.Lock(ctx, 5*time.Second)
+	require.ErrorIs(t, err, objsync.ErrFenceRegression)
+}
+
+func TestMutexFenceEpoch(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	key := fmt.Sprintf("test-%d.lock", time.Now().UnixNano())
+
+	ctx := context.Background()
+	p, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	require.NoError(t, err)
+
+	mu := objsync.NewMutex(p, bucket, key)
+
+	fencingToken, err := mu.Lock(ctx, 5*time.Second)
+	require.NoError(t, err)
+	require.NoError(t, mu.Unlock(ctx))
+
+	// Simulate the lock object being restored from a backup.
+	_, err = p.AtomicUpdateObject(ctx, bucket, key, func(_ string, _ []byte) ([]byte, error) {
+		return []byte("{}"), nil
+	})
+	require.NoError(t, err)
+
+	// Bumping the epoch keeps the fencing tokens monotonic.
+	mu = objsync.NewMutex(p, bucket, key, objsync.WithFenceEpoch(1))
+
+	newFencingToken, err := mu.Lock(ctx, 5*time.Second)
+	require.NoError(t, err)
+	require.Greater(t, newFencingToken, fencingToken)
+	require.Greater(t, newFencingToken, int64(1)<<32)
+	require.NoError(t, mu.Unlock(ctx))
+
+	// Mutexes configured with an older epoch carry on from the newer epoch.
+	fencingToken, err = objsync.NewMutex(p, bucket, key).Lock(ctx, 5*time.Second)
+	require.NoError(t, err)
+	require.Equal(t, newFencingToken+1, fencingToken)
+	require.Equal(t, 1, objsync.CompareFencingTokens(fencingToken, newFencingToken))
+
+	epoch, counter := objsync.SplitFencingToken(fencingToken)
+	require.Equal(t, int64(1), epoch)
+	require.Equal(t, int64(2), counter)
+
+	// A new epoch is started whenever the lock object is created.
+	key = fmt.Sprintf("test-%d.lock", time.Now().UnixNano())
+
+	fencingToken, err = objsync.NewMutex(p, bucket, key, objsync.WithTimeFenceEpoch()).Lock(ctx, 5*time.Second)
+	require.NoError(t, err)
+
+	epoch, counter = objsync.SplitFencingToken(fencingToken)
+	require.Greater(t, epoch, int64(0))
+	require.Equal(t, int64(1), counter)
+}
+
+// transactionalProvider doesn't support reads from within an update (eg. as
+// with a SQLite transaction).
+type transactionalProvider struct {
+	provider.Provider
+	updating atomic.Bool
+}
+
+func (p *transactionalProvider) AtomicUpdateObject(ctx context.Context, bucket, key string, fn provider.UpdateObjectFunc) (string, error) {
+	if !p.updating.CompareAndSwap(false, true) {
+		return "", errors.New("read within an update")
+	}
+	defer p.updating.Store(false)
+
+	return p.Provider.AtomicUpdateObject(ctx, bucket, key, fn)
+}
+
+func TestMutexReadsOutsideUpdates(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	key := fmt.Sprintf("test-%d", time.Now().UnixNano())
+
+	ctx := context.Background()
+	s3Provider, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	require.NoError(t, err)
+
+	p := &transactionalProvider{Provider: s3Provider}
+
+	lease := objsync.NewLease(p, bucket, key+".lease")
+	require.NoError(t, lease.Grant(ctx, time.Minute))
+
+	// The lease is read before the lock object is updated.
+	mu := objsync.NewMutex(p, bucket, key+".lock", objsync.WithLease(lease))
+	_, err = mu.Lock(ctx, time.Minute)
+	require.NoError(t, err)
+
+	ok, _, err := objsync.NewMutex(p, bucket, key+".lock").TryLock(ctx, time.Second)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	require.NoError(t, mu.Unlock(ctx))
+	require.NoError(t, lease.Revoke(ctx))
+
+	// As is the fence sidecar, when the lock object doesn't exist.
+	mu = objsync.NewMutex(p, bucket, key+".sidecar.lock", objsync.WithFenceSidecar(key+".fence"))
+	_, err = mu.Lock(ctx, time.Minute)
+	require.NoError(t, err)
+	require.NoError(t, mu.Unlock(ctx))
+}
+
+// epochProvider reports a fence epoch (eg. as a failover provider would).
+type epochProvider struct {
+	provider.Provider
+	epoch int64
+}
+
+func (p *epochProvider) FenceEpoch() int64 {
+	return p.epoch
+}
+
+func TestMutexProviderFenceEpoch(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	key := fmt.Sprintf("test-%d.lock", time.Now().UnixNano())
+
+	ctx := context.Background()
+	s3Provider, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	require.NoError(t, err)
+
+	p := &epochProvider{Provider: s3Provider}
+	mu := objsync.NewMutex(p, bucket, key)
+
+	fencingToken, err := mu.Lock(ctx, 5*time.Second)
+	require.NoError(t, err)
+	require.NoError(t, mu.Unlock(ctx))
+
+	// The provider switched to a new epoch (eg. it failed over).
+	p.epoch = 2
+
+	newFencingToken, err := mu.Lock(ctx, 5*time.Second)
+	require.NoError(t, err)
+	require.NoError(t, mu.Unlock(ctx))
+	require.Equal(t, 1, objsync.CompareFencingTokens(newFencingToken, fencingToken))
+
+	epoch, counter := objsync.SplitFencingToken(newFencingToken)
+	require.Equal(t, int64(2), epoch)
+	require.Equal(t, int64(1), counter)
+}
+
+func TestMutexOwnerID(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	key := fmt.Sprintf("test-%d.lock", time.Now().UnixNano())
+
+	ctx := context.Background()
+	p, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	require.NoError(t, err)
+
+	mu := objsync.NewMutex(p, bucket, key, objsync.WithOwnerID("worker-1/pod-a"))
+	require.Equal(t, "worker-1/pod-a", mu.ID())
+
+	_, err = mu.Lock(ctx, 5*time.Second)
+	require.NoError(t, err)
+
+	data, err := objsync.ReadObject(ctx, p, bucket, key)
+	require.NoError(t, err)
+	require.Contains(t, string(data), `"id":"worker-1/pod-a"`)
+
+	require.NoError(t, mu.Unlock(ctx))
+}
+
+func TestMutexReclaim(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	key := fmt.Sprintf("test-%d.lock", time.Now().UnixNano())
+
+	ctx := context.Background()
+	p, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	require.NoError(t, err)
+
+	fencingToken, err := objsync.NewMutex(p, bucket, key, objsync.WithOwnerID("worker-1")).Lock(ctx, time.Minute)
+	require.NoError(t, err)
+
+	// Other owners must wait for the lock to expire.
+	ok, _, err := objsync.NewMutex(p, bucket, key, objsync.WithOwnerID("worker-2"), objsync.WithReclaim()).TryLock(ctx, time.Minute)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	// But the same owner (eg. after a restart) can reclaim it.
+	mu := objsync.NewMutex(p, bucket, key, objsync.WithOwnerID("worker-1"), objsync.WithReclaim())
+
+	ok, newFencingToken, err := mu.TryLock(ctx, time.Minute)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Greater(t, newFencingToken, fencingToken)
+
+	require.NoError(t, mu.Unlock(ctx))
+}
+
+func TestMutexHolderInfo(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	ctx := context.Background()
+	p, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	require.NoError(t, err)
+
+	t.Run("Enabled", func(t *testing.T) {
+		key := fmt.Sprintf("test-%d.lock", time.Now().UnixNano())
+
+		mu := objsync.NewMutex(p, bucket, key)
+
+		_, err = mu.Lock(ctx, 5*time.Second)
+		require.NoError(t, err)
+
+		data, err := objsync.ReadObject(ctx, p, bucket, key)
+		require.NoError(t, err)
+		require.Contains(t, string(data), fmt.Sprintf(`"pid":%d`, os.Getpid()))
+
+		require.NoError(t, mu.Unlock(ctx))
+
+		data, err = objsync.ReadObject(ctx, p, bucket, key)
+		require.NoError(t, err)
+		require.NotContains(t, string(data), `"holder"`)
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		key := fmt.Sprintf("test-%d.lock", time.Now().UnixNano())
+
+		mu := objsync.NewMutex(p, bucket, key, objsync.WithoutHolderInfo())
+
+		_, err = mu.Lock(ctx, 5*time.Second)
+		require.NoError(t, err)
+
+		data, err := objsync.ReadObject(ctx, p, bucket, key)
+		require.NoError(t, err)
+		require.NotContains(t, string(data), `"holder"`)
+
+		require.NoError(t, mu.Unlock(ctx))
+	})
+}
+
+func TestMutexMetadata(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	key := fmt.Sprintf("test-%d.lock", time.Now().UnixNano())
+
+	ctx := context.Background()
+	p, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	require.NoError(t, err)
+
+	mu := objsync.NewMutex(p, bucket, key, objsync.WithOwnerID("worker-1"),
+		objsync.WithMetadata(map[string]string{"job": "backfill-42", "note": "reindexing"}))
+
+	fencingToken, err := mu.Lock(ctx, 5*time.Second)
+	require.NoError(t, err)
+
+	info, err := objsync.Inspect(ctx, p, bucket, key)
+	require.NoError(t, err)
+	require.Equal(t, "worker-1", info.Owner)
+	require.WithinDuration(t, time.Now().Add(5*time.Second), info.Expires, time.Second)
+	require.Equal(t, fencingToken, info.FencingToken)
+	require.Equal(t, "backfill-42", info.Metadata["job"])
+	require.Equal(t, os.Getpid(), info.PID)
+	require.NotEmpty(t, info.Binary)
+	require.False(t, info.StartTime.IsZero())
+	require.True(t, info.StartTime.Before(time.Now()))
+
+	require.NoError(t, mu.Unlock(ctx))
+
+	info, err = mu.GetLockInfo(ctx)
+	require.NoError(t, err)
+	require.Empty(t, info.Owner)
+	require.Empty(t, info.Metadata)
+
+	// Objects that aren't locks.
+	_, err = p.AtomicUpdateObject(ctx, bucket, key+".config", func(_ string, _ []byte) ([]byte, error) {
+		return []byte(`{"schemaVersion":1,"replicas":3}`), nil
+	})
+	require.NoError(t, err)
+
+	_, err = objsync.Inspect(ctx, p, bucket, key+".config")
+	require.ErrorIs(t, err, objsync.ErrNotMutex)
+}
+
+func TestMutexExpiryWarning(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	key := fmt.Sprintf("test-%d.lock", time.Now().UnixNano())
+
+	ctx := context.Background()
+	p, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	require.NoError(t, err)
+
+	var warnings int32
+	mu := objsync.NewMutex(p, bucket, key, objsync.WithExpiryWarning(time.Second, func(_ time.Time) {
+		atomic.AddInt32(&warnings, 1)
+	}))
+
+	_, err = mu.Lock(ctx, 2*time.Second)
+	require.NoError(t, err)
+
+	select {
+	case <-mu.ExpiryWarning():
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected an expiry warning")
+	}
+
+	require.NoError(t, mu.Unlock(ctx))
+
+	// No warning should be delivered for a released hold.
+	_, err = mu.Lock(ctx, 2*time.Second)
+	require.NoError(t, err)
+
+	require.NoError(t, mu.Unlock(ctx))
+
+	select {
+	case <-mu.ExpiryWarning():
+		t.Fatal("unexpected expiry warning")
+	case <-time.After(2 * time.Second):
+	}
+
+	require.Equal(t, int32(1), atomic.LoadInt32(&warnings))
+}
+
+func TestMutexDeleteOnUnlock(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	key := fmt.Sprintf("test-%d.lock", time.Now().UnixNano())
+
+	ctx := context.Background()
+	p, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	require.NoError(t, err)
+
+	mu := objsync.NewMutex(p, bucket, key, objsync.WithDeleteOnUnlock(key+".fence"))
+
+	var lastFencingToken int64
+	for i := 0; i < 3; i++ {
+		fencingToken, err := mu.Lock(ctx, 5*time.Second)
+		require.NoError(t, err)
+
+		// Fencing tokens must remain monotonic, even if the lock object is deleted.
+		require.Greater(t, fencingToken, lastFencingToken)
+		lastFencingToken = fencingToken
+
+		require.NoError(t, mu.Unlock(ctx))
+	}
+}
+
+func TestMutexTTLValidation(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	key := fmt.Sprintf("test-%d.lock", time.Now().UnixNano())
+
+	ctx := context.Background()
+	p, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	require.NoError(t, err)
+
+	mu := objsync.NewMutex(p, bucket, key, objsync.WithTTLBounds(5*time.Second, time.Minute))
+
+	_, _, err = mu.TryLock(ctx, 0)
+	require.ErrorIs(t, err, objsync.ErrInvalidTTL)
+
+	_, err = mu.Lock(ctx, -time.Second)
+	require.ErrorIs(t, err, objsync.ErrInvalidTTL)
+
+	// Should be clamped to the minimum duration.
+	ok, _, err := mu.TryLock(ctx, time.Millisecond)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	time.Sleep(100 * time.Millisecond)
+
+	ok, _, err = objsync.NewMutex(p, bucket, key).TryLock(ctx, time.Second)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	require.NoError(t, mu.Unlock(ctx))
+}
+
+func TestMutexLockContext(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	key := fmt.Sprintf("test-%d.lock", time.Now().UnixNano())
+
+	ctx := context.Background()
+	p, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	require.NoError(t, err)
+
+	mu := objsync.NewMutex(p, bucket, key)
+
+	t.Run("Unlock", func(t *testing.T) {
+		lockCtx, _, err := mu.LockContext(ctx, 5*time.Second)
+		require.NoError(t, err)
+
+		require.NoError(t, lockCtx.Err())
+
+		require.NoError(t, mu.Unlock(ctx))
+
+		require.ErrorIs(t, lockCtx.Err(), context.Canceled)
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		lockCtx, _, err := mu.LockContext(ctx, time.Second)
+		require.NoError(t, err)
+
+		select {
+		case <-lockCtx.Done():
+		case <-time.After(2 * time.Second):
+			t.Fatal("expected lock-scoped context to be cancelled")
+		}
+
+		require.ErrorIs(t, context.Cause(lockCtx), objsync.ErrLockLost)
+	})
+}
+
+func TestMutexDone(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	key := fmt.Sprintf("test-%d.lock", time.Now().UnixNano())
+
+	ctx := context.Background()
+	p, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	require.NoError(t, err)
+
+	mu := objsync.NewMutex(p, bucket, key)
+
+	// Not held.
+	<-mu.Done()
+
+	t.Run("Unlock", func(t *testing.T) {
+		_, err := mu.Lock(ctx, 5*time.Second)
+		require.NoError(t, err)
+
+		done := mu.Done()
+		select {
+		case <-done:
+			t.Fatal("expected done channel to be open while the lock is held")
+		default:
+		}
+
+		require.NoError(t, mu.Unlock(ctx))
+
+		<-done
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		_, err := mu.Lock(ctx, time.Second)
+		require.NoError(t, err)
+
+		select {
+		case <-mu.Done():
+		case <-time.After(2 * time.Second):
+			t.Fatal("expected done channel to be closed")
+		}
+	})
+}
+
+func TestMutexLockWithTimeout(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	key := fmt.Sprintf("test-%d.lock", time.Now().UnixNano())
+
+	ctx := context.Background()
+	p, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	require.NoError(t, err)
+
+	mu := objsync.NewMutex(p, bucket, key)
+
+	_, err = mu.LockWithTimeout(ctx, time.Second, 0)
+	require.NoError(t, err)
+
+	other := objsync.NewMutex(p, bucket, key)
+
+	_, err = other.LockWithTimeout(ctx, time.Second, 200*time.Millisecond)
+	require.ErrorIs(t, err, objsync.ErrLockTimeout)
+	require.ErrorIs(t, err, objsync.ErrLockHeld)
+
+	// The error describes the current holder.
+	var heldErr *objsync.LockHeldError
+	require.ErrorAs(t, err, &heldErr)
+	require.Equal(t, mu.ID(), heldErr.Owner)
+
+	// The lock becomes available once it expires.
+	_, err = other.TryLockUntil(ctx, time.Second, time.Now().Add(5*time.Second))
+	require.NoError(t, err)
+
+	require.NoError(t, other.Unlock(ctx))
+}
+
+func TestMutexMaxRetryDelay(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	key := fmt.Sprintf("test-%d.lock", time.Now().UnixNano())
+
+	ctx := context.Background()
+	p, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	require.NoError(t, err)
+
+	mu := objsync.NewMutex(p, bucket, key)
+
+	_, err = mu.Lock(ctx, 2*time.Second)
+	require.NoError(t, err)
+
+	// Without a cap, the backoff would have grown past a second by the time
+	// the lock expires.
+	other := objsync.NewMutex(p, bucket, key, objsync.WithMaxRetryDelay(100*time.Millisecond))
+
+	start := time.Now()
+	_, err = other.Lock(ctx, time.Second)
+	require.NoError(t, err)
+	require.Less(t, time.Since(start), 2500*time.Millisecond)
+
+	require.NoError(t, other.Unlock(ctx))
+}
+
+type fakeClock struct {
+	offset time.Duration
+}
+
+func (c *fakeClock) Now() time.Time {
+	return time.Now().Add(c.offset)
+}
+
+func TestMutexClockSkew(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	key := fmt.Sprintf("test-%d.lock", time.Now().UnixNano())
+
+	ctx := context.Background()
+	p, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	require.NoError(t, err)
+
+	_, err = objsync.NewMutex(p, bucket, key).Lock(ctx, 5*time.Second)
+	require.NoError(t, err)
+
+	// A client whose clock is running ahead would take over the lock early.
+	ahead := &fakeClock{offset: 10 * time.Second}
+
+	ok, _, err := objsync.NewMutex(p, bucket, key, objsync.WithClock(ahead), objsync.WithSkewMargin(time.Minute)).TryLock(ctx, time.Second)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	ok, _, err = objsync.NewMutex(p, bucket, key, objsync.WithClock(ahead)).TryLock(ctx, time.Second)
+	require.NoError(t, err)
+	require.True(t, ok)
+}
+
+func TestMutexClockTimers(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	key := fmt.Sprintf("test-%d.lock", time.Now().UnixNano())
+
+	ctx := context.Background()
+	p, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	require.NoError(t, err)
+
+	// Timers are scheduled relative to the clock, so a clock that is behind
+	// doesn't cut holds short.
+	behind := &fakeClock{offset: -time.Hour}
+	mu := objsync.NewMutex(p, bucket, key, objsync.WithClock(behind))
+
+	lockCtx, _, err := mu.LockContext(ctx, 500*time.Millisecond)
+	require.NoError(t, err)
+
+	time.Sleep(100 * time.Millisecond)
+	require.NoError(t, lockCtx.Err())
+
+	select {
+	case <-lockCtx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("expected the hold to expire")
+	}
+
+	_, err = mu.LockWithTimeout(ctx, time.Second, 100*time.Millisecond)
+	require.NoError(t, err)
+	require.NoError(t, mu.Unlock(ctx))
+}
+
+// serverTimeProvider is a provider whose backend clock is running ahead.
+type serverTimeProvider struct {
+	provider.Provider
+	offset time.Duration
+}
+
+func (p *serverTimeProvider) Now(_ context.Context) (time.Time, error) {
+	return time.Now().Add(p.offset), nil
+}
+
+func (p *serverTimeProvider) Capabilities() provider.Capabilities {
+	caps := provider.CapabilitiesOf(p.Provider)
+	caps.ServerTime = true
+	return caps
+}
+
+func TestMutexProviderClock(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	key := fmt.Sprintf("test-%d.lock", time.Now().UnixNano())
+
+	ctx := context.Background()
+	p, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	require.NoError(t, err)
+
+	_, err = objsync.NewProviderClock(ctx, p)
+	require.ErrorIs(t, err, provider.ErrNotSupported)
+
+	sp := &serverTimeProvider{Provider: p, offset: time.Hour}
+
+	clock, err := objsync.NewProviderClock(ctx, sp)
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Now().Add(time.Hour), clock.Now(), time.Second)
+
+	mu := objsync.NewMutex(sp, bucket, key, objsync.WithClock(clock))
+
+	_, err = mu.Lock(ctx, time.Minute)
+	require.NoError(t, err)
+
+	info, err := mu.GetLockInfo(ctx)
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Now().Add(time.Hour+time.Minute), info.Expires, 5*time.Second)
+}
+
+func TestMutexLockWithKeepAlive(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	key := fmt.Sprintf("test-%d.lock", time.Now().UnixNano())
+
+	ctx := context.Background()
+	p, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	require.NoError(t, err)
+
+	mu := objsync.NewMutex(p, bucket, key)
+
+	_, err = mu.LockWithKeepAlive(ctx, 300*time.Millisecond)
+	require.NoError(t, err)
+
+	// Outlive the initial lock duration.
+	time.Sleep(time.Second)
+
+	ok, _, err := objsync.NewMutex(p, bucket, key).TryLock(ctx, time.Second)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	require.NoError(t, mu.Unlock(ctx))
+
+	ok, _, err = objsync.NewMutex(p, bucket, key).TryLock(ctx, time.Second)
+	require.NoError(t, err)
+	require.True(t, ok)
+}
+
+// failingProvider fails updates while failing is set, or the next failures
+// updates.
+type failingProvider struct {
+	provider.Provider
+	failing  atomic.Bool
+	failures atomic.Int32
+}
+
+func (p *failingProvider) AtomicUpdateObject(ctx context.Context, bucket, key string, fn provider.UpdateObjectFunc) (string, error) {
+	if p.failing.Load() || p.failures.Add(-1) >= 0 {
+		return "", errors.New("unavailable")
+	}
+
+	return p.Provider.AtomicUpdateObject(ctx, bucket, key, fn)
+}
+
+func TestMutexRenewalPolicy(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	ctx := context.Background()
+	s3Provider, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	require.NoError(t, err)
+
+	t.Run("RetryBudget", func(t *testing.T) {
+		key := fmt.Sprintf("test-%d.lock", time.Now().UnixNano())
+
+		p := &failingProvider{Provider: s3Provider}
+
+		lost := make(chan error, 1)
+		mu := objsync.NewMutex(p, bucket, key, objsync.WithRenewalPolicy(objsync.RenewalPolicy{
+			MaxFailures: 2,
+			OnLost:      func(err error) { lost <- err },
+		}))
+
+		start := time.Now()
+		_, err := mu.LockWithKeepAlive(ctx, 3*time.Second)
+		require.NoError(t, err)
+
+		p.failing.Store(true)
+
+		// The hold is given up on after two failed renewals, before it expires.
+		select {
+		case err := <-lost:
+			require.NotErrorIs(t, err, objsync.ErrLockLost)
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected the hold to be given up on")
+		}
+		require.Less(t, time.Since(start), 3*time.Second)
+
+		select {
+		case <-mu.Done():
+		default:
+			t.Fatal("expected the hold to be cancelled")
+		}
+	})
+
+	t.Run("Reacquire", func(t *testing.T) {
+		key := fmt.Sprintf("test-%d.lock", time.Now().UnixNano())
+
+		p := &failingProvider{Provider: s3Provider}
+
+		reacquired := make(chan int64, 1)
+		mu := objsync.NewMutex(p, bucket, key, objsync.WithRenewalPolicy(objsync.RenewalPolicy{
+			MaxFailures:  1,
+			Reacquire:    true,
+			OnReacquired: func(fencingToken int64) { reacquired <- fencingToken },
+			OnLost: func(err error) {
+				t.Errorf("unexpected loss of the hold: %v", err)
+			},
+		}))
+
+		fencingToken, err := mu.LockWithKeepAlive(ctx, 300*time.Millisecond)
+		require.NoError(t, err)
+
+		done := mu.Done()
+
+		// The renewal fails, but the lock is still ours to acquire again.
+		p.failures.Store(1)
+
+		select {
+		case newFencingToken := <-reacquired:
+			require.Greater(t, newFencingToken, fencingToken)
+			fencingToken = newFencingToken
+		case <-time.After(2 * time.Second):
+			t.Fatal("expected the lock to be reacquired")
+		}
+
+		// The previous hold ended, as its fencing token is stale.
+		select {
+		case <-done:
+		default:
+			t.Fatal("expected the previous hold to end")
+		}
+
+		info, err := objsync.Inspect(ctx, s3Provider, bucket, key)
+		require.NoError(t, err)
+		require.Equal(t, mu.ID(), info.Owner)
+		require.Equal(t, fencingToken, info.FencingToken)
+
+		done = mu.Done()
+
+		// Breaking the lock loses the hold, but the lock is free to be
+		// acquired again, under a new hold.
+		require.NoError(t, objsync.BreakLock(ctx, s3Provider, bucket, key))
+
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatal("expected the hold to be lost")
+		}
+
+		select {
+		case newFencingToken := <-reacquired:
+			require.Greater(t, newFencingToken, fencingToken)
+		case <-time.After(2 * time.Second):
+			t.Fatal("expected the lock to be reacquired")
+		}
+
+		require.NoError(t, mu.Unlock(ctx))
+	})
+
+	t.Run("ReacquireReentrant", func(t *testing.T) {
+		key := fmt.Sprintf("test-%d.lock", time.Now().UnixNano())
+
+		p := &failingProvider{Provider: s3Provider}
+
+		reacquired := make(chan int64, 1)
+		mu := objsync.NewMutex(p, bucket, key, objsync.WithReentrant(), objsync.WithRenewalPolicy(objsync.RenewalPolicy{
+			MaxFailures:  1,
+			Reacquire:    true,
+			OnReacquired: func(fencingToken int64) { reacquired <- fencingToken },
+		}))
+
+		_, err := mu.LockWithKeepAlive(ctx, 300*time.Millisecond)
+		require.NoError(t, err)
+
+		p.failures.Store(1)
+
+		select {
+		case <-reacquired:
+		case <-time.After(2 * time.Second):
+			t.Fatal("expected the lock to be reacquired")
+		}
+
+		// Reacquiring didn't take another hold, so a single unlock releases
+		// the lock.
+		require.NoError(t, mu.Unlock(ctx))
+
+		info, err := objsync.Inspect(ctx, s3Provider, bucket, key)
+		require.NoError(t, err)
+		require.Empty(t, info.Owner)
+	})
+}
+
+// Run with -race to check that the keepalive doesn't race manual renewals.
+func TestMutexExtendWithKeepAlive(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	key := fmt.Sprintf("test-%d.lock", time.Now().UnixNano())
+
+	ctx := context.Background()
+	p, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	require.NoError(t, err)
+
+	mu := objsync.NewMutex(p, bucket, key)
+
+	_, err = mu.LockWithKeepAlive(ctx, 300*time.Millisecond)
+	require.NoError(t, err)
+
+	var g errgroup.Group
+	for i := 0; i < 4; i++ {
+		g.Go(func() error {
+			for j := 0; j < 10; j++ {
+				// Renewals by the keepalive must not make the hold look lost.
+				if err := mu.Extend(ctx, 100*time.Millisecond); err != nil {
+					return err
+				}
+
+				time.Sleep(20 * time.Millisecond)
+			}
+
+			return nil
+		})
+	}
+	require.NoError(t, g.Wait())
+
+	require.NoError(t, mu.Unlock(ctx))
+}
+
+func TestMutexExtend(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	key := fmt.Sprintf("test-%d.lock", time.Now().UnixNano())
+
+	ctx := context.Background()
+	p, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	require.NoError(t, err)
+
+	mu := objsync.NewMutex(p, bucket, key)
+
+	_, err = mu.Lock(ctx, 500*time.Millisecond)
+	require.NoError(t, err)
+
+	require.NoError(t, mu.Extend(ctx, time.Second))
+
+	// Outlive the initial lock duration.
+	time.Sleep(time.Second)
+
+	ok, _, err := objsync.NewMutex(p, bucket, key).TryLock(ctx, time.Second)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	// Once the lock has expired, it can no longer be extended.
+	time.Sleep(time.Second)
+
+	require.ErrorIs(t, mu.Extend(ctx, time.Second), objsync.ErrLockLost)
+}
+
+func TestMutexUnlockNotHeld(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	key := fmt.Sprintf("test-%d.lock", time.Now().UnixNano())
+
+	ctx := context.Background()
+	p, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	require.NoError(t, err)
+
+	mu := objsync.NewMutex(p, bucket, key)
+
+	require.ErrorIs(t, mu.Unlock(ctx), objsync.ErrNotHeld)
+
+	fencingToken, err := mu.Lock(ctx, 500*time.Millisecond)
+	require.NoError(t, err)
+
+	require.ErrorIs(t, mu.UnlockWithToken(ctx, fencingToken-1), objsync.ErrNotHeld)
+	require.NoError(t, mu.UnlockWithToken(ctx, fencingToken))
+	require.ErrorIs(t, mu.Unlock(ctx), objsync.ErrNotHeld)
+
+	// The lock expires, and is taken over by another owner.
+	_, err = mu.Lock(ctx, 500*time.Millisecond)
+	require.NoError(t, err)
+
+	time.Sleep(time.Second)
+
+	other := objsync.NewMutex(p, bucket, key)
+	_, err = other.Lock(ctx, time.Second)
+	require.NoError(t, err)
+
+	require.ErrorIs(t, mu.Unlock(ctx), objsync.ErrNotHeld)
+	require.NoError(t, other.Unlock(ctx))
+}
+
+func TestMutexLocker(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	key := fmt.Sprintf("test-%d.lock", time.Now().UnixNano())
+
+	ctx := context.Background()
+	p, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	require.NoError(t, err)
+
+	var l sync.Locker = objsync.NewMutex(p, bucket, key).Locker(5 * time.Second)
+
+	l.Lock()
+
+	ok, _, err := objsync.NewMutex(p, bucket, key).TryLock(ctx, time.Second)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	l.Unlock()
+
+	// Unlocking a mutex that isn't held panics, as with sync.Mutex.
+	require.Panics(t, l.Unlock)
+}
+
+func TestMutexBreakLock(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	key := fmt.Sprintf("test-%d.lock", time.Now().UnixNano())
+
+	ctx := context.Background()
+	p, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	require.NoError(t, err)
+
+	mu := objsync.NewMutex(p, bucket, key, objsync.WithOwnerID("wedged"))
+
+	fencingToken, err := mu.Lock(ctx, time.Minute)
+	require.NoError(t, err)
+
+	require.NoError(t, objsync.BreakLock(ctx, p, bucket, key))
+
+	data, err := objsync.ReadObject(ctx, p, bucket, key)
+	require.NoError(t, err)
+	require.Contains(t, string(data), `"owner":"wedged"`)
+
+	other := objsync.NewMutex(p, bucket, key)
+
+	ok, newFencingToken, err := other.TryLock(ctx, time.Minute)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Greater(t, newFencingToken, fencingToken+1)
+
+	// The broken holder no longer holds the lock.
+	require.ErrorIs(t, mu.Unlock(ctx), objsync.ErrNotHeld)
+
+	require.NoError(t, other.ForceUnlock(ctx))
+
+	owner, err := other.Owner(ctx)
+	require.NoError(t, err)
+	require.Empty(t, owner)
+}
+
+func TestMutexReentrant(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	key := fmt.Sprintf("test-%d.lock", time.Now().UnixNano())
+
+	ctx := context.Background()
+	p, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	require.NoError(t, err)
+
+	outer := objsync.NewMutex(p, bucket, key, objsync.WithOwnerID("owner"), objsync.WithReentrant())
+	inner := objsync.NewMutex(p, bucket, key, objsync.WithOwnerID("owner"), objsync.WithReentrant())
+
+	outerFencingToken, err := outer.Lock(ctx, 5*time.Second)
+	require.NoError(t, err)
+
+	// The same owner can acquire the lock again.
+	ok, innerFencingToken, err := inner.TryLock(ctx, 5*time.Second)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, outerFencingToken, innerFencingToken)
+
+	// But other owners can't.
+	ok, _, err = objsync.NewMutex(p, bucket, key, objsync.WithReentrant()).TryLock(ctx, 5*time.Second)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	// Releasing the inner hold leaves the lock held.
+	require.NoError(t, inner.Unlock(ctx))
+
+	owner, err := outer.Owner(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "owner", owner)
+
+	require.NoError(t, outer.Unlock(ctx))
+
+	owner, err = outer.Owner(ctx)
+	require.NoError(t, err)
+	require.Empty(t, owner)
+}
+
+func TestMutexStatsHandler(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	key := fmt.Sprintf("test-%d.lock", time.Now().UnixNano())
+
+	ctx := context.Background()
+	p, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	require.NoError(t, err)
+
+	var eventsMu sync.Mutex
+	var events []stats.EventType
+	h := stats.HandlerFunc(func(_ context.Context, event stats.Event) {
+		eventsMu.Lock()
+		defer eventsMu.Unlock()
+
+		require.Equal(t, key, event.Key)
+		events = append(events, event.Type)
+	})
+
+	mu := objsync.NewMutex(p, bucket, key, objsync.WithStatsHandler(h))
+
+	_, err = mu.Lock(ctx, 5*time.Second)
+	require.NoError(t, err)
+
+	ok, _, err := objsync.NewMutex(p, bucket, key, objsync.WithStatsHandler(h)).TryLock(ctx, 5*time.Second)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	require.NoError(t, mu.Unlock(ctx))
+
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+
+	require.Equal(t, []stats.EventType{
+		stats.EventAcquireAttempt,
+		stats.EventAcquired,
+		stats.EventAcquireAttempt,
+		stats.EventContended,
+		stats.EventReleased,
+	}, events)
+}
+
+func TestMutexHooks(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	key := fmt.Sprintf("test-%d.lock", time.Now().UnixNano())
+
+	ctx := context.Background()
+	p, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	require.NoError(t, err)
+
+	var acquired, contended, expired atomic.Int32
+	hooks := &stats.Hooks{
+		OnAcquired:   func(context.Context, stats.Event) { acquired.Add(1) },
+		OnContention: func(context.Context, stats.Event) { contended.Add(1) },
+		OnExpired:    func(context.Context, stats.Event) { expired.Add(1) },
+	}
+
+	mu := objsync.NewMutex(p, bucket, key, objsync.WithStatsHandler(hooks))
+
+	_, err = mu.Lock(ctx, 500*time.Millisecond)
+	require.NoError(t, err)
+
+	ok, _, err := objsync.NewMutex(p, bucket, key, objsync.WithStatsHandler(hooks)).TryLock(ctx, time.Second)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	require.Eventually(t, func() bool { return expired.Load() == 1 }, 2*time.Second, 50*time.Millisecond)
+	require.Equal(t, int32(1), acquired.Load())
+	require.Equal(t, int32(1), contended.Load())
+}
+
+func TestMutexLogger(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	key := fmt.Sprintf("test-%d.lock", time.Now().UnixNano())
+
+	ctx := context.Background()
+	p, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	mu := objsync.NewMutex(p, bucket, key, objsync.WithLogger(logger))
+
+	_, err = mu.Lock(ctx, time.Minute)
+	require.NoError(t, err)
+
+	require.NoError(t, mu.Unlock(ctx))
+
+	logs := buf.String()
+	require.Contains(t, logs, "level=DEBUG")
+	require.Contains(t, logs, "objsync: "+string(stats.EventAcquired))
+	require.Contains(t, logs, "objsync: "+string(stats.EventReleased))
+	require.Contains(t, logs, "key="+key)
+}
+
+// countingProvider counts the requests made to the underlying provider.
+type countingProvider struct {
+	provider.Provider
+	updates atomic.Int32
+	heads   atomic.Int32
+}
+
+func (p *countingProvider) AtomicUpdateObject(ctx context.Context, bucket, key string, fn provider.UpdateObjectFunc) (string, error) {
+	p.updates.Add(1)
+	return p.Provider.AtomicUpdateObject(ctx, bucket, key, fn)
+}
+
+func (p *countingProvider) HeadObject(ctx context.Context, bucket, key string) (string, error) {
+	p.heads.Add(1)
+	return p.Provider.(provider.Header).HeadObject(ctx, bucket, key)
+}
+
+func (p *countingProvider) Capabilities() provider.Capabilities {
+	return provider.CapabilitiesOf(p.Provider)
+}
+
+func TestMutexHeadPolling(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	key := fmt.Sprintf("test-%d.lock", time.Now().UnixNano())
+
+	ctx := context.Background()
+	s3Provider, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	require.NoError(t, err)
+
+	mu := objsync.NewMutex(s3Provider, bucket, key)
+	_, err = mu.Lock(ctx, time.Minute)
+	require.NoError(t, err)
+
+	p := &countingProvider{Provider: s3Provider}
+	other := objsync.NewMutex(p, bucket, key)
+
+	for i := 0; i < 5; i++ {
+		ok, _, err := other.TryLock(ctx, time.Minute)
+		require.NoError(t, err)
+		require.False(t, ok)
+	}
+
+	// Only the first attempt reads the lock object, the rest just check
+	// whether it has changed.
+	require.Equal(t, int32(1), p.updates.Load())
+	require.Equal(t, int32(4), p.heads.Load())
+
+	require.NoError(t, mu.Unlock(ctx))
+
+	ok, _, err := other.TryLock(ctx, time.Minute)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	require.NoError(t, other.Unlock(ctx))
+}
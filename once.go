@@ -0,0 +1,239 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/dpeckett/objsync/provider"
+)
+
+// OnceOption is a functional option for configuring a once.
+type OnceOption func(*Once)
+
+// WithOncePollInterval sets how often callers waiting on an in-flight
+// execution check whether it has completed.
+func WithOncePollInterval(interval time.Duration) OnceOption {
+	return func(o *Once) {
+		o.pollInterval = interval
+	}
+}
+
+// Once performs an action exactly once across all processes. It is the
+// distributed equivalent of sync.Once, with the exception that a failed
+// execution (one that returns an error, or whose process dies) is not
+// recorded, and the action will be retried by the next caller.
+type Once struct {
+	provider     provider.Provider
+	bucket       string
+	key          string
+	ttl          time.Duration
+	pollInterval time.Duration
+}
+
+// The current schema version of the once object.
+const onceSchemaVersion = 1
+
+// The JSON content of the once object.
+type onceContent struct {
+	SchemaVersion int        `json:"schemaVersion,omitempty"`
+	CompletedAt   *time.Time `json:"completedAt,omitempty"`
+	Fence         int64      `json:"fence,omitempty"`
+	// The hex encoded SHA-256 hash of the result of the action.
+	ResultHash string `json:"resultHash,omitempty"`
+}
+
+// NewOnce creates a new distributed once. The ttl is the maximum duration the
+// action will be allowed to run for, after which it is assumed to have failed
+// and another caller will execute it.
+func NewOnce(p provider.Provider, bucket, key string, ttl time.Duration, opts ...OnceOption) *Once {
+	o := &Once{
+		provider:     p,
+		bucket:       bucket,
+		key:          key,
+		ttl:          ttl,
+		pollInterval: 100 * time.Millisecond,
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
+}
+
+// Do executes the function if and only if it has not already been
+// successfully executed by any process, reporting whether it was executed by
+// this call. If another process is executing the function, Do waits for it to
+// complete. The result of the function is hashed and recorded (see
+// ResultHash).
+func (o *Once) Do(ctx context.Context, fn func(ctx context.Context) ([]byte, error)) (bool, error) {
+	lockKey := o.key + ".lock"
+
+	mu := NewMutex(o.provider, o.bucket, lockKey)
+
+	for {
+		content, err := o.read(ctx)
+		if err != nil {
+			return false, err
+		}
+
+		if content.CompletedAt != nil {
+			return false, nil
+		}
+
+		ok, fencingToken, err := mu.TryLock(ctx, o.ttl)
+		if err != nil {
+			return false, err
+		}
+
+		if ok {
+			return o.execute(ctx, mu, fencingToken, fn)
+		}
+
+		if err := o.waitForCompletion(ctx, lockKey); err != nil {
+			return false, err
+		}
+	}
+}
+
+// Done reports whether the action has been executed.
+func (o *Once) Done(ctx context.Context) (bool, error) {
+	content, err := o.read(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	return content.CompletedAt != nil, nil
+}
+
+// ResultHash returns the hex encoded SHA-256 hash of the result of the
+// action, or an empty string if the action has not been executed.
+func (o *Once) ResultHash(ctx context.Context) (string, error) {
+	content, err := o.read(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return content.ResultHash, nil
+}
+
+// execute runs the function (if it has not been completed in the meantime)
+// and records its completion.
+func (o *Once) execute(ctx context.Context, mu *Mutex, fencingToken int64, fn func(ctx context.Context) ([]byte, error)) (bool, error) {
+	defer func() {
+		_ = mu.Unlock(context.WithoutCancel(ctx))
+	}()
+
+	// Completed between checking and acquiring the lock.
+	content, err := o.read(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	if content.CompletedAt != nil {
+		return false, nil
+	}
+
+	result, err := fn(ctx)
+	if err != nil {
+		return true, err
+	}
+
+	hash := sha256.Sum256(result)
+
+	_, err = updateObject(ctx, o.provider, o.bucket, o.key, func(_ string, currentData []byte) ([]byte, error) {
+		content, err := o.decode(currentData)
+		if err != nil {
+			return nil, err
+		}
+
+		completedAt := time.Now().UTC()
+		content.CompletedAt = &completedAt
+		content.Fence = fencingToken
+		content.ResultHash = hex.EncodeToString(hash[:])
+
+		return json.Marshal(content)
+	})
+	if err != nil {
+		return true, fmt.Errorf("failed to record completion: %w", err)
+	}
+
+	return true, nil
+}
+
+// waitForCompletion waits for the in-flight execution to complete, or to be
+// abandoned (in which case the lock will have been released or expired).
+func (o *Once) waitForCompletion(ctx context.Context, lockKey string) error {
+	ticker := time.NewTicker(o.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		content, err := o.read(ctx)
+		if err != nil {
+			return err
+		}
+
+		if content.CompletedAt != nil {
+			return nil
+		}
+
+		data, err := readObject(ctx, o.provider, o.bucket, lockKey)
+		if err != nil {
+			return err
+		}
+
+		lock, err := decodeMutexContent(data)
+		if err != nil {
+			return err
+		}
+
+		if lock.Expires == nil || time.Now().After(*lock.Expires) {
+			return nil
+		}
+	}
+}
+
+// read reads the current content of the once object.
+func (o *Once) read(ctx context.Context) (*onceContent, error) {
+	data, err := readObject(ctx, o.provider, o.bucket, o.key)
+	if err != nil {
+		return nil, err
+	}
+
+	return o.decode(data)
+}
+
+// decode decodes the once object.
+func (o *Once) decode(data []byte) (*onceContent, error) {
+	var content onceContent
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &content); err != nil {
+			return nil, err
+		}
+	}
+
+	if content.SchemaVersion == 0 {
+		content.SchemaVersion = onceSchemaVersion
+	}
+
+	return &content, nil
+}
@@ -0,0 +1,103 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dpeckett/objsync"
+	"github.com/dpeckett/objsync/provider/s3"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestOnce(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	ctx := context.Background()
+	p, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	require.NoError(t, err)
+
+	t.Run("ExactlyOnce", func(t *testing.T) {
+		key := fmt.Sprintf("test-%d.once", time.Now().UnixNano())
+
+		var calls, executed int32
+		g, gctx := errgroup.WithContext(ctx)
+		for i := 0; i < 5; i++ {
+			g.Go(func() error {
+				ok, err := objsync.NewOnce(p, bucket, key, 5*time.Second).Do(gctx, func(ctx context.Context) ([]byte, error) {
+					atomic.AddInt32(&calls, 1)
+
+					// Simulate some work.
+					time.Sleep(50 * time.Millisecond)
+
+					return []byte("result"), nil
+				})
+				if err != nil {
+					return err
+				}
+
+				if ok {
+					atomic.AddInt32(&executed, 1)
+				}
+
+				return nil
+			})
+		}
+
+		require.NoError(t, g.Wait())
+
+		require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+		require.Equal(t, int32(1), atomic.LoadInt32(&executed))
+
+		hash := sha256.Sum256([]byte("result"))
+
+		resultHash, err := objsync.NewOnce(p, bucket, key, 5*time.Second).ResultHash(ctx)
+		require.NoError(t, err)
+		require.Equal(t, hex.EncodeToString(hash[:]), resultHash)
+	})
+
+	t.Run("RetryAfterFailure", func(t *testing.T) {
+		key := fmt.Sprintf("test-%d.once", time.Now().UnixNano())
+
+		once := objsync.NewOnce(p, bucket, key, 5*time.Second)
+
+		ok, err := once.Do(ctx, func(ctx context.Context) ([]byte, error) {
+			return nil, errors.New("bang")
+		})
+		require.Error(t, err)
+		require.True(t, ok)
+
+		done, err := once.Done(ctx)
+		require.NoError(t, err)
+		require.False(t, done)
+
+		ok, err = once.Do(ctx, func(ctx context.Context) ([]byte, error) {
+			return nil, nil
+		})
+		require.NoError(t, err)
+		require.True(t, ok)
+
+		done, err = once.Done(ctx)
+		require.NoError(t, err)
+		require.True(t, done)
+	})
+}
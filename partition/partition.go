@@ -0,0 +1,309 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package partition spreads a fixed number of shards across a dynamic group
+// of members, without a coordinator service. Members register themselves in
+// a shared object, and whenever the set of live members changes, the shards
+// are deterministically reassigned (in the same atomic update).
+package partition
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/avast/retry-go/v4"
+	"github.com/dpeckett/objsync/provider"
+	"github.com/google/uuid"
+)
+
+// Config configures a partitioner.
+type Config struct {
+	// Provider is the object storage provider used to store the membership
+	// object.
+	Provider provider.Provider
+	// Bucket is the bucket containing the membership object.
+	Bucket string
+	// Key is the key of the membership object.
+	Key string
+	// Identity is the identity of this member (defaults to a random ID).
+	Identity string
+	// Shards is the number of shards to spread across the members. The first
+	// member to register decides the number of shards.
+	Shards int
+	// MemberTTL is how long a member remains registered without sending a
+	// heartbeat, before it is assumed to have died and its shards are
+	// reassigned.
+	MemberTTL time.Duration
+	// HeartbeatPeriod is how often members refresh their registration and
+	// check for reassignments (defaults to a third of the member ttl).
+	HeartbeatPeriod time.Duration
+}
+
+// Assignment is the set of shards assigned to a member.
+type Assignment struct {
+	// Generation is incremented every time the shards are reassigned. It can
+	// be used as a fencing token, as a shard may briefly be processed by both
+	// its old and new members while they notice the reassignment.
+	Generation int64
+	// Shards are the shards assigned to the member, in ascending order.
+	Shards []int
+}
+
+// The current schema version of the membership object.
+const schemaVersion = 1
+
+// The JSON content of the membership object.
+type content struct {
+	SchemaVersion int                  `json:"schemaVersion,omitempty"`
+	Shards        int                  `json:"shards,omitempty"`
+	Generation    int64                `json:"generation,omitempty"`
+	Members       map[string]time.Time `json:"members,omitempty"`
+	Assignments   map[string][]int     `json:"assignments,omitempty"`
+}
+
+// Run registers this member, and keeps it registered until the context is
+// cancelled. Whenever the shards assigned to this member change, onAssigned
+// is called (in its own goroutine) with a context that is cancelled when the
+// assignment changes again. The next call is not made until the previous one
+// has returned.
+func Run(ctx context.Context, config Config, onAssigned func(ctx context.Context, assignment Assignment)) error {
+	if config.Provider == nil || config.Key == "" {
+		return fmt.Errorf("provider and key must be specified")
+	}
+
+	if config.Shards <= 0 {
+		return fmt.Errorf("number of shards must be greater than zero")
+	}
+
+	if config.MemberTTL <= 0 {
+		return fmt.Errorf("member ttl must be greater than zero")
+	}
+
+	if onAssigned == nil {
+		return fmt.Errorf("onAssigned callback must be specified")
+	}
+
+	identity := config.Identity
+	if identity == "" {
+		identity = uuid.New().String()
+	}
+
+	heartbeatPeriod := config.HeartbeatPeriod
+	if heartbeatPeriod <= 0 {
+		heartbeatPeriod = config.MemberTTL / 3
+	}
+
+	var current *Assignment
+	stop := func() {}
+	defer func() {
+		stop()
+		deregister(context.WithoutCancel(ctx), config, identity)
+	}()
+
+	lastHeartbeat := time.Now()
+
+	ticker := time.NewTicker(heartbeatPeriod)
+	defer ticker.Stop()
+
+	for {
+		assignment, err := heartbeat(ctx, config, identity)
+		if err == nil {
+			lastHeartbeat = time.Now()
+		} else if ctx.Err() != nil {
+			return nil
+		} else if time.Since(lastHeartbeat) > config.MemberTTL {
+			// Our registration has expired, so our shards have been reassigned.
+			assignment = &Assignment{}
+		}
+
+		if assignment != nil && (current == nil || assignment.Generation != current.Generation ||
+			!slices.Equal(assignment.Shards, current.Shards)) {
+			stop()
+
+			current = assignment
+			stop = func() {}
+
+			if len(assignment.Shards) > 0 {
+				assignedCtx, cancel := context.WithCancel(ctx)
+				done := make(chan struct{})
+
+				go func() {
+					defer close(done)
+					onAssigned(assignedCtx, *assignment)
+				}()
+
+				stop = func() {
+					cancel()
+					<-done
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Assignments returns the current assignment of shards to members.
+func Assignments(ctx context.Context, p provider.Provider, bucket, key string) (map[string][]int, error) {
+	var errReadOnly = errors.New("read only")
+
+	var assignments map[string][]int
+	_, err := p.AtomicUpdateObject(ctx, bucket, key, func(_ string, currentData []byte) ([]byte, error) {
+		c, err := decode(currentData, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		assignments = c.Assignments
+
+		return nil, errReadOnly
+	})
+	if err != nil && !errors.Is(err, errReadOnly) {
+		return nil, err
+	}
+
+	return assignments, nil
+}
+
+// heartbeat refreshes this member's registration, reassigning the shards if
+// the set of live members has changed.
+func heartbeat(ctx context.Context, config Config, identity string) (*Assignment, error) {
+	var assignment Assignment
+	err := update(ctx, config, func(c *content) {
+		c.Members[identity] = time.Now().Add(config.MemberTTL).UTC()
+		rebalance(c)
+
+		assignment = Assignment{
+			Generation: c.Generation,
+			Shards:     c.Assignments[identity],
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &assignment, nil
+}
+
+// deregister makes a best effort attempt to remove this member's
+// registration, so that its shards are reassigned immediately.
+func deregister(ctx context.Context, config Config, identity string) {
+	ctx, cancel := context.WithTimeout(ctx, config.MemberTTL)
+	defer cancel()
+
+	_ = update(ctx, config, func(c *content) {
+		delete(c.Members, identity)
+		rebalance(c)
+	})
+}
+
+// update atomically updates the membership object, retrying on conflicts.
+func update(ctx context.Context, config Config, fn func(c *content)) error {
+	return retry.Do(
+		func() error {
+			_, err := config.Provider.AtomicUpdateObject(ctx, config.Bucket, config.Key, func(_ string, currentData []byte) ([]byte, error) {
+				c, err := decode(currentData, config.Shards)
+				if err != nil {
+					return nil, err
+				}
+
+				fn(c)
+
+				return json.Marshal(c)
+			})
+			if err != nil && !errors.Is(err, provider.ErrConflict) {
+				return retry.Unrecoverable(err)
+			}
+
+			return err
+		},
+		retry.Context(ctx),
+		retry.Attempts(0),
+	)
+}
+
+// rebalance reassigns the shards if the set of live members has changed.
+// Shards are dealt out round-robin to the members in order of their identity.
+func rebalance(c *content) {
+	members := make([]string, 0, len(c.Members))
+	for id := range c.Members {
+		members = append(members, id)
+	}
+	sort.Strings(members)
+
+	assigned := make([]string, 0, len(c.Assignments))
+	for id := range c.Assignments {
+		assigned = append(assigned, id)
+	}
+	sort.Strings(assigned)
+
+	if slices.Equal(members, assigned) {
+		return
+	}
+
+	c.Generation++
+	c.Assignments = make(map[string][]int, len(members))
+	if len(members) == 0 {
+		return
+	}
+
+	for shard := 0; shard < c.Shards; shard++ {
+		id := members[shard%len(members)]
+		c.Assignments[id] = append(c.Assignments[id], shard)
+	}
+
+	// Members without any shards are still recorded, so that they aren't
+	// mistaken for new members.
+	for _, id := range members {
+		if _, ok := c.Assignments[id]; !ok {
+			c.Assignments[id] = []int{}
+		}
+	}
+}
+
+// decode decodes the membership object, pruning any expired members.
+func decode(data []byte, shards int) (*content, error) {
+	var c content
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &c); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.SchemaVersion == 0 {
+		c.SchemaVersion = schemaVersion
+	}
+
+	// The first member gets to decide the number of shards.
+	if c.Shards == 0 {
+		c.Shards = shards
+	}
+
+	if c.Members == nil {
+		c.Members = make(map[string]time.Time)
+	}
+
+	now := time.Now()
+	for id, expires := range c.Members {
+		if now.After(expires) {
+			delete(c.Members, id)
+		}
+	}
+
+	return &c, nil
+}
@@ -0,0 +1,245 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package aztables
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/data/aztables"
+	"github.com/dpeckett/objsync/provider"
+	"github.com/dpeckett/objsync/stats"
+)
+
+const (
+	// The row key of lock entities (each object has its own partition).
+	rowKey = "object"
+	// The property that object data is stored in.
+	dataProperty = "Data"
+)
+
+// Option is a functional option for configuring an Azure Table Storage
+// provider.
+type Option func(*Provider)
+
+// WithStatsHandler sets a handler that receives an event for every request
+// made to the table service.
+func WithStatsHandler(h stats.Handler) Option {
+	return func(p *Provider) {
+		p.statsHandler = h
+	}
+}
+
+// Provider is an Azure Table Storage (or CosmosDB Table API) provider. Buckets
+// correspond to tables, and each object is stored as an entity. Writes are
+// conditioned on the entity's ETag.
+type Provider struct {
+	client       *aztables.ServiceClient
+	statsHandler stats.Handler
+}
+
+// NewProvider initializes a new Azure Table Storage provider using the given
+// service client.
+func NewProvider(client *aztables.ServiceClient, opts ...Option) provider.Provider {
+	p := &Provider{
+		client: client,
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+func (p *Provider) AtomicUpdateObject(ctx context.Context, bucket, key string, fn provider.UpdateObjectFunc) (string, error) {
+	table := p.client.NewClient(bucket)
+	partitionKey := url.QueryEscape(key)
+
+	start := time.Now()
+	getResp, err := table.GetEntity(ctx, partitionKey, rowKey, nil)
+	p.emit(ctx, "GetEntity", bucket, key, start, err)
+	if err != nil && !hasStatusCode(err, http.StatusNotFound) {
+		return "", err
+	}
+
+	var currentETag string
+	var currentData []byte
+	if err == nil {
+		currentETag = string(getResp.ETag)
+
+		var entity aztables.EDMEntity
+		if err := json.Unmarshal(getResp.Value, &entity); err != nil {
+			return "", err
+		}
+
+		if data, ok := entity.Properties[dataProperty].(aztables.EDMBinary); ok {
+			currentData = data
+		}
+	}
+
+	newData, err := fn(currentETag, currentData)
+	if err != nil {
+		return "", err
+	}
+
+	entity, err := json.Marshal(aztables.EDMEntity{
+		Entity: aztables.Entity{
+			PartitionKey: partitionKey,
+			RowKey:       rowKey,
+		},
+		Properties: map[string]any{
+			dataProperty: aztables.EDMBinary(newData),
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var newETag azcore.ETag
+	start = time.Now()
+	if currentETag == "" {
+		var addResp aztables.AddEntityResponse
+		addResp, err = table.AddEntity(ctx, entity, nil)
+		p.emit(ctx, "AddEntity", bucket, key, start, err)
+		newETag = addResp.ETag
+	} else {
+		var updateResp aztables.UpdateEntityResponse
+		ifMatch := azcore.ETag(currentETag)
+		updateResp, err = table.UpdateEntity(ctx, entity, &aztables.UpdateEntityOptions{
+			IfMatch:    &ifMatch,
+			UpdateMode: aztables.UpdateModeReplace,
+		})
+		p.emit(ctx, "UpdateEntity", bucket, key, start, err)
+		newETag = updateResp.ETag
+	}
+	if err != nil {
+		if isConflict(err) {
+			return "", provider.ErrConflict
+		}
+
+		return "", err
+	}
+
+	return string(newETag), nil
+}
+
+func (p *Provider) DeleteObject(ctx context.Context, bucket, key, etag string) error {
+	ifMatch := azcore.ETag(etag)
+
+	start := time.Now()
+	_, err := p.client.NewClient(bucket).DeleteEntity(ctx, url.QueryEscape(key), rowKey, &aztables.DeleteEntityOptions{
+		IfMatch: &ifMatch,
+	})
+	p.emit(ctx, "DeleteEntity", bucket, key, start, err)
+	if err != nil {
+		if isConflict(err) {
+			return provider.ErrConflict
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+func (p *Provider) ListObjects(ctx context.Context, bucket, prefix string) ([]string, error) {
+	partitionKeyPrefix := url.QueryEscape(prefix)
+
+	// Escaped keys never contain quotes, so don't need to be quoted.
+	filter := fmt.Sprintf("PartitionKey ge '%s' and RowKey eq '%s'", partitionKeyPrefix, rowKey)
+	selectProperties := "PartitionKey"
+
+	pager := p.client.NewClient(bucket).NewListEntitiesPager(&aztables.ListEntitiesOptions{
+		Filter: &filter,
+		Select: &selectProperties,
+	})
+
+	var keys []string
+	for pager.More() {
+		start := time.Now()
+		page, err := pager.NextPage(ctx)
+		p.emit(ctx, "ListEntities", bucket, prefix, start, err)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, data := range page.Entities {
+			var entity aztables.Entity
+			if err := json.Unmarshal(data, &entity); err != nil {
+				return nil, err
+			}
+
+			// Entities are returned in partition key order, so we're past the
+			// prefix.
+			if !strings.HasPrefix(entity.PartitionKey, partitionKeyPrefix) {
+				slices.Sort(keys)
+				return keys, nil
+			}
+
+			key, err := url.QueryUnescape(entity.PartitionKey)
+			if err != nil {
+				return nil, err
+			}
+
+			keys = append(keys, key)
+		}
+	}
+
+	// Escaping doesn't preserve the order of keys.
+	slices.Sort(keys)
+
+	return keys, nil
+}
+
+func (p *Provider) Capabilities() provider.Capabilities {
+	return provider.Capabilities{
+		ConditionalCreate:    true,
+		ConditionalDelete:    true,
+		StrongReadAfterWrite: true,
+	}
+}
+
+// emit emits a provider request event to the stats handler (if configured).
+func (p *Provider) emit(ctx context.Context, operation, bucket, key string, start time.Time, err error) {
+	if p.statsHandler == nil {
+		return
+	}
+
+	p.statsHandler.HandleEvent(ctx, stats.Event{
+		Type:      stats.EventProviderRequest,
+		Bucket:    bucket,
+		Key:       key,
+		Operation: operation,
+		Duration:  time.Since(start),
+		Err:       err,
+	})
+}
+
+// isConflict returns whether a write failed because the entity was modified
+// (or created, or deleted) concurrently.
+func isConflict(err error) bool {
+	return hasStatusCode(err, http.StatusPreconditionFailed) ||
+		hasStatusCode(err, http.StatusConflict) ||
+		hasStatusCode(err, http.StatusNotFound)
+}
+
+func hasStatusCode(err error, statusCode int) bool {
+	var respErr *azcore.ResponseError
+	return errors.As(err, &respErr) && respErr.StatusCode == statusCode
+}
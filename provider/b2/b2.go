@@ -0,0 +1,470 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package b2 implements a native Backblaze B2 provider.
+//
+// B2 does not support conditional writes (and its S3 compatible API ignores
+// If-Match), so compare-and-swap is emulated using file versions. Every write
+// uploads a new version of the object, recording the ID of the version it was
+// derived from. Walking the versions from oldest to newest, a version is only
+// accepted if it was derived from the previously accepted version, so when
+// two writers race from the same parent the earlier upload wins. The loser
+// deletes its version and returns provider.ErrConflict.
+package b2
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Backblaze/blazer/base"
+	"github.com/dpeckett/objsync/provider"
+	"github.com/dpeckett/objsync/stats"
+)
+
+func init() {
+	provider.Register("b2", open)
+}
+
+// The file info keys used to record the version chain.
+const (
+	parentInfoKey    = "objsync-parent"
+	tombstoneInfoKey = "objsync-tombstone"
+)
+
+// Option is a functional option for configuring a B2 provider.
+type Option func(*Provider)
+
+// WithAPIBase overrides the B2 API base URL.
+func WithAPIBase(url string) Option {
+	return func(p *Provider) {
+		p.authOpts = append(p.authOpts, base.SetAPIBase(url))
+	}
+}
+
+// WithContentType sets the Content-Type of lock objects (defaults to
+// "application/json").
+func WithContentType(contentType string) Option {
+	return func(p *Provider) {
+		p.contentType = contentType
+	}
+}
+
+// WithStatsHandler sets a handler that receives an event for every request
+// made to B2.
+func WithStatsHandler(h stats.Handler) Option {
+	return func(p *Provider) {
+		p.statsHandler = h
+	}
+}
+
+// Provider is a native Backblaze B2 provider.
+type Provider struct {
+	keyID          string
+	applicationKey string
+	authOpts       []base.AuthOption
+	contentType    string
+	statsHandler   stats.Handler
+
+	mu      sync.Mutex
+	b2      *base.B2
+	buckets map[string]*base.Bucket
+}
+
+// NewProvider initializes a new B2 provider, authorizing with the given
+// application key.
+func NewProvider(ctx context.Context, keyID, applicationKey string, opts ...Option) (provider.Provider, error) {
+	p := &Provider{
+		keyID:          keyID,
+		applicationKey: applicationKey,
+		contentType:    "application/json",
+		buckets:        make(map[string]*base.Bucket),
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	var err error
+	p.b2, err = base.AuthorizeAccount(ctx, p.keyID, p.applicationKey, p.authOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to authorize account: %w", err)
+	}
+
+	return p, nil
+}
+
+// open opens a B2 provider from a URL, eg.
+// "b2://keyID:applicationKey@bucket". The credentials default to the
+// B2_APPLICATION_KEY_ID and B2_APPLICATION_KEY environment variables.
+func open(ctx context.Context, u *url.URL) (provider.Provider, error) {
+	keyID := os.Getenv("B2_APPLICATION_KEY_ID")
+	applicationKey := os.Getenv("B2_APPLICATION_KEY")
+	if u.User != nil {
+		keyID = u.User.Username()
+		applicationKey, _ = u.User.Password()
+	}
+
+	var opts []Option
+	if apiBase := u.Query().Get("apiBase"); apiBase != "" {
+		opts = append(opts, WithAPIBase(apiBase))
+	}
+
+	return NewProvider(ctx, keyID, applicationKey, opts...)
+}
+
+func (p *Provider) AtomicUpdateObject(ctx context.Context, bucket, key string, fn provider.UpdateObjectFunc) (string, error) {
+	var etag string
+	err := p.withReauth(ctx, func() (err error) {
+		etag, err = p.update(ctx, bucket, key, fn, false)
+		return err
+	})
+	return etag, err
+}
+
+// DeleteObject deletes an object by writing a tombstone version over it, and
+// then removing all the older versions.
+func (p *Provider) DeleteObject(ctx context.Context, bucket, key, etag string) error {
+	return p.withReauth(ctx, func() error {
+		_, err := p.update(ctx, bucket, key, func(currentETag string, _ []byte) ([]byte, error) {
+			if currentETag == "" || currentETag != etag {
+				return nil, provider.ErrConflict
+			}
+
+			return []byte{}, nil
+		}, true)
+		return err
+	})
+}
+
+// ListObjects lists the objects whose version chains don't end in a
+// tombstone.
+func (p *Provider) ListObjects(ctx context.Context, bucket, prefix string) ([]string, error) {
+	var keys []string
+	err := p.withReauth(ctx, func() (err error) {
+		keys, err = p.list(ctx, bucket, prefix)
+		return err
+	})
+	return keys, err
+}
+
+func (p *Provider) list(ctx context.Context, bucket, prefix string) ([]string, error) {
+	bkt, err := p.bucket(ctx, bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	// The versions of each object, ordered from oldest to newest.
+	var names []string
+	versions := make(map[string][]*base.File)
+
+	startName, startID := prefix, ""
+	for {
+		start := time.Now()
+		files, nextName, nextID, err := bkt.ListFileVersions(ctx, 1000, startName, startID, prefix, "")
+		p.emit(ctx, "ListFileVersions", bkt.Name, prefix, start, err)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, f := range files {
+			if f.Status != "upload" {
+				continue
+			}
+
+			if _, ok := versions[f.Name]; !ok {
+				names = append(names, f.Name)
+			}
+
+			// B2 lists versions of the same file from newest to oldest.
+			versions[f.Name] = append([]*base.File{f}, versions[f.Name]...)
+		}
+
+		if nextName == "" {
+			break
+		}
+		startName, startID = nextName, nextID
+	}
+
+	var keys []string
+	for _, name := range names {
+		if head, _ := resolveChain(versions[name]); head != nil && head.Info.Info[tombstoneInfoKey] == "" {
+			keys = append(keys, name)
+		}
+	}
+
+	return keys, nil
+}
+
+func (p *Provider) update(ctx context.Context, bucket, key string, fn provider.UpdateObjectFunc, tombstone bool) (string, error) {
+	bkt, err := p.bucket(ctx, bucket)
+	if err != nil {
+		return "", err
+	}
+
+	versions, err := p.listVersions(ctx, bkt, key)
+	if err != nil {
+		return "", err
+	}
+
+	head, losers := resolveChain(versions)
+
+	// Clean up after writers that lost a race, but didn't (or couldn't) remove
+	// their version, so that the head is the latest version again.
+	p.deleteVersions(ctx, bkt, key, losers)
+
+	var parentID, currentETag string
+	var currentData []byte
+	if head != nil {
+		parentID = head.ID
+
+		if head.Info.Info[tombstoneInfoKey] == "" {
+			currentETag = head.ID
+
+			currentData, err = p.download(ctx, bkt, key, head.ID)
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+
+	newData, err := fn(currentETag, currentData)
+	if err != nil {
+		return "", err
+	}
+
+	info := map[string]string{parentInfoKey: parentID}
+	if tombstone {
+		info[tombstoneInfoKey] = "true"
+	}
+
+	uploaded, err := p.upload(ctx, bkt, key, newData, info)
+	if err != nil {
+		return "", err
+	}
+
+	versions, err = p.listVersions(ctx, bkt, key)
+	if err != nil {
+		return "", err
+	}
+
+	chain, losers := resolveChain(versions)
+	if chain == nil || !isAccepted(versions, losers, uploaded.ID) {
+		p.deleteVersions(ctx, bkt, key, []*base.File{uploaded})
+
+		return "", provider.ErrConflict
+	}
+
+	// Prune the versions that were superseded by our write, losers first so
+	// that the oldest remaining version is always part of the chain.
+	var superseded []*base.File
+	for _, f := range versions {
+		if f.ID == uploaded.ID {
+			break
+		}
+		superseded = append(superseded, f)
+	}
+	p.deleteVersions(ctx, bkt, key, losers)
+	p.deleteVersions(ctx, bkt, key, superseded)
+
+	return uploaded.ID, nil
+}
+
+// withReauth calls fn, reauthorizing and trying again once if the account
+// authorization token has expired.
+func (p *Provider) withReauth(ctx context.Context, fn func() error) error {
+	err := fn()
+	if err == nil || base.Action(err) != base.ReAuthenticate {
+		return err
+	}
+
+	b2, authErr := base.AuthorizeAccount(ctx, p.keyID, p.applicationKey, p.authOpts...)
+	if authErr != nil {
+		return fmt.Errorf("failed to reauthorize account: %w", authErr)
+	}
+
+	p.mu.Lock()
+	p.b2.Update(b2)
+	p.mu.Unlock()
+
+	return fn()
+}
+
+// bucket looks up (and caches) the bucket with the given name.
+func (p *Provider) bucket(ctx context.Context, name string) (*base.Bucket, error) {
+	p.mu.Lock()
+	bkt, ok := p.buckets[name]
+	p.mu.Unlock()
+	if ok {
+		return bkt, nil
+	}
+
+	start := time.Now()
+	buckets, err := p.b2.ListBuckets(ctx, name)
+	p.emit(ctx, "ListBuckets", name, "", start, err)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(buckets) == 0 {
+		return nil, fmt.Errorf("bucket %q not found", name)
+	}
+
+	p.mu.Lock()
+	p.buckets[name] = buckets[0]
+	p.mu.Unlock()
+
+	return buckets[0], nil
+}
+
+// listVersions returns the versions of an object, ordered from oldest to
+// newest.
+func (p *Provider) listVersions(ctx context.Context, bkt *base.Bucket, key string) ([]*base.File, error) {
+	var versions []*base.File
+	startName, startID := key, ""
+	for {
+		start := time.Now()
+		files, nextName, nextID, err := bkt.ListFileVersions(ctx, 100, startName, startID, key, "")
+		p.emit(ctx, "ListFileVersions", bkt.Name, key, start, err)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, f := range files {
+			if f.Name == key && f.Status == "upload" {
+				versions = append(versions, f)
+			}
+		}
+
+		if nextName != key {
+			break
+		}
+		startName, startID = nextName, nextID
+	}
+
+	// B2 lists versions of the same file from newest to oldest.
+	for i, j := 0, len(versions)-1; i < j; i, j = i+1, j-1 {
+		versions[i], versions[j] = versions[j], versions[i]
+	}
+
+	return versions, nil
+}
+
+func (p *Provider) download(ctx context.Context, bkt *base.Bucket, key, id string) ([]byte, error) {
+	start := time.Now()
+	r, err := bkt.DownloadFileByName(ctx, key, 0, 0, false)
+	p.emit(ctx, "DownloadFileByName", bkt.Name, key, start, err)
+	if err != nil {
+		if code, _ := base.Code(err); code == http.StatusNotFound {
+			return nil, provider.ErrConflict
+		}
+
+		return nil, err
+	}
+	defer r.Close()
+
+	// Someone has uploaded a new version since we listed them.
+	if r.ID != id {
+		return nil, provider.ErrConflict
+	}
+
+	return io.ReadAll(r)
+}
+
+func (p *Provider) upload(ctx context.Context, bkt *base.Bucket, key string, data []byte, info map[string]string) (*base.File, error) {
+	start := time.Now()
+	url, err := bkt.GetUploadURL(ctx)
+	p.emit(ctx, "GetUploadURL", bkt.Name, key, start, err)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha1.Sum(data)
+
+	start = time.Now()
+	f, err := url.UploadFile(ctx, bytes.NewReader(data), len(data), key, p.contentType, hex.EncodeToString(sum[:]), info)
+	p.emit(ctx, "UploadFile", bkt.Name, key, start, err)
+	return f, err
+}
+
+// deleteVersions makes a best effort attempt to delete the given versions of
+// an object, they may have already been removed by another client.
+func (p *Provider) deleteVersions(ctx context.Context, bkt *base.Bucket, key string, versions []*base.File) {
+	for _, f := range versions {
+		start := time.Now()
+		err := bkt.File(f.ID, key).DeleteFileVersion(ctx)
+		p.emit(ctx, "DeleteFileVersion", bkt.Name, key, start, err)
+	}
+}
+
+// resolveChain walks the versions of an object (ordered from oldest to newest)
+// and returns the accepted head of the version chain, along with any versions
+// that lost a race and are not part of the chain.
+func resolveChain(versions []*base.File) (head *base.File, losers []*base.File) {
+	for _, f := range versions {
+		if head == nil || f.Info.Info[parentInfoKey] == head.ID {
+			head = f
+			continue
+		}
+
+		losers = append(losers, f)
+	}
+
+	return head, losers
+}
+
+// isAccepted returns whether the version with the given ID is part of the
+// version chain.
+func isAccepted(versions, losers []*base.File, id string) bool {
+	for _, f := range losers {
+		if f.ID == id {
+			return false
+		}
+	}
+
+	for _, f := range versions {
+		if f.ID == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *Provider) Capabilities() provider.Capabilities {
+	return provider.Capabilities{
+		ConditionalCreate:    true,
+		ConditionalDelete:    true,
+		StrongReadAfterWrite: true,
+	}
+}
+
+// emit emits a provider request event to the stats handler (if configured).
+func (p *Provider) emit(ctx context.Context, operation, bucket, key string, start time.Time, err error) {
+	if p.statsHandler == nil {
+		return
+	}
+
+	p.statsHandler.HandleEvent(ctx, stats.Event{
+		Type:      stats.EventProviderRequest,
+		Bucket:    bucket,
+		Key:       key,
+		Operation: operation,
+		Duration:  time.Since(start),
+		Err:       err,
+	})
+}
@@ -0,0 +1,207 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package cassandra
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dpeckett/objsync/provider"
+	"github.com/dpeckett/objsync/stats"
+	"github.com/gocql/gocql"
+)
+
+// Option is a functional option for configuring a Cassandra provider.
+type Option func(*Provider)
+
+// WithSerialConsistency sets the consistency level used for lightweight
+// transactions, and for reading objects (defaults to gocql.Serial). Use
+// gocql.LocalSerial to restrict the Paxos rounds to the local datacenter.
+func WithSerialConsistency(consistency gocql.SerialConsistency) Option {
+	return func(p *Provider) {
+		p.serialConsistency = consistency
+	}
+}
+
+// WithStatsHandler sets a handler that receives an event for every query
+// made to the cluster.
+func WithStatsHandler(h stats.Handler) Option {
+	return func(p *Provider) {
+		p.statsHandler = h
+	}
+}
+
+// Provider is a Cassandra (or ScyllaDB) provider. Buckets correspond to tables
+// in the session's keyspace, which must have the schema:
+//
+//	CREATE TABLE <bucket> (key text PRIMARY KEY, data blob, version bigint);
+//
+// Updates are made using lightweight transactions conditioned on the version
+// of the object.
+type Provider struct {
+	session           *gocql.Session
+	serialConsistency gocql.SerialConsistency
+	statsHandler      stats.Handler
+}
+
+// NewProvider initializes a new Cassandra provider using the given session.
+func NewProvider(session *gocql.Session, opts ...Option) provider.Provider {
+	p := &Provider{
+		session:           session,
+		serialConsistency: gocql.Serial,
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+func (p *Provider) AtomicUpdateObject(ctx context.Context, bucket, key string, fn provider.UpdateObjectFunc) (string, error) {
+	var currentVersion int64
+	var currentData []byte
+
+	// Reading at a serial consistency level ensures we observe the result of
+	// any in-flight lightweight transactions.
+	start := time.Now()
+	err := p.session.Query(fmt.Sprintf(`SELECT data, version FROM %s WHERE key = ?`, quoteIdentifier(bucket)), key).
+		WithContext(ctx).
+		Consistency(gocql.Consistency(p.serialConsistency)).
+		Scan(&currentData, &currentVersion)
+	p.emit(ctx, "Select", bucket, key, start, err)
+	if err != nil && !errors.Is(err, gocql.ErrNotFound) {
+		return "", err
+	}
+
+	var currentETag string
+	if currentVersion != 0 {
+		currentETag = strconv.FormatInt(currentVersion, 10)
+	}
+
+	newData, err := fn(currentETag, currentData)
+	if err != nil {
+		return "", err
+	}
+
+	var q *gocql.Query
+	newVersion := currentVersion + 1
+	if currentVersion == 0 {
+		newVersion = provider.InitialVersion()
+		q = p.session.Query(fmt.Sprintf(`INSERT INTO %s (key, data, version) VALUES (?, ?, ?) IF NOT EXISTS`, quoteIdentifier(bucket)),
+			key, newData, newVersion)
+	} else {
+		q = p.session.Query(fmt.Sprintf(`UPDATE %s SET data = ?, version = ? WHERE key = ? IF version = ?`, quoteIdentifier(bucket)),
+			newData, newVersion, key, currentVersion)
+	}
+
+	start = time.Now()
+	applied, err := q.WithContext(ctx).
+		SerialConsistency(p.serialConsistency).
+		MapScanCAS(map[string]interface{}{})
+	p.emit(ctx, "LightweightTransaction", bucket, key, start, err)
+	if err != nil {
+		return "", err
+	}
+
+	if !applied {
+		return "", provider.ErrConflict
+	}
+
+	return strconv.FormatInt(newVersion, 10), nil
+}
+
+func (p *Provider) DeleteObject(ctx context.Context, bucket, key, etag string) error {
+	version, err := strconv.ParseInt(etag, 10, 64)
+	if err != nil {
+		return provider.ErrConflict
+	}
+
+	start := time.Now()
+	applied, err := p.session.Query(fmt.Sprintf(`DELETE FROM %s WHERE key = ? IF version = ?`, quoteIdentifier(bucket)), key, version).
+		WithContext(ctx).
+		SerialConsistency(p.serialConsistency).
+		MapScanCAS(map[string]interface{}{})
+	p.emit(ctx, "Delete", bucket, key, start, err)
+	if err != nil {
+		return err
+	}
+
+	if !applied {
+		return provider.ErrConflict
+	}
+
+	return nil
+}
+
+// ListObjects lists objects by scanning the whole table, as objects are
+// partitioned by key (so can't be queried by prefix).
+func (p *Provider) ListObjects(ctx context.Context, bucket, prefix string) ([]string, error) {
+	start := time.Now()
+	iter := p.session.Query(fmt.Sprintf(`SELECT key FROM %s`, quoteIdentifier(bucket))).
+		WithContext(ctx).
+		Iter()
+
+	var keys []string
+	var key string
+	for iter.Scan(&key) {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+
+	err := iter.Close()
+	p.emit(ctx, "Select", bucket, prefix, start, err)
+	if err != nil {
+		return nil, err
+	}
+
+	// Partitions are ordered by the hash of their key.
+	slices.Sort(keys)
+
+	return keys, nil
+}
+
+// quoteIdentifier quotes a table name, so that it can be safely interpolated
+// into a CQL statement.
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func (p *Provider) Capabilities() provider.Capabilities {
+	return provider.Capabilities{
+		ConditionalCreate: true,
+		ConditionalDelete: true,
+		// Local serial reads can miss writes made through other
+		// datacenters.
+		StrongReadAfterWrite: p.serialConsistency == gocql.Serial,
+	}
+}
+
+// emit emits a provider request event to the stats handler (if configured).
+func (p *Provider) emit(ctx context.Context, operation, bucket, key string, start time.Time, err error) {
+	if p.statsHandler == nil {
+		return
+	}
+
+	p.statsHandler.HandleEvent(ctx, stats.Event{
+		Type:      stats.EventProviderRequest,
+		Bucket:    bucket,
+		Key:       key,
+		Operation: operation,
+		Duration:  time.Since(start),
+		Err:       err,
+	})
+}
@@ -0,0 +1,307 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package circuitbreaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dpeckett/objsync/provider"
+)
+
+// ErrOpen is returned when the circuit breaker is open, and calls to the
+// underlying provider are being rejected.
+var ErrOpen = fmt.Errorf("circuit breaker is open")
+
+// Option is a functional option for configuring a circuit breaker.
+type Option func(*Provider)
+
+// WithFailureThreshold sets the number of consecutive failures after which
+// the circuit breaker opens.
+func WithFailureThreshold(n int) Option {
+	return func(p *Provider) {
+		p.failureThreshold = n
+	}
+}
+
+// WithOpenDuration sets how long the circuit breaker stays open before
+// allowing probe calls through to the underlying provider.
+func WithOpenDuration(d time.Duration) Option {
+	return func(p *Provider) {
+		p.openDuration = d
+	}
+}
+
+// WithHalfOpenProbes sets the maximum number of concurrent probe calls
+// allowed through while the circuit breaker is half-open.
+func WithHalfOpenProbes(n int) Option {
+	return func(p *Provider) {
+		p.halfOpenProbes = n
+	}
+}
+
+type state int
+
+const (
+	stateClosed state = iota
+	stateOpen
+	stateHalfOpen
+)
+
+// Provider is a provider that wraps another provider with a circuit breaker.
+type Provider struct {
+	next             provider.Provider
+	failureThreshold int
+	openDuration     time.Duration
+	halfOpenProbes   int
+	mu               sync.Mutex
+	state            state
+	failures         int
+	openedAt         time.Time
+	probes           int
+}
+
+// NewProvider wraps a provider with a circuit breaker.
+func NewProvider(next provider.Provider, opts ...Option) provider.Provider {
+	p := &Provider{
+		next:             next,
+		failureThreshold: 5,
+		openDuration:     10 * time.Second,
+		halfOpenProbes:   1,
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+func (p *Provider) AtomicUpdateObject(ctx context.Context, bucket, key string, fn provider.UpdateObjectFunc) (string, error) {
+	probe, err := p.allow()
+	if err != nil {
+		return "", err
+	}
+
+	// Errors returned by the update function are not provider failures.
+	var fnErr error
+	etag, err := p.next.AtomicUpdateObject(ctx, bucket, key, func(currentETag string, currentData []byte) ([]byte, error) {
+		newData, err := fn(currentETag, currentData)
+		fnErr = err
+		return newData, err
+	})
+
+	failed := err != nil && fnErr == nil && ctx.Err() == nil && !errors.Is(err, provider.ErrConflict)
+	p.record(probe, failed)
+
+	return etag, err
+}
+
+func (p *Provider) DeleteObject(ctx context.Context, bucket, key, etag string) error {
+	deleter, ok := p.next.(provider.Deleter)
+	if !ok {
+		return provider.ErrNotSupported
+	}
+
+	probe, err := p.allow()
+	if err != nil {
+		return err
+	}
+
+	err = deleter.DeleteObject(ctx, bucket, key, etag)
+
+	failed := err != nil && ctx.Err() == nil && !errors.Is(err, provider.ErrConflict) && !errors.Is(err, provider.ErrNotSupported)
+	p.record(probe, failed)
+
+	return err
+}
+
+func (p *Provider) GetObject(ctx context.Context, bucket, key string) ([]byte, string, error) {
+	getter, ok := p.next.(provider.Getter)
+	if !ok {
+		return nil, "", provider.ErrNotSupported
+	}
+
+	probe, err := p.allow()
+	if err != nil {
+		return nil, "", err
+	}
+
+	data, etag, err := getter.GetObject(ctx, bucket, key)
+
+	failed := err != nil && ctx.Err() == nil && !errors.Is(err, provider.ErrConflict) && !errors.Is(err, provider.ErrNotSupported)
+	p.record(probe, failed)
+
+	return data, etag, err
+}
+
+func (p *Provider) HeadObject(ctx context.Context, bucket, key string) (string, error) {
+	header, ok := p.next.(provider.Header)
+	if !ok {
+		return "", provider.ErrNotSupported
+	}
+
+	probe, err := p.allow()
+	if err != nil {
+		return "", err
+	}
+
+	etag, err := header.HeadObject(ctx, bucket, key)
+
+	failed := err != nil && ctx.Err() == nil && !errors.Is(err, provider.ErrNotSupported)
+	p.record(probe, failed)
+
+	return etag, err
+}
+
+func (p *Provider) ListObjects(ctx context.Context, bucket, prefix string) ([]string, error) {
+	lister, ok := p.next.(provider.Lister)
+	if !ok {
+		return nil, provider.ErrNotSupported
+	}
+
+	probe, err := p.allow()
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := lister.ListObjects(ctx, bucket, prefix)
+
+	failed := err != nil && ctx.Err() == nil && !errors.Is(err, provider.ErrNotSupported)
+	p.record(probe, failed)
+
+	return keys, err
+}
+
+// WatchObject watches an object for changes. As watches are long lived, only
+// whether the watch could be established counts towards the circuit breaker.
+func (p *Provider) WatchObject(ctx context.Context, bucket, key string) <-chan provider.Event {
+	watcher, ok := p.next.(provider.Watcher)
+	if !ok {
+		return provider.WatchError(provider.ErrNotSupported)
+	}
+
+	probe, err := p.allow()
+	if err != nil {
+		return provider.WatchError(err)
+	}
+
+	events := make(chan provider.Event, 1)
+
+	go func() {
+		defer close(events)
+
+		var recorded bool
+		for event := range watcher.WatchObject(ctx, bucket, key) {
+			if !recorded {
+				failed := event.Err != nil && ctx.Err() == nil && !errors.Is(event.Err, provider.ErrNotSupported)
+				p.record(probe, failed)
+				recorded = true
+			}
+
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		if !recorded {
+			p.record(probe, false)
+		}
+	}()
+
+	return events
+}
+
+func (p *Provider) Now(ctx context.Context) (time.Time, error) {
+	if _, ok := p.next.(provider.Clock); !ok {
+		return time.Time{}, provider.ErrNotSupported
+	}
+
+	probe, err := p.allow()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	now, err := provider.NowOf(ctx, p.next)
+
+	failed := err != nil && ctx.Err() == nil
+	p.record(probe, failed)
+
+	return now, err
+}
+
+// Capabilities returns the capabilities of the wrapped provider.
+func (p *Provider) Capabilities() provider.Capabilities {
+	return provider.CapabilitiesOf(p.next)
+}
+
+// FenceEpoch returns the fence epoch of the wrapped provider.
+func (p *Provider) FenceEpoch() int64 {
+	return provider.FenceEpochOf(p.next)
+}
+
+// allow determines whether a call is allowed through to the underlying
+// provider, and whether it is a half-open probe.
+func (p *Provider) allow() (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.state == stateOpen {
+		if time.Since(p.openedAt) < p.openDuration {
+			return false, ErrOpen
+		}
+
+		p.state = stateHalfOpen
+		p.probes = 0
+	}
+
+	if p.state == stateHalfOpen {
+		if p.probes >= p.halfOpenProbes {
+			return false, ErrOpen
+		}
+
+		p.probes++
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// record records the outcome of a call.
+func (p *Provider) record(probe, failed bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if probe {
+		p.probes--
+	}
+
+	if !failed {
+		// A successful probe closes the circuit breaker.
+		if probe || p.state == stateClosed {
+			p.state = stateClosed
+			p.failures = 0
+		}
+
+		return
+	}
+
+	p.failures++
+
+	if probe || p.failures >= p.failureThreshold {
+		p.state = stateOpen
+		p.openedAt = time.Now()
+	}
+}
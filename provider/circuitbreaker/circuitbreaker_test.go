@@ -0,0 +1,161 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package circuitbreaker_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dpeckett/objsync/provider"
+	"github.com/dpeckett/objsync/provider/circuitbreaker"
+	"github.com/dpeckett/objsync/provider/mem"
+	"github.com/stretchr/testify/require"
+)
+
+var errDown = errors.New("provider down")
+
+// backend is a provider that can be taken down, and that counts the calls
+// made to it.
+type backend struct {
+	provider.Provider
+	down  atomic.Bool
+	calls atomic.Int32
+}
+
+func (b *backend) AtomicUpdateObject(ctx context.Context, bucket, key string, fn provider.UpdateObjectFunc) (string, error) {
+	b.calls.Add(1)
+
+	if b.down.Load() {
+		return "", errDown
+	}
+
+	return b.Provider.AtomicUpdateObject(ctx, bucket, key, fn)
+}
+
+func put(ctx context.Context, p provider.Provider, value string) error {
+	_, err := p.AtomicUpdateObject(ctx, "bucket", "key", func(_ string, _ []byte) ([]byte, error) {
+		return []byte(value), nil
+	})
+	return err
+}
+
+func TestProvider(t *testing.T) {
+	ctx := context.Background()
+
+	const openDuration = 50 * time.Millisecond
+
+	t.Run("Transitions", func(t *testing.T) {
+		b := &backend{Provider: mem.NewProvider()}
+		p := circuitbreaker.NewProvider(b,
+			circuitbreaker.WithFailureThreshold(3),
+			circuitbreaker.WithOpenDuration(openDuration))
+
+		require.NoError(t, put(ctx, p, "hello"))
+
+		// Closed, failures are passed through until the threshold is reached.
+		b.down.Store(true)
+
+		for i := 0; i < 3; i++ {
+			require.ErrorIs(t, put(ctx, p, "hello"), errDown)
+		}
+
+		// Open, calls fail fast without reaching the provider.
+		calls := b.calls.Load()
+		require.ErrorIs(t, put(ctx, p, "hello"), circuitbreaker.ErrOpen)
+		require.Equal(t, calls, b.calls.Load())
+
+		// Half-open, a failed probe opens the circuit breaker again.
+		time.Sleep(openDuration)
+
+		require.ErrorIs(t, put(ctx, p, "hello"), errDown)
+		require.ErrorIs(t, put(ctx, p, "hello"), circuitbreaker.ErrOpen)
+
+		// Half-open, a successful probe closes the circuit breaker.
+		time.Sleep(openDuration)
+		b.down.Store(false)
+
+		require.NoError(t, put(ctx, p, "world"))
+		require.NoError(t, put(ctx, p, "world"))
+
+		// And the failure count starts again.
+		b.down.Store(true)
+
+		for i := 0; i < 3; i++ {
+			require.ErrorIs(t, put(ctx, p, "hello"), errDown)
+		}
+		require.ErrorIs(t, put(ctx, p, "hello"), circuitbreaker.ErrOpen)
+	})
+
+	t.Run("SuccessResetsFailures", func(t *testing.T) {
+		b := &backend{Provider: mem.NewProvider()}
+		p := circuitbreaker.NewProvider(b,
+			circuitbreaker.WithFailureThreshold(2),
+			circuitbreaker.WithOpenDuration(time.Hour))
+
+		for i := 0; i < 3; i++ {
+			b.down.Store(true)
+			require.ErrorIs(t, put(ctx, p, "hello"), errDown)
+
+			b.down.Store(false)
+			require.NoError(t, put(ctx, p, "hello"))
+		}
+	})
+
+	t.Run("ExpectedErrors", func(t *testing.T) {
+		b := &backend{Provider: mem.NewProvider()}
+		p := circuitbreaker.NewProvider(b,
+			circuitbreaker.WithFailureThreshold(1),
+			circuitbreaker.WithOpenDuration(time.Hour))
+
+		// Errors returned by the update function aren't provider failures.
+		errAbort := errors.New("abort")
+		_, err := p.AtomicUpdateObject(ctx, "bucket", "key", func(_ string, _ []byte) ([]byte, error) {
+			return nil, errAbort
+		})
+		require.ErrorIs(t, err, errAbort)
+
+		// Nor are conflicts.
+		_, err = p.AtomicUpdateObject(ctx, "bucket", "key", func(_ string, _ []byte) ([]byte, error) {
+			require.NoError(t, put(ctx, b, "world"))
+
+			return []byte("hello"), nil
+		})
+		require.ErrorIs(t, err, provider.ErrConflict)
+
+		require.NoError(t, put(ctx, p, "hello"))
+	})
+
+	t.Run("HalfOpenProbes", func(t *testing.T) {
+		b := &backend{Provider: mem.NewProvider()}
+		p := circuitbreaker.NewProvider(b,
+			circuitbreaker.WithFailureThreshold(1),
+			circuitbreaker.WithOpenDuration(openDuration),
+			circuitbreaker.WithHalfOpenProbes(1))
+
+		b.down.Store(true)
+		require.ErrorIs(t, put(ctx, p, "hello"), errDown)
+
+		time.Sleep(openDuration)
+		b.down.Store(false)
+
+		// Only one probe is let through at a time.
+		_, err := p.AtomicUpdateObject(ctx, "bucket", "key", func(_ string, _ []byte) ([]byte, error) {
+			require.ErrorIs(t, put(ctx, p, "world"), circuitbreaker.ErrOpen)
+
+			return []byte("hello"), nil
+		})
+		require.NoError(t, err)
+
+		require.NoError(t, put(ctx, p, "world"))
+	})
+}
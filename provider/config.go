@@ -0,0 +1,111 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"os"
+)
+
+// Config describes a provider, so that it can be decoded from a configuration
+// file (JSON or YAML). Either the URL (see Open), or the type and the fields
+// that the provider needs, must be set.
+type Config struct {
+	// URL of the provider, eg. "s3://bucket?region=eu-west-1". The other
+	// fields are ignored if this is set.
+	URL string `json:"url,omitempty" yaml:"url,omitempty"`
+	// Type of the provider, the URL scheme it is registered with (eg. "s3").
+	Type string `json:"type,omitempty" yaml:"type,omitempty"`
+	// Bucket to store objects in.
+	Bucket string `json:"bucket,omitempty" yaml:"bucket,omitempty"`
+	// Endpoint of the storage service, if it isn't implied by the provider.
+	Endpoint string `json:"endpoint,omitempty" yaml:"endpoint,omitempty"`
+	// Region of the storage service.
+	Region string `json:"region,omitempty" yaml:"region,omitempty"`
+	// AccessKeyID is the ID of the credentials to use. If not set, the
+	// provider falls back to its own defaults (usually environment variables).
+	AccessKeyID string `json:"accessKeyID,omitempty" yaml:"accessKeyID,omitempty"`
+	// SecretAccessKey is the secret of the credentials to use.
+	SecretAccessKey string `json:"secretAccessKey,omitempty" yaml:"secretAccessKey,omitempty"`
+	// Params are any other provider specific parameters (the query
+	// parameters of the URL).
+	Params map[string]string `json:"params,omitempty" yaml:"params,omitempty"`
+}
+
+// Open opens the provider described by the configuration. It returns the
+// provider, and the bucket to store objects in.
+func (c *Config) Open(ctx context.Context) (Provider, string, error) {
+	rawURL, err := c.url()
+	if err != nil {
+		return nil, "", err
+	}
+
+	return Open(ctx, rawURL)
+}
+
+// url returns the URL of the provider described by the configuration.
+func (c *Config) url() (string, error) {
+	if c.URL != "" {
+		return c.URL, nil
+	}
+
+	if c.Type == "" {
+		return "", errors.New("missing provider type")
+	}
+
+	query := make(url.Values)
+	for name, value := range c.Params {
+		query.Set(name, value)
+	}
+
+	if c.Endpoint != "" {
+		query.Set("endpoint", c.Endpoint)
+	}
+
+	if c.Region != "" {
+		query.Set("region", c.Region)
+	}
+
+	u := &url.URL{
+		Scheme:   c.Type,
+		Host:     c.Bucket,
+		RawQuery: query.Encode(),
+	}
+
+	if c.AccessKeyID != "" {
+		u.User = url.UserPassword(c.AccessKeyID, c.SecretAccessKey)
+	}
+
+	return u.String(), nil
+}
+
+// FromEnv opens the provider described by the environment. The provider is
+// given by the OBJSYNC_PROVIDER_URL environment variable (see Open), or
+// otherwise by OBJSYNC_PROVIDER (the provider type, defaulting to "s3"),
+// and BUCKET. Each provider falls back to its standard environment variables
+// for anything else (eg. AWS_ENDPOINT_URL_S3, AWS_REGION, AWS_ACCESS_KEY_ID
+// and AWS_SECRET_ACCESS_KEY for S3).
+//
+// It returns the provider, and the bucket to store objects in.
+func FromEnv(ctx context.Context) (Provider, string, error) {
+	conf := Config{
+		URL:    os.Getenv("OBJSYNC_PROVIDER_URL"),
+		Type:   os.Getenv("OBJSYNC_PROVIDER"),
+		Bucket: os.Getenv("BUCKET"),
+	}
+
+	if conf.Type == "" {
+		conf.Type = "s3"
+	}
+
+	return conf.Open(ctx)
+}
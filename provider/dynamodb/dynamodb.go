@@ -0,0 +1,263 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package dynamodb
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"slices"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/dpeckett/objsync/provider"
+)
+
+func init() {
+	provider.Register("dynamodb", open)
+}
+
+// The attribute names of lock items.
+const (
+	keyAttribute     = "key"
+	dataAttribute    = "data"
+	versionAttribute = "version"
+)
+
+// Option is a functional option for configuring a DynamoDB provider.
+type Option func(*options)
+
+type options struct {
+	endpointURL     string
+	region          string
+	accessKeyID     string
+	secretAccessKey string
+}
+
+// WithEndpointURL overrides the DynamoDB endpoint (eg. for DynamoDB Local).
+func WithEndpointURL(endpointURL string) Option {
+	return func(o *options) {
+		o.endpointURL = endpointURL
+	}
+}
+
+// WithRegion sets the AWS region.
+func WithRegion(region string) Option {
+	return func(o *options) {
+		o.region = region
+	}
+}
+
+// WithStaticCredentials uses static credentials, rather than the default
+// credential chain.
+func WithStaticCredentials(accessKeyID, secretAccessKey string) Option {
+	return func(o *options) {
+		o.accessKeyID = accessKeyID
+		o.secretAccessKey = secretAccessKey
+	}
+}
+
+// Provider is a DynamoDB provider. Buckets correspond to tables, which must
+// have a string partition key named "key". Each object is stored as an item
+// along with a version number, that is used for conditional writes.
+type Provider struct {
+	client *dynamodb.Client
+}
+
+// NewProvider initializes a new DynamoDB provider.
+func NewProvider(ctx context.Context, opts ...Option) (provider.Provider, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	var loadOpts []func(*config.LoadOptions) error
+	if o.region != "" {
+		loadOpts = append(loadOpts, config.WithRegion(o.region))
+	}
+	if o.accessKeyID != "" {
+		loadOpts = append(loadOpts, config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(o.accessKeyID, o.secretAccessKey, "")))
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx, loadOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	client := dynamodb.NewFromConfig(cfg, func(options *dynamodb.Options) {
+		if o.endpointURL != "" {
+			options.BaseEndpoint = aws.String(o.endpointURL)
+		}
+	})
+
+	return &Provider{client: client}, nil
+}
+
+// open opens a DynamoDB provider from a URL, where the bucket is the table,
+// eg. "dynamodb://table?region=eu-west-1". The credentials default to the
+// AWS SDK defaults, and can be given as the user info of the URL. The
+// endpoint parameter overrides the DynamoDB endpoint (eg. for DynamoDB
+// Local).
+func open(ctx context.Context, u *url.URL) (provider.Provider, error) {
+	query := u.Query()
+
+	var opts []Option
+	if region := query.Get("region"); region != "" {
+		opts = append(opts, WithRegion(region))
+	}
+
+	if endpointURL := query.Get("endpoint"); endpointURL != "" {
+		opts = append(opts, WithEndpointURL(endpointURL))
+	}
+
+	if u.User != nil {
+		secretAccessKey, _ := u.User.Password()
+		opts = append(opts, WithStaticCredentials(u.User.Username(), secretAccessKey))
+	}
+
+	return NewProvider(ctx, opts...)
+}
+
+func (p *Provider) AtomicUpdateObject(ctx context.Context, bucket, key string, fn provider.UpdateObjectFunc) (string, error) {
+	getResp, err := p.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(bucket),
+		Key:            map[string]types.AttributeValue{keyAttribute: &types.AttributeValueMemberS{Value: key}},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var currentVersion string
+	var currentData []byte
+	if getResp.Item != nil {
+		if v, ok := getResp.Item[versionAttribute].(*types.AttributeValueMemberN); ok {
+			currentVersion = v.Value
+		}
+
+		if v, ok := getResp.Item[dataAttribute].(*types.AttributeValueMemberB); ok {
+			currentData = v.Value
+		}
+	}
+
+	newData, err := fn(currentVersion, currentData)
+	if err != nil {
+		return "", err
+	}
+
+	newVersion := provider.InitialVersion()
+	if currentVersion != "" {
+		newVersion, err = strconv.ParseInt(currentVersion, 10, 64)
+		if err != nil {
+			return "", err
+		}
+		newVersion++
+	}
+
+	putInput := &dynamodb.PutItemInput{
+		TableName: aws.String(bucket),
+		Item: map[string]types.AttributeValue{
+			keyAttribute:     &types.AttributeValueMemberS{Value: key},
+			dataAttribute:    &types.AttributeValueMemberB{Value: newData},
+			versionAttribute: &types.AttributeValueMemberN{Value: strconv.FormatInt(newVersion, 10)},
+		},
+		ExpressionAttributeNames: map[string]string{"#k": keyAttribute},
+		ConditionExpression:      aws.String("attribute_not_exists(#k)"),
+	}
+	if currentVersion != "" {
+		putInput.ExpressionAttributeNames = map[string]string{"#v": versionAttribute}
+		putInput.ExpressionAttributeValues = map[string]types.AttributeValue{
+			":v": &types.AttributeValueMemberN{Value: currentVersion},
+		}
+		putInput.ConditionExpression = aws.String("#v = :v")
+	}
+
+	if _, err := p.client.PutItem(ctx, putInput); err != nil {
+		var condErr *types.ConditionalCheckFailedException
+		if errors.As(err, &condErr) {
+			return "", provider.ErrConflict
+		}
+
+		return "", err
+	}
+
+	return strconv.FormatInt(newVersion, 10), nil
+}
+
+func (p *Provider) DeleteObject(ctx context.Context, bucket, key, etag string) error {
+	_, err := p.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName:                aws.String(bucket),
+		Key:                      map[string]types.AttributeValue{keyAttribute: &types.AttributeValueMemberS{Value: key}},
+		ExpressionAttributeNames: map[string]string{"#v": versionAttribute},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":v": &types.AttributeValueMemberN{Value: etag},
+		},
+		ConditionExpression: aws.String("#v = :v"),
+	})
+	if err != nil {
+		var condErr *types.ConditionalCheckFailedException
+		if errors.As(err, &condErr) {
+			return provider.ErrConflict
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+func (p *Provider) Capabilities() provider.Capabilities {
+	return provider.Capabilities{
+		ConditionalCreate:    true,
+		ConditionalDelete:    true,
+		StrongReadAfterWrite: true,
+	}
+}
+
+// ListObjects lists objects by scanning the whole table, as items are
+// partitioned by key (so can't be queried by prefix).
+func (p *Provider) ListObjects(ctx context.Context, bucket, prefix string) ([]string, error) {
+	scanInput := &dynamodb.ScanInput{
+		TableName:                aws.String(bucket),
+		ProjectionExpression:     aws.String("#k"),
+		ExpressionAttributeNames: map[string]string{"#k": keyAttribute},
+		ConsistentRead:           aws.Bool(true),
+	}
+	if prefix != "" {
+		scanInput.FilterExpression = aws.String("begins_with(#k, :p)")
+		scanInput.ExpressionAttributeValues = map[string]types.AttributeValue{
+			":p": &types.AttributeValueMemberS{Value: prefix},
+		}
+	}
+
+	var keys []string
+	paginator := dynamodb.NewScanPaginator(p.client, scanInput)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range page.Items {
+			if v, ok := item[keyAttribute].(*types.AttributeValueMemberS); ok {
+				keys = append(keys, v.Value)
+			}
+		}
+	}
+
+	// Items are scanned in the order of the hash of their key.
+	slices.Sort(keys)
+
+	return keys, nil
+}
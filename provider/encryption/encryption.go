@@ -0,0 +1,299 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package encryption implements a provider that encrypts objects (with
+// AES-GCM) before passing them on to another provider, so that lock metadata
+// (eg. owner IDs, and job metadata) isn't stored in plaintext in buckets that
+// others can read.
+//
+// Each object is bound to its bucket and key, so encrypted objects can't be
+// swapped around by someone with write access to the bucket. Object keys,
+// and the size of objects, are not hidden.
+package encryption
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dpeckett/objsync/provider"
+)
+
+// ErrNotEncrypted is returned when an object isn't encrypted (and plaintext
+// objects aren't allowed, see WithAllowPlaintext).
+var ErrNotEncrypted = errors.New("object is not encrypted")
+
+// Keyring supplies the keys used to encrypt and decrypt objects. It can be
+// implemented by eg. a KMS client, that decrypts data keys on demand (keyrings
+// are called for every read and write, so such implementations should cache
+// their keys).
+type Keyring interface {
+	// EncryptionKey returns the key used to encrypt new objects, along with
+	// its ID. Keys must be 16, 24 or 32 bytes long (selecting AES-128,
+	// AES-192 or AES-256).
+	EncryptionKey(ctx context.Context) (id string, key []byte, err error)
+	// DecryptionKey returns the key with the given ID.
+	DecryptionKey(ctx context.Context, id string) ([]byte, error)
+}
+
+// StaticKeyring is a keyring with a fixed set of keys.
+type StaticKeyring struct {
+	// CurrentKeyID is the ID of the key used to encrypt new objects.
+	CurrentKeyID string
+	// Keys are the keys, by ID. Old keys can be kept around to decrypt
+	// objects written before the key was rotated.
+	Keys map[string][]byte
+}
+
+// StaticKey returns a keyring with a single key.
+func StaticKey(key []byte) *StaticKeyring {
+	return &StaticKeyring{
+		CurrentKeyID: "static",
+		Keys:         map[string][]byte{"static": key},
+	}
+}
+
+func (k *StaticKeyring) EncryptionKey(ctx context.Context) (string, []byte, error) {
+	key, err := k.DecryptionKey(ctx, k.CurrentKeyID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return k.CurrentKeyID, key, nil
+}
+
+func (k *StaticKeyring) DecryptionKey(_ context.Context, id string) ([]byte, error) {
+	key, ok := k.Keys[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", id)
+	}
+
+	return key, nil
+}
+
+// Option is a functional option for configuring an encrypting provider.
+type Option func(*Provider)
+
+// WithAllowPlaintext allows reading objects that aren't encrypted, for
+// encrypting an existing bucket. Such objects are encrypted the next time
+// they are written.
+func WithAllowPlaintext() Option {
+	return func(p *Provider) {
+		p.allowPlaintext = true
+	}
+}
+
+// Provider is a provider that encrypts objects before passing them on to
+// another provider.
+type Provider struct {
+	next           provider.Provider
+	keyring        Keyring
+	allowPlaintext bool
+}
+
+// NewProvider wraps a provider, encrypting objects with keys from the given
+// keyring.
+func NewProvider(next provider.Provider, keyring Keyring, opts ...Option) provider.Provider {
+	p := &Provider{
+		next:    next,
+		keyring: keyring,
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+// The current version of the encrypted object format.
+const formatVersion = 1
+
+// The JSON content of an encrypted object.
+type encryptedObject struct {
+	Version    int    `json:"objsyncEncrypted"`
+	KeyID      string `json:"keyID"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+func (p *Provider) AtomicUpdateObject(ctx context.Context, bucket, key string, fn provider.UpdateObjectFunc) (string, error) {
+	return p.next.AtomicUpdateObject(ctx, bucket, key, func(currentETag string, currentData []byte) ([]byte, error) {
+		plaintext, err := p.decrypt(ctx, bucket, key, currentData)
+		if err != nil {
+			return nil, err
+		}
+
+		newData, err := fn(currentETag, plaintext)
+		if err != nil {
+			return nil, err
+		}
+
+		return p.encrypt(ctx, bucket, key, newData)
+	})
+}
+
+func (p *Provider) DeleteObject(ctx context.Context, bucket, key, etag string) error {
+	deleter, ok := p.next.(provider.Deleter)
+	if !ok {
+		return provider.ErrNotSupported
+	}
+
+	return deleter.DeleteObject(ctx, bucket, key, etag)
+}
+
+func (p *Provider) GetObject(ctx context.Context, bucket, key string) ([]byte, string, error) {
+	getter, ok := p.next.(provider.Getter)
+	if !ok {
+		return nil, "", provider.ErrNotSupported
+	}
+
+	data, etag, err := getter.GetObject(ctx, bucket, key)
+	if err != nil {
+		return nil, "", err
+	}
+
+	plaintext, err := p.decrypt(ctx, bucket, key, data)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return plaintext, etag, nil
+}
+
+func (p *Provider) HeadObject(ctx context.Context, bucket, key string) (string, error) {
+	header, ok := p.next.(provider.Header)
+	if !ok {
+		return "", provider.ErrNotSupported
+	}
+
+	return header.HeadObject(ctx, bucket, key)
+}
+
+func (p *Provider) ListObjects(ctx context.Context, bucket, prefix string) ([]string, error) {
+	lister, ok := p.next.(provider.Lister)
+	if !ok {
+		return nil, provider.ErrNotSupported
+	}
+
+	return lister.ListObjects(ctx, bucket, prefix)
+}
+
+func (p *Provider) WatchObject(ctx context.Context, bucket, key string) <-chan provider.Event {
+	watcher, ok := p.next.(provider.Watcher)
+	if !ok {
+		return provider.WatchError(provider.ErrNotSupported)
+	}
+
+	return watcher.WatchObject(ctx, bucket, key)
+}
+
+func (p *Provider) Now(ctx context.Context) (time.Time, error) {
+	return provider.NowOf(ctx, p.next)
+}
+
+// Capabilities returns the capabilities of the wrapped provider.
+func (p *Provider) Capabilities() provider.Capabilities {
+	return provider.CapabilitiesOf(p.next)
+}
+
+// FenceEpoch returns the fence epoch of the wrapped provider.
+func (p *Provider) FenceEpoch() int64 {
+	return provider.FenceEpochOf(p.next)
+}
+
+// encrypt encrypts the content of an object.
+func (p *Provider) encrypt(ctx context.Context, bucket, key string, plaintext []byte) ([]byte, error) {
+	// Missing objects stay missing.
+	if len(plaintext) == 0 {
+		return plaintext, nil
+	}
+
+	keyID, encryptionKey, err := p.keyring.EncryptionKey(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get encryption key: %w", err)
+	}
+
+	aead, err := newAEAD(encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(&encryptedObject{
+		Version:    formatVersion,
+		KeyID:      keyID,
+		Nonce:      nonce,
+		Ciphertext: aead.Seal(nil, nonce, plaintext, additionalData(bucket, key)),
+	})
+}
+
+// decrypt decrypts the content of an object.
+func (p *Provider) decrypt(ctx context.Context, bucket, key string, data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return data, nil
+	}
+
+	var obj encryptedObject
+	if err := json.Unmarshal(data, &obj); err != nil || obj.Version == 0 {
+		if p.allowPlaintext {
+			return data, nil
+		}
+
+		return nil, ErrNotEncrypted
+	}
+
+	if obj.Version > formatVersion {
+		return nil, fmt.Errorf("unsupported encrypted object version: %d", obj.Version)
+	}
+
+	decryptionKey, err := p.keyring.DecryptionKey(ctx, obj.KeyID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get decryption key: %w", err)
+	}
+
+	aead, err := newAEAD(decryptionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(obj.Nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("failed to decrypt object: invalid nonce")
+	}
+
+	plaintext, err := aead.Open(nil, obj.Nonce, obj.Ciphertext, additionalData(bucket, key))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt object: %w", err)
+	}
+
+	return plaintext, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key: %w", err)
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// additionalData binds the ciphertext of an object to its bucket and key.
+func additionalData(bucket, key string) []byte {
+	return []byte(bucket + "\x00" + key)
+}
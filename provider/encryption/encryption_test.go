@@ -0,0 +1,200 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package encryption_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/dpeckett/objsync"
+	"github.com/dpeckett/objsync/provider"
+	"github.com/dpeckett/objsync/provider/encryption"
+	"github.com/dpeckett/objsync/provider/mem"
+	"github.com/stretchr/testify/require"
+)
+
+func put(ctx context.Context, p provider.Provider, key, value string) (string, error) {
+	return p.AtomicUpdateObject(ctx, "bucket", key, func(_ string, _ []byte) ([]byte, error) {
+		return []byte(value), nil
+	})
+}
+
+func TestProvider(t *testing.T) {
+	ctx := context.Background()
+
+	key := bytes.Repeat([]byte{1}, 32)
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		next := mem.NewProvider()
+		p := encryption.NewProvider(next, encryption.StaticKey(key))
+
+		etag, err := put(ctx, p, "key", "hello")
+		require.NoError(t, err)
+
+		data, currentETag, err := p.(provider.Getter).GetObject(ctx, "bucket", "key")
+		require.NoError(t, err)
+		require.Equal(t, "hello", string(data))
+		require.Equal(t, etag, currentETag)
+
+		// Updates see the plaintext.
+		_, err = p.AtomicUpdateObject(ctx, "bucket", "key", func(_ string, currentData []byte) ([]byte, error) {
+			require.Equal(t, "hello", string(currentData))
+
+			return []byte("world"), nil
+		})
+		require.NoError(t, err)
+
+		// But it isn't stored.
+		ciphertext, _, err := next.(provider.Getter).GetObject(ctx, "bucket", "key")
+		require.NoError(t, err)
+		require.NotContains(t, string(ciphertext), "world")
+	})
+
+	t.Run("Tampered", func(t *testing.T) {
+		next := mem.NewProvider()
+		p := encryption.NewProvider(next, encryption.StaticKey(key))
+
+		_, err := put(ctx, p, "key", "hello")
+		require.NoError(t, err)
+
+		_, err = next.AtomicUpdateObject(ctx, "bucket", "key", func(_ string, currentData []byte) ([]byte, error) {
+			var obj map[string]json.RawMessage
+			if err := json.Unmarshal(currentData, &obj); err != nil {
+				return nil, err
+			}
+
+			var ciphertext []byte
+			if err := json.Unmarshal(obj["ciphertext"], &ciphertext); err != nil {
+				return nil, err
+			}
+
+			ciphertext[0] ^= 1
+
+			var err error
+			obj["ciphertext"], err = json.Marshal(ciphertext)
+			if err != nil {
+				return nil, err
+			}
+
+			return json.Marshal(obj)
+		})
+		require.NoError(t, err)
+
+		_, _, err = p.(provider.Getter).GetObject(ctx, "bucket", "key")
+		require.ErrorContains(t, err, "failed to decrypt object")
+	})
+
+	t.Run("Swapped", func(t *testing.T) {
+		next := mem.NewProvider()
+		p := encryption.NewProvider(next, encryption.StaticKey(key))
+
+		_, err := put(ctx, p, "a", "hello")
+		require.NoError(t, err)
+
+		// Copy the encrypted object to another key.
+		ciphertext, _, err := next.(provider.Getter).GetObject(ctx, "bucket", "a")
+		require.NoError(t, err)
+
+		_, err = put(ctx, next, "b", string(ciphertext))
+		require.NoError(t, err)
+
+		_, _, err = p.(provider.Getter).GetObject(ctx, "bucket", "b")
+		require.ErrorContains(t, err, "failed to decrypt object")
+	})
+
+	t.Run("WrongKey", func(t *testing.T) {
+		next := mem.NewProvider()
+
+		_, err := put(ctx, encryption.NewProvider(next, encryption.StaticKey(key)), "key", "hello")
+		require.NoError(t, err)
+
+		p := encryption.NewProvider(next, encryption.StaticKey(bytes.Repeat([]byte{2}, 32)))
+
+		_, _, err = p.(provider.Getter).GetObject(ctx, "bucket", "key")
+		require.ErrorContains(t, err, "failed to decrypt object")
+	})
+
+	t.Run("KeyRotation", func(t *testing.T) {
+		next := mem.NewProvider()
+
+		_, err := put(ctx, encryption.NewProvider(next, &encryption.StaticKeyring{
+			CurrentKeyID: "old",
+			Keys:         map[string][]byte{"old": key},
+		}), "key", "hello")
+		require.NoError(t, err)
+
+		p := encryption.NewProvider(next, &encryption.StaticKeyring{
+			CurrentKeyID: "new",
+			Keys:         map[string][]byte{"old": key, "new": bytes.Repeat([]byte{2}, 16)},
+		})
+
+		data, _, err := p.(provider.Getter).GetObject(ctx, "bucket", "key")
+		require.NoError(t, err)
+		require.Equal(t, "hello", string(data))
+
+		_, err = put(ctx, p, "key", "world")
+		require.NoError(t, err)
+
+		ciphertext, _, err := next.(provider.Getter).GetObject(ctx, "bucket", "key")
+		require.NoError(t, err)
+		require.Contains(t, string(ciphertext), `"keyID":"new"`)
+	})
+
+	t.Run("Plaintext", func(t *testing.T) {
+		next := mem.NewProvider()
+
+		_, err := put(ctx, next, "key", "hello")
+		require.NoError(t, err)
+
+		_, _, err = encryption.NewProvider(next, encryption.StaticKey(key)).(provider.Getter).GetObject(ctx, "bucket", "key")
+		require.ErrorIs(t, err, encryption.ErrNotEncrypted)
+
+		p := encryption.NewProvider(next, encryption.StaticKey(key), encryption.WithAllowPlaintext())
+
+		data, _, err := p.(provider.Getter).GetObject(ctx, "bucket", "key")
+		require.NoError(t, err)
+		require.Equal(t, "hello", string(data))
+
+		// It's encrypted the next time it's written.
+		_, err = put(ctx, p, "key", "world")
+		require.NoError(t, err)
+
+		ciphertext, _, err := next.(provider.Getter).GetObject(ctx, "bucket", "key")
+		require.NoError(t, err)
+		require.NotContains(t, string(ciphertext), "world")
+	})
+}
+
+func TestMutex(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+
+	next := mem.NewProvider()
+	p := encryption.NewProvider(next, encryption.StaticKey(bytes.Repeat([]byte{1}, 32)))
+
+	mu := objsync.NewMutex(p, "bucket", "test.lock", objsync.WithOwnerID("secret-owner"))
+
+	_, err := mu.Lock(ctx, time.Minute)
+	require.NoError(t, err)
+
+	ok, _, err := objsync.NewMutex(p, "bucket", "test.lock").TryLock(ctx, time.Minute)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	// The owner of the lock isn't stored in plaintext.
+	data, _, err := next.(provider.Getter).GetObject(ctx, "bucket", "test.lock")
+	require.NoError(t, err)
+	require.NotContains(t, string(data), "secret-owner")
+
+	require.NoError(t, mu.Unlock(ctx))
+}
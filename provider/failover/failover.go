@@ -0,0 +1,302 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package failover implements a provider that uses a primary provider, and
+// fails over to a secondary provider (eg. an object store in another region)
+// when the primary is unreachable, so that an outage degrades coordination
+// rather than halting it.
+//
+// Objects are not replicated between the providers, so locks held on one
+// provider are not visible on the other. To keep fencing tokens monotonic,
+// every switch between the providers starts a new fence epoch (see
+// provider.FenceEpocher), derived from the current time. Fencing tokens
+// issued after a switch therefore compare greater than those issued before
+// it, and resources protected by fencing tokens reject writes from holders
+// that acquired their lock before the switch.
+//
+// Each process fails over independently, so during a partial outage
+// processes may briefly use different providers.
+package failover
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/dpeckett/objsync/provider"
+)
+
+// Option is a functional option for configuring a failover provider.
+type Option func(*Provider)
+
+// WithFailureThreshold sets the number of consecutive failures after which
+// the provider fails over.
+func WithFailureThreshold(n int) Option {
+	return func(p *Provider) {
+		p.failureThreshold = n
+	}
+}
+
+// WithFailbackInterval sets how long the provider waits after failing over
+// before trying the primary provider again (zero disables failing back).
+func WithFailbackInterval(d time.Duration) Option {
+	return func(p *Provider) {
+		p.failbackInterval = d
+	}
+}
+
+const (
+	primary   = 0
+	secondary = 1
+)
+
+// The origin of fence epochs, the same as for time derived fence epochs (see
+// objsync.WithTimeFenceEpoch), so that the two are comparable.
+var fenceEpochOrigin = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Provider is a provider that fails over from a primary provider to a
+// secondary provider.
+type Provider struct {
+	providers        [2]provider.Provider
+	failureThreshold int
+	failbackInterval time.Duration
+	mu               sync.Mutex
+	active           int
+	failures         int
+	failingBack      bool
+	switchedAt       time.Time
+	epoch            int64
+}
+
+// NewProvider creates a provider that uses the primary provider, failing over
+// to the secondary provider when the primary is unreachable.
+func NewProvider(primaryProvider, secondaryProvider provider.Provider, opts ...Option) provider.Provider {
+	p := &Provider{
+		providers:        [2]provider.Provider{primaryProvider, secondaryProvider},
+		failureThreshold: 3,
+		failbackInterval: 5 * time.Minute,
+		// Processes may (re)start on the primary provider after others have
+		// failed over, so start in a new epoch.
+		epoch: newEpoch(0),
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+func (p *Provider) AtomicUpdateObject(ctx context.Context, bucket, key string, fn provider.UpdateObjectFunc) (string, error) {
+	var etag string
+	err := p.do(ctx, func(next provider.Provider) (bool, error) {
+		// Errors returned by the update function are not provider failures.
+		var fnErr error
+		var err error
+		etag, err = next.AtomicUpdateObject(ctx, bucket, key, func(currentETag string, currentData []byte) ([]byte, error) {
+			newData, err := fn(currentETag, currentData)
+			fnErr = err
+			return newData, err
+		})
+
+		return err != nil && fnErr == nil && !errors.Is(err, provider.ErrConflict), err
+	})
+
+	return etag, err
+}
+
+func (p *Provider) DeleteObject(ctx context.Context, bucket, key, etag string) error {
+	return p.do(ctx, func(next provider.Provider) (bool, error) {
+		deleter, ok := next.(provider.Deleter)
+		if !ok {
+			return false, provider.ErrNotSupported
+		}
+
+		err := deleter.DeleteObject(ctx, bucket, key, etag)
+
+		return err != nil && !errors.Is(err, provider.ErrConflict) && !errors.Is(err, provider.ErrNotSupported), err
+	})
+}
+
+func (p *Provider) GetObject(ctx context.Context, bucket, key string) ([]byte, string, error) {
+	var data []byte
+	var etag string
+	err := p.do(ctx, func(next provider.Provider) (bool, error) {
+		getter, ok := next.(provider.Getter)
+		if !ok {
+			return false, provider.ErrNotSupported
+		}
+
+		var err error
+		data, etag, err = getter.GetObject(ctx, bucket, key)
+
+		return err != nil && !errors.Is(err, provider.ErrNotSupported), err
+	})
+
+	return data, etag, err
+}
+
+func (p *Provider) HeadObject(ctx context.Context, bucket, key string) (string, error) {
+	var etag string
+	err := p.do(ctx, func(next provider.Provider) (bool, error) {
+		header, ok := next.(provider.Header)
+		if !ok {
+			return false, provider.ErrNotSupported
+		}
+
+		var err error
+		etag, err = header.HeadObject(ctx, bucket, key)
+
+		return err != nil && !errors.Is(err, provider.ErrNotSupported), err
+	})
+
+	return etag, err
+}
+
+func (p *Provider) ListObjects(ctx context.Context, bucket, prefix string) ([]string, error) {
+	var keys []string
+	err := p.do(ctx, func(next provider.Provider) (bool, error) {
+		lister, ok := next.(provider.Lister)
+		if !ok {
+			return false, provider.ErrNotSupported
+		}
+
+		var err error
+		keys, err = lister.ListObjects(ctx, bucket, prefix)
+
+		return err != nil && !errors.Is(err, provider.ErrNotSupported), err
+	})
+
+	return keys, err
+}
+
+// WatchObject watches an object on the active provider. Watches don't follow
+// a failover, instead they fail, and the watcher falls back to polling.
+func (p *Provider) WatchObject(ctx context.Context, bucket, key string) <-chan provider.Event {
+	p.mu.Lock()
+	active := p.active
+	p.mu.Unlock()
+
+	watcher, ok := p.providers[active].(provider.Watcher)
+	if !ok {
+		return provider.WatchError(provider.ErrNotSupported)
+	}
+
+	return watcher.WatchObject(ctx, bucket, key)
+}
+
+// Now returns the current time according to the active provider.
+func (p *Provider) Now(ctx context.Context) (time.Time, error) {
+	p.mu.Lock()
+	active := p.active
+	p.mu.Unlock()
+
+	return provider.NowOf(ctx, p.providers[active])
+}
+
+// Capabilities returns the capabilities shared by both providers.
+func (p *Provider) Capabilities() provider.Capabilities {
+	primaryCaps := provider.CapabilitiesOf(p.providers[primary])
+	secondaryCaps := provider.CapabilitiesOf(p.providers[secondary])
+
+	return provider.Capabilities{
+		ConditionalCreate:    primaryCaps.ConditionalCreate && secondaryCaps.ConditionalCreate,
+		ConditionalDelete:    primaryCaps.ConditionalDelete && secondaryCaps.ConditionalDelete,
+		Watch:                primaryCaps.Watch && secondaryCaps.Watch,
+		ServerTime:           primaryCaps.ServerTime && secondaryCaps.ServerTime,
+		StrongReadAfterWrite: primaryCaps.StrongReadAfterWrite && secondaryCaps.StrongReadAfterWrite,
+	}
+}
+
+// FenceEpoch returns the fence epoch of the provider, which is bumped every
+// time the provider switches between the primary and secondary providers.
+func (p *Provider) FenceEpoch() int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.epoch
+}
+
+// do calls fn with the active provider, and if the call fails such that the
+// provider fails over, retries it with the other provider. Fn returns
+// whether the provider failed (rather than eg. reporting a conflict).
+func (p *Provider) do(ctx context.Context, fn func(next provider.Provider) (bool, error)) error {
+	active := p.acquire()
+
+	failed, err := fn(p.providers[active])
+	if !p.record(active, failed && ctx.Err() == nil) {
+		return err
+	}
+
+	active = p.acquire()
+
+	failed, err = fn(p.providers[active])
+	p.record(active, failed && ctx.Err() == nil)
+
+	return err
+}
+
+// acquire returns the provider to use for a call, failing back to the
+// primary provider if it's time to try it again.
+func (p *Provider) acquire() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.active == secondary && p.failbackInterval > 0 && time.Since(p.switchedAt) >= p.failbackInterval {
+		p.switchTo(primary)
+		// A single failure is enough to fail over again.
+		p.failingBack = true
+	}
+
+	return p.active
+}
+
+// record records the outcome of a call to the given provider, returning
+// whether the active provider has changed since (and the call should be
+// retried).
+func (p *Provider) record(active int, failed bool) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if active != p.active {
+		return failed
+	}
+
+	if !failed {
+		p.failures = 0
+		p.failingBack = false
+		return false
+	}
+
+	p.failures++
+
+	if !p.failingBack && p.failures < p.failureThreshold {
+		return false
+	}
+
+	p.switchTo(1 - active)
+
+	return true
+}
+
+// switchTo makes the given provider active, starting a new fence epoch.
+func (p *Provider) switchTo(active int) {
+	p.active = active
+	p.failures = 0
+	p.failingBack = false
+	p.switchedAt = time.Now()
+	p.epoch = newEpoch(p.epoch)
+}
+
+// newEpoch returns a fence epoch, derived from the current time, that is
+// newer than the given epoch.
+func newEpoch(epoch int64) int64 {
+	return max(epoch+1, int64(time.Since(fenceEpochOrigin)/time.Second))
+}
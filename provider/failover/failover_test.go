@@ -0,0 +1,186 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package failover_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dpeckett/objsync"
+	"github.com/dpeckett/objsync/provider"
+	"github.com/dpeckett/objsync/provider/failover"
+	"github.com/dpeckett/objsync/provider/mem"
+	"github.com/stretchr/testify/require"
+)
+
+var errDown = errors.New("provider down")
+
+// region is a provider that can be taken down.
+type region struct {
+	provider.Provider
+	down atomic.Bool
+}
+
+func newRegion() *region {
+	return &region{Provider: mem.NewProvider()}
+}
+
+func (r *region) AtomicUpdateObject(ctx context.Context, bucket, key string, fn provider.UpdateObjectFunc) (string, error) {
+	if r.down.Load() {
+		return "", errDown
+	}
+
+	return r.Provider.AtomicUpdateObject(ctx, bucket, key, fn)
+}
+
+func (r *region) GetObject(ctx context.Context, bucket, key string) ([]byte, string, error) {
+	if r.down.Load() {
+		return nil, "", errDown
+	}
+
+	return r.Provider.(provider.Getter).GetObject(ctx, bucket, key)
+}
+
+func (r *region) DeleteObject(ctx context.Context, bucket, key, etag string) error {
+	if r.down.Load() {
+		return errDown
+	}
+
+	return r.Provider.(provider.Deleter).DeleteObject(ctx, bucket, key, etag)
+}
+
+func put(ctx context.Context, p provider.Provider, value string) error {
+	_, err := p.AtomicUpdateObject(ctx, "bucket", "key", func(_ string, _ []byte) ([]byte, error) {
+		return []byte(value), nil
+	})
+	return err
+}
+
+func get(t *testing.T, ctx context.Context, p provider.Provider) string {
+	data, _, err := p.(provider.Getter).GetObject(ctx, "bucket", "key")
+	require.NoError(t, err)
+
+	return string(data)
+}
+
+func TestProvider(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Failover", func(t *testing.T) {
+		primary, secondary := newRegion(), newRegion()
+		p := failover.NewProvider(primary, secondary,
+			failover.WithFailureThreshold(2),
+			failover.WithFailbackInterval(0))
+
+		epoch := provider.FenceEpochOf(p)
+		require.NotZero(t, epoch)
+
+		require.NoError(t, put(ctx, p, "hello"))
+		require.Equal(t, "hello", get(t, ctx, primary))
+
+		primary.down.Store(true)
+
+		// Below the threshold, failures are returned.
+		require.ErrorIs(t, put(ctx, p, "world"), errDown)
+		require.Equal(t, epoch, provider.FenceEpochOf(p))
+
+		// Reaching the threshold fails over, and the call is retried on the
+		// secondary provider, in a new fence epoch.
+		require.NoError(t, put(ctx, p, "world"))
+		require.Greater(t, provider.FenceEpochOf(p), epoch)
+		require.Equal(t, "world", get(t, ctx, secondary))
+
+		// Later calls go straight to the secondary provider.
+		primary.down.Store(false)
+
+		require.NoError(t, put(ctx, p, "again"))
+		require.Equal(t, "again", get(t, ctx, p))
+		require.Equal(t, "hello", get(t, ctx, primary))
+	})
+
+	t.Run("Failback", func(t *testing.T) {
+		const failbackInterval = 50 * time.Millisecond
+
+		primary, secondary := newRegion(), newRegion()
+		p := failover.NewProvider(primary, secondary,
+			failover.WithFailureThreshold(1),
+			failover.WithFailbackInterval(failbackInterval))
+
+		primary.down.Store(true)
+
+		require.NoError(t, put(ctx, p, "hello"))
+		require.Equal(t, "hello", get(t, ctx, secondary))
+
+		epoch := provider.FenceEpochOf(p)
+
+		// The primary provider is tried again after the failback interval,
+		// and while it's still down, a single failure fails over again.
+		time.Sleep(failbackInterval)
+
+		require.NoError(t, put(ctx, p, "world"))
+		require.Equal(t, "world", get(t, ctx, secondary))
+		require.Greater(t, provider.FenceEpochOf(p), epoch+1)
+
+		// Once it's back, the provider fails back to it.
+		primary.down.Store(false)
+		time.Sleep(failbackInterval)
+
+		epoch = provider.FenceEpochOf(p)
+
+		require.NoError(t, put(ctx, p, "again"))
+		require.Equal(t, "again", get(t, ctx, primary))
+		require.Greater(t, provider.FenceEpochOf(p), epoch)
+	})
+
+	t.Run("ExpectedErrors", func(t *testing.T) {
+		primary, secondary := newRegion(), newRegion()
+		p := failover.NewProvider(primary, secondary,
+			failover.WithFailureThreshold(1))
+
+		epoch := provider.FenceEpochOf(p)
+
+		// Errors returned by the update function, and conflicts, aren't
+		// provider failures.
+		errAbort := errors.New("abort")
+		_, err := p.AtomicUpdateObject(ctx, "bucket", "key", func(_ string, _ []byte) ([]byte, error) {
+			return nil, errAbort
+		})
+		require.ErrorIs(t, err, errAbort)
+
+		require.ErrorIs(t, p.(provider.Deleter).DeleteObject(ctx, "bucket", "key", "stale"), provider.ErrConflict)
+
+		require.Equal(t, epoch, provider.FenceEpochOf(p))
+	})
+}
+
+func TestMutex(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+
+	primary, secondary := newRegion(), newRegion()
+	p := failover.NewProvider(primary, secondary,
+		failover.WithFailureThreshold(1))
+
+	mu := objsync.NewMutex(p, "bucket", "test.lock")
+
+	fencingToken, err := mu.Lock(ctx, time.Minute)
+	require.NoError(t, err)
+
+	// The primary region goes down while the lock is held, so the lock is
+	// acquired again on the secondary provider, with a greater fencing token.
+	primary.down.Store(true)
+
+	newFencingToken, err := objsync.NewMutex(p, "bucket", "test.lock").Lock(ctx, time.Minute)
+	require.NoError(t, err)
+	require.Greater(t, newFencingToken, fencingToken)
+}
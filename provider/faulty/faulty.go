@@ -0,0 +1,350 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package faulty implements a provider that injects faults into the calls
+// made to another provider, for chaos testing how applications behave when
+// the lock backend misbehaves.
+package faulty
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/dpeckett/objsync/provider"
+)
+
+// ErrInjected is returned by calls that failed due to an injected fault.
+var ErrInjected = errors.New("injected fault")
+
+// Option is a functional option for configuring a faulty provider.
+type Option func(*Provider)
+
+// WithLatency delays calls, with the given probability, by a random duration
+// of up to the given latency.
+func WithLatency(probability float64, latency time.Duration) Option {
+	return func(p *Provider) {
+		p.latencyProbability = probability
+		p.latency = latency
+	}
+}
+
+// WithErrors fails calls with ErrInjected, with the given probability,
+// before they reach the underlying provider (eg. a transient network error).
+func WithErrors(probability float64) Option {
+	return func(p *Provider) {
+		p.errorProbability = probability
+	}
+}
+
+// WithDroppedResponses fails successful writes (updates and deletes) with
+// ErrInjected, with the given probability, after they have been applied by
+// the underlying provider (eg. a connection reset before the response was
+// received).
+func WithDroppedResponses(probability float64) Option {
+	return func(p *Provider) {
+		p.dropProbability = probability
+	}
+}
+
+// WithStaleReads makes reads of an object (see provider.Getter and
+// provider.Header) return the version preceding the latest version seen by
+// the provider, with the given probability (eg. an eventually consistent
+// object store).
+func WithStaleReads(probability float64) Option {
+	return func(p *Provider) {
+		p.staleProbability = probability
+	}
+}
+
+// WithSeed seeds the random number generator used to inject faults, so that
+// the injected faults are reproducible (for a given order of calls).
+func WithSeed(seed int64) Option {
+	return func(p *Provider) {
+		p.rand = rand.New(rand.NewSource(seed))
+	}
+}
+
+// Provider is a provider that injects faults into the calls made to another
+// provider.
+type Provider struct {
+	next               provider.Provider
+	latencyProbability float64
+	latency            time.Duration
+	errorProbability   float64
+	dropProbability    float64
+	staleProbability   float64
+	mu                 sync.Mutex
+	rand               *rand.Rand
+	versions           map[string]*versions
+}
+
+// The latest two versions of an object seen by the provider.
+type versions struct {
+	current     objectVersion
+	previous    objectVersion
+	hasPrevious bool
+}
+
+type objectVersion struct {
+	etag string
+	data []byte
+}
+
+// NewProvider wraps a provider, injecting faults into the calls made to it.
+// By default no faults are injected.
+func NewProvider(next provider.Provider, opts ...Option) provider.Provider {
+	p := &Provider{
+		next:     next,
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+		versions: make(map[string]*versions),
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+func (p *Provider) AtomicUpdateObject(ctx context.Context, bucket, key string, fn provider.UpdateObjectFunc) (string, error) {
+	if err := p.inject(ctx); err != nil {
+		return "", err
+	}
+
+	var current objectVersion
+	var newData []byte
+	etag, err := p.next.AtomicUpdateObject(ctx, bucket, key, func(currentETag string, currentData []byte) ([]byte, error) {
+		current = objectVersion{etag: currentETag, data: currentData}
+
+		var err error
+		newData, err = fn(currentETag, currentData)
+		return newData, err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	p.observe(bucket, key, current)
+	p.observe(bucket, key, objectVersion{etag: etag, data: newData})
+
+	if p.chance(p.dropProbability) {
+		return "", ErrInjected
+	}
+
+	return etag, nil
+}
+
+func (p *Provider) DeleteObject(ctx context.Context, bucket, key, etag string) error {
+	deleter, ok := p.next.(provider.Deleter)
+	if !ok {
+		return provider.ErrNotSupported
+	}
+
+	if err := p.inject(ctx); err != nil {
+		return err
+	}
+
+	if err := deleter.DeleteObject(ctx, bucket, key, etag); err != nil {
+		return err
+	}
+
+	p.observe(bucket, key, objectVersion{})
+
+	if p.chance(p.dropProbability) {
+		return ErrInjected
+	}
+
+	return nil
+}
+
+func (p *Provider) GetObject(ctx context.Context, bucket, key string) ([]byte, string, error) {
+	getter, ok := p.next.(provider.Getter)
+	if !ok {
+		return nil, "", provider.ErrNotSupported
+	}
+
+	if err := p.inject(ctx); err != nil {
+		return nil, "", err
+	}
+
+	data, etag, err := getter.GetObject(ctx, bucket, key)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if stale, ok := p.stale(bucket, key, &objectVersion{etag: etag, data: data}); ok {
+		return stale.data, stale.etag, nil
+	}
+
+	return data, etag, nil
+}
+
+func (p *Provider) HeadObject(ctx context.Context, bucket, key string) (string, error) {
+	header, ok := p.next.(provider.Header)
+	if !ok {
+		return "", provider.ErrNotSupported
+	}
+
+	if err := p.inject(ctx); err != nil {
+		return "", err
+	}
+
+	etag, err := header.HeadObject(ctx, bucket, key)
+	if err != nil {
+		return "", err
+	}
+
+	if stale, ok := p.stale(bucket, key, nil); ok {
+		return stale.etag, nil
+	}
+
+	return etag, nil
+}
+
+func (p *Provider) ListObjects(ctx context.Context, bucket, prefix string) ([]string, error) {
+	lister, ok := p.next.(provider.Lister)
+	if !ok {
+		return nil, provider.ErrNotSupported
+	}
+
+	if err := p.inject(ctx); err != nil {
+		return nil, err
+	}
+
+	return lister.ListObjects(ctx, bucket, prefix)
+}
+
+// WatchObject watches an object for changes. Faults are only injected when
+// the watch is established.
+func (p *Provider) WatchObject(ctx context.Context, bucket, key string) <-chan provider.Event {
+	watcher, ok := p.next.(provider.Watcher)
+	if !ok {
+		return provider.WatchError(provider.ErrNotSupported)
+	}
+
+	if err := p.inject(ctx); err != nil {
+		return provider.WatchError(err)
+	}
+
+	return watcher.WatchObject(ctx, bucket, key)
+}
+
+func (p *Provider) Now(ctx context.Context) (time.Time, error) {
+	if _, ok := p.next.(provider.Clock); !ok {
+		return time.Time{}, provider.ErrNotSupported
+	}
+
+	if err := p.inject(ctx); err != nil {
+		return time.Time{}, err
+	}
+
+	return provider.NowOf(ctx, p.next)
+}
+
+// Capabilities returns the capabilities of the wrapped provider (reads are no
+// longer strongly consistent if stale reads are injected).
+func (p *Provider) Capabilities() provider.Capabilities {
+	caps := provider.CapabilitiesOf(p.next)
+	if p.staleProbability > 0 {
+		caps.StrongReadAfterWrite = false
+	}
+
+	return caps
+}
+
+// FenceEpoch returns the fence epoch of the wrapped provider.
+func (p *Provider) FenceEpoch() int64 {
+	return provider.FenceEpochOf(p.next)
+}
+
+// inject injects latency, and errors, into a call before it's made.
+func (p *Provider) inject(ctx context.Context) error {
+	if p.chance(p.latencyProbability) {
+		p.mu.Lock()
+		latency := time.Duration(p.rand.Int63n(int64(p.latency) + 1))
+		p.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(latency):
+		}
+	}
+
+	if p.chance(p.errorProbability) {
+		return ErrInjected
+	}
+
+	return nil
+}
+
+// chance returns true with the given probability.
+func (p *Provider) chance(probability float64) bool {
+	if probability <= 0 {
+		return false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.rand.Float64() < probability
+}
+
+// observe records a version of an object seen by the provider.
+func (p *Provider) observe(bucket, key string, version objectVersion) {
+	if p.staleProbability <= 0 {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	v, ok := p.versions[bucket+"/"+key]
+	if !ok {
+		p.versions[bucket+"/"+key] = &versions{current: version}
+		return
+	}
+
+	if v.current.etag != version.etag {
+		v.previous = v.current
+		v.current = version
+		v.hasPrevious = true
+	}
+}
+
+// stale returns the version of an object preceding the latest version, if a
+// stale read should be injected. The latest version returned by the
+// underlying provider is recorded first (if known, heads don't return the
+// content of the object).
+func (p *Provider) stale(bucket, key string, latest *objectVersion) (objectVersion, bool) {
+	if p.staleProbability <= 0 {
+		return objectVersion{}, false
+	}
+
+	if latest != nil {
+		p.observe(bucket, key, *latest)
+	}
+
+	if !p.chance(p.staleProbability) {
+		return objectVersion{}, false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	v, ok := p.versions[bucket+"/"+key]
+	if !ok || !v.hasPrevious {
+		return objectVersion{}, false
+	}
+
+	return v.previous, true
+}
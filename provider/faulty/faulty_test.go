@@ -0,0 +1,139 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package faulty_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dpeckett/objsync/provider"
+	"github.com/dpeckett/objsync/provider/faulty"
+	"github.com/dpeckett/objsync/provider/mem"
+	"github.com/stretchr/testify/require"
+)
+
+func put(ctx context.Context, p provider.Provider, value string) (string, error) {
+	return p.AtomicUpdateObject(ctx, "bucket", "key", func(_ string, _ []byte) ([]byte, error) {
+		return []byte(value), nil
+	})
+}
+
+func get(t *testing.T, ctx context.Context, p provider.Provider) (string, string) {
+	data, etag, err := p.(provider.Getter).GetObject(ctx, "bucket", "key")
+	require.NoError(t, err)
+
+	return string(data), etag
+}
+
+func TestProvider(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("NoFaults", func(t *testing.T) {
+		p := faulty.NewProvider(mem.NewProvider())
+
+		for i := 0; i < 100; i++ {
+			etag, err := put(ctx, p, "hello")
+			require.NoError(t, err)
+
+			_, currentETag := get(t, ctx, p)
+			require.Equal(t, etag, currentETag)
+		}
+
+		require.Equal(t, provider.CapabilitiesOf(mem.NewProvider()), provider.CapabilitiesOf(p))
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		next := mem.NewProvider()
+		p := faulty.NewProvider(next, faulty.WithErrors(1))
+
+		_, err := put(ctx, p, "hello")
+		require.ErrorIs(t, err, faulty.ErrInjected)
+
+		_, _, err = p.(provider.Getter).GetObject(ctx, "bucket", "key")
+		require.ErrorIs(t, err, faulty.ErrInjected)
+
+		// The calls never reached the provider.
+		data, _ := get(t, ctx, next)
+		require.Empty(t, data)
+	})
+
+	t.Run("DroppedResponses", func(t *testing.T) {
+		next := mem.NewProvider()
+		p := faulty.NewProvider(next, faulty.WithDroppedResponses(1))
+
+		_, err := put(ctx, p, "hello")
+		require.ErrorIs(t, err, faulty.ErrInjected)
+
+		// The write was applied regardless.
+		data, etag := get(t, ctx, next)
+		require.Equal(t, "hello", data)
+
+		require.ErrorIs(t, p.(provider.Deleter).DeleteObject(ctx, "bucket", "key", etag), faulty.ErrInjected)
+
+		data, _ = get(t, ctx, next)
+		require.Empty(t, data)
+	})
+
+	t.Run("StaleReads", func(t *testing.T) {
+		p := faulty.NewProvider(mem.NewProvider(), faulty.WithStaleReads(1))
+		require.False(t, provider.CapabilitiesOf(p).StrongReadAfterWrite)
+
+		etag, err := put(ctx, p, "hello")
+		require.NoError(t, err)
+
+		// The preceding version is the missing object.
+		data, currentETag := get(t, ctx, p)
+		require.Empty(t, data)
+		require.Empty(t, currentETag)
+
+		_, err = put(ctx, p, "world")
+		require.NoError(t, err)
+
+		data, currentETag = get(t, ctx, p)
+		require.Equal(t, "hello", data)
+		require.Equal(t, etag, currentETag)
+
+		currentETag, err = p.(provider.Header).HeadObject(ctx, "bucket", "key")
+		require.NoError(t, err)
+		require.Equal(t, etag, currentETag)
+	})
+
+	t.Run("Latency", func(t *testing.T) {
+		p := faulty.NewProvider(mem.NewProvider(), faulty.WithLatency(1, time.Hour))
+
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+
+		_, err := put(ctx, p, "hello")
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("Seed", func(t *testing.T) {
+		outcomes := func() []bool {
+			p := faulty.NewProvider(mem.NewProvider(), faulty.WithErrors(0.5), faulty.WithSeed(42))
+
+			var outcomes []bool
+			for i := 0; i < 32; i++ {
+				_, err := put(ctx, p, "hello")
+				require.True(t, err == nil || errors.Is(err, faulty.ErrInjected))
+				outcomes = append(outcomes, err == nil)
+			}
+
+			return outcomes
+		}
+
+		first := outcomes()
+		require.Contains(t, first, true)
+		require.Contains(t, first, false)
+		require.Equal(t, first, outcomes())
+	})
+}
@@ -0,0 +1,294 @@
+//go:build fdb
+
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package fdb implements a FoundationDB provider.
+//
+// This package requires cgo and the FoundationDB client library, and is only
+// built with the "fdb" build tag.
+package fdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/subspace"
+	"github.com/apple/foundationdb/bindings/go/src/fdb/tuple"
+	"github.com/dpeckett/objsync/provider"
+	"github.com/dpeckett/objsync/stats"
+)
+
+// FoundationDB error codes that indicate a transaction conflicted with another
+// transaction, and can safely be retried.
+const (
+	errCodeTransactionTooOld = 1007
+	errCodeNotCommitted      = 1020
+)
+
+// Option is a functional option for configuring a FoundationDB provider.
+type Option func(*Provider)
+
+// WithSubspace sets the subspace that objects are stored in (defaults to
+// ("objsync")).
+func WithSubspace(ss subspace.Subspace) Option {
+	return func(p *Provider) {
+		p.subspace = ss
+	}
+}
+
+// WithStatsHandler sets a handler that receives an event for every
+// transaction made against the database.
+func WithStatsHandler(h stats.Handler) Option {
+	return func(p *Provider) {
+		p.statsHandler = h
+	}
+}
+
+// Provider is a FoundationDB provider. Objects are stored under the key
+// (bucket, key) in the provider's subspace, along with a version number that
+// is used as the ETag.
+type Provider struct {
+	db           fdb.Database
+	subspace     subspace.Subspace
+	statsHandler stats.Handler
+}
+
+// NewProvider initializes a new FoundationDB provider using the given
+// database (the caller is responsible for selecting the API version).
+func NewProvider(db fdb.Database, opts ...Option) provider.Provider {
+	p := &Provider{
+		db:       db,
+		subspace: subspace.Sub("objsync"),
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+func (p *Provider) AtomicUpdateObject(ctx context.Context, bucket, key string, fn provider.UpdateObjectFunc) (etag string, err error) {
+	start := time.Now()
+	defer func() {
+		p.emit(ctx, "Transaction", bucket, key, start, err)
+	}()
+
+	tr, err := p.createTransaction(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer tr.Cancel()
+
+	k := p.subspace.Pack(tuple.Tuple{bucket, key})
+
+	currentVersion, currentData, err := p.get(tr, k)
+	if err != nil {
+		return "", err
+	}
+
+	var currentETag string
+	if currentVersion != 0 {
+		currentETag = strconv.FormatInt(currentVersion, 10)
+	}
+
+	newData, err := fn(currentETag, currentData)
+	if err != nil {
+		return "", err
+	}
+
+	newVersion := currentVersion + 1
+	if currentVersion == 0 {
+		newVersion = provider.InitialVersion()
+	}
+
+	tr.Set(k, tuple.Tuple{newVersion, newData}.Pack())
+
+	if err := tr.Commit().Get(); err != nil {
+		return "", toProviderError(err)
+	}
+
+	return strconv.FormatInt(newVersion, 10), nil
+}
+
+func (p *Provider) DeleteObject(ctx context.Context, bucket, key, etag string) (err error) {
+	start := time.Now()
+	defer func() {
+		p.emit(ctx, "Transaction", bucket, key, start, err)
+	}()
+
+	tr, err := p.createTransaction(ctx)
+	if err != nil {
+		return err
+	}
+	defer tr.Cancel()
+
+	k := p.subspace.Pack(tuple.Tuple{bucket, key})
+
+	currentVersion, _, err := p.get(tr, k)
+	if err != nil {
+		return err
+	}
+
+	if currentVersion == 0 || strconv.FormatInt(currentVersion, 10) != etag {
+		return provider.ErrConflict
+	}
+
+	tr.Clear(k)
+
+	if err := tr.Commit().Get(); err != nil {
+		return toProviderError(err)
+	}
+
+	return nil
+}
+
+func (p *Provider) ListObjects(ctx context.Context, bucket, prefix string) (keys []string, err error) {
+	start := time.Now()
+	defer func() {
+		p.emit(ctx, "Transaction", bucket, prefix, start, err)
+	}()
+
+	tr, err := p.createTransaction(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tr.Cancel()
+
+	// Strip the terminator of the packed prefix, so that it matches any key
+	// that starts with the prefix.
+	begin := p.subspace.Pack(tuple.Tuple{bucket, prefix})
+	r, err := fdb.PrefixRange(begin[:len(begin)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	kvs, err := tr.Snapshot().GetRange(r, fdb.RangeOptions{Mode: fdb.StreamingModeWantAll}).GetSliceWithError()
+	if err != nil {
+		return nil, toProviderError(err)
+	}
+
+	for _, kv := range kvs {
+		t, err := p.subspace.Unpack(kv.Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unpack key: %w", err)
+		}
+
+		if len(t) != 2 {
+			return nil, fmt.Errorf("malformed key")
+		}
+
+		key, ok := t[1].(string)
+		if !ok {
+			return nil, fmt.Errorf("malformed key")
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// createTransaction creates a new transaction, that will time out when the
+// context's deadline is reached.
+func (p *Provider) createTransaction(ctx context.Context) (fdb.Transaction, error) {
+	if err := ctx.Err(); err != nil {
+		return fdb.Transaction{}, err
+	}
+
+	tr, err := p.db.CreateTransaction()
+	if err != nil {
+		return fdb.Transaction{}, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := tr.Options().SetTimeout(time.Until(deadline).Milliseconds()); err != nil {
+			tr.Cancel()
+			return fdb.Transaction{}, err
+		}
+	}
+
+	return tr, nil
+}
+
+// get reads the current version and data of an object, if the object does
+// not exist a version of zero is returned.
+func (p *Provider) get(tr fdb.Transaction, k fdb.Key) (int64, []byte, error) {
+	value, err := tr.Get(k).Get()
+	if err != nil {
+		return 0, nil, toProviderError(err)
+	}
+
+	if value == nil {
+		return 0, nil, nil
+	}
+
+	t, err := tuple.Unpack(value)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to unpack object: %w", err)
+	}
+
+	if len(t) != 2 {
+		return 0, nil, fmt.Errorf("malformed object")
+	}
+
+	version, ok := t[0].(int64)
+	if !ok {
+		return 0, nil, fmt.Errorf("malformed object version")
+	}
+
+	data, ok := t[1].([]byte)
+	if !ok {
+		return 0, nil, fmt.Errorf("malformed object data")
+	}
+
+	return version, data, nil
+}
+
+func (p *Provider) Capabilities() provider.Capabilities {
+	return provider.Capabilities{
+		ConditionalCreate:    true,
+		ConditionalDelete:    true,
+		StrongReadAfterWrite: true,
+	}
+}
+
+// emit emits a provider request event to the stats handler (if configured).
+func (p *Provider) emit(ctx context.Context, operation, bucket, key string, start time.Time, err error) {
+	if p.statsHandler == nil {
+		return
+	}
+
+	p.statsHandler.HandleEvent(ctx, stats.Event{
+		Type:      stats.EventProviderRequest,
+		Bucket:    bucket,
+		Key:       key,
+		Operation: operation,
+		Duration:  time.Since(start),
+		Err:       err,
+	})
+}
+
+// toProviderError converts transaction conflicts into provider.ErrConflict.
+func toProviderError(err error) error {
+	var fdbErr fdb.Error
+	if errors.As(err, &fdbErr) {
+		switch fdbErr.Code {
+		case errCodeTransactionTooOld, errCodeNotCommitted:
+			return provider.ErrConflict
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,257 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package firestore
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/dpeckett/objsync/provider"
+	"github.com/dpeckett/objsync/stats"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// The field that object data is stored in.
+const dataField = "data"
+
+// Option is a functional option for configuring a Firestore provider.
+type Option func(*Provider)
+
+// WithStatsHandler sets a handler that receives an event for every request
+// made to Firestore.
+func WithStatsHandler(h stats.Handler) Option {
+	return func(p *Provider) {
+		p.statsHandler = h
+	}
+}
+
+// Provider is a Firestore provider. Buckets correspond to collections, and
+// each object is stored as a document. Writes are conditioned on the update
+// time of the document (which is used as the ETag).
+type Provider struct {
+	client       *firestore.Client
+	statsHandler stats.Handler
+}
+
+// NewProvider initializes a new Firestore provider using the given client.
+func NewProvider(client *firestore.Client, opts ...Option) provider.Provider {
+	p := &Provider{
+		client: client,
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+func (p *Provider) AtomicUpdateObject(ctx context.Context, bucket, key string, fn provider.UpdateObjectFunc) (string, error) {
+	doc := p.doc(bucket, key)
+
+	start := time.Now()
+	snap, err := doc.Get(ctx)
+	p.emit(ctx, "Get", bucket, key, start, err)
+	if err != nil && status.Code(err) != codes.NotFound {
+		return "", err
+	}
+
+	var currentETag string
+	var currentData []byte
+	if snap.Exists() {
+		currentETag = updateTimeETag(snap.UpdateTime)
+
+		if v, err := snap.DataAt(dataField); err == nil {
+			currentData, _ = v.([]byte)
+		}
+	}
+
+	newData, err := fn(currentETag, currentData)
+	if err != nil {
+		return "", err
+	}
+
+	var result *firestore.WriteResult
+	start = time.Now()
+	if snap.Exists() {
+		result, err = doc.Update(ctx, []firestore.Update{{Path: dataField, Value: newData}},
+			firestore.LastUpdateTime(snap.UpdateTime))
+		p.emit(ctx, "Update", bucket, key, start, err)
+	} else {
+		result, err = doc.Create(ctx, map[string]any{dataField: newData})
+		p.emit(ctx, "Create", bucket, key, start, err)
+	}
+	if err != nil {
+		if isConflict(err) {
+			return "", provider.ErrConflict
+		}
+
+		return "", err
+	}
+
+	return updateTimeETag(result.UpdateTime), nil
+}
+
+func (p *Provider) DeleteObject(ctx context.Context, bucket, key, etag string) error {
+	updateTimeNanos, err := strconv.ParseInt(etag, 10, 64)
+	if err != nil {
+		return provider.ErrConflict
+	}
+
+	start := time.Now()
+	_, err = p.doc(bucket, key).Delete(ctx, firestore.LastUpdateTime(time.Unix(0, updateTimeNanos)))
+	p.emit(ctx, "Delete", bucket, key, start, err)
+	if err != nil {
+		if isConflict(err) {
+			return provider.ErrConflict
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+func (p *Provider) ListObjects(ctx context.Context, bucket, prefix string) ([]string, error) {
+	docIDPrefix := url.QueryEscape(prefix)
+
+	start := time.Now()
+	iter := p.client.Collection(bucket).
+		Select().
+		OrderBy(firestore.DocumentID, firestore.Asc).
+		StartAt(docIDPrefix).
+		Documents(ctx)
+	defer iter.Stop()
+
+	var keys []string
+	for {
+		snap, err := iter.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			p.emit(ctx, "List", bucket, prefix, start, err)
+			return nil, err
+		}
+
+		// Documents are returned in ID order, so we're past the prefix.
+		if !strings.HasPrefix(snap.Ref.ID, docIDPrefix) {
+			break
+		}
+
+		key, err := url.QueryUnescape(snap.Ref.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+	p.emit(ctx, "List", bucket, prefix, start, nil)
+
+	// Escaping doesn't preserve the order of keys.
+	slices.Sort(keys)
+
+	return keys, nil
+}
+
+// WatchObject watches an object for changes, using a Firestore snapshot
+// listener.
+func (p *Provider) WatchObject(ctx context.Context, bucket, key string) <-chan provider.Event {
+	events := make(chan provider.Event, 1)
+
+	go func() {
+		defer close(events)
+
+		iter := p.doc(bucket, key).Snapshots(ctx)
+		defer iter.Stop()
+
+		for {
+			snap, err := iter.Next()
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+
+				select {
+				case events <- provider.Event{Err: err}:
+				case <-ctx.Done():
+				}
+
+				return
+			}
+
+			var event provider.Event
+			if snap.Exists() {
+				event.ETag = updateTimeETag(snap.UpdateTime)
+			}
+
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return events
+}
+
+// doc returns a reference to the document for an object. Keys are escaped as
+// document IDs can't contain slashes.
+func (p *Provider) doc(bucket, key string) *firestore.DocumentRef {
+	return p.client.Collection(bucket).Doc(url.QueryEscape(key))
+}
+
+func (p *Provider) Capabilities() provider.Capabilities {
+	return provider.Capabilities{
+		ConditionalCreate:    true,
+		ConditionalDelete:    true,
+		Watch:                true,
+		StrongReadAfterWrite: true,
+	}
+}
+
+// emit emits a provider request event to the stats handler (if configured).
+func (p *Provider) emit(ctx context.Context, operation, bucket, key string, start time.Time, err error) {
+	if p.statsHandler == nil {
+		return
+	}
+
+	p.statsHandler.HandleEvent(ctx, stats.Event{
+		Type:      stats.EventProviderRequest,
+		Bucket:    bucket,
+		Key:       key,
+		Operation: operation,
+		Duration:  time.Since(start),
+		Err:       err,
+	})
+}
+
+func updateTimeETag(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// isConflict returns whether a write failed due to a precondition failing.
+func isConflict(err error) bool {
+	switch status.Code(err) {
+	case codes.AlreadyExists, codes.FailedPrecondition, codes.NotFound:
+		return true
+	default:
+		return false
+	}
+}
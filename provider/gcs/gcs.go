@@ -13,14 +13,22 @@ import (
 	"context"
 	"errors"
 	"io"
+	"net/url"
 	"strings"
+	"time"
 
 	"cloud.google.com/go/storage"
 	"github.com/dpeckett/objsync/provider"
+	"github.com/dpeckett/objsync/stats"
 	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 )
 
+func init() {
+	provider.Register("gcs", open)
+}
+
 // Option is a functional option for configuring a GCS provider.
 type Option func(context.Context, *Provider) error
 
@@ -36,14 +44,54 @@ func WithCredentialsFile(ctx context.Context, credentialsFile string) Option {
 	}
 }
 
+// WithCacheControl sets the Cache-Control metadata of lock objects (eg. "no-store").
+func WithCacheControl(cacheControl string) Option {
+	return func(_ context.Context, p *Provider) error {
+		p.cacheControl = cacheControl
+		return nil
+	}
+}
+
+// WithContentType sets the Content-Type of lock objects (defaults to
+// "application/json").
+func WithContentType(contentType string) Option {
+	return func(_ context.Context, p *Provider) error {
+		p.contentType = contentType
+		return nil
+	}
+}
+
+// WithStorageClass sets the storage class of lock objects (eg. "STANDARD").
+func WithStorageClass(storageClass string) Option {
+	return func(_ context.Context, p *Provider) error {
+		p.storageClass = storageClass
+		return nil
+	}
+}
+
+// WithStatsHandler sets a handler that receives an event for every request
+// made to the object store.
+func WithStatsHandler(h stats.Handler) Option {
+	return func(_ context.Context, p *Provider) error {
+		p.statsHandler = h
+		return nil
+	}
+}
+
 // Provider is a GCS provider.
 type Provider struct {
-	client *storage.Client
+	client       *storage.Client
+	statsHandler stats.Handler
+	cacheControl string
+	contentType  string
+	storageClass string
 }
 
 // NewProvider initializes a new GCS provider.
 func NewProvider(ctx context.Context, opts ...Option) (provider.Provider, error) {
-	p := &Provider{}
+	p := &Provider{
+		contentType: "application/json",
+	}
 
 	for _, opt := range opts {
 		if err := opt(ctx, p); err != nil {
@@ -63,11 +111,25 @@ func NewProvider(ctx context.Context, opts ...Option) (provider.Provider, error)
 	return p, nil
 }
 
+// open opens a GCS provider from a URL, eg.
+// "gcs://bucket?credentialsFile=/path/to/key.json". Without a credentials
+// file, the application default credentials are used.
+func open(ctx context.Context, u *url.URL) (provider.Provider, error) {
+	var opts []Option
+	if credentialsFile := u.Query().Get("credentialsFile"); credentialsFile != "" {
+		opts = append(opts, WithCredentialsFile(ctx, credentialsFile))
+	}
+
+	return NewProvider(ctx, opts...)
+}
+
 func (p *Provider) AtomicUpdateObject(ctx context.Context, bucket, key string, fn provider.UpdateObjectFunc) (string, error) {
 	bkt := p.client.Bucket(bucket)
 
 	obj := bkt.Object(key)
+	start := time.Now()
 	attrs, err := obj.Attrs(ctx)
+	p.emit(ctx, "GetObjectAttrs", bucket, key, start, err)
 	if err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
 		return "", err
 	}
@@ -82,7 +144,9 @@ func (p *Provider) AtomicUpdateObject(ctx context.Context, bucket, key string, f
 		currentETag = strings.Trim(attrs.Etag, "\"")
 	}
 
+	start = time.Now()
 	reader, err := obj.NewReader(ctx)
+	p.emit(ctx, "GetObject", bucket, key, start, err)
 	if err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
 		return "", err
 	}
@@ -102,11 +166,18 @@ func (p *Provider) AtomicUpdateObject(ctx context.Context, bucket, key string, f
 	}
 
 	writer := obj.If(storage.Conditions{GenerationMatch: currentGeneration}).NewWriter(ctx)
+	writer.ContentType = p.contentType
+	writer.CacheControl = p.cacheControl
+	writer.StorageClass = p.storageClass
+
+	start = time.Now()
 	if _, err := writer.Write(newData); err != nil {
 		return "", err
 	}
 
-	if err := writer.Close(); err != nil {
+	err = writer.Close()
+	p.emit(ctx, "PutObject", bucket, key, start, err)
+	if err != nil {
 		var apiErr *googleapi.Error
 		if errors.As(err, &apiErr) && apiErr.Code == 412 {
 			return "", provider.ErrConflict
@@ -115,10 +186,109 @@ func (p *Provider) AtomicUpdateObject(ctx context.Context, bucket, key string, f
 		return "", err
 	}
 
+	start = time.Now()
 	newAttrs, err := obj.Attrs(ctx)
+	p.emit(ctx, "GetObjectAttrs", bucket, key, start, err)
 	if err != nil {
 		return "", err
 	}
 
 	return strings.Trim(newAttrs.Etag, "\""), nil
 }
+
+func (p *Provider) GetObject(ctx context.Context, bucket, key string) ([]byte, string, error) {
+	obj := p.client.Bucket(bucket).Object(key)
+
+	start := time.Now()
+	reader, err := obj.NewReader(ctx)
+	p.emit(ctx, "GetObject", bucket, key, start, err)
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return nil, "", nil
+		}
+
+		return nil, "", err
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// The reader doesn't expose the ETag, so read it (for the same generation)
+	// from the object's attributes.
+	start = time.Now()
+	attrs, err := obj.Generation(reader.Attrs.Generation).Attrs(ctx)
+	p.emit(ctx, "GetObjectAttrs", bucket, key, start, err)
+	if err != nil {
+		// Replaced in the meantime, report the read as a conflict.
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return nil, "", provider.ErrConflict
+		}
+
+		return nil, "", err
+	}
+
+	return data, strings.Trim(attrs.Etag, "\""), nil
+}
+
+func (p *Provider) HeadObject(ctx context.Context, bucket, key string) (string, error) {
+	start := time.Now()
+	attrs, err := p.client.Bucket(bucket).Object(key).Attrs(ctx)
+	p.emit(ctx, "GetObjectAttrs", bucket, key, start, err)
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return "", nil
+		}
+
+		return "", err
+	}
+
+	return strings.Trim(attrs.Etag, "\""), nil
+}
+
+func (p *Provider) ListObjects(ctx context.Context, bucket, prefix string) ([]string, error) {
+	var keys []string
+
+	start := time.Now()
+	it := p.client.Bucket(bucket).Objects(ctx, &storage.Query{Prefix: prefix})
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			p.emit(ctx, "ListObjects", bucket, prefix, start, err)
+			return nil, err
+		}
+
+		keys = append(keys, attrs.Name)
+	}
+	p.emit(ctx, "ListObjects", bucket, prefix, start, nil)
+
+	return keys, nil
+}
+
+func (p *Provider) Capabilities() provider.Capabilities {
+	return provider.Capabilities{
+		ConditionalCreate:    true,
+		StrongReadAfterWrite: true,
+	}
+}
+
+// emit emits a provider request event to the stats handler (if configured).
+func (p *Provider) emit(ctx context.Context, operation, bucket, key string, start time.Time, err error) {
+	if p.statsHandler == nil {
+		return
+	}
+
+	p.statsHandler.HandleEvent(ctx, stats.Event{
+		Type:      stats.EventProviderRequest,
+		Bucket:    bucket,
+		Key:       key,
+		Operation: operation,
+		Duration:  time.Since(start),
+		Err:       err,
+	})
+}
@@ -0,0 +1,145 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package grpcproxy implements a provider that proxies requests to an
+// objsyncd server over gRPC, along with the server side of the protocol.
+package grpcproxy
+
+import (
+	"context"
+	"time"
+
+	"github.com/dpeckett/objsync/provider"
+	"github.com/dpeckett/objsync/stats"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Option is a functional option for configuring a gRPC proxy provider.
+type Option func(*Provider)
+
+// WithStatsHandler sets a handler that receives an event for every request
+// made to the proxy server.
+func WithStatsHandler(h stats.Handler) Option {
+	return func(p *Provider) {
+		p.statsHandler = h
+	}
+}
+
+// Provider is a provider that proxies requests to an objsyncd server.
+type Provider struct {
+	conn         grpc.ClientConnInterface
+	statsHandler stats.Handler
+}
+
+// NewProvider initializes a new gRPC proxy provider using the given client
+// connection.
+func NewProvider(conn grpc.ClientConnInterface, opts ...Option) provider.Provider {
+	p := &Provider{
+		conn: conn,
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+func (p *Provider) AtomicUpdateObject(ctx context.Context, bucket, key string, fn provider.UpdateObjectFunc) (string, error) {
+	var getResp GetObjectResponse
+	start := time.Now()
+	err := p.conn.Invoke(ctx, getObjectMethod, &GetObjectRequest{
+		Bucket: bucket,
+		Key:    key,
+	}, &getResp, grpc.CallContentSubtype(jsonCodec{}.Name()))
+	p.emit(ctx, "GetObject", bucket, key, start, err)
+	if err != nil {
+		return "", fromStatus(err)
+	}
+
+	newData, err := fn(getResp.ETag, getResp.Data)
+	if err != nil {
+		return "", err
+	}
+
+	var putResp PutObjectResponse
+	start = time.Now()
+	err = p.conn.Invoke(ctx, putObjectMethod, &PutObjectRequest{
+		Bucket:  bucket,
+		Key:     key,
+		IfMatch: getResp.ETag,
+		Data:    newData,
+	}, &putResp, grpc.CallContentSubtype(jsonCodec{}.Name()))
+	p.emit(ctx, "PutObject", bucket, key, start, err)
+	if err != nil {
+		return "", fromStatus(err)
+	}
+
+	return putResp.ETag, nil
+}
+
+func (p *Provider) DeleteObject(ctx context.Context, bucket, key, etag string) error {
+	start := time.Now()
+	err := p.conn.Invoke(ctx, deleteObjectMethod, &DeleteObjectRequest{
+		Bucket:  bucket,
+		Key:     key,
+		IfMatch: etag,
+	}, &DeleteObjectResponse{}, grpc.CallContentSubtype(jsonCodec{}.Name()))
+	p.emit(ctx, "DeleteObject", bucket, key, start, err)
+	if err != nil {
+		return fromStatus(err)
+	}
+
+	return nil
+}
+
+func (p *Provider) ListObjects(ctx context.Context, bucket, prefix string) ([]string, error) {
+	var resp ListObjectsResponse
+	start := time.Now()
+	err := p.conn.Invoke(ctx, listObjectsMethod, &ListObjectsRequest{
+		Bucket: bucket,
+		Prefix: prefix,
+	}, &resp, grpc.CallContentSubtype(jsonCodec{}.Name()))
+	p.emit(ctx, "ListObjects", bucket, prefix, start, err)
+	if err != nil {
+		return nil, fromStatus(err)
+	}
+
+	return resp.Keys, nil
+}
+
+// emit emits a provider request event to the stats handler (if configured).
+func (p *Provider) emit(ctx context.Context, operation, bucket, key string, start time.Time, err error) {
+	if p.statsHandler == nil {
+		return
+	}
+
+	p.statsHandler.HandleEvent(ctx, stats.Event{
+		Type:      stats.EventProviderRequest,
+		Bucket:    bucket,
+		Key:       key,
+		Operation: operation,
+		Duration:  time.Since(start),
+		Err:       err,
+	})
+}
+
+// fromStatus converts a gRPC status error back into a provider error.
+func fromStatus(err error) error {
+	switch status.Code(err) {
+	case codes.Aborted:
+		return provider.ErrConflict
+	case codes.Unimplemented:
+		return provider.ErrNotSupported
+	default:
+		return err
+	}
+}
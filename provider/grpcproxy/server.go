@@ -0,0 +1,152 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package grpcproxy
+
+import (
+	"context"
+	"errors"
+
+	"github.com/dpeckett/objsync/provider"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// errReadOnly is used to abort the update when only reading an object.
+var errReadOnly = errors.New("read only")
+
+// ServerOption is a functional option for configuring a proxy server.
+type ServerOption func(*Server)
+
+// WithAllowedBuckets restricts clients to the given buckets, by default all
+// buckets the underlying provider has access to are allowed.
+func WithAllowedBuckets(buckets ...string) ServerOption {
+	return func(s *Server) {
+		s.allowedBuckets = make(map[string]bool, len(buckets))
+		for _, bucket := range buckets {
+			s.allowedBuckets[bucket] = true
+		}
+	}
+}
+
+// Server is a gRPC server that fronts a provider, so that clients can use
+// objsync without holding storage credentials.
+type Server struct {
+	provider       provider.Provider
+	allowedBuckets map[string]bool
+}
+
+// NewServer creates a new proxy server for the given provider.
+func NewServer(p provider.Provider, opts ...ServerOption) *Server {
+	s := &Server{
+		provider: p,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+func (s *Server) GetObject(ctx context.Context, req *GetObjectRequest) (*GetObjectResponse, error) {
+	if err := s.checkBucket(req.Bucket); err != nil {
+		return nil, err
+	}
+
+	var resp GetObjectResponse
+	_, err := s.provider.AtomicUpdateObject(ctx, req.Bucket, req.Key, func(etag string, data []byte) ([]byte, error) {
+		resp.ETag = etag
+		resp.Data = data
+		return nil, errReadOnly
+	})
+	if err != nil && !errors.Is(err, errReadOnly) {
+		return nil, toStatus(err)
+	}
+
+	return &resp, nil
+}
+
+func (s *Server) PutObject(ctx context.Context, req *PutObjectRequest) (*PutObjectResponse, error) {
+	if err := s.checkBucket(req.Bucket); err != nil {
+		return nil, err
+	}
+
+	etag, err := s.provider.AtomicUpdateObject(ctx, req.Bucket, req.Key, func(etag string, _ []byte) ([]byte, error) {
+		if etag != req.IfMatch {
+			return nil, provider.ErrConflict
+		}
+
+		return req.Data, nil
+	})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &PutObjectResponse{ETag: etag}, nil
+}
+
+func (s *Server) DeleteObject(ctx context.Context, req *DeleteObjectRequest) (*DeleteObjectResponse, error) {
+	if err := s.checkBucket(req.Bucket); err != nil {
+		return nil, err
+	}
+
+	deleter, ok := s.provider.(provider.Deleter)
+	if !ok {
+		return nil, toStatus(provider.ErrNotSupported)
+	}
+
+	if err := deleter.DeleteObject(ctx, req.Bucket, req.Key, req.IfMatch); err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &DeleteObjectResponse{}, nil
+}
+
+func (s *Server) ListObjects(ctx context.Context, req *ListObjectsRequest) (*ListObjectsResponse, error) {
+	if err := s.checkBucket(req.Bucket); err != nil {
+		return nil, err
+	}
+
+	lister, ok := s.provider.(provider.Lister)
+	if !ok {
+		return nil, toStatus(provider.ErrNotSupported)
+	}
+
+	keys, err := lister.ListObjects(ctx, req.Bucket, req.Prefix)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &ListObjectsResponse{Keys: keys}, nil
+}
+
+func (s *Server) checkBucket(bucket string) error {
+	if s.allowedBuckets != nil && !s.allowedBuckets[bucket] {
+		return status.Errorf(codes.PermissionDenied, "access to bucket %q is not allowed", bucket)
+	}
+
+	return nil
+}
+
+// toStatus converts a provider error into a gRPC status error.
+func toStatus(err error) error {
+	switch {
+	case errors.Is(err, provider.ErrConflict):
+		return status.Error(codes.Aborted, err.Error())
+	case errors.Is(err, provider.ErrNotSupported):
+		return status.Error(codes.Unimplemented, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	default:
+		return status.Error(codes.Unknown, err.Error())
+	}
+}
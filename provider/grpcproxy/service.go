@@ -0,0 +1,166 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package grpcproxy
+
+import (
+	"context"
+	"encoding/json"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+)
+
+// ServiceName is the fully qualified name of the proxy gRPC service.
+const ServiceName = "objsync.v1.Provider"
+
+const (
+	getObjectMethod    = "/" + ServiceName + "/GetObject"
+	putObjectMethod    = "/" + ServiceName + "/PutObject"
+	deleteObjectMethod = "/" + ServiceName + "/DeleteObject"
+	listObjectsMethod  = "/" + ServiceName + "/ListObjects"
+)
+
+// GetObjectRequest requests the current contents of an object.
+type GetObjectRequest struct {
+	Bucket string `json:"bucket"`
+	Key    string `json:"key"`
+}
+
+// GetObjectResponse contains the current contents of an object, an empty
+// ETag indicates that the object does not exist.
+type GetObjectResponse struct {
+	ETag string `json:"etag,omitempty"`
+	Data []byte `json:"data,omitempty"`
+}
+
+// PutObjectRequest requests that an object is overwritten, but only if its
+// current ETag matches IfMatch (an empty IfMatch means the object must not
+// exist).
+type PutObjectRequest struct {
+	Bucket  string `json:"bucket"`
+	Key     string `json:"key"`
+	IfMatch string `json:"ifMatch,omitempty"`
+	Data    []byte `json:"data"`
+}
+
+// PutObjectResponse contains the ETag of the newly written object.
+type PutObjectResponse struct {
+	ETag string `json:"etag"`
+}
+
+// DeleteObjectRequest requests that an object is deleted, but only if its
+// current ETag matches IfMatch.
+type DeleteObjectRequest struct {
+	Bucket  string `json:"bucket"`
+	Key     string `json:"key"`
+	IfMatch string `json:"ifMatch"`
+}
+
+// DeleteObjectResponse is the (empty) response to a delete request.
+type DeleteObjectResponse struct{}
+
+// ListObjectsRequest requests the keys of the objects in a bucket that start
+// with the given prefix.
+type ListObjectsRequest struct {
+	Bucket string `json:"bucket"`
+	Prefix string `json:"prefix,omitempty"`
+}
+
+// ListObjectsResponse contains the keys of the listed objects, in
+// lexicographic order.
+type ListObjectsResponse struct {
+	Keys []string `json:"keys,omitempty"`
+}
+
+// ProviderServer is the server API for the proxy service.
+type ProviderServer interface {
+	GetObject(context.Context, *GetObjectRequest) (*GetObjectResponse, error)
+	PutObject(context.Context, *PutObjectRequest) (*PutObjectResponse, error)
+	DeleteObject(context.Context, *DeleteObjectRequest) (*DeleteObjectResponse, error)
+	ListObjects(context.Context, *ListObjectsRequest) (*ListObjectsResponse, error)
+}
+
+// RegisterProviderServer registers the proxy service with a gRPC server.
+func RegisterProviderServer(s grpc.ServiceRegistrar, srv ProviderServer) {
+	s.RegisterService(&serviceDesc, srv)
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*ProviderServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetObject",
+			Handler: func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+				return handleUnary(srv, ctx, dec, interceptor, getObjectMethod, ProviderServer.GetObject)
+			},
+		},
+		{
+			MethodName: "PutObject",
+			Handler: func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+				return handleUnary(srv, ctx, dec, interceptor, putObjectMethod, ProviderServer.PutObject)
+			},
+		},
+		{
+			MethodName: "DeleteObject",
+			Handler: func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+				return handleUnary(srv, ctx, dec, interceptor, deleteObjectMethod, ProviderServer.DeleteObject)
+			},
+		},
+		{
+			MethodName: "ListObjects",
+			Handler: func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+				return handleUnary(srv, ctx, dec, interceptor, listObjectsMethod, ProviderServer.ListObjects)
+			},
+		},
+	},
+	Streams: []grpc.StreamDesc{},
+}
+
+func handleUnary[Req, Resp any](srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor,
+	fullMethod string, method func(ProviderServer, context.Context, *Req) (*Resp, error)) (any, error) {
+	req := new(Req)
+	if err := dec(req); err != nil {
+		return nil, err
+	}
+
+	if interceptor == nil {
+		return method(srv.(ProviderServer), ctx, req)
+	}
+
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: fullMethod,
+	}
+
+	return interceptor(ctx, req, info, func(ctx context.Context, req any) (any, error) {
+		return method(srv.(ProviderServer), ctx, req.(*Req))
+	})
+}
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+// jsonCodec is a gRPC codec that marshals messages as JSON, the proxy service
+// is small enough that it isn't worth generating protobuf bindings for.
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
@@ -0,0 +1,221 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package http implements a provider that talks to a HTTP lock service, along
+// with a reference implementation of the service itself. This is useful in
+// environments where only HTTPS egress is allowed.
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	nethttp "net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dpeckett/objsync/provider"
+	"github.com/dpeckett/objsync/stats"
+)
+
+// Option is a functional option for configuring a HTTP provider.
+type Option func(*Provider)
+
+// WithHTTPClient sets the HTTP client used to make requests (defaults to
+// http.DefaultClient).
+func WithHTTPClient(client *nethttp.Client) Option {
+	return func(p *Provider) {
+		p.client = client
+	}
+}
+
+// WithStatsHandler sets a handler that receives an event for every request
+// made to the lock service.
+func WithStatsHandler(h stats.Handler) Option {
+	return func(p *Provider) {
+		p.statsHandler = h
+	}
+}
+
+// Provider is a provider that talks to a HTTP lock service.
+type Provider struct {
+	baseURL      string
+	client       *nethttp.Client
+	statsHandler stats.Handler
+}
+
+// NewProvider initializes a new HTTP provider for the lock service at the
+// given base URL.
+func NewProvider(baseURL string, opts ...Option) provider.Provider {
+	p := &Provider{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client:  nethttp.DefaultClient,
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+func (p *Provider) AtomicUpdateObject(ctx context.Context, bucket, key string, fn provider.UpdateObjectFunc) (string, error) {
+	req, err := nethttp.NewRequestWithContext(ctx, nethttp.MethodGet, p.objectURL(bucket, key), nil)
+	if err != nil {
+		return "", err
+	}
+
+	start := time.Now()
+	resp, err := p.client.Do(req)
+	if err != nil {
+		p.emit(ctx, "GetObject", bucket, key, start, err)
+		return "", err
+	}
+
+	var currentETag string
+	var currentData []byte
+	switch resp.StatusCode {
+	case nethttp.StatusOK:
+		currentETag = unquoteETag(resp.Header.Get("ETag"))
+		currentData, err = io.ReadAll(resp.Body)
+	case nethttp.StatusNotFound:
+	default:
+		err = responseError(resp)
+	}
+	_ = resp.Body.Close()
+	p.emit(ctx, "GetObject", bucket, key, start, err)
+	if err != nil {
+		return "", err
+	}
+
+	newData, err := fn(currentETag, currentData)
+	if err != nil {
+		return "", err
+	}
+
+	req, err = nethttp.NewRequestWithContext(ctx, nethttp.MethodPut, p.objectURL(bucket, key), bytes.NewReader(newData))
+	if err != nil {
+		return "", err
+	}
+
+	if currentETag != "" {
+		req.Header.Set("If-Match", quoteETag(currentETag))
+	} else {
+		req.Header.Set("If-None-Match", "*")
+	}
+
+	start = time.Now()
+	resp, err = p.client.Do(req)
+	if err != nil {
+		p.emit(ctx, "PutObject", bucket, key, start, err)
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		err = responseError(resp)
+	}
+	p.emit(ctx, "PutObject", bucket, key, start, err)
+	if err != nil {
+		return "", err
+	}
+
+	return unquoteETag(resp.Header.Get("ETag")), nil
+}
+
+func (p *Provider) DeleteObject(ctx context.Context, bucket, key, etag string) error {
+	req, err := nethttp.NewRequestWithContext(ctx, nethttp.MethodDelete, p.objectURL(bucket, key), nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("If-Match", quoteETag(etag))
+
+	start := time.Now()
+	resp, err := p.client.Do(req)
+	if err != nil {
+		p.emit(ctx, "DeleteObject", bucket, key, start, err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		err = responseError(resp)
+	}
+	p.emit(ctx, "DeleteObject", bucket, key, start, err)
+
+	return err
+}
+
+func (p *Provider) ListObjects(ctx context.Context, bucket, prefix string) ([]string, error) {
+	listURL := p.baseURL + "/" + url.PathEscape(bucket) + "?" + url.Values{"prefix": {prefix}}.Encode()
+
+	req, err := nethttp.NewRequestWithContext(ctx, nethttp.MethodGet, listURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	resp, err := p.client.Do(req)
+	if err != nil {
+		p.emit(ctx, "ListObjects", bucket, prefix, start, err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var listResp listObjectsResponse
+	if resp.StatusCode == nethttp.StatusOK {
+		err = json.NewDecoder(resp.Body).Decode(&listResp)
+	} else {
+		err = responseError(resp)
+	}
+	p.emit(ctx, "ListObjects", bucket, prefix, start, err)
+	if err != nil {
+		return nil, err
+	}
+
+	return listResp.Keys, nil
+}
+
+func (p *Provider) objectURL(bucket, key string) string {
+	return p.baseURL + "/" + url.PathEscape(bucket) + "/" + url.PathEscape(key)
+}
+
+// emit emits a provider request event to the stats handler (if configured).
+func (p *Provider) emit(ctx context.Context, operation, bucket, key string, start time.Time, err error) {
+	if p.statsHandler == nil {
+		return
+	}
+
+	p.statsHandler.HandleEvent(ctx, stats.Event{
+		Type:      stats.EventProviderRequest,
+		Bucket:    bucket,
+		Key:       key,
+		Operation: operation,
+		Duration:  time.Since(start),
+		Err:       err,
+	})
+}
+
+// responseError converts an unsuccessful response into an error.
+func responseError(resp *nethttp.Response) error {
+	switch resp.StatusCode {
+	case nethttp.StatusPreconditionFailed:
+		return provider.ErrConflict
+	case nethttp.StatusNotImplemented:
+		return provider.ErrNotSupported
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+
+	return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+}
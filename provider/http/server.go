@@ -0,0 +1,234 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	nethttp "net/http"
+	"strings"
+
+	"github.com/dpeckett/objsync/provider"
+)
+
+// errReadOnly is used to abort the update when only reading an object.
+var errReadOnly = errors.New("read only")
+
+// The JSON response to a list request.
+type listObjectsResponse struct {
+	Keys []string `json:"keys"`
+}
+
+// maxObjectSize is the maximum size of an object that can be written.
+const maxObjectSize = 1 << 20
+
+// HandlerOption is a functional option for configuring a HTTP lock service
+// handler.
+type HandlerOption func(*handler)
+
+// WithAllowedBuckets restricts clients to the given buckets, by default all
+// buckets the underlying provider has access to are allowed.
+func WithAllowedBuckets(buckets ...string) HandlerOption {
+	return func(h *handler) {
+		h.allowedBuckets = make(map[string]bool, len(buckets))
+		for _, bucket := range buckets {
+			h.allowedBuckets[bucket] = true
+		}
+	}
+}
+
+type handler struct {
+	provider       provider.Provider
+	allowedBuckets map[string]bool
+}
+
+// NewHandler returns a HTTP handler that exposes a provider over REST.
+//
+// Objects are addressed as /{bucket}/{key}. GET returns the object, along
+// with its ETag. PUT writes the object, but only if the If-Match header
+// matches its current ETag (or If-None-Match is "*" and the object doesn't
+// exist). DELETE deletes the object, but only if the If-Match header matches
+// its current ETag. Failed preconditions are reported with a 412 status.
+//
+// GET /{bucket}?prefix={prefix} lists the keys of the objects in a bucket that
+// start with the prefix, as a JSON object of the form {"keys": [...]}.
+func NewHandler(p provider.Provider, opts ...HandlerOption) nethttp.Handler {
+	h := &handler{
+		provider: p,
+	}
+
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	mux := nethttp.NewServeMux()
+	mux.HandleFunc("GET /{bucket}", h.listObjects)
+	mux.HandleFunc("GET /{bucket}/{key...}", h.getObject)
+	mux.HandleFunc("PUT /{bucket}/{key...}", h.putObject)
+	mux.HandleFunc("DELETE /{bucket}/{key...}", h.deleteObject)
+
+	return mux
+}
+
+func (h *handler) getObject(w nethttp.ResponseWriter, r *nethttp.Request) {
+	bucket, key, ok := h.objectPath(w, r)
+	if !ok {
+		return
+	}
+
+	var currentETag string
+	var currentData []byte
+	_, err := h.provider.AtomicUpdateObject(r.Context(), bucket, key, func(etag string, data []byte) ([]byte, error) {
+		currentETag = etag
+		currentData = data
+		return nil, errReadOnly
+	})
+	if err != nil && !errors.Is(err, errReadOnly) {
+		writeError(w, err)
+		return
+	}
+
+	if currentETag == "" {
+		nethttp.Error(w, "object not found", nethttp.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("ETag", quoteETag(currentETag))
+	_, _ = w.Write(currentData)
+}
+
+func (h *handler) putObject(w nethttp.ResponseWriter, r *nethttp.Request) {
+	bucket, key, ok := h.objectPath(w, r)
+	if !ok {
+		return
+	}
+
+	var ifMatch string
+	if r.Header.Get("If-None-Match") != "*" {
+		ifMatch = unquoteETag(r.Header.Get("If-Match"))
+		if ifMatch == "" {
+			nethttp.Error(w, "either If-Match or If-None-Match is required", nethttp.StatusPreconditionRequired)
+			return
+		}
+	}
+
+	data, err := io.ReadAll(nethttp.MaxBytesReader(w, r.Body, maxObjectSize))
+	if err != nil {
+		nethttp.Error(w, err.Error(), nethttp.StatusRequestEntityTooLarge)
+		return
+	}
+
+	etag, err := h.provider.AtomicUpdateObject(r.Context(), bucket, key, func(etag string, _ []byte) ([]byte, error) {
+		if etag != ifMatch {
+			return nil, provider.ErrConflict
+		}
+
+		return data, nil
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("ETag", quoteETag(etag))
+	w.WriteHeader(nethttp.StatusNoContent)
+}
+
+func (h *handler) deleteObject(w nethttp.ResponseWriter, r *nethttp.Request) {
+	bucket, key, ok := h.objectPath(w, r)
+	if !ok {
+		return
+	}
+
+	ifMatch := unquoteETag(r.Header.Get("If-Match"))
+	if ifMatch == "" {
+		nethttp.Error(w, "If-Match is required", nethttp.StatusPreconditionRequired)
+		return
+	}
+
+	deleter, ok := h.provider.(provider.Deleter)
+	if !ok {
+		writeError(w, provider.ErrNotSupported)
+		return
+	}
+
+	if err := deleter.DeleteObject(r.Context(), bucket, key, ifMatch); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(nethttp.StatusNoContent)
+}
+
+func (h *handler) listObjects(w nethttp.ResponseWriter, r *nethttp.Request) {
+	bucket := r.PathValue("bucket")
+	if h.allowedBuckets != nil && !h.allowedBuckets[bucket] {
+		nethttp.Error(w, "access to bucket is not allowed", nethttp.StatusForbidden)
+		return
+	}
+
+	lister, ok := h.provider.(provider.Lister)
+	if !ok {
+		writeError(w, provider.ErrNotSupported)
+		return
+	}
+
+	keys, err := lister.ListObjects(r.Context(), bucket, r.URL.Query().Get("prefix"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(listObjectsResponse{Keys: keys})
+}
+
+func (h *handler) objectPath(w nethttp.ResponseWriter, r *nethttp.Request) (string, string, bool) {
+	bucket, key := r.PathValue("bucket"), r.PathValue("key")
+	if key == "" {
+		nethttp.Error(w, "object key is required", nethttp.StatusBadRequest)
+		return "", "", false
+	}
+
+	if h.allowedBuckets != nil && !h.allowedBuckets[bucket] {
+		nethttp.Error(w, "access to bucket is not allowed", nethttp.StatusForbidden)
+		return "", "", false
+	}
+
+	return bucket, key, true
+}
+
+// writeError writes a provider error as a HTTP error response.
+func writeError(w nethttp.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, provider.ErrConflict):
+		nethttp.Error(w, err.Error(), nethttp.StatusPreconditionFailed)
+	case errors.Is(err, provider.ErrNotSupported):
+		nethttp.Error(w, err.Error(), nethttp.StatusNotImplemented)
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		nethttp.Error(w, err.Error(), nethttp.StatusServiceUnavailable)
+	default:
+		slog.Warn("Provider request failed", slog.Any("error", err))
+
+		nethttp.Error(w, "internal server error", nethttp.StatusInternalServerError)
+	}
+}
+
+func quoteETag(etag string) string {
+	return `"` + etag + `"`
+}
+
+func unquoteETag(etag string) string {
+	return strings.Trim(strings.TrimPrefix(etag, "W/"), `"`)
+}
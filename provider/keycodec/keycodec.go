@@ -0,0 +1,314 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package keycodec
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/dpeckett/objsync/provider"
+)
+
+// ErrInvalidKey is returned (wrapped in an *InvalidKeyError) when a key can't
+// be used as an object key.
+var ErrInvalidKey = errors.New("invalid key")
+
+// InvalidKeyError describes why a key can't be used as an object key.
+type InvalidKeyError struct {
+	Key    string
+	Reason string
+}
+
+func (e *InvalidKeyError) Error() string {
+	return fmt.Sprintf("invalid key %q: %s", e.Key, e.Reason)
+}
+
+func (e *InvalidKeyError) Unwrap() error {
+	return ErrInvalidKey
+}
+
+// Codec maps the keys used by synchronization primitives to object keys.
+type Codec interface {
+	EncodeKey(key string) (string, error)
+}
+
+// The default maximum length of object keys (in bytes), this is the limit
+// imposed by both S3 and GCS.
+const DefaultMaxLength = 1024
+
+// Characters that are either disallowed, or known to cause problems, in
+// object keys on common providers.
+const unsafeChars = "\\{}^%`[]\"<>~#|"
+
+// The character that sanitized keys use to escape unsafe characters (it's
+// escaped itself, so that sanitizing is reversible).
+const escapeChar = '!'
+
+// Standard is a configurable key codec that enforces the character and length
+// limits common to object storage providers.
+type Standard struct {
+	// Prefix is prepended to all keys (eg. "team-a/locks/").
+	Prefix string
+	// Sanitize escapes unsafe characters, rather than rejecting the key. Each
+	// byte of an unsafe character is replaced by '!' and its hex value (eg.
+	// "a#b" becomes "a!23b"), and '!' is escaped too, so distinct keys never
+	// map to the same object key.
+	Sanitize bool
+	// HashLongKeys replaces the tail of keys longer than MaxLength with a hash,
+	// rather than rejecting the key.
+	HashLongKeys bool
+	// MaxLength is the maximum length of object keys in bytes. Defaults to
+	// DefaultMaxLength.
+	MaxLength int
+}
+
+func (c *Standard) EncodeKey(key string) (string, error) {
+	if key == "" {
+		return "", &InvalidKeyError{Key: key, Reason: "key is empty"}
+	}
+
+	if !utf8.ValidString(key) {
+		return "", &InvalidKeyError{Key: key, Reason: "key is not valid UTF-8"}
+	}
+
+	if !c.Sanitize {
+		for _, r := range key {
+			if isUnsafe(r) {
+				return "", &InvalidKeyError{Key: key, Reason: fmt.Sprintf("key contains unsafe character %q", r)}
+			}
+		}
+	}
+
+	encodedKey := c.Prefix + c.escape(key)
+
+	// Disallowed by GCS.
+	if encodedKey == "." || encodedKey == ".." {
+		return "", &InvalidKeyError{Key: key, Reason: "key is a relative path"}
+	}
+
+	maxLength := c.MaxLength
+	if maxLength == 0 {
+		maxLength = DefaultMaxLength
+	}
+
+	if len(encodedKey) > maxLength {
+		if !c.HashLongKeys {
+			return "", &InvalidKeyError{Key: key, Reason: fmt.Sprintf("key is longer than %d bytes", maxLength)}
+		}
+
+		sum := sha256.Sum256([]byte(encodedKey))
+		hash := hex.EncodeToString(sum[:])
+
+		if len(hash)+1 > maxLength {
+			return "", &InvalidKeyError{Key: key, Reason: fmt.Sprintf("maximum key length of %d bytes is too short to hash key", maxLength)}
+		}
+
+		// Truncate on a rune boundary.
+		truncatedKey := encodedKey[:maxLength-len(hash)-1]
+		for !utf8.ValidString(truncatedKey) {
+			truncatedKey = truncatedKey[:len(truncatedKey)-1]
+		}
+
+		encodedKey = truncatedKey + "-" + hash
+	}
+
+	return encodedKey, nil
+}
+
+// decodeKey reverses the escaping of unsafe characters by a sanitizing codec.
+// Keys that weren't escaped by it (eg. hashed keys) are returned as is.
+func (c *Standard) decodeKey(encodedKey string) string {
+	if !c.Sanitize || !strings.ContainsRune(encodedKey, escapeChar) {
+		return encodedKey
+	}
+
+	var decoded []byte
+	for i := 0; i < len(encodedKey); i++ {
+		if encodedKey[i] != escapeChar {
+			decoded = append(decoded, encodedKey[i])
+			continue
+		}
+
+		if i+3 > len(encodedKey) {
+			return encodedKey
+		}
+
+		b, err := hex.DecodeString(encodedKey[i+1 : i+3])
+		if err != nil {
+			return encodedKey
+		}
+
+		decoded = append(decoded, b...)
+		i += 2
+	}
+
+	if !utf8.Valid(decoded) {
+		return encodedKey
+	}
+
+	return string(decoded)
+}
+
+// escape escapes the unsafe characters (and the escape character) in a key,
+// if the codec sanitizes keys. Each of their UTF-8 bytes is replaced by the
+// escape character followed by its hex value.
+func (c *Standard) escape(key string) string {
+	if !c.Sanitize {
+		return key
+	}
+
+	var sb strings.Builder
+	for _, r := range key {
+		if !isUnsafe(r) && r != escapeChar {
+			sb.WriteRune(r)
+			continue
+		}
+
+		for _, b := range []byte(string(r)) {
+			fmt.Fprintf(&sb, "%c%02X", escapeChar, b)
+		}
+	}
+
+	return sb.String()
+}
+
+func isUnsafe(r rune) bool {
+	return unicode.IsControl(r) || strings.ContainsRune(unsafeChars, r)
+}
+
+// Provider is a provider that encodes keys before passing them on to another
+// provider. This applies the key codec consistently to every synchronization
+// primitive using the provider.
+type Provider struct {
+	next  provider.Provider
+	codec Codec
+}
+
+// NewProvider wraps a provider with a key codec.
+func NewProvider(next provider.Provider, codec Codec) provider.Provider {
+	return &Provider{
+		next:  next,
+		codec: codec,
+	}
+}
+
+func (p *Provider) AtomicUpdateObject(ctx context.Context, bucket, key string, fn provider.UpdateObjectFunc) (string, error) {
+	encodedKey, err := p.codec.EncodeKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	return p.next.AtomicUpdateObject(ctx, bucket, encodedKey, fn)
+}
+
+func (p *Provider) DeleteObject(ctx context.Context, bucket, key, etag string) error {
+	deleter, ok := p.next.(provider.Deleter)
+	if !ok {
+		return provider.ErrNotSupported
+	}
+
+	encodedKey, err := p.codec.EncodeKey(key)
+	if err != nil {
+		return err
+	}
+
+	return deleter.DeleteObject(ctx, bucket, encodedKey, etag)
+}
+
+func (p *Provider) Now(ctx context.Context) (time.Time, error) {
+	return provider.NowOf(ctx, p.next)
+}
+
+// Capabilities returns the capabilities of the wrapped provider.
+func (p *Provider) Capabilities() provider.Capabilities {
+	return provider.CapabilitiesOf(p.next)
+}
+
+// FenceEpoch returns the fence epoch of the wrapped provider.
+func (p *Provider) FenceEpoch() int64 {
+	return provider.FenceEpochOf(p.next)
+}
+
+func (p *Provider) GetObject(ctx context.Context, bucket, key string) ([]byte, string, error) {
+	getter, ok := p.next.(provider.Getter)
+	if !ok {
+		return nil, "", provider.ErrNotSupported
+	}
+
+	encodedKey, err := p.codec.EncodeKey(key)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return getter.GetObject(ctx, bucket, encodedKey)
+}
+
+func (p *Provider) HeadObject(ctx context.Context, bucket, key string) (string, error) {
+	header, ok := p.next.(provider.Header)
+	if !ok {
+		return "", provider.ErrNotSupported
+	}
+
+	encodedKey, err := p.codec.EncodeKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	return header.HeadObject(ctx, bucket, encodedKey)
+}
+
+func (p *Provider) WatchObject(ctx context.Context, bucket, key string) <-chan provider.Event {
+	watcher, ok := p.next.(provider.Watcher)
+	if !ok {
+		return provider.WatchError(provider.ErrNotSupported)
+	}
+
+	encodedKey, err := p.codec.EncodeKey(key)
+	if err != nil {
+		return provider.WatchError(err)
+	}
+
+	return watcher.WatchObject(ctx, bucket, encodedKey)
+}
+
+// ListObjects lists the objects whose (unencoded) keys start with the given
+// prefix. This is only supported by the Standard codec, which lists the
+// objects under its own prefix, strips it from the returned keys, and
+// reverses the escaping of sanitized keys (hashed keys are returned as is).
+func (p *Provider) ListObjects(ctx context.Context, bucket, prefix string) ([]string, error) {
+	lister, ok := p.next.(provider.Lister)
+	if !ok {
+		return nil, provider.ErrNotSupported
+	}
+
+	codec, ok := p.codec.(*Standard)
+	if !ok {
+		return nil, provider.ErrNotSupported
+	}
+
+	encodedKeys, err := lister.ListObjects(ctx, bucket, codec.Prefix+codec.escape(prefix))
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(encodedKeys))
+	for _, encodedKey := range encodedKeys {
+		keys = append(keys, codec.decodeKey(strings.TrimPrefix(encodedKey, codec.Prefix)))
+	}
+
+	return keys, nil
+}
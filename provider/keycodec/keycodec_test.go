@@ -0,0 +1,138 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package keycodec_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/dpeckett/objsync/provider"
+	"github.com/dpeckett/objsync/provider/keycodec"
+	"github.com/dpeckett/objsync/provider/mem"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStandard(t *testing.T) {
+	t.Run("Reject", func(t *testing.T) {
+		codec := &keycodec.Standard{MaxLength: 16}
+
+		for key, reason := range map[string]string{
+			"":                      "key is empty",
+			"\xff":                  "key is not valid UTF-8",
+			"a#b":                   "unsafe character '#'",
+			"a\nb":                  "unsafe character '\\n'",
+			".":                     "relative path",
+			"..":                    "relative path",
+			strings.Repeat("a", 17): "longer than 16 bytes",
+		} {
+			_, err := codec.EncodeKey(key)
+			require.ErrorIs(t, err, keycodec.ErrInvalidKey, key)
+
+			var invalidKeyErr *keycodec.InvalidKeyError
+			require.ErrorAs(t, err, &invalidKeyErr)
+			require.Equal(t, key, invalidKeyErr.Key)
+			require.Contains(t, invalidKeyErr.Error(), reason)
+		}
+	})
+
+	t.Run("Prefix", func(t *testing.T) {
+		codec := &keycodec.Standard{Prefix: "team-a/locks/"}
+
+		encodedKey, err := codec.EncodeKey("test.lock")
+		require.NoError(t, err)
+		require.Equal(t, "team-a/locks/test.lock", encodedKey)
+	})
+
+	t.Run("Sanitize", func(t *testing.T) {
+		codec := &keycodec.Standard{Sanitize: true}
+
+		for key, expected := range map[string]string{
+			"test.lock": "test.lock",
+			"a_b":       "a_b",
+			"a#b":       "a!23b",
+			"a|b":       "a!7Cb",
+			"a!23b":     "a!2123b",
+			"a\u0085b":  "a!C2!85b",
+		} {
+			encodedKey, err := codec.EncodeKey(key)
+			require.NoError(t, err)
+			require.Equal(t, expected, encodedKey, key)
+		}
+	})
+
+	t.Run("HashLongKeys", func(t *testing.T) {
+		codec := &keycodec.Standard{HashLongKeys: true, MaxLength: 80}
+
+		key := strings.Repeat("é", 50)
+
+		encodedKey, err := codec.EncodeKey(key)
+		require.NoError(t, err)
+		require.LessOrEqual(t, len(encodedKey), 80)
+		require.True(t, strings.HasPrefix(key, strings.Split(encodedKey, "-")[0]))
+
+		// Keys sharing the truncated prefix don't collide.
+		otherEncodedKey, err := codec.EncodeKey(key + "a")
+		require.NoError(t, err)
+		require.NotEqual(t, encodedKey, otherEncodedKey)
+
+		// The hash can't fit.
+		_, err = (&keycodec.Standard{HashLongKeys: true, MaxLength: 64}).EncodeKey(strings.Repeat("a", 65))
+		require.ErrorIs(t, err, keycodec.ErrInvalidKey)
+	})
+}
+
+func TestProvider(t *testing.T) {
+	ctx := context.Background()
+
+	next := mem.NewProvider()
+	p := keycodec.NewProvider(next, &keycodec.Standard{Prefix: "locks/", Sanitize: true})
+
+	put := func(key, value string) {
+		_, err := p.AtomicUpdateObject(ctx, "bucket", key, func(_ string, _ []byte) ([]byte, error) {
+			return []byte(value), nil
+		})
+		require.NoError(t, err)
+	}
+
+	// Keys that only differ in their unsafe characters are distinct objects.
+	put("a#b", "hash")
+	put("a|b", "pipe")
+
+	for key, value := range map[string]string{"a#b": "hash", "a|b": "pipe"} {
+		data, _, err := p.(provider.Getter).GetObject(ctx, "bucket", key)
+		require.NoError(t, err)
+		require.Equal(t, value, string(data))
+	}
+
+	data, _, err := next.(provider.Getter).GetObject(ctx, "bucket", "locks/a!23b")
+	require.NoError(t, err)
+	require.Equal(t, "hash", string(data))
+
+	// Listing returns the original keys.
+	put("ab", "plain")
+
+	keys, err := p.(provider.Lister).ListObjects(ctx, "bucket", "a")
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"a#b", "a|b", "ab"}, keys)
+
+	keys, err = p.(provider.Lister).ListObjects(ctx, "bucket", "a#")
+	require.NoError(t, err)
+	require.Equal(t, []string{"a#b"}, keys)
+
+	// Invalid keys are rejected before they reach the provider.
+	_, err = p.AtomicUpdateObject(ctx, "bucket", "", func(_ string, _ []byte) ([]byte, error) {
+		return []byte("hello"), nil
+	})
+	require.ErrorIs(t, err, keycodec.ErrInvalidKey)
+
+	err = p.(provider.Deleter).DeleteObject(ctx, "bucket", "\xff", "1")
+	require.ErrorIs(t, err, keycodec.ErrInvalidKey)
+}
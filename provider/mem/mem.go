@@ -0,0 +1,146 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package mem implements an in-memory provider, for tests, and for
+// coordinating goroutines within a single process.
+package mem
+
+import (
+	"bytes"
+	"context"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/dpeckett/objsync/provider"
+)
+
+func init() {
+	provider.Register("mem", open)
+}
+
+// The provider shared by every "mem://" URL, so that providers opened by
+// different parts of a process see the same objects.
+var shared = NewProvider()
+
+// open opens the in-memory provider shared by the process, eg. "mem://bucket".
+func open(_ context.Context, _ *url.URL) (provider.Provider, error) {
+	return shared, nil
+}
+
+type object struct {
+	etag string
+	data []byte
+}
+
+// Provider is an in-memory provider.
+type Provider struct {
+	mu      sync.Mutex
+	objects map[string]*object
+	version int64
+}
+
+// NewProvider creates a new, empty, in-memory provider.
+func NewProvider() provider.Provider {
+	return &Provider{
+		objects: make(map[string]*object),
+	}
+}
+
+func (p *Provider) AtomicUpdateObject(_ context.Context, bucket, key string, fn provider.UpdateObjectFunc) (string, error) {
+	currentETag, currentData := p.get(bucket, key)
+
+	newData, err := fn(currentETag, currentData)
+	if err != nil {
+		return "", err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	obj := p.objects[objectName(bucket, key)]
+	if (obj == nil && currentETag != "") || (obj != nil && obj.etag != currentETag) {
+		return "", provider.ErrConflict
+	}
+
+	p.version++
+	obj = &object{
+		etag: strconv.FormatInt(p.version, 10),
+		data: bytes.Clone(newData),
+	}
+	p.objects[objectName(bucket, key)] = obj
+
+	return obj.etag, nil
+}
+
+func (p *Provider) DeleteObject(_ context.Context, bucket, key, etag string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	obj := p.objects[objectName(bucket, key)]
+	if obj == nil || obj.etag != etag {
+		return provider.ErrConflict
+	}
+
+	delete(p.objects, objectName(bucket, key))
+
+	return nil
+}
+
+func (p *Provider) GetObject(_ context.Context, bucket, key string) ([]byte, string, error) {
+	etag, data := p.get(bucket, key)
+	return data, etag, nil
+}
+
+func (p *Provider) HeadObject(_ context.Context, bucket, key string) (string, error) {
+	etag, _ := p.get(bucket, key)
+	return etag, nil
+}
+
+func (p *Provider) ListObjects(_ context.Context, bucket, prefix string) ([]string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var keys []string
+	for name := range p.objects {
+		if key, ok := strings.CutPrefix(name, objectName(bucket, "")); ok && strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+func (p *Provider) Capabilities() provider.Capabilities {
+	return provider.Capabilities{
+		ConditionalCreate:    true,
+		ConditionalDelete:    true,
+		StrongReadAfterWrite: true,
+	}
+}
+
+// get returns the ETag and a copy of the content of an object.
+func (p *Provider) get(bucket, key string) (string, []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	obj := p.objects[objectName(bucket, key)]
+	if obj == nil {
+		return "", nil
+	}
+
+	return obj.etag, bytes.Clone(obj.data)
+}
+
+func objectName(bucket, key string) string {
+	return bucket + "\x00" + key
+}
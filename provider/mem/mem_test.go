@@ -0,0 +1,194 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package mem_test
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/dpeckett/objsync"
+	"github.com/dpeckett/objsync/provider"
+	"github.com/dpeckett/objsync/provider/mem"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestProvider(t *testing.T) {
+	ctx := context.Background()
+
+	p := mem.NewProvider()
+
+	t.Run("Update", func(t *testing.T) {
+		etag, err := p.AtomicUpdateObject(ctx, "bucket", "update", func(currentETag string, currentData []byte) ([]byte, error) {
+			require.Empty(t, currentETag)
+			require.Empty(t, currentData)
+
+			return []byte("hello"), nil
+		})
+		require.NoError(t, err)
+		require.NotEmpty(t, etag)
+
+		data, currentETag, err := p.(provider.Getter).GetObject(ctx, "bucket", "update")
+		require.NoError(t, err)
+		require.Equal(t, "hello", string(data))
+		require.Equal(t, etag, currentETag)
+
+		// The returned content is a copy.
+		data[0] = 'j'
+
+		newETag, err := p.AtomicUpdateObject(ctx, "bucket", "update", func(currentETag string, currentData []byte) ([]byte, error) {
+			require.Equal(t, etag, currentETag)
+			require.Equal(t, "hello", string(currentData))
+
+			return []byte("world"), nil
+		})
+		require.NoError(t, err)
+		require.NotEqual(t, etag, newETag)
+
+		currentETag, err = p.(provider.Header).HeadObject(ctx, "bucket", "update")
+		require.NoError(t, err)
+		require.Equal(t, newETag, currentETag)
+	})
+
+	t.Run("Conflict", func(t *testing.T) {
+		_, err := p.AtomicUpdateObject(ctx, "bucket", "conflict", func(_ string, _ []byte) ([]byte, error) {
+			// Race another writer.
+			_, err := p.AtomicUpdateObject(ctx, "bucket", "conflict", func(_ string, _ []byte) ([]byte, error) {
+				return []byte("world"), nil
+			})
+			require.NoError(t, err)
+
+			return []byte("hello"), nil
+		})
+		require.ErrorIs(t, err, provider.ErrConflict)
+
+		data, _, err := p.(provider.Getter).GetObject(ctx, "bucket", "conflict")
+		require.NoError(t, err)
+		require.Equal(t, "world", string(data))
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		var g errgroup.Group
+		for i := 0; i < 10; i++ {
+			g.Go(func() error {
+				for {
+					_, err := p.AtomicUpdateObject(ctx, "bucket", "counter", func(_ string, currentData []byte) ([]byte, error) {
+						var n int
+						if len(currentData) > 0 {
+							var err error
+							if n, err = strconv.Atoi(string(currentData)); err != nil {
+								return nil, err
+							}
+						}
+
+						return []byte(strconv.Itoa(n + 1)), nil
+					})
+					if !errors.Is(err, provider.ErrConflict) {
+						return err
+					}
+				}
+			})
+		}
+		require.NoError(t, g.Wait())
+
+		data, _, err := p.(provider.Getter).GetObject(ctx, "bucket", "counter")
+		require.NoError(t, err)
+		require.Equal(t, "10", string(data))
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		deleter := p.(provider.Deleter)
+
+		etag, err := p.AtomicUpdateObject(ctx, "bucket", "delete", func(_ string, _ []byte) ([]byte, error) {
+			return []byte("hello"), nil
+		})
+		require.NoError(t, err)
+
+		require.ErrorIs(t, deleter.DeleteObject(ctx, "bucket", "delete", etag+"0"), provider.ErrConflict)
+		require.NoError(t, deleter.DeleteObject(ctx, "bucket", "delete", etag))
+		require.ErrorIs(t, deleter.DeleteObject(ctx, "bucket", "delete", etag), provider.ErrConflict)
+
+		currentETag, err := p.(provider.Header).HeadObject(ctx, "bucket", "delete")
+		require.NoError(t, err)
+		require.Empty(t, currentETag)
+
+		// The ETags of a recreated object aren't reused, so a stale ETag can't
+		// match it.
+		newETag, err := p.AtomicUpdateObject(ctx, "bucket", "delete", func(_ string, _ []byte) ([]byte, error) {
+			return []byte("hello"), nil
+		})
+		require.NoError(t, err)
+		require.NotEqual(t, etag, newETag)
+
+		require.ErrorIs(t, deleter.DeleteObject(ctx, "bucket", "delete", etag), provider.ErrConflict)
+	})
+
+	t.Run("List", func(t *testing.T) {
+		for _, key := range []string{"list/b", "list/a", "other"} {
+			_, err := p.AtomicUpdateObject(ctx, "list", key, func(_ string, _ []byte) ([]byte, error) {
+				return []byte("hello"), nil
+			})
+			require.NoError(t, err)
+		}
+
+		// Objects in other buckets aren't listed.
+		_, err := p.AtomicUpdateObject(ctx, "other", "list/c", func(_ string, _ []byte) ([]byte, error) {
+			return []byte("hello"), nil
+		})
+		require.NoError(t, err)
+
+		keys, err := p.(provider.Lister).ListObjects(ctx, "list", "list/")
+		require.NoError(t, err)
+		require.Equal(t, []string{"list/a", "list/b"}, keys)
+	})
+
+	t.Run("Open", func(t *testing.T) {
+		p1, bucket, err := provider.Open(ctx, "mem://bucket")
+		require.NoError(t, err)
+		require.Equal(t, "bucket", bucket)
+
+		p2, _, err := provider.Open(ctx, "mem://bucket")
+		require.NoError(t, err)
+
+		// Every URL opens the same provider.
+		etag, err := p1.AtomicUpdateObject(ctx, bucket, "open", func(_ string, _ []byte) ([]byte, error) {
+			return []byte("hello"), nil
+		})
+		require.NoError(t, err)
+
+		data, currentETag, err := p2.(provider.Getter).GetObject(ctx, bucket, "open")
+		require.NoError(t, err)
+		require.Equal(t, "hello", string(data))
+		require.Equal(t, etag, currentETag)
+	})
+}
+
+func TestMutex(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+
+	p := mem.NewProvider()
+
+	mu := objsync.NewMutex(p, "bucket", "test.lock")
+
+	for i := 0; i < 2; i++ {
+		_, err := mu.Lock(ctx, time.Minute)
+		require.NoError(t, err)
+
+		ok, _, err := objsync.NewMutex(p, "bucket", "test.lock").TryLock(ctx, time.Minute)
+		require.NoError(t, err)
+		require.False(t, ok)
+
+		require.NoError(t, mu.Unlock(ctx))
+	}
+}
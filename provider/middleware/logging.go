@@ -0,0 +1,49 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// Logging returns middleware that logs every call to the provider. Calls are
+// logged at debug level, and provider failures at warn level.
+func Logging(logger *slog.Logger) Middleware {
+	return Intercept(func(ctx context.Context, call *Call, invoke Invoker) error {
+		start := time.Now()
+		err := invoke(ctx)
+
+		level := slog.LevelDebug
+		if call.Failed(err) {
+			level = slog.LevelWarn
+		}
+
+		if !logger.Enabled(ctx, level) {
+			return err
+		}
+
+		attrs := []slog.Attr{
+			slog.String("operation", string(call.Operation)),
+			slog.String("bucket", call.Bucket),
+			slog.String("key", call.Key),
+			slog.Duration("duration", time.Since(start)),
+		}
+
+		if err != nil {
+			attrs = append(attrs, slog.Any("error", err))
+		}
+
+		logger.LogAttrs(ctx, level, "objsync: provider request", attrs...)
+
+		return err
+	})
+}
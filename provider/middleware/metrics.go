@@ -0,0 +1,43 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package middleware
+
+import (
+	"context"
+	"time"
+
+	"github.com/dpeckett/objsync/stats"
+)
+
+// Metrics returns middleware that emits a provider request event to the given
+// stats handler for every call to the provider (eg. to export them with the
+// metrics/prometheus package). Only provider failures are reported as errors.
+func Metrics(h stats.Handler) Middleware {
+	return Intercept(func(ctx context.Context, call *Call, invoke Invoker) error {
+		start := time.Now()
+		err := invoke(ctx)
+
+		event := stats.Event{
+			Type:      stats.EventProviderRequest,
+			Bucket:    call.Bucket,
+			Key:       call.Key,
+			Operation: string(call.Operation),
+			Duration:  time.Since(start),
+		}
+
+		if call.Failed(err) {
+			event.Err = err
+		}
+
+		h.HandleEvent(ctx, event)
+
+		return err
+	})
+}
@@ -0,0 +1,224 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package middleware implements composable provider middleware, for
+// cross-cutting concerns (eg. logging, metrics, rate limiting and retries)
+// that would otherwise have to be implemented by every provider.
+//
+// Middleware is applied to a provider with Chain, eg.
+//
+//	p = middleware.Chain(p,
+//		middleware.Logging(logger),
+//		middleware.Retry(),
+//	)
+package middleware
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/dpeckett/objsync/provider"
+)
+
+// Middleware wraps a provider, adding behaviour to it.
+type Middleware func(provider.Provider) provider.Provider
+
+// Chain wraps a provider with the given middleware. The first middleware is
+// the outermost, so it sees every call first.
+func Chain(p provider.Provider, middlewares ...Middleware) provider.Provider {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		p = middlewares[i](p)
+	}
+
+	return p
+}
+
+// Operation is the name of a provider operation.
+type Operation string
+
+const (
+	OperationUpdate Operation = "AtomicUpdateObject"
+	OperationGet    Operation = "GetObject"
+	OperationHead   Operation = "HeadObject"
+	OperationDelete Operation = "DeleteObject"
+	OperationList   Operation = "ListObjects"
+	OperationNow    Operation = "Now"
+)
+
+// Call is a call made to a provider.
+type Call struct {
+	// Operation is the operation being called.
+	Operation Operation
+	// Bucket is the bucket of the object.
+	Bucket string
+	// Key is the key of the object (or the prefix, when listing objects).
+	Key string
+	// The error returned by the update function, if the last invocation
+	// of an update failed because of it.
+	fnErr error
+}
+
+// Failed reports whether an error returned by invoking the call is a failure
+// of the provider, rather than an expected outcome (eg. a conflict, or an
+// error returned by the update function).
+func (c *Call) Failed(err error) bool {
+	if err == nil || (c.fnErr != nil && errors.Is(err, c.fnErr)) {
+		return false
+	}
+
+	return !errors.Is(err, provider.ErrConflict) && !errors.Is(err, provider.ErrNotSupported)
+}
+
+// Invoker passes a call through to the wrapped provider.
+type Invoker func(ctx context.Context) error
+
+// Interceptor intercepts a call to a provider. It must call invoke (once, or
+// several times eg. to retry) to pass the call through to the wrapped
+// provider, returning the error from the final invocation.
+type Interceptor func(ctx context.Context, call *Call, invoke Invoker) error
+
+// Intercept returns middleware that passes every call through the given
+// interceptor. The wrapped provider keeps the capabilities of the provider it
+// wraps. Watches are long lived, so they are passed through as is.
+func Intercept(interceptor Interceptor) Middleware {
+	return func(next provider.Provider) provider.Provider {
+		return &interceptedProvider{
+			next:        next,
+			interceptor: interceptor,
+		}
+	}
+}
+
+type interceptedProvider struct {
+	next        provider.Provider
+	interceptor Interceptor
+}
+
+func (p *interceptedProvider) AtomicUpdateObject(ctx context.Context, bucket, key string, fn provider.UpdateObjectFunc) (string, error) {
+	call := &Call{Operation: OperationUpdate, Bucket: bucket, Key: key}
+
+	var etag string
+	err := p.interceptor(ctx, call, func(ctx context.Context) error {
+		call.fnErr = nil
+
+		var err error
+		etag, err = p.next.AtomicUpdateObject(ctx, bucket, key, func(currentETag string, currentData []byte) ([]byte, error) {
+			newData, err := fn(currentETag, currentData)
+			call.fnErr = err
+			return newData, err
+		})
+		return err
+	})
+
+	return etag, err
+}
+
+func (p *interceptedProvider) DeleteObject(ctx context.Context, bucket, key, etag string) error {
+	deleter, ok := p.next.(provider.Deleter)
+	if !ok {
+		return provider.ErrNotSupported
+	}
+
+	call := &Call{Operation: OperationDelete, Bucket: bucket, Key: key}
+
+	return p.interceptor(ctx, call, func(ctx context.Context) error {
+		return deleter.DeleteObject(ctx, bucket, key, etag)
+	})
+}
+
+func (p *interceptedProvider) GetObject(ctx context.Context, bucket, key string) ([]byte, string, error) {
+	getter, ok := p.next.(provider.Getter)
+	if !ok {
+		return nil, "", provider.ErrNotSupported
+	}
+
+	call := &Call{Operation: OperationGet, Bucket: bucket, Key: key}
+
+	var data []byte
+	var etag string
+	err := p.interceptor(ctx, call, func(ctx context.Context) error {
+		var err error
+		data, etag, err = getter.GetObject(ctx, bucket, key)
+		return err
+	})
+
+	return data, etag, err
+}
+
+func (p *interceptedProvider) HeadObject(ctx context.Context, bucket, key string) (string, error) {
+	header, ok := p.next.(provider.Header)
+	if !ok {
+		return "", provider.ErrNotSupported
+	}
+
+	call := &Call{Operation: OperationHead, Bucket: bucket, Key: key}
+
+	var etag string
+	err := p.interceptor(ctx, call, func(ctx context.Context) error {
+		var err error
+		etag, err = header.HeadObject(ctx, bucket, key)
+		return err
+	})
+
+	return etag, err
+}
+
+func (p *interceptedProvider) ListObjects(ctx context.Context, bucket, prefix string) ([]string, error) {
+	lister, ok := p.next.(provider.Lister)
+	if !ok {
+		return nil, provider.ErrNotSupported
+	}
+
+	call := &Call{Operation: OperationList, Bucket: bucket, Key: prefix}
+
+	var keys []string
+	err := p.interceptor(ctx, call, func(ctx context.Context) error {
+		var err error
+		keys, err = lister.ListObjects(ctx, bucket, prefix)
+		return err
+	})
+
+	return keys, err
+}
+
+func (p *interceptedProvider) WatchObject(ctx context.Context, bucket, key string) <-chan provider.Event {
+	watcher, ok := p.next.(provider.Watcher)
+	if !ok {
+		return provider.WatchError(provider.ErrNotSupported)
+	}
+
+	return watcher.WatchObject(ctx, bucket, key)
+}
+
+func (p *interceptedProvider) Now(ctx context.Context) (time.Time, error) {
+	clock, ok := p.next.(provider.Clock)
+	if !ok {
+		return time.Time{}, provider.ErrNotSupported
+	}
+
+	call := &Call{Operation: OperationNow}
+
+	var now time.Time
+	err := p.interceptor(ctx, call, func(ctx context.Context) error {
+		var err error
+		now, err = clock.Now(ctx)
+		return err
+	})
+
+	return now, err
+}
+
+func (p *interceptedProvider) Capabilities() provider.Capabilities {
+	return provider.CapabilitiesOf(p.next)
+}
+
+func (p *interceptedProvider) FenceEpoch() int64 {
+	return provider.FenceEpochOf(p.next)
+}
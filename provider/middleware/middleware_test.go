@@ -0,0 +1,213 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package middleware_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/avast/retry-go/v4"
+	"github.com/dpeckett/objsync/provider"
+	"github.com/dpeckett/objsync/provider/mem"
+	"github.com/dpeckett/objsync/provider/middleware"
+	"github.com/dpeckett/objsync/stats"
+	"github.com/stretchr/testify/require"
+)
+
+var errDown = errors.New("provider down")
+
+// flaky is a provider whose updates fail a given number of times.
+type flaky struct {
+	provider.Provider
+	failures atomic.Int32
+	calls    atomic.Int32
+}
+
+func (f *flaky) AtomicUpdateObject(ctx context.Context, bucket, key string, fn provider.UpdateObjectFunc) (string, error) {
+	f.calls.Add(1)
+
+	if f.failures.Add(-1) >= 0 {
+		return "", errDown
+	}
+
+	return f.Provider.AtomicUpdateObject(ctx, bucket, key, fn)
+}
+
+func put(ctx context.Context, p provider.Provider, value string) error {
+	_, err := p.AtomicUpdateObject(ctx, "bucket", "key", func(_ string, _ []byte) ([]byte, error) {
+		return []byte(value), nil
+	})
+	return err
+}
+
+type limiterFunc func(ctx context.Context) error
+
+func (f limiterFunc) Wait(ctx context.Context) error {
+	return f(ctx)
+}
+
+func TestChain(t *testing.T) {
+	ctx := context.Background()
+
+	var calls []string
+	record := func(name string) middleware.Middleware {
+		return middleware.Intercept(func(ctx context.Context, call *middleware.Call, invoke middleware.Invoker) error {
+			calls = append(calls, name+":"+string(call.Operation)+":"+call.Key)
+			return invoke(ctx)
+		})
+	}
+
+	p := middleware.Chain(mem.NewProvider(), record("outer"), record("inner"))
+
+	require.NoError(t, put(ctx, p, "hello"))
+
+	data, _, err := p.(provider.Getter).GetObject(ctx, "bucket", "key")
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(data))
+
+	require.Equal(t, []string{
+		"outer:AtomicUpdateObject:key",
+		"inner:AtomicUpdateObject:key",
+		"outer:GetObject:key",
+		"inner:GetObject:key",
+	}, calls)
+
+	// The capabilities of the wrapped provider are kept.
+	require.Equal(t, provider.CapabilitiesOf(mem.NewProvider()), provider.CapabilitiesOf(p))
+}
+
+func TestRetry(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Failures", func(t *testing.T) {
+		f := &flaky{Provider: mem.NewProvider()}
+		p := middleware.Chain(f, middleware.Retry(retry.Delay(time.Millisecond)))
+
+		f.failures.Store(2)
+		require.NoError(t, put(ctx, p, "hello"))
+		require.Equal(t, int32(3), f.calls.Load())
+
+		f.calls.Store(0)
+		f.failures.Store(3)
+		require.ErrorIs(t, put(ctx, p, "hello"), errDown)
+		require.Equal(t, int32(3), f.calls.Load())
+	})
+
+	t.Run("ExpectedErrors", func(t *testing.T) {
+		f := &flaky{Provider: mem.NewProvider()}
+		p := middleware.Chain(f, middleware.Retry(retry.Delay(time.Millisecond)))
+
+		// Errors returned by the update function aren't retried.
+		errAbort := errors.New("abort")
+		_, err := p.AtomicUpdateObject(ctx, "bucket", "key", func(_ string, _ []byte) ([]byte, error) {
+			return nil, errAbort
+		})
+		require.ErrorIs(t, err, errAbort)
+		require.Equal(t, int32(1), f.calls.Load())
+
+		// Nor are conflicts.
+		f.calls.Store(0)
+		_, err = p.AtomicUpdateObject(ctx, "bucket", "key", func(_ string, _ []byte) ([]byte, error) {
+			require.NoError(t, put(ctx, f.Provider, "world"))
+
+			return []byte("hello"), nil
+		})
+		require.ErrorIs(t, err, provider.ErrConflict)
+		require.Equal(t, int32(1), f.calls.Load())
+	})
+}
+
+func TestMetrics(t *testing.T) {
+	ctx := context.Background()
+
+	var events []stats.Event
+	h := stats.HandlerFunc(func(_ context.Context, event stats.Event) {
+		events = append(events, event)
+	})
+
+	f := &flaky{Provider: mem.NewProvider()}
+	p := middleware.Chain(f, middleware.Metrics(h))
+
+	require.NoError(t, put(ctx, p, "hello"))
+
+	f.failures.Store(1)
+	require.ErrorIs(t, put(ctx, p, "hello"), errDown)
+
+	_, err := p.AtomicUpdateObject(ctx, "bucket", "key", func(_ string, _ []byte) ([]byte, error) {
+		require.NoError(t, put(ctx, f.Provider, "world"))
+
+		return []byte("hello"), nil
+	})
+	require.ErrorIs(t, err, provider.ErrConflict)
+
+	require.Len(t, events, 3)
+	for _, event := range events {
+		require.Equal(t, stats.EventProviderRequest, event.Type)
+		require.Equal(t, "bucket", event.Bucket)
+		require.Equal(t, "key", event.Key)
+		require.Equal(t, string(middleware.OperationUpdate), event.Operation)
+	}
+
+	// Only provider failures are reported as errors.
+	require.NoError(t, events[0].Err)
+	require.ErrorIs(t, events[1].Err, errDown)
+	require.NoError(t, events[2].Err)
+}
+
+func TestLogging(t *testing.T) {
+	ctx := context.Background()
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelWarn}))
+
+	f := &flaky{Provider: mem.NewProvider()}
+	p := middleware.Chain(f, middleware.Logging(logger))
+
+	// Successful calls are logged at debug level.
+	require.NoError(t, put(ctx, p, "hello"))
+	require.Empty(t, buf.String())
+
+	// Failures at warn level.
+	f.failures.Store(1)
+	require.ErrorIs(t, put(ctx, p, "hello"), errDown)
+	require.Contains(t, buf.String(), "level=WARN")
+	require.Contains(t, buf.String(), "operation=AtomicUpdateObject")
+	require.Contains(t, buf.String(), "error=\"provider down\"")
+}
+
+func TestRateLimit(t *testing.T) {
+	ctx := context.Background()
+
+	errLimited := errors.New("rate limited")
+
+	var waits int
+	limiter := limiterFunc(func(_ context.Context) error {
+		waits++
+		if waits > 1 {
+			return errLimited
+		}
+
+		return nil
+	})
+
+	f := &flaky{Provider: mem.NewProvider()}
+	p := middleware.Chain(f, middleware.RateLimit(limiter))
+
+	require.NoError(t, put(ctx, p, "hello"))
+
+	// Calls that aren't allowed don't reach the provider.
+	require.ErrorIs(t, put(ctx, p, "hello"), errLimited)
+	require.Equal(t, int32(1), f.calls.Load())
+}
@@ -0,0 +1,34 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package middleware
+
+import (
+	"context"
+)
+
+// Limiter limits the rate of calls, it's implemented by eg.
+// golang.org/x/time/rate.Limiter.
+type Limiter interface {
+	// Wait blocks until a call is allowed, or the context is done.
+	Wait(ctx context.Context) error
+}
+
+// RateLimit returns middleware that limits the rate of calls to the provider
+// (eg. to stay within the request rate limits of an object store). To also
+// limit retries, it should come after the retry middleware in the chain.
+func RateLimit(limiter Limiter) Middleware {
+	return Intercept(func(ctx context.Context, _ *Call, invoke Invoker) error {
+		if err := limiter.Wait(ctx); err != nil {
+			return err
+		}
+
+		return invoke(ctx)
+	})
+}
@@ -0,0 +1,46 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package middleware
+
+import (
+	"context"
+	"time"
+
+	"github.com/avast/retry-go/v4"
+)
+
+// Retry returns middleware that retries calls that failed due to a provider
+// failure (eg. a transient network error), but not conflicts, or errors
+// returned by the update function. By default calls are attempted three
+// times, with an exponential backoff starting at 100ms, this can be changed
+// with the given retry options.
+//
+// Updates whose outcome is unknown (eg. a timeout) are retried too, so the
+// update function may see the result of its own earlier invocation.
+func Retry(opts ...retry.Option) Middleware {
+	return Intercept(func(ctx context.Context, call *Call, invoke Invoker) error {
+		retryOpts := []retry.Option{
+			retry.Attempts(3),
+			retry.Delay(100 * time.Millisecond),
+			retry.LastErrorOnly(true),
+		}
+		retryOpts = append(retryOpts, opts...)
+		retryOpts = append(retryOpts,
+			retry.Context(ctx),
+			retry.RetryIf(func(err error) bool {
+				return call.Failed(err) && ctx.Err() == nil
+			}),
+		)
+
+		return retry.Do(func() error {
+			return invoke(ctx)
+		}, retryOpts...)
+	})
+}
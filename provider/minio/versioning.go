@@ -0,0 +1,225 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package minio
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"slices"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/dpeckett/objsync/provider"
+)
+
+// The metadata keys used to record the version chain.
+const (
+	parentMetadataKey    = "objsync-parent"
+	tombstoneMetadataKey = "objsync-tombstone"
+)
+
+// objectVersion is a version of an object, along with the version it was
+// derived from.
+type objectVersion struct {
+	id        string
+	parentID  string
+	tombstone bool
+}
+
+// updateVersioned emulates compare-and-swap using object versions. Every write
+// creates a new version of the object, recording the ID of the version it was
+// derived from. Walking the versions from oldest to newest, a version is only
+// accepted if it was derived from the previously accepted version, so when
+// two writers race from the same parent the earlier write wins. The loser
+// deletes its version and returns provider.ErrConflict.
+func (p *Provider) updateVersioned(ctx context.Context, bucket, key string, fn provider.UpdateObjectFunc, tombstone bool) (string, error) {
+	versions, err := p.listVersions(ctx, bucket, key)
+	if err != nil {
+		return "", err
+	}
+
+	head, losers := resolveChain(versions)
+
+	// Clean up after writers that lost a race, but didn't (or couldn't) remove
+	// their version.
+	p.deleteVersions(ctx, bucket, key, losers)
+
+	var parentID, currentETag string
+	var currentData []byte
+	if head != nil {
+		parentID = head.id
+
+		if !head.tombstone {
+			currentETag = head.id
+
+			start := time.Now()
+			getResp, err := p.client.GetObject(ctx, &s3.GetObjectInput{
+				Bucket:    aws.String(bucket),
+				Key:       aws.String(key),
+				VersionId: aws.String(head.id),
+			})
+			p.emit(ctx, "GetObject", bucket, key, start, err)
+			if err != nil {
+				// The version was pruned by a newer write.
+				if hasErrorCode(err, "NoSuchKey") || hasErrorCode(err, "NoSuchVersion") {
+					return "", provider.ErrConflict
+				}
+
+				return "", err
+			}
+			defer getResp.Body.Close()
+
+			currentData, err = io.ReadAll(getResp.Body)
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+
+	newData, err := fn(currentETag, currentData)
+	if err != nil {
+		return "", err
+	}
+
+	metadata := map[string]string{parentMetadataKey: parentID}
+	if tombstone {
+		metadata[tombstoneMetadataKey] = "true"
+	}
+
+	start := time.Now()
+	putResp, err := p.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(newData),
+		ContentType: aws.String("application/json"),
+		Metadata:    metadata,
+	})
+	p.emit(ctx, "PutObject", bucket, key, start, err)
+	if err != nil {
+		return "", err
+	}
+
+	uploaded := &objectVersion{id: aws.ToString(putResp.VersionId)}
+
+	versions, err = p.listVersions(ctx, bucket, key)
+	if err != nil {
+		return "", err
+	}
+
+	head, losers = resolveChain(versions)
+
+	isUploaded := func(v *objectVersion) bool { return v.id == uploaded.id }
+	if head == nil || !slices.ContainsFunc(versions, isUploaded) || slices.ContainsFunc(losers, isUploaded) {
+		p.deleteVersions(ctx, bucket, key, []*objectVersion{uploaded})
+
+		return "", provider.ErrConflict
+	}
+
+	// Prune the versions that were superseded by our write, losers first so
+	// that the oldest remaining version is always part of the chain.
+	var superseded []*objectVersion
+	for _, v := range versions {
+		if v.id == uploaded.id {
+			break
+		}
+		superseded = append(superseded, v)
+	}
+	p.deleteVersions(ctx, bucket, key, losers)
+	p.deleteVersions(ctx, bucket, key, superseded)
+
+	return uploaded.id, nil
+}
+
+// listVersions returns the versions of an object, ordered from oldest to
+// newest.
+func (p *Provider) listVersions(ctx context.Context, bucket, key string) ([]*objectVersion, error) {
+	var versions []*objectVersion
+
+	paginator := s3.NewListObjectVersionsPaginator(p.client, &s3.ListObjectVersionsInput{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(key),
+	})
+	for paginator.HasMorePages() {
+		start := time.Now()
+		page, err := paginator.NextPage(ctx)
+		p.emit(ctx, "ListObjectVersions", bucket, key, start, err)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, v := range page.Versions {
+			if aws.ToString(v.Key) != key {
+				continue
+			}
+
+			// Versions are listed without their metadata.
+			start := time.Now()
+			headResp, err := p.client.HeadObject(ctx, &s3.HeadObjectInput{
+				Bucket:    aws.String(bucket),
+				Key:       aws.String(key),
+				VersionId: v.VersionId,
+			})
+			p.emit(ctx, "HeadObject", bucket, key, start, err)
+			if err != nil {
+				// Deleted since it was listed.
+				if hasErrorCode(err, "NotFound") || hasErrorCode(err, "NoSuchVersion") {
+					continue
+				}
+
+				return nil, err
+			}
+
+			versions = append(versions, &objectVersion{
+				id:        aws.ToString(v.VersionId),
+				parentID:  headResp.Metadata[parentMetadataKey],
+				tombstone: headResp.Metadata[tombstoneMetadataKey] != "",
+			})
+		}
+	}
+
+	// Versions of the same object are listed from newest to oldest.
+	for i, j := 0, len(versions)-1; i < j; i, j = i+1, j-1 {
+		versions[i], versions[j] = versions[j], versions[i]
+	}
+
+	return versions, nil
+}
+
+// deleteVersions makes a best effort attempt to permanently delete the given
+// versions of an object, they may have already been removed by another client.
+func (p *Provider) deleteVersions(ctx context.Context, bucket, key string, versions []*objectVersion) {
+	for _, v := range versions {
+		start := time.Now()
+		_, err := p.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+			Bucket:    aws.String(bucket),
+			Key:       aws.String(key),
+			VersionId: aws.String(v.id),
+		})
+		p.emit(ctx, "DeleteObject", bucket, key, start, err)
+	}
+}
+
+// resolveChain walks the versions of an object (ordered from oldest to newest)
+// and returns the accepted head of the version chain, along with any versions
+// that lost a race and are not part of the chain.
+func resolveChain(versions []*objectVersion) (head *objectVersion, losers []*objectVersion) {
+	for _, v := range versions {
+		if head == nil || v.parentID == head.id {
+			head = v
+			continue
+		}
+
+		losers = append(losers, v)
+	}
+
+	return head, losers
+}
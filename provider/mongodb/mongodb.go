@@ -0,0 +1,207 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/dpeckett/objsync/provider"
+	"github.com/dpeckett/objsync/stats"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+// Option is a functional option for configuring a MongoDB provider.
+type Option func(*Provider)
+
+// WithStatsHandler sets a handler that receives an event for every request
+// made to the database.
+func WithStatsHandler(h stats.Handler) Option {
+	return func(p *Provider) {
+		p.statsHandler = h
+	}
+}
+
+// Provider is a MongoDB provider. Buckets correspond to collections, and each
+// object is stored as a document (keyed by _id) along with a version number,
+// that is used for conditional updates.
+type Provider struct {
+	db           *mongo.Database
+	statsHandler stats.Handler
+}
+
+// lockDocument is the document stored for each object.
+type lockDocument struct {
+	Key     string `bson:"_id"`
+	Data    []byte `bson:"data"`
+	Version int64  `bson:"version"`
+}
+
+// NewProvider initializes a new MongoDB provider. Reads and writes are made
+// with majority read/write concerns against the primary, as anything weaker
+// could allow two clients to believe they hold the same lock.
+func NewProvider(db *mongo.Database, opts ...Option) provider.Provider {
+	p := &Provider{
+		db: db.Client().Database(db.Name(), options.Database().
+			SetReadConcern(readconcern.Majority()).
+			SetWriteConcern(writeconcern.Majority()).
+			SetReadPreference(readpref.Primary())),
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+func (p *Provider) AtomicUpdateObject(ctx context.Context, bucket, key string, fn provider.UpdateObjectFunc) (string, error) {
+	coll := p.db.Collection(bucket)
+
+	var current lockDocument
+	start := time.Now()
+	err := coll.FindOne(ctx, bson.D{{Key: "_id", Value: key}}).Decode(&current)
+	p.emit(ctx, "FindOne", bucket, key, start, err)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return "", err
+	}
+
+	var currentETag string
+	if current.Version != 0 {
+		currentETag = strconv.FormatInt(current.Version, 10)
+	}
+
+	newData, err := fn(currentETag, current.Data)
+	if err != nil {
+		return "", err
+	}
+
+	if current.Version == 0 {
+		newVersion := provider.InitialVersion()
+
+		start = time.Now()
+		_, err = coll.InsertOne(ctx, lockDocument{Key: key, Data: newData, Version: newVersion})
+		p.emit(ctx, "InsertOne", bucket, key, start, err)
+		if err != nil {
+			if mongo.IsDuplicateKeyError(err) {
+				return "", provider.ErrConflict
+			}
+
+			return "", err
+		}
+
+		return strconv.FormatInt(newVersion, 10), nil
+	}
+
+	filter := bson.D{{Key: "_id", Value: key}, {Key: "version", Value: current.Version}}
+	update := bson.D{
+		{Key: "$set", Value: bson.D{{Key: "data", Value: newData}}},
+		{Key: "$inc", Value: bson.D{{Key: "version", Value: int64(1)}}},
+	}
+
+	var updated lockDocument
+	start = time.Now()
+	err = coll.FindOneAndUpdate(ctx, filter, update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&updated)
+	p.emit(ctx, "FindOneAndUpdate", bucket, key, start, err)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return "", provider.ErrConflict
+		}
+
+		return "", err
+	}
+
+	return strconv.FormatInt(updated.Version, 10), nil
+}
+
+func (p *Provider) DeleteObject(ctx context.Context, bucket, key, etag string) error {
+	version, err := strconv.ParseInt(etag, 10, 64)
+	if err != nil {
+		return provider.ErrConflict
+	}
+
+	start := time.Now()
+	res, err := p.db.Collection(bucket).DeleteOne(ctx,
+		bson.D{{Key: "_id", Value: key}, {Key: "version", Value: version}})
+	p.emit(ctx, "DeleteOne", bucket, key, start, err)
+	if err != nil {
+		return err
+	}
+
+	if res.DeletedCount == 0 {
+		return provider.ErrConflict
+	}
+
+	return nil
+}
+
+func (p *Provider) ListObjects(ctx context.Context, bucket, prefix string) ([]string, error) {
+	filter := bson.D{}
+	if prefix != "" {
+		filter = bson.D{{Key: "_id", Value: bson.D{{Key: "$regex", Value: "^" + regexp.QuoteMeta(prefix)}}}}
+	}
+
+	start := time.Now()
+	cursor, err := p.db.Collection(bucket).Find(ctx, filter, options.Find().
+		SetProjection(bson.D{{Key: "_id", Value: 1}}).
+		SetSort(bson.D{{Key: "_id", Value: 1}}))
+	p.emit(ctx, "Find", bucket, prefix, start, err)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var keys []string
+	for cursor.Next(ctx) {
+		var doc struct {
+			Key string `bson:"_id"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, doc.Key)
+	}
+
+	return keys, cursor.Err()
+}
+
+func (p *Provider) Capabilities() provider.Capabilities {
+	return provider.Capabilities{
+		ConditionalCreate:    true,
+		ConditionalDelete:    true,
+		StrongReadAfterWrite: true,
+	}
+}
+
+// emit emits a provider request event to the stats handler (if configured).
+func (p *Provider) emit(ctx context.Context, operation, bucket, key string, start time.Time, err error) {
+	if p.statsHandler == nil {
+		return
+	}
+
+	p.statsHandler.HandleEvent(ctx, stats.Event{
+		Type:      stats.EventProviderRequest,
+		Bucket:    bucket,
+		Key:       key,
+		Operation: operation,
+		Duration:  time.Since(start),
+		Err:       err,
+	})
+}
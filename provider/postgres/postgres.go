@@ -0,0 +1,266 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dpeckett/objsync/provider"
+	"github.com/dpeckett/objsync/stats"
+)
+
+// Option is a functional option for configuring a PostgreSQL provider.
+type Option func(*Provider)
+
+// WithTableName sets the name of the table that objects are stored in
+// (defaults to "objsync_objects").
+func WithTableName(tableName string) Option {
+	return func(p *Provider) {
+		p.tableName = tableName
+	}
+}
+
+// WithStatsHandler sets a handler that receives an event for every query
+// made to the database.
+func WithStatsHandler(h stats.Handler) Option {
+	return func(p *Provider) {
+		p.statsHandler = h
+	}
+}
+
+// Provider is a PostgreSQL provider. Objects are stored as rows in a single
+// table, keyed by bucket and key, along with a version number that is used
+// for compare-and-swap updates.
+type Provider struct {
+	db           *sql.DB
+	tableName    string
+	statsHandler stats.Handler
+}
+
+// NewProvider initializes a new PostgreSQL provider using the given database
+// handle (eg. opened with the pgx or lib/pq drivers). The objects table will
+// be created if it does not already exist.
+func NewProvider(ctx context.Context, db *sql.DB, opts ...Option) (provider.Provider, error) {
+	p := &Provider{
+		db:        db,
+		tableName: "objsync_objects",
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	_, err := p.db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	bucket TEXT NOT NULL,
+	key TEXT NOT NULL,
+	data BYTEA NOT NULL,
+	version BIGINT NOT NULL,
+	PRIMARY KEY (bucket, key)
+)`, p.table()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create objects table: %w", err)
+	}
+
+	return p, nil
+}
+
+func (p *Provider) AtomicUpdateObject(ctx context.Context, bucket, key string, fn provider.UpdateObjectFunc) (string, error) {
+	var currentVersion int64
+	var currentData []byte
+
+	start := time.Now()
+	err := p.db.QueryRowContext(ctx,
+		fmt.Sprintf(`SELECT data, version FROM %s WHERE bucket = $1 AND key = $2`, p.table()),
+		bucket, key).Scan(&currentData, &currentVersion)
+	p.emit(ctx, "Select", bucket, key, start, err)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+
+	var currentETag string
+	if currentVersion != 0 {
+		currentETag = strconv.FormatInt(currentVersion, 10)
+	}
+
+	newData, err := fn(currentETag, currentData)
+	if err != nil {
+		return "", err
+	}
+
+	var res sql.Result
+	newVersion := currentVersion + 1
+	start = time.Now()
+	if currentVersion == 0 {
+		newVersion = provider.InitialVersion()
+		res, err = p.db.ExecContext(ctx,
+			fmt.Sprintf(`INSERT INTO %s (bucket, key, data, version) VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING`, p.table()),
+			bucket, key, newData, newVersion)
+		p.emit(ctx, "Insert", bucket, key, start, err)
+	} else {
+		res, err = p.db.ExecContext(ctx,
+			fmt.Sprintf(`UPDATE %s SET data = $1, version = $2 WHERE bucket = $3 AND key = $4 AND version = $5`, p.table()),
+			newData, newVersion, bucket, key, currentVersion)
+		p.emit(ctx, "Update", bucket, key, start, err)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return "", err
+	}
+
+	if rowsAffected == 0 {
+		return "", provider.ErrConflict
+	}
+
+	return strconv.FormatInt(newVersion, 10), nil
+}
+
+func (p *Provider) DeleteObject(ctx context.Context, bucket, key, etag string) error {
+	version, err := strconv.ParseInt(etag, 10, 64)
+	if err != nil {
+		return provider.ErrConflict
+	}
+
+	start := time.Now()
+	res, err := p.db.ExecContext(ctx,
+		fmt.Sprintf(`DELETE FROM %s WHERE bucket = $1 AND key = $2 AND version = $3`, p.table()),
+		bucket, key, version)
+	p.emit(ctx, "Delete", bucket, key, start, err)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return provider.ErrConflict
+	}
+
+	return nil
+}
+
+func (p *Provider) GetObject(ctx context.Context, bucket, key string) ([]byte, string, error) {
+	var data []byte
+	var version int64
+
+	start := time.Now()
+	err := p.db.QueryRowContext(ctx,
+		fmt.Sprintf(`SELECT data, version FROM %s WHERE bucket = $1 AND key = $2`, p.table()),
+		bucket, key).Scan(&data, &version)
+	p.emit(ctx, "Select", bucket, key, start, err)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, "", nil
+		}
+
+		return nil, "", err
+	}
+
+	return data, strconv.FormatInt(version, 10), nil
+}
+
+func (p *Provider) HeadObject(ctx context.Context, bucket, key string) (string, error) {
+	var version int64
+
+	start := time.Now()
+	err := p.db.QueryRowContext(ctx,
+		fmt.Sprintf(`SELECT version FROM %s WHERE bucket = $1 AND key = $2`, p.table()),
+		bucket, key).Scan(&version)
+	p.emit(ctx, "Select", bucket, key, start, err)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil
+		}
+
+		return "", err
+	}
+
+	return strconv.FormatInt(version, 10), nil
+}
+
+func (p *Provider) ListObjects(ctx context.Context, bucket, prefix string) ([]string, error) {
+	start := time.Now()
+	rows, err := p.db.QueryContext(ctx,
+		fmt.Sprintf(`SELECT key FROM %s WHERE bucket = $1 AND substr(key, 1, length($2)) = $2 ORDER BY key`, p.table()),
+		bucket, prefix)
+	p.emit(ctx, "List", bucket, prefix, start, err)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// table returns the quoted name of the objects table.
+func (p *Provider) table() string {
+	return `"` + strings.ReplaceAll(p.tableName, `"`, `""`) + `"`
+}
+
+// Now returns the current time, according to the database server.
+func (p *Provider) Now(ctx context.Context) (time.Time, error) {
+	var now time.Time
+
+	start := time.Now()
+	err := p.db.QueryRowContext(ctx, `SELECT now()`).Scan(&now)
+	p.emit(ctx, "Now", "", "", start, err)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return now, nil
+}
+
+func (p *Provider) Capabilities() provider.Capabilities {
+	return provider.Capabilities{
+		ConditionalCreate:    true,
+		ConditionalDelete:    true,
+		ServerTime:           true,
+		StrongReadAfterWrite: true,
+	}
+}
+
+// emit emits a provider request event to the stats handler (if configured).
+func (p *Provider) emit(ctx context.Context, operation, bucket, key string, start time.Time, err error) {
+	if p.statsHandler == nil {
+		return
+	}
+
+	p.statsHandler.HandleEvent(ctx, stats.Event{
+		Type:      stats.EventProviderRequest,
+		Bucket:    bucket,
+		Key:       key,
+		Operation: operation,
+		Duration:  time.Since(start),
+		Err:       err,
+	})
+}
@@ -12,14 +12,172 @@ package provider
 import (
 	"context"
 	"fmt"
+	"math/rand/v2"
+	"time"
 )
 
 // ErrConflict is returned when a write conflict is detected,
 // this is expected when multiple clients are racing to acquire a mutex.
 var ErrConflict = fmt.Errorf("write conflict")
 
+// ErrNotSupported is returned when a provider does not support an operation.
+var ErrNotSupported = fmt.Errorf("operation not supported")
+
 type UpdateObjectFunc func(string, []byte) ([]byte, error)
 
 type Provider interface {
 	AtomicUpdateObject(ctx context.Context, bucket, key string, fn UpdateObjectFunc) (string, error)
 }
+
+// Getter is implemented by providers that support reading objects directly,
+// without the overhead of an update (eg. taking a write lock).
+type Getter interface {
+	// GetObject returns the content and ETag of an object. If the object
+	// doesn't exist, the content and ETag are empty.
+	GetObject(ctx context.Context, bucket, key string) ([]byte, string, error)
+}
+
+// Header is implemented by providers that support cheaply reading the ETag
+// of an object, without its content (eg. to poll for changes).
+type Header interface {
+	// HeadObject returns the ETag of an object, or an empty ETag if the object
+	// doesn't exist.
+	HeadObject(ctx context.Context, bucket, key string) (string, error)
+}
+
+// Deleter is implemented by providers that support conditionally deleting
+// objects.
+type Deleter interface {
+	// DeleteObject deletes an object, but only if its current ETag matches the
+	// given ETag, otherwise ErrConflict is returned.
+	DeleteObject(ctx context.Context, bucket, key, etag string) error
+}
+
+// Lister is implemented by providers that support listing objects.
+type Lister interface {
+	// ListObjects returns the keys of the objects in a bucket that start with
+	// the given prefix, in lexicographic order.
+	ListObjects(ctx context.Context, bucket, prefix string) ([]string, error)
+}
+
+// Event is a change to a watched object.
+type Event struct {
+	// ETag is the ETag of the object after the change (empty if the object
+	// doesn't exist).
+	ETag string
+	// Err is set if the watch failed, no more events are sent after an error.
+	Err error
+}
+
+// Watcher is implemented by providers that can notify clients of changes to
+// objects, so that clients don't have to poll for them.
+type Watcher interface {
+	// WatchObject watches an object for changes. An event is sent with the
+	// current ETag of the object once the watch is established, and after
+	// every change (changes in quick succession may be coalesced). The channel
+	// is closed when ctx is done, or after an error.
+	WatchObject(ctx context.Context, bucket, key string) <-chan Event
+}
+
+// Clock is implemented by providers that can report the current time,
+// according to the storage backend (eg. to check lock expiry against a clock
+// shared by all clients).
+type Clock interface {
+	// Now returns the current time, according to the storage backend.
+	Now(ctx context.Context) (time.Time, error)
+}
+
+// FenceEpocher is implemented by providers that may switch to a different
+// storage backend (eg. on failover), where the fencing tokens recorded in
+// lock objects would otherwise regress. Mutexes move into the reported fence
+// epoch, as if it had been set with objsync.WithFenceEpoch.
+type FenceEpocher interface {
+	// FenceEpoch returns the current fence epoch of the provider.
+	FenceEpoch() int64
+}
+
+// NowOf returns the current time according to the storage backend of a
+// provider, or ErrNotSupported if it can't report it (see Clock).
+func NowOf(ctx context.Context, p Provider) (time.Time, error) {
+	clock, ok := p.(Clock)
+	if !ok {
+		return time.Time{}, ErrNotSupported
+	}
+
+	return clock.Now(ctx)
+}
+
+// FenceEpochOf returns the fence epoch of a provider, or 0 if it doesn't
+// have one (see FenceEpocher).
+func FenceEpochOf(p Provider) int64 {
+	if epocher, ok := p.(FenceEpocher); ok {
+		return epocher.FenceEpoch()
+	}
+
+	return 0
+}
+
+// WatchError returns a watch that fails immediately with the given error (eg.
+// for providers wrapping a provider that can't watch objects).
+func WatchError(err error) <-chan Event {
+	events := make(chan Event, 1)
+	events <- Event{Err: err}
+	close(events)
+
+	return events
+}
+
+// Capabilities describes the guarantees made, and the optional operations
+// supported, by a provider.
+type Capabilities struct {
+	// ConditionalCreate is whether creating an object fails with ErrConflict
+	// if someone else created it first. Without it, racing creators can
+	// clobber each other.
+	ConditionalCreate bool
+	// ConditionalDelete is whether objects can be conditionally deleted (see
+	// Deleter).
+	ConditionalDelete bool
+	// Watch is whether objects can be watched for changes natively (see
+	// Watcher).
+	Watch bool
+	// ServerTime is whether the provider can report the current time
+	// according to the storage backend (see Clock).
+	ServerTime bool
+	// StrongReadAfterWrite is whether reads (see Getter and Header) always
+	// reflect the latest successful write. Without it, reads may be stale,
+	// and are only good as hints.
+	StrongReadAfterWrite bool
+}
+
+// CapabilityReporter is implemented by providers that can report their
+// capabilities.
+type CapabilityReporter interface {
+	// Capabilities returns the capabilities of the provider.
+	Capabilities() Capabilities
+}
+
+// CapabilitiesOf returns the capabilities of a provider. If the provider
+// doesn't report its capabilities, they are inferred from the optional
+// interfaces it implements, and no guarantees are assumed.
+func CapabilitiesOf(p Provider) Capabilities {
+	if reporter, ok := p.(CapabilityReporter); ok {
+		return reporter.Capabilities()
+	}
+
+	var caps Capabilities
+	_, caps.ConditionalDelete = p.(Deleter)
+	_, caps.Watch = p.(Watcher)
+	_, caps.ServerTime = p.(Clock)
+
+	return caps
+}
+
+// InitialVersion returns a random version for a newly created object, for
+// providers that derive ETags from a version counter. Were versions to
+// restart at 1, an object that was deleted and recreated would reuse the
+// ETags of its previous incarnation, so that a stale ETag could match the
+// new object (the ABA problem). The version leaves plenty of room to be
+// incremented.
+func InitialVersion() int64 {
+	return rand.Int64N(1<<62) + 1
+}
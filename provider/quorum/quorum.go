@@ -0,0 +1,404 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package quorum implements a provider that replicates objects across several
+// underlying providers (eg. object stores from different vendors), so that
+// locks don't depend on the consistency of any single one of them.
+//
+// Each replica stores a versioned envelope around the object, along with the
+// state it was derived from. An update reads the replicas, determines the
+// latest state that was (or, with replicas missing, might have been) accepted
+// by a majority of them, and conditionally writes the next version to each
+// replica in turn, succeeding only once a majority has accepted it (otherwise
+// the replicas that did accept it are rolled back). As updates are serialized
+// by the underlying conditional writes, the underlying providers must support
+// conditional creates.
+//
+// As only whole objects are replicated, fencing tokens (and everything else
+// stored in the object) stay monotonic as long as a majority of the replicas
+// survive.
+package quorum
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/dpeckett/objsync/provider"
+	"github.com/google/uuid"
+)
+
+// ErrNoQuorum is returned when a majority of the underlying providers could
+// not be read from, or written to.
+var ErrNoQuorum = errors.New("no quorum")
+
+// Provider is a provider that replicates objects across several underlying
+// providers, with updates succeeding once accepted by a majority of them.
+type Provider struct {
+	providers []provider.Provider
+	quorum    int
+}
+
+// NewProvider creates a provider that replicates objects across the given
+// providers. An odd number of providers (eg. three or five) should be used,
+// as a majority must be available for updates to succeed. Providers that
+// don't support conditional creates (see provider.Capabilities) are refused.
+func NewProvider(providers ...provider.Provider) (provider.Provider, error) {
+	if len(providers) == 0 {
+		return nil, fmt.Errorf("at least one provider must be specified")
+	}
+
+	for i, p := range providers {
+		if !provider.CapabilitiesOf(p).ConditionalCreate {
+			return nil, fmt.Errorf("provider %d doesn't support conditional creates", i)
+		}
+	}
+
+	return &Provider{
+		providers: providers,
+		quorum:    len(providers)/2 + 1,
+	}, nil
+}
+
+// state is a version of the object.
+type state struct {
+	Version int64  `json:"version,omitempty"`
+	Nonce   string `json:"nonce,omitempty"`
+	Data    []byte `json:"data,omitempty"`
+	Deleted bool   `json:"deleted,omitempty"`
+}
+
+// stateID uniquely identifies a state.
+type stateID struct {
+	version int64
+	nonce   string
+}
+
+func (s *state) id() stateID {
+	return stateID{version: s.Version, nonce: s.Nonce}
+}
+
+// etag returns the ETag of the state, or an empty ETag if the object doesn't
+// exist in this state.
+func (s *state) etag() string {
+	if s.Version == 0 || s.Deleted {
+		return ""
+	}
+
+	return strconv.FormatInt(s.Version, 10) + "-" + s.Nonce
+}
+
+// envelope is the object stored in each replica.
+type envelope struct {
+	state
+	// The state this state was derived from, which was accepted by a majority
+	// of the replicas.
+	Prev *state `json:"prev,omitempty"`
+}
+
+// replica is the result of reading a replica.
+type replica struct {
+	etag     string
+	data     []byte
+	envelope envelope
+	err      error
+}
+
+func (p *Provider) AtomicUpdateObject(ctx context.Context, bucket, key string, fn provider.UpdateObjectFunc) (string, error) {
+	replicas := p.read(ctx, bucket, key)
+
+	current, err := p.resolve(replicas)
+	if err != nil {
+		return "", err
+	}
+
+	var currentData []byte
+	if !current.Deleted {
+		currentData = current.Data
+	}
+
+	newData, err := fn(current.etag(), currentData)
+	if err != nil {
+		return "", err
+	}
+
+	next := p.next(current)
+	next.Data = newData
+
+	if err := p.write(ctx, bucket, key, replicas, next); err != nil {
+		return "", err
+	}
+
+	return next.etag(), nil
+}
+
+func (p *Provider) DeleteObject(ctx context.Context, bucket, key, etag string) error {
+	replicas := p.read(ctx, bucket, key)
+
+	current, err := p.resolve(replicas)
+	if err != nil {
+		return err
+	}
+
+	if etag == "" || current.etag() != etag {
+		return provider.ErrConflict
+	}
+
+	// Deletes are recorded as tombstones, so that the version history (which
+	// orders updates) is preserved.
+	next := p.next(current)
+	next.Deleted = true
+
+	return p.write(ctx, bucket, key, replicas, next)
+}
+
+func (p *Provider) GetObject(ctx context.Context, bucket, key string) ([]byte, string, error) {
+	current, err := p.resolve(p.read(ctx, bucket, key))
+	if err != nil {
+		return nil, "", err
+	}
+
+	if current.Deleted {
+		return nil, "", nil
+	}
+
+	return current.Data, current.etag(), nil
+}
+
+func (p *Provider) Capabilities() provider.Capabilities {
+	// Majority reads overlap majority writes, so only stale replicas can
+	// cause stale reads.
+	strongReadAfterWrite := true
+	for _, replicaProvider := range p.providers {
+		strongReadAfterWrite = strongReadAfterWrite && provider.CapabilitiesOf(replicaProvider).StrongReadAfterWrite
+	}
+
+	return provider.Capabilities{
+		ConditionalCreate:    true,
+		ConditionalDelete:    true,
+		StrongReadAfterWrite: strongReadAfterWrite,
+	}
+}
+
+// read reads every replica concurrently.
+func (p *Provider) read(ctx context.Context, bucket, key string) []replica {
+	replicas := make([]replica, len(p.providers))
+
+	var wg sync.WaitGroup
+	for i, replicaProvider := range p.providers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			r := &replicas[i]
+			r.etag, r.data, r.err = readReplica(ctx, replicaProvider, bucket, key)
+			if r.err == nil && len(r.data) > 0 {
+				if err := json.Unmarshal(r.data, &r.envelope); err != nil {
+					r.err = fmt.Errorf("malformed replica: %w", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	return replicas
+}
+
+// resolve determines the latest state accepted by a majority of the replicas.
+func (p *Provider) resolve(replicas []replica) (*state, error) {
+	var errs []error
+	counts := make(map[stateID]int)
+	accepted := make(map[stateID]*state)
+	for i := range replicas {
+		r := &replicas[i]
+		if r.err != nil {
+			errs = append(errs, r.err)
+			continue
+		}
+
+		counts[r.envelope.id()]++
+		if counts[r.envelope.id()] >= p.quorum {
+			accepted[r.envelope.id()] = &r.envelope.state
+		}
+
+		// Updates are only ever derived from accepted states.
+		if r.envelope.Prev != nil {
+			accepted[r.envelope.Prev.id()] = r.envelope.Prev
+		}
+	}
+
+	if len(replicas)-len(errs) < p.quorum {
+		return nil, fmt.Errorf("%w: %w", ErrNoQuorum, errors.Join(errs...))
+	}
+
+	latest := &state{}
+	for _, s := range accepted {
+		if s.Version > latest.Version {
+			latest = s
+		}
+	}
+
+	// Newer states that weren't accepted by a majority are the remains of
+	// failed updates (or of updates that are still in progress), and are
+	// overwritten by the next update. If a replica is missing though, it might
+	// have accepted the newest of them, so that state is adopted instead (and
+	// repaired by the next update), so as never to lose an update that might
+	// have succeeded. Should there be several such states of the same version,
+	// there's no telling which (if any) was accepted.
+	if len(errs) > 0 {
+		var newest *state
+		var ambiguous bool
+		for i := range replicas {
+			r := &replicas[i]
+			if r.err != nil || r.envelope.Version <= latest.Version {
+				continue
+			}
+
+			switch {
+			case newest == nil || r.envelope.Version > newest.Version:
+				newest = &r.envelope.state
+				ambiguous = false
+			case r.envelope.Version == newest.Version && r.envelope.id() != newest.id():
+				ambiguous = true
+			}
+		}
+
+		if ambiguous {
+			return nil, provider.ErrConflict
+		}
+
+		if newest != nil {
+			latest = newest
+		}
+	}
+
+	return latest, nil
+}
+
+// next returns the envelope for the state following the current state.
+func (p *Provider) next(current *state) *envelope {
+	next := &envelope{
+		state: state{
+			Version: current.Version + 1,
+			Nonce:   uuid.New().String(),
+		},
+	}
+
+	if current.Version > 0 {
+		prev := *current
+		next.Prev = &prev
+	}
+
+	return next
+}
+
+// write conditionally writes the next envelope to each replica in turn, on
+// the replica not having changed since it was read. If a majority of the
+// replicas don't accept it, the replicas that did are rolled back.
+func (p *Provider) write(ctx context.Context, bucket, key string, replicas []replica, next *envelope) error {
+	data, err := json.Marshal(next)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	var conflict bool
+	newETags := make([]string, len(replicas))
+	var accepted int
+	for i := range replicas {
+		r := &replicas[i]
+		if r.err != nil {
+			continue
+		}
+
+		newETags[i], err = p.providers[i].AtomicUpdateObject(ctx, bucket, key, func(currentETag string, _ []byte) ([]byte, error) {
+			if currentETag != r.etag {
+				return nil, provider.ErrConflict
+			}
+
+			return data, nil
+		})
+		if err != nil {
+			// Someone else is updating the object, so we've lost.
+			if errors.Is(err, provider.ErrConflict) {
+				conflict = true
+				break
+			}
+
+			errs = append(errs, err)
+			continue
+		}
+
+		accepted++
+	}
+
+	if accepted >= p.quorum {
+		return nil
+	}
+
+	p.rollback(ctx, bucket, key, replicas, newETags)
+
+	if conflict {
+		return provider.ErrConflict
+	}
+
+	return fmt.Errorf("%w: %w", ErrNoQuorum, errors.Join(errs...))
+}
+
+// rollback restores the replicas that accepted a failed update to their
+// previous state (if they haven't changed since). Replicas that can't be
+// rolled back are overwritten by the next successful update.
+func (p *Provider) rollback(ctx context.Context, bucket, key string, replicas []replica, newETags []string) {
+	for i, newETag := range newETags {
+		if newETag == "" {
+			continue
+		}
+
+		prevData := replicas[i].data
+		if len(prevData) == 0 {
+			// An empty envelope is equivalent to a missing object.
+			prevData = []byte("{}")
+		}
+
+		_, _ = p.providers[i].AtomicUpdateObject(ctx, bucket, key, func(currentETag string, _ []byte) ([]byte, error) {
+			if currentETag != newETag {
+				return nil, provider.ErrConflict
+			}
+
+			return prevData, nil
+		})
+	}
+}
+
+// readReplica reads the ETag and content of an object from a replica.
+func readReplica(ctx context.Context, p provider.Provider, bucket, key string) (string, []byte, error) {
+	if getter, ok := p.(provider.Getter); ok {
+		data, etag, err := getter.GetObject(ctx, bucket, key)
+		if !errors.Is(err, provider.ErrNotSupported) {
+			return etag, data, err
+		}
+	}
+
+	var errReadOnly = errors.New("read only")
+
+	var etag string
+	var data []byte
+	_, err := p.AtomicUpdateObject(ctx, bucket, key, func(currentETag string, currentData []byte) ([]byte, error) {
+		etag, data = currentETag, currentData
+		return nil, errReadOnly
+	})
+	if err != nil && !errors.Is(err, errReadOnly) {
+		return "", nil, err
+	}
+
+	return etag, data, nil
+}
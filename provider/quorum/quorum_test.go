@@ -0,0 +1,224 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package quorum_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/dpeckett/objsync/provider"
+	"github.com/dpeckett/objsync/provider/mem"
+	"github.com/dpeckett/objsync/provider/quorum"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
+)
+
+var errDown = errors.New("replica down")
+
+// replica is a provider that can be taken down.
+type replica struct {
+	provider.Provider
+	down atomic.Bool
+}
+
+func (r *replica) AtomicUpdateObject(ctx context.Context, bucket, key string, fn provider.UpdateObjectFunc) (string, error) {
+	if r.down.Load() {
+		return "", errDown
+	}
+
+	return r.Provider.AtomicUpdateObject(ctx, bucket, key, fn)
+}
+
+func (r *replica) Capabilities() provider.Capabilities {
+	return provider.CapabilitiesOf(r.Provider)
+}
+
+func newReplicas(n int) []*replica {
+	replicas := make([]*replica, n)
+	for i := range replicas {
+		replicas[i] = &replica{Provider: mem.NewProvider()}
+	}
+
+	return replicas
+}
+
+func newProvider(t *testing.T, replicas []*replica) provider.Provider {
+	providers := make([]provider.Provider, len(replicas))
+	for i, r := range replicas {
+		providers[i] = r
+	}
+
+	p, err := quorum.NewProvider(providers...)
+	require.NoError(t, err)
+
+	return p
+}
+
+func put(ctx context.Context, p provider.Provider, key, value string) (string, error) {
+	return p.AtomicUpdateObject(ctx, "bucket", key, func(_ string, _ []byte) ([]byte, error) {
+		return []byte(value), nil
+	})
+}
+
+func get(t *testing.T, ctx context.Context, p provider.Provider, key string) (string, string) {
+	data, etag, err := p.(provider.Getter).GetObject(ctx, "bucket", key)
+	require.NoError(t, err)
+
+	return string(data), etag
+}
+
+func TestProvider(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Update", func(t *testing.T) {
+		p := newProvider(t, newReplicas(3))
+
+		etag, err := put(ctx, p, "key", "hello")
+		require.NoError(t, err)
+
+		data, currentETag := get(t, ctx, p, "key")
+		require.Equal(t, "hello", data)
+		require.Equal(t, etag, currentETag)
+
+		require.ErrorIs(t, p.(provider.Deleter).DeleteObject(ctx, "bucket", "key", "1-stale"), provider.ErrConflict)
+		require.NoError(t, p.(provider.Deleter).DeleteObject(ctx, "bucket", "key", etag))
+
+		data, currentETag = get(t, ctx, p, "key")
+		require.Empty(t, data)
+		require.Empty(t, currentETag)
+	})
+
+	t.Run("ConcurrentWriters", func(t *testing.T) {
+		p := newProvider(t, newReplicas(3))
+
+		var g errgroup.Group
+		for i := 0; i < 10; i++ {
+			g.Go(func() error {
+				for {
+					_, err := p.AtomicUpdateObject(ctx, "bucket", "counter", func(_ string, currentData []byte) ([]byte, error) {
+						var n int
+						if len(currentData) > 0 {
+							var err error
+							if n, err = strconv.Atoi(string(currentData)); err != nil {
+								return nil, err
+							}
+						}
+
+						return []byte(strconv.Itoa(n + 1)), nil
+					})
+					if !errors.Is(err, provider.ErrConflict) {
+						return err
+					}
+				}
+			})
+		}
+		require.NoError(t, g.Wait())
+
+		data, _ := get(t, ctx, p, "counter")
+		require.Equal(t, "10", data)
+	})
+
+	t.Run("ReplicaDown", func(t *testing.T) {
+		replicas := newReplicas(3)
+		p := newProvider(t, replicas)
+
+		_, err := put(ctx, p, "key", "hello")
+		require.NoError(t, err)
+
+		// A minority of the replicas being down doesn't stop updates.
+		replicas[2].down.Store(true)
+
+		_, err = put(ctx, p, "key", "world")
+		require.NoError(t, err)
+
+		data, _ := get(t, ctx, p, "key")
+		require.Equal(t, "world", data)
+
+		// But a majority does.
+		replicas[1].down.Store(true)
+
+		_, err = put(ctx, p, "key", "again")
+		require.ErrorIs(t, err, quorum.ErrNoQuorum)
+
+		// The stale replica catches up with the next update.
+		replicas[1].down.Store(false)
+		replicas[2].down.Store(false)
+
+		data, _ = get(t, ctx, p, "key")
+		require.Equal(t, "world", data)
+
+		_, err = put(ctx, p, "key", "again")
+		require.NoError(t, err)
+
+		replicas[0].down.Store(true)
+
+		data, _ = get(t, ctx, p, "key")
+		require.Equal(t, "again", data)
+	})
+
+	t.Run("RollbackDebris", func(t *testing.T) {
+		replicas := newReplicas(3)
+		p := newProvider(t, replicas)
+
+		etag, err := put(ctx, p, "key", "hello")
+		require.NoError(t, err)
+
+		// Leave behind the remains of a failed update on one replica, as if
+		// its rollback had failed.
+		_, err = replicas[0].AtomicUpdateObject(ctx, "bucket", "key", func(_ string, currentData []byte) ([]byte, error) {
+			var envelope map[string]any
+			if err := json.Unmarshal(currentData, &envelope); err != nil {
+				return nil, err
+			}
+
+			prev := map[string]any{
+				"version": envelope["version"],
+				"nonce":   envelope["nonce"],
+				"data":    envelope["data"],
+			}
+
+			return json.Marshal(map[string]any{
+				"version": envelope["version"].(float64) + 1,
+				"nonce":   "debris",
+				"data":    []byte("debris"),
+				"prev":    prev,
+			})
+		})
+		require.NoError(t, err)
+
+		// With every replica available, the debris is known not to have been
+		// accepted.
+		data, currentETag := get(t, ctx, p, "key")
+		require.Equal(t, "hello", data)
+		require.Equal(t, etag, currentETag)
+
+		// With a replica missing, it might have been, so it's adopted.
+		replicas[2].down.Store(true)
+
+		data, _ = get(t, ctx, p, "key")
+		require.Equal(t, "debris", data)
+
+		_, err = p.AtomicUpdateObject(ctx, "bucket", "key", func(_ string, currentData []byte) ([]byte, error) {
+			require.Equal(t, "debris", string(currentData))
+
+			return []byte("world"), nil
+		})
+		require.NoError(t, err)
+
+		replicas[2].down.Store(false)
+
+		data, _ = get(t, ctx, p, "key")
+		require.Equal(t, "world", data)
+	})
+}
@@ -0,0 +1,305 @@
+//go:build ceph
+
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package rados implements a native Ceph RADOS provider, talking to the
+// cluster directly with librados rather than going through radosgw.
+//
+// This package requires cgo and the librados development headers, and is
+// only built with the "ceph" build tag.
+package rados
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ceph/go-ceph/rados"
+	"github.com/dpeckett/objsync/provider"
+	"github.com/dpeckett/objsync/stats"
+)
+
+// The omap key that object data is stored under.
+const dataOmapKey = "objsync.data"
+
+// Option is a functional option for configuring a RADOS provider.
+type Option func(*Provider)
+
+// WithNamespace sets the RADOS namespace that objects are stored in.
+func WithNamespace(namespace string) Option {
+	return func(p *Provider) {
+		p.namespace = namespace
+	}
+}
+
+// WithStatsHandler sets a handler that receives an event for every operation
+// made against the cluster.
+func WithStatsHandler(h stats.Handler) Option {
+	return func(p *Provider) {
+		p.statsHandler = h
+	}
+}
+
+// Provider is a Ceph RADOS provider. Buckets correspond to pools, and object
+// data is stored in the object's omap. Updates are conditioned on the RADOS
+// object version (which is used as the ETag).
+type Provider struct {
+	conn         *rados.Conn
+	namespace    string
+	statsHandler stats.Handler
+
+	mu     sync.Mutex
+	ioctxs map[string]*ioctx
+}
+
+// ioctx wraps an IO context, serializing operations so that the last object
+// version can be read back reliably.
+type ioctx struct {
+	mu sync.Mutex
+	*rados.IOContext
+}
+
+// NewProvider initializes a new RADOS provider using the given (connected)
+// cluster handle.
+func NewProvider(conn *rados.Conn, opts ...Option) provider.Provider {
+	p := &Provider{
+		conn:   conn,
+		ioctxs: make(map[string]*ioctx),
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+func (p *Provider) AtomicUpdateObject(ctx context.Context, bucket, key string, fn provider.UpdateObjectFunc) (string, error) {
+	ioctx, err := p.ioctx(bucket)
+	if err != nil {
+		return "", err
+	}
+
+	currentVersion, currentData, err := p.read(ctx, ioctx, bucket, key)
+	if err != nil {
+		return "", err
+	}
+
+	var currentETag string
+	if currentVersion != 0 {
+		currentETag = strconv.FormatUint(currentVersion, 10)
+	}
+
+	newData, err := fn(currentETag, currentData)
+	if err != nil {
+		return "", err
+	}
+
+	op := rados.CreateWriteOp()
+	defer op.Release()
+
+	if currentVersion == 0 {
+		op.Create(rados.CreateExclusive)
+	} else {
+		op.AssertVersion(currentVersion)
+	}
+	op.SetOmap(map[string][]byte{dataOmapKey: newData})
+
+	newVersion, err := p.write(ctx, ioctx, op, bucket, key)
+	if err != nil {
+		return "", err
+	}
+
+	return strconv.FormatUint(newVersion, 10), nil
+}
+
+func (p *Provider) DeleteObject(ctx context.Context, bucket, key, etag string) error {
+	version, err := strconv.ParseUint(etag, 10, 64)
+	if err != nil {
+		return provider.ErrConflict
+	}
+
+	ioctx, err := p.ioctx(bucket)
+	if err != nil {
+		return err
+	}
+
+	op := rados.CreateWriteOp()
+	defer op.Release()
+
+	op.AssertVersion(version)
+	op.Remove()
+
+	_, err = p.write(ctx, ioctx, op, bucket, key)
+	return err
+}
+
+func (p *Provider) ListObjects(ctx context.Context, bucket, prefix string) ([]string, error) {
+	ioctx, err := p.ioctx(bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	ioctx.mu.Lock()
+	defer ioctx.mu.Unlock()
+
+	// RADOS doesn't support listing by prefix, so the whole namespace is
+	// listed (in no particular order).
+	var keys []string
+	start := time.Now()
+	err = ioctx.ListObjects(func(oid string) {
+		if strings.HasPrefix(oid, prefix) {
+			keys = append(keys, oid)
+		}
+	})
+	p.emit(ctx, "ListObjects", bucket, prefix, start, err)
+	if err != nil {
+		return nil, err
+	}
+
+	slices.Sort(keys)
+
+	return keys, nil
+}
+
+// ioctx returns the (cached) IO context for the given pool.
+func (p *Provider) ioctx(pool string) (*ioctx, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if ioctx, ok := p.ioctxs[pool]; ok {
+		return ioctx, nil
+	}
+
+	radosIOCtx, err := p.conn.OpenIOContext(pool)
+	if err != nil {
+		return nil, err
+	}
+	radosIOCtx.SetNamespace(p.namespace)
+
+	p.ioctxs[pool] = &ioctx{IOContext: radosIOCtx}
+
+	return p.ioctxs[pool], nil
+}
+
+// read reads the current data and version of an object, if the object does
+// not exist a version of zero is returned.
+func (p *Provider) read(ctx context.Context, ioctx *ioctx, bucket, key string) (uint64, []byte, error) {
+	op := rados.CreateReadOp()
+	defer op.Release()
+
+	op.AssertExists()
+	step := op.GetOmapValuesByKeys([]string{dataOmapKey})
+
+	ioctx.mu.Lock()
+	defer ioctx.mu.Unlock()
+
+	start := time.Now()
+	err := op.Operate(ioctx.IOContext, key, rados.OperationNoFlag)
+	p.emit(ctx, "Read", bucket, key, start, err)
+	if err != nil {
+		if errors.Is(err, rados.ErrNotFound) {
+			return 0, nil, nil
+		}
+
+		return 0, nil, err
+	}
+
+	version, err := ioctx.GetLastVersion()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var data []byte
+	for {
+		kv, err := step.Next()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		if kv == nil {
+			break
+		}
+
+		if kv.Key == dataOmapKey {
+			data = kv.Value
+		}
+	}
+
+	return version, data, nil
+}
+
+// write performs a write operation, returning the new version of the object.
+func (p *Provider) write(ctx context.Context, ioctx *ioctx, op *rados.WriteOp, bucket, key string) (uint64, error) {
+	ioctx.mu.Lock()
+	defer ioctx.mu.Unlock()
+
+	start := time.Now()
+	err := op.Operate(ioctx.IOContext, key, rados.OperationNoFlag)
+	p.emit(ctx, "Write", bucket, key, start, err)
+	if err != nil {
+		if isConflict(err) {
+			return 0, provider.ErrConflict
+		}
+
+		return 0, err
+	}
+
+	return ioctx.GetLastVersion()
+}
+
+// isConflict returns whether a write failed due to a version assertion, or
+// an exclusive create, failing.
+func isConflict(err error) bool {
+	if errors.Is(err, rados.ErrObjectExists) || errors.Is(err, rados.ErrNotFound) {
+		return true
+	}
+
+	var codeErr interface{ ErrorCode() int }
+	if errors.As(err, &codeErr) {
+		// Version assertions fail with ERANGE if the object is newer, and
+		// EOVERFLOW if it is older than the asserted version.
+		switch codeErr.ErrorCode() {
+		case -int(syscall.ERANGE), -int(syscall.EOVERFLOW):
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *Provider) Capabilities() provider.Capabilities {
+	return provider.Capabilities{
+		ConditionalCreate:    true,
+		ConditionalDelete:    true,
+		StrongReadAfterWrite: true,
+	}
+}
+
+// emit emits a provider request event to the stats handler (if configured).
+func (p *Provider) emit(ctx context.Context, operation, bucket, key string, start time.Time, err error) {
+	if p.statsHandler == nil {
+		return
+	}
+
+	p.statsHandler.HandleEvent(ctx, stats.Event{
+		Type:      stats.EventProviderRequest,
+		Bucket:    bucket,
+		Key:       key,
+		Operation: operation,
+		Duration:  time.Since(start),
+		Err:       err,
+	})
+}
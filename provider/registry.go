@@ -0,0 +1,91 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+)
+
+// Opener opens a provider from a URL (see Open).
+type Opener func(ctx context.Context, u *url.URL) (Provider, error)
+
+var (
+	openersMu sync.RWMutex
+	openers   = make(map[string]Opener)
+)
+
+// Register makes a provider available by the given URL scheme (see Open).
+// Providers register their schemes when their package is imported, so
+// applications must import the providers they want to support, eg.
+//
+//	import _ "github.com/dpeckett/objsync/provider/s3"
+//
+// Register panics if the scheme is already registered.
+func Register(scheme string, opener Opener) {
+	openersMu.Lock()
+	defer openersMu.Unlock()
+
+	if _, ok := openers[scheme]; ok {
+		panic(fmt.Sprintf("provider: scheme %q registered twice", scheme))
+	}
+
+	openers[scheme] = opener
+}
+
+// Schemes returns the registered URL schemes, in sorted order.
+func Schemes() []string {
+	openersMu.RLock()
+	defer openersMu.RUnlock()
+
+	schemes := make([]string, 0, len(openers))
+	for scheme := range openers {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+
+	return schemes
+}
+
+// Open opens the provider identified by the given URL (eg.
+// "s3://bucket?region=eu-west-1"), so that the provider can be selected by
+// configuration. The scheme selects the provider, and the query parameters
+// configure it (see the documentation of each provider). It returns the
+// provider, and the bucket named by the host of the URL.
+func Open(ctx context.Context, rawURL string) (Provider, string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		// Don't leak any credentials in the url.
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+
+		return nil, "", fmt.Errorf("invalid provider url: %w", err)
+	}
+
+	openersMu.RLock()
+	opener, ok := openers[u.Scheme]
+	openersMu.RUnlock()
+	if !ok {
+		return nil, "", fmt.Errorf("unknown provider scheme %q (forgotten import?)", u.Scheme)
+	}
+
+	p, err := opener(ctx, u)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to open %s provider: %w", u.Scheme, err)
+	}
+
+	return p, u.Host, nil
+}
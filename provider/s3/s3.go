@@ -13,53 +13,395 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	awsretry "github.com/aws/aws-sdk-go-v2/aws/retry"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 	"github.com/aws/smithy-go"
 	smithymiddleware "github.com/aws/smithy-go/middleware"
 	smithyhttp "github.com/aws/smithy-go/transport/http"
 	"github.com/dpeckett/objsync/provider"
+	"github.com/dpeckett/objsync/stats"
 )
 
+func init() {
+	provider.Register("s3", open)
+}
+
+// WriteMode is how writes are made conditional on the current state of an
+// object.
+type WriteMode int
+
+const (
+	// WriteModeAuto uses native conditional writes for AWS S3 endpoints, and
+	// Ceph compatible conditional writes for everything else.
+	WriteModeAuto WriteMode = iota
+	// WriteModeNative uses If-None-Match: * when creating objects, and quoted
+	// If-Match ETags when updating them (as supported by AWS S3).
+	WriteModeNative
+	// WriteModeCeph uses unquoted If-Match ETags when updating objects, to
+	// work around a bug in Ceph's S3 API (https://tracker.ceph.com/issues/64439).
+	// Objects are created unconditionally, so racing creators can clobber each
+	// other.
+	WriteModeCeph
+)
+
+func (m WriteMode) String() string {
+	switch m {
+	case WriteModeNative:
+		return "Native"
+	case WriteModeCeph:
+		return "Ceph"
+	default:
+		return "Auto"
+	}
+}
+
+// Option is a functional option for configuring an S3 provider.
+type Option func(*Provider)
+
+// WithWriteMode sets how writes are made conditional (defaults to
+// WriteModeAuto, which picks a mode based on the endpoint).
+func WithWriteMode(mode WriteMode) Option {
+	return func(p *Provider) {
+		p.writeMode = mode
+	}
+}
+
+// WithConditionalDelete enables conditional (If-Match) deletes of objects.
+// Only enable this if the backend honors If-Match on DeleteObject (eg. AWS S3).
+func WithConditionalDelete() Option {
+	return func(p *Provider) {
+		p.conditionalDelete = true
+	}
+}
+
+// WithCacheControl sets the Cache-Control header of lock objects (eg. "no-store"),
+// this prevents CDNs from serving stale lock objects.
+func WithCacheControl(cacheControl string) Option {
+	return func(p *Provider) {
+		p.cacheControl = cacheControl
+	}
+}
+
+// WithContentType sets the Content-Type of lock objects (defaults to
+// "application/json").
+func WithContentType(contentType string) Option {
+	return func(p *Provider) {
+		p.contentType = contentType
+	}
+}
+
+// WithStorageClass sets the storage class of lock objects (eg. "STANDARD").
+func WithStorageClass(storageClass string) Option {
+	return func(p *Provider) {
+		p.storageClass = storageClass
+	}
+}
+
+// WithStatsHandler sets a handler that receives an event for every request
+// made to the object store.
+func WithStatsHandler(h stats.Handler) Option {
+	return func(p *Provider) {
+		p.statsHandler = h
+	}
+}
+
+// WithPathStyle sets whether buckets are addressed by path
+// (https://endpoint/bucket/key), rather than by virtual host
+// (https://bucket.endpoint/key). Defaults to virtual host addressing for AWS
+// S3, and path style addressing for everything else.
+func WithPathStyle(pathStyle bool) Option {
+	return func(p *Provider) {
+		p.pathStyle = &pathStyle
+	}
+}
+
+// WithHTTPClient sets the HTTP client used to make requests (eg. to use a
+// custom transport).
+func WithHTTPClient(client *http.Client) Option {
+	return func(p *Provider) {
+		p.httpClient = client
+	}
+}
+
+// WithMaxAttempts sets the maximum number of attempts made for each request,
+// including the first (defaults to 3, 1 disables retries). A conditional
+// write that is retried after its response was lost fails with a conflict,
+// which is safe, as callers re-read the object before trying again.
+func WithMaxAttempts(maxAttempts int) Option {
+	return func(p *Provider) {
+		p.maxAttempts = maxAttempts
+	}
+}
+
+// WithRetryer sets the retryer used for requests, replacing the SDK's
+// standard retryer (and WithMaxAttempts).
+func WithRetryer(retryer func() aws.Retryer) Option {
+	return func(p *Provider) {
+		p.retryer = retryer
+	}
+}
+
+// WithSSES3 encrypts lock objects at rest with S3 managed keys (SSE-S3).
+func WithSSES3() Option {
+	return func(p *Provider) {
+		p.sse = types.ServerSideEncryptionAes256
+		p.sseKMSKeyID = ""
+	}
+}
+
+// WithSSEKMS encrypts lock objects at rest with the given KMS key (SSE-KMS).
+// If the key ID is empty, the AWS managed key for S3 is used.
+func WithSSEKMS(keyID string) Option {
+	return func(p *Provider) {
+		p.sse = types.ServerSideEncryptionAwsKms
+		p.sseKMSKeyID = keyID
+	}
+}
+
+// WithAssumeRole assumes the given IAM role (with STS), using the credentials
+// the provider was constructed with to do so.
+func WithAssumeRole(roleARN string, optFns ...func(*stscreds.AssumeRoleOptions)) Option {
+	return func(p *Provider) {
+		p.credentials = func(cfg aws.Config) aws.CredentialsProvider {
+			return stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), roleARN, optFns...)
+		}
+	}
+}
+
+// WithWebIdentity assumes the given IAM role (with STS), using the web
+// identity token in the given file (eg. a Kubernetes service account token).
+// The token file is read again whenever the credentials are refreshed.
+//
+// The default credential chain (see NewProviderWithDefaultCredentials)
+// already does this when the AWS_ROLE_ARN and AWS_WEB_IDENTITY_TOKEN_FILE
+// environment variables are set (eg. on EKS).
+func WithWebIdentity(roleARN, tokenFile string, optFns ...func(*stscreds.WebIdentityRoleOptions)) Option {
+	return func(p *Provider) {
+		p.credentials = func(cfg aws.Config) aws.CredentialsProvider {
+			return stscreds.NewWebIdentityRoleProvider(sts.NewFromConfig(cfg), roleARN,
+				stscreds.IdentityTokenFile(tokenFile), optFns...)
+		}
+	}
+}
+
 type Provider struct {
-	client *s3.Client
+	client            *s3.Client
+	credentials       func(aws.Config) aws.CredentialsProvider
+	pathStyle         *bool
+	httpClient        *http.Client
+	maxAttempts       int
+	retryer           func() aws.Retryer
+	sse               types.ServerSideEncryption
+	sseKMSKeyID       string
+	statsHandler      stats.Handler
+	writeMode         WriteMode
+	conditionalDelete bool
+	cacheControl      string
+	contentType       string
+	storageClass      string
 }
 
-func NewProvider(ctx context.Context, endpointURL, region, accessKeyID, secretAccessKey string) (provider.Provider, error) {
-	customResolver := aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...any) (aws.Endpoint, error) {
-		return aws.Endpoint{
-			URL:           endpointURL,
-			SigningRegion: region,
-		}, nil
-	})
+func NewProvider(ctx context.Context, endpointURL, region, accessKeyID, secretAccessKey string, opts ...Option) (provider.Provider, error) {
+	return newProvider(ctx, endpointURL, region,
+		credentials.NewStaticCredentialsProvider(accessKeyID, secretAccessKey, ""), opts...)
+}
+
+// NewProviderWithDefaultCredentials creates a provider that uses the default
+// AWS credential chain (environment variables, shared config and credential
+// files, SSO, web identity tokens, and EC2/ECS instance roles), rather than
+// static keys. If the endpoint URL is empty, the AWS S3 endpoint for the
+// region is used, and if the region is empty, it is taken from the shared
+// config.
+func NewProviderWithDefaultCredentials(ctx context.Context, endpointURL, region string, opts ...Option) (provider.Provider, error) {
+	return newProvider(ctx, endpointURL, region, nil, opts...)
+}
+
+func newProvider(ctx context.Context, endpointURL, region string, creds aws.CredentialsProvider, opts ...Option) (provider.Provider, error) {
+	p := &Provider{
+		contentType: "application/json",
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	var loadOpts []func(*config.LoadOptions) error
+	if endpointURL != "" {
+		customResolver := aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...any) (aws.Endpoint, error) {
+			return aws.Endpoint{
+				URL:           endpointURL,
+				SigningRegion: region,
+			}, nil
+		})
 
-	cfg, err := config.LoadDefaultConfig(ctx,
-		config.WithEndpointResolverWithOptions(customResolver),
-		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(accessKeyID, secretAccessKey, "")),
-		config.WithRegion(region))
+		loadOpts = append(loadOpts, config.WithEndpointResolverWithOptions(customResolver))
+	}
+	if creds != nil {
+		loadOpts = append(loadOpts, config.WithCredentialsProvider(creds))
+	}
+	if region != "" {
+		loadOpts = append(loadOpts, config.WithRegion(region))
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx, loadOpts...)
 	if err != nil {
 		return nil, err
 	}
 
-	client := s3.NewFromConfig(cfg, func(options *s3.Options) {
-		options.UsePathStyle = true
-		options.Retryer = awsretry.AddWithMaxAttempts(awsretry.NewStandard(), 0)
+	if p.credentials != nil {
+		cfg.Credentials = aws.NewCredentialsCache(p.credentials(cfg))
+	}
+
+	if p.httpClient != nil {
+		cfg.HTTPClient = p.httpClient
+	}
+
+	isAWS := endpointURL == "" || isAWSEndpoint(endpointURL)
+
+	p.client = s3.NewFromConfig(cfg, func(options *s3.Options) {
+		options.UsePathStyle = !isAWS
+		if p.pathStyle != nil {
+			options.UsePathStyle = *p.pathStyle
+		}
+
+		if p.retryer != nil {
+			options.Retryer = p.retryer()
+		} else if p.maxAttempts > 0 {
+			options.Retryer = awsretry.AddWithMaxAttempts(awsretry.NewStandard(), p.maxAttempts)
+		} else {
+			options.Retryer = awsretry.NewStandard()
+		}
 	})
 
-	return &Provider{client: client}, nil
+	if p.writeMode == WriteModeAuto {
+		p.writeMode = WriteModeCeph
+		if isAWS {
+			p.writeMode = WriteModeNative
+		}
+	}
+
+	return p, nil
+}
+
+// open opens an S3 provider from a URL, eg.
+// "s3://accessKeyID:secretAccessKey@bucket?endpoint=https://...". The
+// credentials default to the default AWS credential chain, the region (the
+// region parameter) to AWS_REGION, or "us-east-1", and the endpoint (the
+// endpoint parameter) to AWS_ENDPOINT_URL_S3, or AWS S3 in the region. The
+// roleARN, writeMode ("auto", "native" or "ceph"), conditionalDelete,
+// cacheControl, storageClass, pathStyle, maxAttempts, and sse ("s3" or "kms",
+// with sseKMSKeyID) parameters set the corresponding options.
+func open(ctx context.Context, u *url.URL) (provider.Provider, error) {
+	query := u.Query()
+
+	region := query.Get("region")
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	endpointURL := query.Get("endpoint")
+	if endpointURL == "" {
+		endpointURL = os.Getenv("AWS_ENDPOINT_URL_S3")
+	}
+	if endpointURL == "" {
+		endpointURL = "https://s3." + region + ".amazonaws.com"
+	}
+
+	var opts []Option
+	if roleARN := query.Get("roleARN"); roleARN != "" {
+		opts = append(opts, WithAssumeRole(roleARN))
+	}
+
+	switch strings.ToLower(query.Get("writeMode")) {
+	case "", "auto":
+	case "native":
+		opts = append(opts, WithWriteMode(WriteModeNative))
+	case "ceph":
+		opts = append(opts, WithWriteMode(WriteModeCeph))
+	default:
+		return nil, fmt.Errorf("invalid write mode %q", query.Get("writeMode"))
+	}
+
+	if query.Has("conditionalDelete") {
+		conditionalDelete, err := strconv.ParseBool(query.Get("conditionalDelete"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid conditionalDelete: %w", err)
+		}
+
+		if conditionalDelete {
+			opts = append(opts, WithConditionalDelete())
+		}
+	}
+
+	if cacheControl := query.Get("cacheControl"); cacheControl != "" {
+		opts = append(opts, WithCacheControl(cacheControl))
+	}
+
+	if storageClass := query.Get("storageClass"); storageClass != "" {
+		opts = append(opts, WithStorageClass(storageClass))
+	}
+
+	if query.Has("pathStyle") {
+		pathStyle, err := strconv.ParseBool(query.Get("pathStyle"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid pathStyle: %w", err)
+		}
+
+		opts = append(opts, WithPathStyle(pathStyle))
+	}
+
+	if query.Has("maxAttempts") {
+		maxAttempts, err := strconv.Atoi(query.Get("maxAttempts"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid maxAttempts: %w", err)
+		}
+
+		opts = append(opts, WithMaxAttempts(maxAttempts))
+	}
+
+	switch strings.ToLower(query.Get("sse")) {
+	case "":
+	case "s3", strings.ToLower(string(types.ServerSideEncryptionAes256)):
+		opts = append(opts, WithSSES3())
+	case "kms", string(types.ServerSideEncryptionAwsKms):
+		opts = append(opts, WithSSEKMS(query.Get("sseKMSKeyID")))
+	default:
+		return nil, fmt.Errorf("invalid sse %q", query.Get("sse"))
+	}
+
+	if u.User != nil {
+		secretAccessKey, _ := u.User.Password()
+		return NewProvider(ctx, endpointURL, region, u.User.Username(), secretAccessKey, opts...)
+	}
+
+	return NewProviderWithDefaultCredentials(ctx, endpointURL, region, opts...)
 }
 
 func (p *Provider) AtomicUpdateObject(ctx context.Context, bucket, key string, fn provider.UpdateObjectFunc) (string, error) {
+	start := time.Now()
 	getResp, err := p.client.GetObject(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(bucket),
 		Key:    aws.String(key),
 	})
+	p.emit(ctx, "GetObject", bucket, key, start, err)
 	if err != nil {
 		var apiErr smithy.APIError
 		if errors.As(err, &apiErr) && apiErr.ErrorCode() != "NoSuchKey" {
@@ -87,28 +429,45 @@ func (p *Provider) AtomicUpdateObject(ctx context.Context, bucket, key string, f
 		return "", err
 	}
 
-	putResp, err := p.client.PutObject(ctx, &s3.PutObjectInput{
+	putInput := &s3.PutObjectInput{
 		Bucket:      aws.String(bucket),
 		Key:         aws.String(key),
 		Body:        bytes.NewReader(newData),
-		ContentType: aws.String("application/json"),
-	}, func(options *s3.Options) {
+		ContentType: aws.String(p.contentType),
+	}
+	if p.cacheControl != "" {
+		putInput.CacheControl = aws.String(p.cacheControl)
+	}
+	if p.storageClass != "" {
+		putInput.StorageClass = types.StorageClass(p.storageClass)
+	}
+	if p.sse != "" {
+		putInput.ServerSideEncryption = p.sse
+	}
+	if p.sseKMSKeyID != "" {
+		putInput.SSEKMSKeyId = aws.String(p.sseKMSKeyID)
+	}
+
+	start = time.Now()
+	putResp, err := p.client.PutObject(ctx, putInput, func(options *s3.Options) {
 		options.APIOptions = []func(*smithymiddleware.Stack) error{
 			func(stack *smithymiddleware.Stack) error {
 				if currentETag != "" {
-					// You might ask why the ETag is not wrapped in quotes like the spec says it should be.
-					// This is because of a bug in Ceph's S3 API implementation: https://tracker.ceph.com/issues/64439
-					// Other providers seem to be tolerant of this, so we'll just go with it.
-					return smithyhttp.AddHeaderValue("If-Match", currentETag)(stack)
+					return smithyhttp.AddHeaderValue("If-Match", p.ifMatch(currentETag))(stack)
+				}
+
+				// First writer wins.
+				if p.writeMode == WriteModeNative {
+					return smithyhttp.AddHeaderValue("If-None-Match", "*")(stack)
 				}
 
 				return nil
 			},
 		}
 	})
+	p.emit(ctx, "PutObject", bucket, key, start, err)
 	if err != nil {
-		var apiErr smithy.APIError
-		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "PreconditionFailed" {
+		if isConflict(err) {
 			return "", provider.ErrConflict
 		}
 
@@ -117,3 +476,160 @@ func (p *Provider) AtomicUpdateObject(ctx context.Context, bucket, key string, f
 
 	return strings.Trim(*putResp.ETag, "\""), nil
 }
+
+func (p *Provider) DeleteObject(ctx context.Context, bucket, key, etag string) error {
+	if !p.conditionalDelete {
+		return provider.ErrNotSupported
+	}
+
+	start := time.Now()
+	_, err := p.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}, func(options *s3.Options) {
+		options.APIOptions = append(options.APIOptions, smithyhttp.AddHeaderValue("If-Match", p.ifMatch(etag)))
+	})
+	p.emit(ctx, "DeleteObject", bucket, key, start, err)
+	if err != nil {
+		var apiErr smithy.APIError
+		if isConflict(err) || (errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchKey") {
+			return provider.ErrConflict
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+func (p *Provider) GetObject(ctx context.Context, bucket, key string) ([]byte, string, error) {
+	start := time.Now()
+	resp, err := p.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	p.emit(ctx, "GetObject", bucket, key, start, err)
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchKey" {
+			return nil, "", nil
+		}
+
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return data, strings.Trim(aws.ToString(resp.ETag), "\""), nil
+}
+
+func (p *Provider) HeadObject(ctx context.Context, bucket, key string) (string, error) {
+	start := time.Now()
+	resp, err := p.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	p.emit(ctx, "HeadObject", bucket, key, start, err)
+	if err != nil {
+		// HEAD responses have no body, so there is no error code to go by.
+		var notFound *types.NotFound
+		var respErr *smithyhttp.ResponseError
+		if errors.As(err, &notFound) || (errors.As(err, &respErr) && respErr.HTTPStatusCode() == 404) {
+			return "", nil
+		}
+
+		return "", err
+	}
+
+	return strings.Trim(aws.ToString(resp.ETag), "\""), nil
+}
+
+func (p *Provider) ListObjects(ctx context.Context, bucket, prefix string) ([]string, error) {
+	var keys []string
+
+	paginator := s3.NewListObjectsV2Paginator(p.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		start := time.Now()
+		page, err := paginator.NextPage(ctx)
+		p.emit(ctx, "ListObjectsV2", bucket, prefix, start, err)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, obj := range page.Contents {
+			keys = append(keys, aws.ToString(obj.Key))
+		}
+	}
+
+	return keys, nil
+}
+
+// ifMatch returns the If-Match header value for the given ETag.
+func (p *Provider) ifMatch(etag string) string {
+	// Ceph compares If-Match against the unquoted ETag, even though the spec
+	// says it should be quoted (other providers seem to be tolerant of this).
+	if p.writeMode == WriteModeCeph {
+		return etag
+	}
+
+	return `"` + etag + `"`
+}
+
+func (p *Provider) Capabilities() provider.Capabilities {
+	return provider.Capabilities{
+		ConditionalCreate:    p.writeMode == WriteModeNative,
+		ConditionalDelete:    p.conditionalDelete,
+		StrongReadAfterWrite: true,
+	}
+}
+
+// emit emits a provider request event to the stats handler (if configured).
+func (p *Provider) emit(ctx context.Context, operation, bucket, key string, start time.Time, err error) {
+	if p.statsHandler == nil {
+		return
+	}
+
+	p.statsHandler.HandleEvent(ctx, stats.Event{
+		Type:      stats.EventProviderRequest,
+		Bucket:    bucket,
+		Key:       key,
+		Operation: operation,
+		Duration:  time.Since(start),
+		Err:       err,
+	})
+}
+
+// isAWSEndpoint returns whether the endpoint is AWS S3 (which supports native
+// conditional writes).
+func isAWSEndpoint(endpointURL string) bool {
+	u, err := url.Parse(endpointURL)
+	if err != nil {
+		return false
+	}
+
+	host := u.Hostname()
+	return strings.HasSuffix(host, ".amazonaws.com") || strings.HasSuffix(host, ".amazonaws.com.cn")
+}
+
+// isConflict returns whether a write failed due to a precondition failing, or
+// a concurrent conditional write to the same object.
+func isConflict(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+
+	switch apiErr.ErrorCode() {
+	case "PreconditionFailed", "ConditionalRequestConflict":
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,331 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package sftp implements a provider for legacy environments whose only
+// shared storage is an SFTP server.
+//
+// SFTP has no conditional writes, so each update is made while holding a
+// short-lived guard file (created exclusively). Objects are written to a
+// temporary file and renamed into place, and their version is tracked in a
+// sidecar file that is used as the ETag.
+package sftp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dpeckett/objsync/provider"
+	"github.com/dpeckett/objsync/stats"
+	"github.com/pkg/sftp"
+)
+
+// The suffixes of the files used to track each object.
+const (
+	guardSuffix   = ".objsync-guard"
+	versionSuffix = ".objsync-version"
+	tempSuffix    = ".objsync-tmp-"
+)
+
+// Option is a functional option for configuring a SFTP provider.
+type Option func(*Provider)
+
+// WithStaleGuardTimeout sets how old a guard file must be before it is assumed
+// to have been left behind by a crashed client, and is removed (defaults to
+// 30 seconds).
+func WithStaleGuardTimeout(timeout time.Duration) Option {
+	return func(p *Provider) {
+		p.staleGuardTimeout = timeout
+	}
+}
+
+// WithStatsHandler sets a handler that receives an event for every update
+// made to the SFTP server.
+func WithStatsHandler(h stats.Handler) Option {
+	return func(p *Provider) {
+		p.statsHandler = h
+	}
+}
+
+// Provider is a SFTP provider. Buckets correspond to directories on the
+// server (which must already exist).
+type Provider struct {
+	client            *sftp.Client
+	staleGuardTimeout time.Duration
+	statsHandler      stats.Handler
+}
+
+// NewProvider initializes a new SFTP provider using the given client.
+func NewProvider(client *sftp.Client, opts ...Option) provider.Provider {
+	p := &Provider{
+		client:            client,
+		staleGuardTimeout: 30 * time.Second,
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+func (p *Provider) AtomicUpdateObject(ctx context.Context, bucket, key string, fn provider.UpdateObjectFunc) (etag string, err error) {
+	start := time.Now()
+	defer func() {
+		p.emit(ctx, "AtomicUpdateObject", bucket, key, start, err)
+	}()
+
+	objectPath := path.Join(bucket, key)
+
+	release, err := p.guard(objectPath)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	currentVersion, err := p.readVersion(objectPath)
+	if err != nil {
+		return "", err
+	}
+
+	var currentETag string
+	var currentData []byte
+	if currentVersion != 0 {
+		currentETag = strconv.FormatInt(currentVersion, 10)
+
+		currentData, err = p.readFile(objectPath)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	newData, err := fn(currentETag, currentData)
+	if err != nil {
+		return "", err
+	}
+
+	// Bump the version first, so that a crash part way through the update
+	// results in a spurious version change, rather than a stale ETag.
+	newVersion := currentVersion + 1
+	if err := p.writeFile(objectPath+versionSuffix, []byte(strconv.FormatInt(newVersion, 10))); err != nil {
+		return "", err
+	}
+
+	if err := p.writeFile(objectPath, newData); err != nil {
+		return "", err
+	}
+
+	return strconv.FormatInt(newVersion, 10), nil
+}
+
+func (p *Provider) DeleteObject(ctx context.Context, bucket, key, etag string) (err error) {
+	start := time.Now()
+	defer func() {
+		p.emit(ctx, "DeleteObject", bucket, key, start, err)
+	}()
+
+	objectPath := path.Join(bucket, key)
+
+	release, err := p.guard(objectPath)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	currentVersion, err := p.readVersion(objectPath)
+	if err != nil {
+		return err
+	}
+
+	if currentVersion == 0 || strconv.FormatInt(currentVersion, 10) != etag {
+		return provider.ErrConflict
+	}
+
+	if err := p.client.Remove(objectPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if err := p.client.Remove(objectPath + versionSuffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+func (p *Provider) ListObjects(ctx context.Context, bucket, prefix string) (keys []string, err error) {
+	start := time.Now()
+	defer func() {
+		p.emit(ctx, "ListObjects", bucket, prefix, start, err)
+	}()
+
+	// An object exists for as long as its version file does.
+	walker := p.client.Walk(bucket)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			if walker.Path() == bucket && errors.Is(err, os.ErrNotExist) {
+				return nil, nil
+			}
+
+			return nil, err
+		}
+
+		rel := strings.TrimPrefix(strings.TrimPrefix(walker.Path(), bucket), "/")
+		if walker.Stat().IsDir() {
+			// Skip directories that can't contain any matching objects.
+			if rel != "" && !strings.HasPrefix(rel, prefix) && !strings.HasPrefix(prefix, rel+"/") {
+				walker.SkipDir()
+			}
+
+			continue
+		}
+
+		key, ok := strings.CutSuffix(rel, versionSuffix)
+		if ok && strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+
+	// Walks are in lexical order of each directory, rather than of the keys.
+	slices.Sort(keys)
+
+	return keys, nil
+}
+
+// guard exclusively creates the guard file for an object, returning a function
+// that removes it again. If the guard is held by another client,
+// provider.ErrConflict is returned.
+func (p *Provider) guard(objectPath string) (func(), error) {
+	guardPath := objectPath + guardSuffix
+
+	f, err := p.client.OpenFile(guardPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		// SFTP servers don't reliably report why an exclusive create failed.
+		fi, statErr := p.client.Stat(guardPath)
+		if statErr != nil {
+			return nil, err
+		}
+
+		if time.Since(fi.ModTime()) > p.staleGuardTimeout {
+			_ = p.client.Remove(guardPath)
+		}
+
+		return nil, provider.ErrConflict
+	}
+	_ = f.Close()
+
+	return func() {
+		_ = p.client.Remove(guardPath)
+	}, nil
+}
+
+// readVersion reads the version of an object from its sidecar file, if the
+// object does not exist a version of zero is returned.
+func (p *Provider) readVersion(objectPath string) (int64, error) {
+	data, err := p.readFile(objectPath + versionSuffix)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+
+		return 0, err
+	}
+
+	version, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("malformed version file: %w", err)
+	}
+
+	return version, nil
+}
+
+func (p *Provider) readFile(filePath string) ([]byte, error) {
+	f, err := p.client.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return io.ReadAll(f)
+}
+
+// writeFile atomically replaces a file, by writing to a temporary file and
+// renaming it into place.
+func (p *Provider) writeFile(filePath string, data []byte) error {
+	var suffix [8]byte
+	if _, err := rand.Read(suffix[:]); err != nil {
+		return err
+	}
+
+	tempPath := filePath + tempSuffix + hex.EncodeToString(suffix[:])
+
+	f, err := p.client.OpenFile(tempPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		_ = p.client.Remove(tempPath)
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		_ = p.client.Remove(tempPath)
+		return err
+	}
+
+	if err := p.client.PosixRename(tempPath, filePath); err != nil {
+		// Fallback for servers without the posix-rename extension, plain
+		// SFTP renames fail if the target exists (but we hold the guard).
+		if removeErr := p.client.Remove(filePath); removeErr != nil && !errors.Is(removeErr, os.ErrNotExist) {
+			_ = p.client.Remove(tempPath)
+			return err
+		}
+
+		if err := p.client.Rename(tempPath, filePath); err != nil {
+			_ = p.client.Remove(tempPath)
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *Provider) Capabilities() provider.Capabilities {
+	return provider.Capabilities{
+		ConditionalCreate:    true,
+		ConditionalDelete:    true,
+		StrongReadAfterWrite: true,
+	}
+}
+
+// emit emits a provider request event to the stats handler (if configured).
+func (p *Provider) emit(ctx context.Context, operation, bucket, key string, start time.Time, err error) {
+	if p.statsHandler == nil {
+		return
+	}
+
+	p.statsHandler.HandleEvent(ctx, stats.Event{
+		Type:      stats.EventProviderRequest,
+		Bucket:    bucket,
+		Key:       key,
+		Operation: operation,
+		Duration:  time.Since(start),
+		Err:       err,
+	})
+}
@@ -0,0 +1,318 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dpeckett/objsync/provider"
+	"github.com/dpeckett/objsync/stats"
+)
+
+// Option is a functional option for configuring a SQLite provider.
+type Option func(*Provider)
+
+// WithTableName sets the name of the table that objects are stored in
+// (defaults to "objsync_objects").
+func WithTableName(tableName string) Option {
+	return func(p *Provider) {
+		p.tableName = tableName
+	}
+}
+
+// WithBusyTimeout sets how long to wait for another process to release its
+// write lock on the database before giving up (defaults to 5 seconds).
+func WithBusyTimeout(timeout time.Duration) Option {
+	return func(p *Provider) {
+		p.busyTimeout = timeout
+	}
+}
+
+// WithStatsHandler sets a handler that receives an event for every query
+// made to the database.
+func WithStatsHandler(h stats.Handler) Option {
+	return func(p *Provider) {
+		p.statsHandler = h
+	}
+}
+
+// Provider is a SQLite provider, intended for coordinating multiple processes
+// on a single host (eg. edge and IoT deployments). Each update runs inside
+// an immediate transaction, so updates to the database are serialized and
+// the version check is only there as a safety net.
+type Provider struct {
+	db           *sql.DB
+	tableName    string
+	busyTimeout  time.Duration
+	statsHandler stats.Handler
+}
+
+// NewProvider initializes a new SQLite provider using the given database
+// handle (eg. opened with the mattn/go-sqlite3 or modernc.org/sqlite drivers).
+// The objects table will be created if it does not already exist.
+func NewProvider(ctx context.Context, db *sql.DB, opts ...Option) (provider.Provider, error) {
+	p := &Provider{
+		db:          db,
+		tableName:   "objsync_objects",
+		busyTimeout: 5 * time.Second,
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	_, err := p.db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	bucket TEXT NOT NULL,
+	key TEXT NOT NULL,
+	data BLOB NOT NULL,
+	version INTEGER NOT NULL,
+	PRIMARY KEY (bucket, key)
+)`, p.table()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create objects table: %w", err)
+	}
+
+	return p, nil
+}
+
+func (p *Provider) AtomicUpdateObject(ctx context.Context, bucket, key string, fn provider.UpdateObjectFunc) (etag string, err error) {
+	conn, err := p.begin(ctx, bucket, key)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		err = p.end(ctx, conn, bucket, key, err)
+	}()
+
+	var currentVersion int64
+	var currentData []byte
+
+	start := time.Now()
+	err = conn.QueryRowContext(ctx,
+		fmt.Sprintf(`SELECT data, version FROM %s WHERE bucket = ? AND key = ?`, p.table()),
+		bucket, key).Scan(&currentData, &currentVersion)
+	p.emit(ctx, "Select", bucket, key, start, err)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+
+	var currentETag string
+	if currentVersion != 0 {
+		currentETag = strconv.FormatInt(currentVersion, 10)
+	}
+
+	newData, err := fn(currentETag, currentData)
+	if err != nil {
+		return "", err
+	}
+
+	var res sql.Result
+	newVersion := currentVersion + 1
+	start = time.Now()
+	if currentVersion == 0 {
+		newVersion = provider.InitialVersion()
+		res, err = conn.ExecContext(ctx,
+			fmt.Sprintf(`INSERT INTO %s (bucket, key, data, version) VALUES (?, ?, ?, ?) ON CONFLICT DO NOTHING`, p.table()),
+			bucket, key, newData, newVersion)
+		p.emit(ctx, "Insert", bucket, key, start, err)
+	} else {
+		res, err = conn.ExecContext(ctx,
+			fmt.Sprintf(`UPDATE %s SET data = ?, version = ? WHERE bucket = ? AND key = ? AND version = ?`, p.table()),
+			newData, newVersion, bucket, key, currentVersion)
+		p.emit(ctx, "Update", bucket, key, start, err)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return "", err
+	}
+
+	if rowsAffected == 0 {
+		return "", provider.ErrConflict
+	}
+
+	return strconv.FormatInt(newVersion, 10), nil
+}
+
+func (p *Provider) DeleteObject(ctx context.Context, bucket, key, etag string) error {
+	version, err := strconv.ParseInt(etag, 10, 64)
+	if err != nil {
+		return provider.ErrConflict
+	}
+
+	start := time.Now()
+	res, err := p.db.ExecContext(ctx,
+		fmt.Sprintf(`DELETE FROM %s WHERE bucket = ? AND key = ? AND version = ?`, p.table()),
+		bucket, key, version)
+	p.emit(ctx, "Delete", bucket, key, start, err)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return provider.ErrConflict
+	}
+
+	return nil
+}
+
+func (p *Provider) GetObject(ctx context.Context, bucket, key string) ([]byte, string, error) {
+	var data []byte
+	var version int64
+
+	start := time.Now()
+	err := p.db.QueryRowContext(ctx,
+		fmt.Sprintf(`SELECT data, version FROM %s WHERE bucket = ? AND key = ?`, p.table()),
+		bucket, key).Scan(&data, &version)
+	p.emit(ctx, "Select", bucket, key, start, err)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, "", nil
+		}
+
+		return nil, "", err
+	}
+
+	return data, strconv.FormatInt(version, 10), nil
+}
+
+func (p *Provider) HeadObject(ctx context.Context, bucket, key string) (string, error) {
+	var version int64
+
+	start := time.Now()
+	err := p.db.QueryRowContext(ctx,
+		fmt.Sprintf(`SELECT version FROM %s WHERE bucket = ? AND key = ?`, p.table()),
+		bucket, key).Scan(&version)
+	p.emit(ctx, "Select", bucket, key, start, err)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil
+		}
+
+		return "", err
+	}
+
+	return strconv.FormatInt(version, 10), nil
+}
+
+func (p *Provider) ListObjects(ctx context.Context, bucket, prefix string) ([]string, error) {
+	start := time.Now()
+	rows, err := p.db.QueryContext(ctx,
+		fmt.Sprintf(`SELECT key FROM %s WHERE bucket = ? AND substr(key, 1, length(?)) = ? ORDER BY key`, p.table()),
+		bucket, prefix, prefix)
+	p.emit(ctx, "List", bucket, prefix, start, err)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// begin starts an immediate transaction on a dedicated connection, taking the
+// database write lock up front so that concurrent updates are serialized.
+func (p *Provider) begin(ctx context.Context, bucket, key string) (*sql.Conn, error) {
+	conn, err := p.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf("PRAGMA busy_timeout = %d", p.busyTimeout.Milliseconds())); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	start := time.Now()
+	_, err = conn.ExecContext(ctx, "BEGIN IMMEDIATE")
+	p.emit(ctx, "Begin", bucket, key, start, err)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// end commits the transaction (or rolls it back if err is non-nil) and
+// returns the connection to the pool.
+func (p *Provider) end(ctx context.Context, conn *sql.Conn, bucket, key string, err error) error {
+	defer conn.Close()
+
+	// Always finish the transaction, even if the caller's context is done.
+	ctx = context.WithoutCancel(ctx)
+
+	if err != nil {
+		_, _ = conn.ExecContext(ctx, "ROLLBACK")
+		return err
+	}
+
+	start := time.Now()
+	_, err = conn.ExecContext(ctx, "COMMIT")
+	p.emit(ctx, "Commit", bucket, key, start, err)
+	if err != nil {
+		_, _ = conn.ExecContext(ctx, "ROLLBACK")
+		return err
+	}
+
+	return nil
+}
+
+// table returns the quoted name of the objects table.
+func (p *Provider) table() string {
+	return `"` + strings.ReplaceAll(p.tableName, `"`, `""`) + `"`
+}
+
+func (p *Provider) Capabilities() provider.Capabilities {
+	return provider.Capabilities{
+		ConditionalCreate:    true,
+		ConditionalDelete:    true,
+		StrongReadAfterWrite: true,
+	}
+}
+
+// emit emits a provider request event to the stats handler (if configured).
+func (p *Provider) emit(ctx context.Context, operation, bucket, key string, start time.Time, err error) {
+	if p.statsHandler == nil {
+		return
+	}
+
+	p.statsHandler.HandleEvent(ctx, stats.Event{
+		Type:      stats.EventProviderRequest,
+		Bucket:    bucket,
+		Key:       key,
+		Operation: operation,
+		Duration:  time.Since(start),
+		Err:       err,
+	})
+}
@@ -0,0 +1,172 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package sqlite_test
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/dpeckett/objsync"
+	"github.com/dpeckett/objsync/provider"
+	"github.com/dpeckett/objsync/provider/sqlite"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
+	_ "modernc.org/sqlite"
+)
+
+func TestProvider(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "objsync.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, db.Close())
+	})
+
+	p, err := sqlite.NewProvider(ctx, db)
+	require.NoError(t, err)
+
+	t.Run("Update", func(t *testing.T) {
+		etag, err := p.AtomicUpdateObject(ctx, "bucket", "update", func(currentETag string, currentData []byte) ([]byte, error) {
+			require.Empty(t, currentETag)
+			require.Empty(t, currentData)
+
+			return []byte("hello"), nil
+		})
+		require.NoError(t, err)
+		require.NotEmpty(t, etag)
+
+		data, currentETag, err := p.(provider.Getter).GetObject(ctx, "bucket", "update")
+		require.NoError(t, err)
+		require.Equal(t, "hello", string(data))
+		require.Equal(t, etag, currentETag)
+
+		newETag, err := p.AtomicUpdateObject(ctx, "bucket", "update", func(currentETag string, currentData []byte) ([]byte, error) {
+			require.Equal(t, etag, currentETag)
+			require.Equal(t, "hello", string(currentData))
+
+			return []byte("world"), nil
+		})
+		require.NoError(t, err)
+		require.NotEqual(t, etag, newETag)
+
+		currentETag, err = p.(provider.Header).HeadObject(ctx, "bucket", "update")
+		require.NoError(t, err)
+		require.Equal(t, newETag, currentETag)
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		var g errgroup.Group
+		for i := 0; i < 10; i++ {
+			g.Go(func() error {
+				_, err := p.AtomicUpdateObject(ctx, "bucket", "counter", func(_ string, currentData []byte) ([]byte, error) {
+					var n int
+					if len(currentData) > 0 {
+						var err error
+						if n, err = strconv.Atoi(string(currentData)); err != nil {
+							return nil, err
+						}
+					}
+
+					return []byte(strconv.Itoa(n + 1)), nil
+				})
+				return err
+			})
+		}
+		require.NoError(t, g.Wait())
+
+		data, _, err := p.(provider.Getter).GetObject(ctx, "bucket", "counter")
+		require.NoError(t, err)
+		require.Equal(t, "10", string(data))
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		deleter := p.(provider.Deleter)
+
+		etag, err := p.AtomicUpdateObject(ctx, "bucket", "delete", func(_ string, _ []byte) ([]byte, error) {
+			return []byte("hello"), nil
+		})
+		require.NoError(t, err)
+
+		require.ErrorIs(t, deleter.DeleteObject(ctx, "bucket", "delete", etag+"0"), provider.ErrConflict)
+		require.NoError(t, deleter.DeleteObject(ctx, "bucket", "delete", etag))
+
+		currentETag, err := p.(provider.Header).HeadObject(ctx, "bucket", "delete")
+		require.NoError(t, err)
+		require.Empty(t, currentETag)
+
+		// The ETags of a recreated object aren't reused, so a stale ETag can't
+		// match it.
+		newETag, err := p.AtomicUpdateObject(ctx, "bucket", "delete", func(_ string, _ []byte) ([]byte, error) {
+			return []byte("hello"), nil
+		})
+		require.NoError(t, err)
+		require.NotEqual(t, etag, newETag)
+
+		require.ErrorIs(t, deleter.DeleteObject(ctx, "bucket", "delete", etag), provider.ErrConflict)
+	})
+
+	t.Run("List", func(t *testing.T) {
+		for _, key := range []string{"list/b", "list/a", "other"} {
+			_, err := p.AtomicUpdateObject(ctx, "list", key, func(_ string, _ []byte) ([]byte, error) {
+				return []byte("hello"), nil
+			})
+			require.NoError(t, err)
+		}
+
+		keys, err := p.(provider.Lister).ListObjects(ctx, "list", "list/")
+		require.NoError(t, err)
+		require.Equal(t, []string{"list/a", "list/b"}, keys)
+	})
+}
+
+func TestMutex(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "objsync.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, db.Close())
+	})
+
+	// With a single connection, any read made from within an update would
+	// deadlock.
+	db.SetMaxOpenConns(1)
+
+	p, err := sqlite.NewProvider(ctx, db)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	t.Cleanup(cancel)
+
+	lease := objsync.NewLease(p, "bucket", "test.lease")
+	require.NoError(t, lease.Grant(ctx, time.Minute))
+
+	mu := objsync.NewMutex(p, "bucket", "test.lock",
+		objsync.WithLease(lease),
+		objsync.WithDeleteOnUnlock("test.fence"))
+
+	for i := 0; i < 2; i++ {
+		_, err = mu.Lock(ctx, time.Minute)
+		require.NoError(t, err)
+
+		ok, _, err := objsync.NewMutex(p, "bucket", "test.lock").TryLock(ctx, time.Minute)
+		require.NoError(t, err)
+		require.False(t, ok)
+
+		require.NoError(t, mu.Unlock(ctx))
+	}
+
+	require.NoError(t, lease.Revoke(ctx))
+}
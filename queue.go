@@ -0,0 +1,356 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dpeckett/objsync/provider"
+	"github.com/google/uuid"
+)
+
+// ErrInvalidReceipt is returned when acknowledging a message whose
+// visibility timeout has expired, as it may have been redelivered to another
+// consumer.
+var ErrInvalidReceipt = errors.New("invalid receipt")
+
+// QueueOption is a functional option for configuring a queue.
+type QueueOption func(*Queue)
+
+// WithQueuePollInterval sets how often Dequeue checks for new messages, when
+// the queue is empty.
+func WithQueuePollInterval(interval time.Duration) QueueOption {
+	return func(q *Queue) {
+		q.pollInterval = interval
+	}
+}
+
+// Queue is a distributed FIFO queue, with at-least-once delivery. Each
+// message is stored in its own object, and the order of the messages is
+// recorded in an index object. Dequeued messages are hidden from other
+// consumers for a visibility timeout, and are redelivered if they are not
+// acknowledged within it. It is intended for low volume job handoff, as every
+// operation updates the index object.
+type Queue struct {
+	provider     provider.Provider
+	bucket       string
+	key          string
+	pollInterval time.Duration
+}
+
+// Message is a message that has been dequeued from a queue.
+type Message struct {
+	ID         string
+	Body       []byte
+	EnqueuedAt time.Time
+	// Receives is the number of times the message has been dequeued
+	// (including this time).
+	Receives int
+	// Receipt identifies this delivery of the message, it is required to
+	// acknowledge the message.
+	Receipt string
+}
+
+// The current schema version of the queue index object.
+const queueSchemaVersion = 1
+
+// The JSON content of the queue index object.
+type queueContent struct {
+	SchemaVersion int           `json:"schemaVersion,omitempty"`
+	Messages      []*queueEntry `json:"messages,omitempty"`
+}
+
+type queueEntry struct {
+	ID             string     `json:"id"`
+	EnqueuedAt     time.Time  `json:"enqueuedAt"`
+	Receives       int        `json:"receives,omitempty"`
+	Receipt        string     `json:"receipt,omitempty"`
+	InvisibleUntil *time.Time `json:"invisibleUntil,omitempty"`
+}
+
+// NewQueue creates a new distributed queue. The index is stored in the object
+// with the given key, and messages are stored alongside it.
+func NewQueue(p provider.Provider, bucket, key string, opts ...QueueOption) *Queue {
+	q := &Queue{
+		provider:     p,
+		bucket:       bucket,
+		key:          key,
+		pollInterval: time.Second,
+	}
+
+	for _, opt := range opts {
+		opt(q)
+	}
+
+	return q
+}
+
+// Enqueue adds a message to the back of the queue, returning its ID.
+func (q *Queue) Enqueue(ctx context.Context, body []byte) (string, error) {
+	id := fmt.Sprintf("%d-%s", time.Now().UnixNano(), uuid.New().String())
+
+	// Write the message before adding it to the index, so that consumers
+	// never see a message that doesn't exist.
+	etag, err := q.provider.AtomicUpdateObject(ctx, q.bucket, q.messageKey(id), func(_ string, _ []byte) ([]byte, error) {
+		return body, nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to write message: %w", err)
+	}
+
+	_, err = updateObject(ctx, q.provider, q.bucket, q.key, func(_ string, currentData []byte) ([]byte, error) {
+		content, err := decodeQueueContent(currentData)
+		if err != nil {
+			return nil, err
+		}
+
+		content.Messages = append(content.Messages, &queueEntry{
+			ID:         id,
+			EnqueuedAt: time.Now().UTC(),
+		})
+
+		return json.Marshal(content)
+	})
+	if err != nil {
+		q.deleteMessage(context.WithoutCancel(ctx), id, etag)
+
+		return "", err
+	}
+
+	return id, nil
+}
+
+// Dequeue removes the message at the front of the queue, blocking until a
+// message is available, or ctx is done. The message is hidden from other
+// consumers until the visibility timeout has elapsed, after which it will be
+// redelivered unless it has been acknowledged.
+func (q *Queue) Dequeue(ctx context.Context, visibilityTimeout time.Duration) (*Message, error) {
+	ticker := time.NewTicker(q.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		msg, ok, err := q.TryDequeue(ctx, visibilityTimeout)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			return msg, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// TryDequeue removes the message at the front of the queue without blocking.
+// If the queue is empty, it returns false.
+func (q *Queue) TryDequeue(ctx context.Context, visibilityTimeout time.Duration) (*Message, bool, error) {
+	var errQueueEmpty = errors.New("queue is empty")
+
+	if visibilityTimeout <= 0 {
+		return nil, false, fmt.Errorf("invalid visibility timeout: %s", visibilityTimeout)
+	}
+
+	for {
+		var entry queueEntry
+		_, err := updateObject(ctx, q.provider, q.bucket, q.key, func(_ string, currentData []byte) ([]byte, error) {
+			entry = queueEntry{}
+
+			content, err := decodeQueueContent(currentData)
+			if err != nil {
+				return nil, err
+			}
+
+			now := time.Now()
+			for _, e := range content.Messages {
+				if e.InvisibleUntil != nil && now.Before(*e.InvisibleUntil) {
+					continue
+				}
+
+				invisibleUntil := now.Add(visibilityTimeout).UTC()
+				e.InvisibleUntil = &invisibleUntil
+				e.Receipt = uuid.New().String()
+				e.Receives++
+
+				entry = *e
+				break
+			}
+
+			// Nothing to dequeue, so there's no need to write the index.
+			if entry.ID == "" {
+				return nil, errQueueEmpty
+			}
+
+			return json.Marshal(content)
+		})
+		if err != nil {
+			if errors.Is(err, errQueueEmpty) {
+				return nil, false, nil
+			}
+
+			return nil, false, err
+		}
+
+		body, etag, err := q.readMessage(ctx, entry.ID)
+		if err != nil {
+			return nil, false, err
+		}
+
+		// The message object has gone missing, so drop it from the queue.
+		if etag == "" {
+			if err := q.Ack(ctx, &Message{ID: entry.ID, Receipt: entry.Receipt}); err != nil && !errors.Is(err, ErrInvalidReceipt) {
+				return nil, false, err
+			}
+
+			continue
+		}
+
+		return &Message{
+			ID:         entry.ID,
+			Body:       body,
+			EnqueuedAt: entry.EnqueuedAt,
+			Receives:   entry.Receives,
+			Receipt:    entry.Receipt,
+		}, true, nil
+	}
+}
+
+// Ack acknowledges a message, permanently removing it from the queue. If the
+// visibility timeout of the message has expired (and so it may have been
+// redelivered), ErrInvalidReceipt is returned.
+func (q *Queue) Ack(ctx context.Context, msg *Message) error {
+	_, err := updateObject(ctx, q.provider, q.bucket, q.key, func(_ string, currentData []byte) ([]byte, error) {
+		content, err := decodeQueueContent(currentData)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, e := range content.Messages {
+			if e.ID != msg.ID {
+				continue
+			}
+
+			if e.Receipt != msg.Receipt || e.InvisibleUntil == nil || time.Now().After(*e.InvisibleUntil) {
+				return nil, ErrInvalidReceipt
+			}
+
+			content.Messages = append(content.Messages[:i], content.Messages[i+1:]...)
+
+			return json.Marshal(content)
+		}
+
+		return nil, ErrInvalidReceipt
+	})
+	if err != nil {
+		return err
+	}
+
+	_, etag, err := q.readMessage(ctx, msg.ID)
+	if err == nil && etag != "" {
+		q.deleteMessage(ctx, msg.ID, etag)
+	}
+
+	return nil
+}
+
+// Nack returns a message to the queue, making it immediately visible to other
+// consumers.
+func (q *Queue) Nack(ctx context.Context, msg *Message) error {
+	_, err := updateObject(ctx, q.provider, q.bucket, q.key, func(_ string, currentData []byte) ([]byte, error) {
+		content, err := decodeQueueContent(currentData)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, e := range content.Messages {
+			if e.ID == msg.ID && e.Receipt == msg.Receipt {
+				e.InvisibleUntil = nil
+				e.Receipt = ""
+
+				return json.Marshal(content)
+			}
+		}
+
+		return nil, ErrInvalidReceipt
+	})
+	return err
+}
+
+// Len returns the number of messages in the queue (including those that are
+// currently invisible).
+func (q *Queue) Len(ctx context.Context) (int, error) {
+	data, err := readObject(ctx, q.provider, q.bucket, q.key)
+	if err != nil {
+		return -1, err
+	}
+
+	content, err := decodeQueueContent(data)
+	if err != nil {
+		return -1, err
+	}
+
+	return len(content.Messages), nil
+}
+
+// messageKey returns the key of the object a message is stored in.
+func (q *Queue) messageKey(id string) string {
+	return q.key + ".msg/" + id
+}
+
+// readMessage reads the body of a message, if the message doesn't exist an
+// empty ETag is returned.
+func (q *Queue) readMessage(ctx context.Context, id string) ([]byte, string, error) {
+	var errReadOnly = errors.New("read only")
+
+	var body []byte
+	var etag string
+	_, err := q.provider.AtomicUpdateObject(ctx, q.bucket, q.messageKey(id), func(currentETag string, currentData []byte) ([]byte, error) {
+		body = currentData
+		etag = currentETag
+		return nil, errReadOnly
+	})
+	if err != nil && !errors.Is(err, errReadOnly) {
+		return nil, "", err
+	}
+
+	return body, etag, nil
+}
+
+// deleteMessage makes a best effort attempt to remove a message object. If
+// the provider doesn't support deletes, the object is left behind.
+func (q *Queue) deleteMessage(ctx context.Context, id, etag string) {
+	if deleter, ok := q.provider.(provider.Deleter); ok {
+		_ = deleter.DeleteObject(ctx, q.bucket, q.messageKey(id), etag)
+	}
+}
+
+// decodeQueueContent decodes the queue index object.
+func decodeQueueContent(data []byte) (*queueContent, error) {
+	var content queueContent
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &content); err != nil {
+			return nil, err
+		}
+	}
+
+	if content.SchemaVersion == 0 {
+		content.SchemaVersion = queueSchemaVersion
+	}
+
+	return &content, nil
+}
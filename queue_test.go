@@ -0,0 +1,93 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dpeckett/objsync"
+	"github.com/dpeckett/objsync/provider/s3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueue(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	ctx := context.Background()
+	p, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	require.NoError(t, err)
+
+	t.Run("FIFO", func(t *testing.T) {
+		key := fmt.Sprintf("test-%d.queue", time.Now().UnixNano())
+
+		q := objsync.NewQueue(p, bucket, key)
+
+		for i := 0; i < 3; i++ {
+			_, err := q.Enqueue(ctx, []byte(fmt.Sprintf("job-%d", i)))
+			require.NoError(t, err)
+		}
+
+		n, err := q.Len(ctx)
+		require.NoError(t, err)
+		require.Equal(t, 3, n)
+
+		for i := 0; i < 3; i++ {
+			msg, err := q.Dequeue(ctx, 5*time.Second)
+			require.NoError(t, err)
+			require.Equal(t, fmt.Sprintf("job-%d", i), string(msg.Body))
+
+			require.NoError(t, q.Ack(ctx, msg))
+		}
+
+		_, ok, err := q.TryDequeue(ctx, 5*time.Second)
+		require.NoError(t, err)
+		require.False(t, ok)
+	})
+
+	t.Run("Redelivery", func(t *testing.T) {
+		key := fmt.Sprintf("test-%d.queue", time.Now().UnixNano())
+
+		q := objsync.NewQueue(p, bucket, key)
+
+		_, err := q.Enqueue(ctx, []byte("job"))
+		require.NoError(t, err)
+
+		msg, err := q.Dequeue(ctx, 200*time.Millisecond)
+		require.NoError(t, err)
+
+		// Hidden from other consumers until the visibility timeout elapses.
+		_, ok, err := q.TryDequeue(ctx, 5*time.Second)
+		require.NoError(t, err)
+		require.False(t, ok)
+
+		time.Sleep(300 * time.Millisecond)
+
+		redelivered, err := q.Dequeue(ctx, 5*time.Second)
+		require.NoError(t, err)
+		require.Equal(t, msg.ID, redelivered.ID)
+		require.Equal(t, 2, redelivered.Receives)
+
+		// The original delivery can no longer be acknowledged.
+		require.ErrorIs(t, q.Ack(ctx, msg), objsync.ErrInvalidReceipt)
+
+		require.NoError(t, q.Nack(ctx, redelivered))
+
+		msg, err = q.Dequeue(ctx, 5*time.Second)
+		require.NoError(t, err)
+		require.NoError(t, q.Ack(ctx, msg))
+	})
+}
@@ -0,0 +1,148 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/dpeckett/objsync/provider"
+)
+
+// RateLimiter is a distributed token bucket rate limiter. It mirrors the
+// semantics of golang.org/x/time/rate.Limiter, the bucket starts full and is
+// refilled at a rate of r tokens per second, up to a maximum of burst tokens.
+// All the processes sharing a rate limiter should use the same rate and
+// burst.
+type RateLimiter struct {
+	provider provider.Provider
+	bucket   string
+	key      string
+	rate     float64
+	burst    int
+}
+
+// The current schema version of the rate limiter object.
+const rateLimiterSchemaVersion = 1
+
+// The JSON content of the rate limiter object.
+type rateLimiterContent struct {
+	SchemaVersion int        `json:"schemaVersion,omitempty"`
+	Tokens        float64    `json:"tokens,omitempty"`
+	LastRefill    *time.Time `json:"lastRefill,omitempty"`
+}
+
+// NewRateLimiter creates a new distributed rate limiter that allows events up
+// to rate r (per second) and permits bursts of at most burst events.
+func NewRateLimiter(p provider.Provider, bucket, key string, r float64, burst int) *RateLimiter {
+	return &RateLimiter{
+		provider: p,
+		bucket:   bucket,
+		key:      key,
+		rate:     r,
+		burst:    burst,
+	}
+}
+
+// Allow reports whether an event may happen now, consuming a token if so.
+func (l *RateLimiter) Allow(ctx context.Context) (bool, error) {
+	return l.AllowN(ctx, 1)
+}
+
+// AllowN reports whether n events may happen now, consuming n tokens if so.
+func (l *RateLimiter) AllowN(ctx context.Context, n int) (bool, error) {
+	ok, _, err := l.take(ctx, n)
+	return ok, err
+}
+
+// Wait blocks until an event may happen, or ctx is done.
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	return l.WaitN(ctx, 1)
+}
+
+// WaitN blocks until n events may happen, or ctx is done.
+func (l *RateLimiter) WaitN(ctx context.Context, n int) error {
+	if n > l.burst {
+		return fmt.Errorf("requested %d tokens exceeds burst of %d", n, l.burst)
+	}
+
+	for {
+		ok, wait, err := l.take(ctx, n)
+		if err != nil {
+			return err
+		}
+
+		if ok {
+			return nil
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// take attempts to consume n tokens from the bucket. If there are not enough
+// tokens, it returns how long until there will be.
+func (l *RateLimiter) take(ctx context.Context, n int) (bool, time.Duration, error) {
+	var errInsufficientTokens = errors.New("insufficient tokens")
+
+	if l.rate <= 0 {
+		return false, 0, fmt.Errorf("invalid rate: %v", l.rate)
+	}
+
+	var wait time.Duration
+	_, err := updateObject(ctx, l.provider, l.bucket, l.key, func(_ string, currentData []byte) ([]byte, error) {
+		var content rateLimiterContent
+		if len(currentData) > 0 {
+			if err := json.Unmarshal(currentData, &content); err != nil {
+				return nil, err
+			}
+		}
+
+		now := time.Now().UTC()
+
+		// Refill the bucket, based on the time elapsed since it was last refilled.
+		if content.LastRefill == nil {
+			content.Tokens = float64(l.burst)
+		} else if elapsed := now.Sub(*content.LastRefill); elapsed > 0 {
+			content.Tokens = math.Min(float64(l.burst), content.Tokens+elapsed.Seconds()*l.rate)
+		}
+
+		content.SchemaVersion = rateLimiterSchemaVersion
+		content.LastRefill = &now
+
+		if content.Tokens < float64(n) {
+			wait = time.Duration((float64(n) - content.Tokens) / l.rate * float64(time.Second))
+			return nil, errInsufficientTokens
+		}
+
+		content.Tokens -= float64(n)
+
+		return json.Marshal(content)
+	})
+	if err != nil {
+		if errors.Is(err, errInsufficientTokens) {
+			return false, wait, nil
+		}
+
+		return false, 0, err
+	}
+
+	return true, 0, nil
+}
@@ -0,0 +1,79 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dpeckett/objsync"
+	"github.com/dpeckett/objsync/provider/s3"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestRateLimiter(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	ctx := context.Background()
+	p, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	require.NoError(t, err)
+
+	t.Run("Allow", func(t *testing.T) {
+		key := fmt.Sprintf("test-%d.ratelimit", time.Now().UnixNano())
+
+		// Practically no refill during the test.
+		limiter := objsync.NewRateLimiter(p, bucket, key, 0.001, 2)
+
+		for i := 0; i < 2; i++ {
+			ok, err := limiter.Allow(ctx)
+			require.NoError(t, err)
+			require.True(t, ok)
+		}
+
+		ok, err := objsync.NewRateLimiter(p, bucket, key, 0.001, 2).Allow(ctx)
+		require.NoError(t, err)
+		require.False(t, ok)
+	})
+
+	t.Run("Wait", func(t *testing.T) {
+		key := fmt.Sprintf("test-%d.ratelimit", time.Now().UnixNano())
+
+		const rate = 20
+
+		start := time.Now()
+
+		g, ctx := errgroup.WithContext(ctx)
+		for i := 0; i < 3; i++ {
+			g.Go(func() error {
+				limiter := objsync.NewRateLimiter(p, bucket, key, rate, 1)
+
+				for j := 0; j < 3; j++ {
+					if err := limiter.Wait(ctx); err != nil {
+						return fmt.Errorf("wait: %w", err)
+					}
+				}
+
+				return nil
+			})
+		}
+
+		require.NoError(t, g.Wait())
+
+		// The first event is allowed immediately (from the burst).
+		require.GreaterOrEqual(t, time.Since(start), 8*time.Second/rate)
+	})
+}
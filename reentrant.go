@@ -0,0 +1,37 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync
+
+// WithReentrant allows a mutex that is already held by the same owner ID to
+// be acquired again, rather than deadlocking. The number of holds is tracked
+// in the lock object, and the lock is only released once every hold has been
+// unlocked. Each hold shares the fencing token of the first.
+//
+// Each layer taking the lock should use its own mutex (with the same owner
+// ID, see WithOwnerID), as a mutex only tracks a single hold locally. Deleting
+// the lock object on unlock (WithDeleteOnUnlock) is not supported in
+// reentrant mode.
+func WithReentrant() MutexOption {
+	return func(mu *Mutex) {
+		mu.reentrant = true
+	}
+}
+
+// owns reports whether the lock object (with the given ETag) is held by this
+// mutex. In reentrant mode, the lock object may have since been updated by
+// another hold with the same owner ID.
+func (mu *Mutex) owns(currentETag string, content *mutexContent) bool {
+	if mu.reentrant {
+		return content.ID == mu.id && content.Expires != nil && !mu.now().After(*content.Expires)
+	}
+
+	etag, _, _ := mu.holdState()
+	return currentETag == etag
+}
@@ -0,0 +1,46 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// RunWithLock acquires the mutex, and runs fn while keeping the lock alive.
+// The context passed to fn is cancelled if the lock is lost. The mutex is
+// always released once fn returns.
+func RunWithLock(ctx context.Context, mu *Mutex, length time.Duration, fn func(ctx context.Context, fencingToken int64) error) (err error) {
+	lockCtx, fencingToken, err := mu.LockContext(ctx, length)
+	if err != nil {
+		return err
+	}
+
+	mu.startKeepAlive(ctx, length)
+
+	defer func() {
+		if unlockErr := mu.Unlock(context.WithoutCancel(ctx)); unlockErr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to unlock: %w", unlockErr))
+		}
+	}()
+
+	if err := fn(lockCtx, fencingToken); err != nil {
+		return err
+	}
+
+	// The work may not have been performed safely.
+	if cause := context.Cause(lockCtx); errors.Is(cause, ErrLockLost) {
+		return cause
+	}
+
+	return nil
+}
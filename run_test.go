@@ -0,0 +1,67 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dpeckett/objsync"
+	"github.com/dpeckett/objsync/provider/s3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunWithLock(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	key := fmt.Sprintf("test-%d.lock", time.Now().UnixNano())
+
+	ctx := context.Background()
+	p, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	require.NoError(t, err)
+
+	mu := objsync.NewMutex(p, bucket, key)
+
+	err = objsync.RunWithLock(ctx, mu, time.Second, func(ctx context.Context, fencingToken int64) error {
+		require.Greater(t, fencingToken, int64(0))
+
+		// Outlive the initial lock duration.
+		time.Sleep(2500 * time.Millisecond)
+
+		// The lock should have been kept alive.
+		require.NoError(t, ctx.Err())
+
+		ok, _, err := objsync.NewMutex(p, bucket, key).TryLock(ctx, time.Second)
+		require.NoError(t, err)
+		require.False(t, ok)
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	// The lock should have been released.
+	ok, _, err := objsync.NewMutex(p, bucket, key).TryLock(ctx, time.Second)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	// Errors should be passed through.
+	errTest := errors.New("test")
+	err = objsync.RunWithLock(ctx, objsync.NewMutex(p, bucket, key+".other"), time.Second, func(ctx context.Context, _ int64) error {
+		return errTest
+	})
+	require.ErrorIs(t, err, errTest)
+}
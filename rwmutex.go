@@ -0,0 +1,413 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/avast/retry-go/v4"
+	"github.com/dpeckett/objsync/provider"
+	"github.com/google/uuid"
+)
+
+// RWMutexOption is a functional option for configuring a read-write mutex.
+type RWMutexOption func(*RWMutex)
+
+// RWMutexPolicy is how a read-write mutex schedules readers and writers
+// waiting for the lock.
+type RWMutexPolicy int
+
+const (
+	// RWMutexPreferWriters turns new readers away once a writer is waiting for
+	// the lock, until it has been acquired, so that writers are not starved by
+	// a steady stream of readers. Readers may be starved by a steady stream of
+	// writers.
+	RWMutexPreferWriters RWMutexPolicy = iota
+	// RWMutexPreferReaders admits new readers even while a writer is waiting,
+	// so writers only acquire the lock once there are no readers at all. This
+	// suits read-mostly workloads, but writers may be starved.
+	RWMutexPreferReaders
+	// RWMutexFair alternates between readers and writers. A waiting writer
+	// turns new readers away, as with RWMutexPreferWriters, but the readers
+	// turned away are admitted as soon as the writer releases the lock,
+	// before any other writer. Neither readers nor writers are starved.
+	RWMutexFair
+)
+
+// RWMutex is a distributed reader/writer mutex. The lock can be held by an
+// arbitrary number of readers or a single writer. How waiting readers and
+// writers are scheduled is configurable (see RWMutexPolicy), by default
+// writers are preferred.
+//
+// The policy is enforced by the instances acquiring the lock, so every
+// instance sharing a lock should use the same policy.
+type RWMutex struct {
+	provider provider.Provider
+	bucket   string
+	key      string
+	id       string
+	ttl      time.Duration
+	policy   RWMutexPolicy
+	// The locks currently held by this instance.
+	mu      sync.Mutex
+	readers int
+	writer  bool
+}
+
+// The current schema version of the read-write mutex object.
+const rwMutexSchemaVersion = 1
+
+// The JSON content of the read-write mutex object.
+type rwMutexContent struct {
+	SchemaVersion int                     `json:"schemaVersion,omitempty"`
+	Readers       map[string]*rwMutexRead `json:"readers,omitempty"`
+	Writer        *rwMutexWrite           `json:"writer,omitempty"`
+	PendingWriter *rwMutexWrite           `json:"pendingWriter,omitempty"`
+	// The readers turned away by a writer, that are admitted before any other
+	// writer (with the fair policy).
+	PendingReaders map[string]time.Time `json:"pendingReaders,omitempty"`
+}
+
+type rwMutexRead struct {
+	Count   int       `json:"count"`
+	Expires time.Time `json:"expires"`
+}
+
+type rwMutexWrite struct {
+	ID      string    `json:"id"`
+	Expires time.Time `json:"expires"`
+}
+
+// NewRWMutex creates a new distributed read-write mutex. Locks not released
+// within the ttl are automatically released.
+func NewRWMutex(p provider.Provider, bucket, key string, ttl time.Duration, opts ...RWMutexOption) *RWMutex {
+	rw := &RWMutex{
+		provider: p,
+		bucket:   bucket,
+		key:      key,
+		id:       uuid.New().String(),
+		ttl:      ttl,
+	}
+
+	for _, opt := range opts {
+		opt(rw)
+	}
+
+	return rw
+}
+
+// WithRWMutexOwnerID sets a stable owner ID for the read-write mutex, instead
+// of a randomly generated one.
+func WithRWMutexOwnerID(id string) RWMutexOption {
+	return func(rw *RWMutex) {
+		rw.id = id
+	}
+}
+
+// WithRWMutexPolicy sets how the read-write mutex schedules waiting readers
+// and writers. Defaults to RWMutexPreferWriters.
+func WithRWMutexPolicy(policy RWMutexPolicy) RWMutexOption {
+	return func(rw *RWMutex) {
+		rw.policy = policy
+	}
+}
+
+// RLock acquires a read lock. It blocks until no writer holds the lock, or
+// ctx is done.
+func (rw *RWMutex) RLock(ctx context.Context) error {
+	return retry.Do(
+		func() error {
+			ok, err := rw.TryRLock(ctx)
+			if err != nil {
+				return retry.Unrecoverable(err)
+			}
+
+			if ok {
+				return nil
+			}
+
+			return &LockHeldError{}
+		},
+		retry.Context(ctx),
+		retry.Attempts(0),
+		retry.MaxDelay(maxRetryDelay),
+		// Report that the lock was held if we give up.
+		retry.WrapContextErrorWithLastError(true),
+	)
+}
+
+// TryRLock attempts to acquire a read lock without blocking.
+func (rw *RWMutex) TryRLock(ctx context.Context) (bool, error) {
+	var errWriteLocked = fmt.Errorf("write locked")
+
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	var acquired bool
+	_, err := rw.provider.AtomicUpdateObject(ctx, rw.bucket, rw.key, func(_ string, currentData []byte) ([]byte, error) {
+		acquired = false
+
+		content, err := decodeRWMutexContent(currentData)
+		if err != nil {
+			return nil, err
+		}
+
+		pendingWriter := content.PendingWriter != nil && content.PendingWriter.ID != rw.id
+		if rw.policy == RWMutexPreferReaders {
+			pendingWriter = false
+		}
+
+		if content.Writer != nil || pendingWriter {
+			if rw.policy != RWMutexFair {
+				return nil, errWriteLocked
+			}
+
+			// Wait for the writer, and be admitted before the next one.
+			content.PendingReaders[rw.id] = time.Now().Add(rw.ttl).UTC()
+			return json.Marshal(content)
+		}
+
+		delete(content.PendingReaders, rw.id)
+
+		read, ok := content.Readers[rw.id]
+		if !ok {
+			read = &rwMutexRead{}
+		}
+
+		read.Count++
+		read.Expires = time.Now().Add(rw.ttl).UTC()
+		content.Readers[rw.id] = read
+		acquired = true
+
+		return json.Marshal(content)
+	})
+	if err != nil {
+		if errors.Is(err, errWriteLocked) || errors.Is(err, provider.ErrConflict) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	if !acquired {
+		return false, nil
+	}
+
+	rw.readers++
+
+	return true, nil
+}
+
+// RUnlock releases a read lock.
+func (rw *RWMutex) RUnlock(ctx context.Context) error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	if rw.readers == 0 {
+		return fmt.Errorf("read %w", ErrNotHeld)
+	}
+
+	_, err := updateObject(ctx, rw.provider, rw.bucket, rw.key, func(_ string, currentData []byte) ([]byte, error) {
+		content, err := decodeRWMutexContent(currentData)
+		if err != nil {
+			return nil, err
+		}
+
+		// Our read lock may have already expired.
+		if read, ok := content.Readers[rw.id]; ok {
+			read.Count--
+			if read.Count <= 0 {
+				delete(content.Readers, rw.id)
+			}
+		}
+
+		return json.Marshal(content)
+	})
+	if err != nil {
+		return err
+	}
+
+	rw.readers--
+
+	return nil
+}
+
+// Lock acquires the write lock. It blocks until there are no other readers
+// or writers, or ctx is done.
+func (rw *RWMutex) Lock(ctx context.Context) error {
+	return retry.Do(
+		func() error {
+			ok, err := rw.TryLock(ctx)
+			if err != nil {
+				return retry.Unrecoverable(err)
+			}
+
+			if ok {
+				return nil
+			}
+
+			return &LockHeldError{}
+		},
+		retry.Context(ctx),
+		retry.Attempts(0),
+		retry.MaxDelay(maxRetryDelay),
+		// Report that the lock was held if we give up.
+		retry.WrapContextErrorWithLastError(true),
+	)
+}
+
+// TryLock attempts to acquire the write lock without blocking. If the lock is
+// held by readers, this instance is recorded as a pending writer, so that no
+// new readers are admitted until it has acquired the lock (or the pending
+// registration expires). With RWMutexPreferReaders, new readers are admitted
+// regardless.
+func (rw *RWMutex) TryLock(ctx context.Context) (bool, error) {
+	var errLocked = fmt.Errorf("locked")
+
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	var acquired bool
+	_, err := rw.provider.AtomicUpdateObject(ctx, rw.bucket, rw.key, func(_ string, currentData []byte) ([]byte, error) {
+		acquired = false
+
+		content, err := decodeRWMutexContent(currentData)
+		if err != nil {
+			return nil, err
+		}
+
+		if content.Writer != nil {
+			return nil, errLocked
+		}
+
+		if content.PendingWriter != nil && content.PendingWriter.ID != rw.id {
+			return nil, errLocked
+		}
+
+		// The readers that waited for the last writer go first.
+		if rw.policy == RWMutexFair && content.PendingWriter == nil && len(content.PendingReaders) > 0 {
+			return nil, errLocked
+		}
+
+		expires := time.Now().Add(rw.ttl).UTC()
+		if len(content.Readers) > 0 {
+			if rw.policy == RWMutexPreferReaders {
+				return nil, errLocked
+			}
+
+			// Wait for the existing readers to drain.
+			content.PendingWriter = &rwMutexWrite{ID: rw.id, Expires: expires}
+			return json.Marshal(content)
+		}
+
+		content.PendingWriter = nil
+		content.Writer = &rwMutexWrite{ID: rw.id, Expires: expires}
+		acquired = true
+
+		return json.Marshal(content)
+	})
+	if err != nil {
+		if errors.Is(err, errLocked) || errors.Is(err, provider.ErrConflict) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	if !acquired {
+		return false, nil
+	}
+
+	rw.writer = true
+
+	return true, nil
+}
+
+// Unlock releases the write lock.
+func (rw *RWMutex) Unlock(ctx context.Context) error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	if !rw.writer {
+		return fmt.Errorf("write %w", ErrNotHeld)
+	}
+
+	_, err := updateObject(ctx, rw.provider, rw.bucket, rw.key, func(_ string, currentData []byte) ([]byte, error) {
+		content, err := decodeRWMutexContent(currentData)
+		if err != nil {
+			return nil, err
+		}
+
+		// Our write lock may have already expired.
+		if content.Writer != nil && content.Writer.ID == rw.id {
+			content.Writer = nil
+		}
+
+		return json.Marshal(content)
+	})
+	if err != nil {
+		return err
+	}
+
+	rw.writer = false
+
+	return nil
+}
+
+// decodeRWMutexContent decodes the read-write mutex object, pruning any
+// expired locks.
+func decodeRWMutexContent(data []byte) (*rwMutexContent, error) {
+	var content rwMutexContent
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &content); err != nil {
+			return nil, err
+		}
+	}
+
+	if content.SchemaVersion == 0 {
+		content.SchemaVersion = rwMutexSchemaVersion
+	}
+
+	if content.Readers == nil {
+		content.Readers = make(map[string]*rwMutexRead)
+	}
+
+	if content.PendingReaders == nil {
+		content.PendingReaders = make(map[string]time.Time)
+	}
+
+	now := time.Now()
+	for id, read := range content.Readers {
+		if now.After(read.Expires) {
+			delete(content.Readers, id)
+		}
+	}
+
+	if content.Writer != nil && now.After(content.Writer.Expires) {
+		content.Writer = nil
+	}
+
+	if content.PendingWriter != nil && now.After(content.PendingWriter.Expires) {
+		content.PendingWriter = nil
+	}
+
+	for id, expires := range content.PendingReaders {
+		if now.After(expires) {
+			delete(content.PendingReaders, id)
+		}
+	}
+
+	return &content, nil
+}
@@ -0,0 +1,237 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync_test
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dpeckett/objsync"
+	"github.com/dpeckett/objsync/provider/s3"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestRWMutex(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	ctx := context.Background()
+	p, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	require.NoError(t, err)
+
+	t.Run("ReadersAndWriters", func(t *testing.T) {
+		key := fmt.Sprintf("test-%d.rwlock", time.Now().UnixNano())
+
+		var readers, writers int32
+		g, ctx := errgroup.WithContext(ctx)
+		for i := 0; i < 4; i++ {
+			write := i == 0
+
+			g.Go(func() error {
+				rw := objsync.NewRWMutex(p, bucket, key, 5*time.Second)
+
+				for j := 0; j < 3; j++ {
+					if write {
+						if err := rw.Lock(ctx); err != nil {
+							return fmt.Errorf("lock: %w", err)
+						}
+
+						if n := atomic.AddInt32(&writers, 1); n > 1 || atomic.LoadInt32(&readers) > 0 {
+							return fmt.Errorf("write lock is not exclusive")
+						}
+					} else {
+						if err := rw.RLock(ctx); err != nil {
+							return fmt.Errorf("rlock: %w", err)
+						}
+
+						atomic.AddInt32(&readers, 1)
+
+						if atomic.LoadInt32(&writers) > 0 {
+							return fmt.Errorf("read lock held concurrently with write lock")
+						}
+					}
+
+					// Simulate some work.
+					time.Sleep(time.Millisecond*10 + time.Duration(rand.Intn(5))*time.Millisecond)
+
+					if write {
+						atomic.AddInt32(&writers, -1)
+
+						if err := rw.Unlock(ctx); err != nil {
+							return fmt.Errorf("unlock: %w", err)
+						}
+					} else {
+						atomic.AddInt32(&readers, -1)
+
+						if err := rw.RUnlock(ctx); err != nil {
+							return fmt.Errorf("runlock: %w", err)
+						}
+					}
+				}
+
+				return nil
+			})
+		}
+
+		require.NoError(t, g.Wait())
+	})
+
+	t.Run("TryLock", func(t *testing.T) {
+		key := fmt.Sprintf("test-%d.rwlock", time.Now().UnixNano())
+
+		reader := objsync.NewRWMutex(p, bucket, key, 5*time.Second)
+		writer := objsync.NewRWMutex(p, bucket, key, 5*time.Second)
+
+		ok, err := reader.TryRLock(ctx)
+		require.NoError(t, err)
+		require.True(t, ok)
+
+		// Multiple readers are allowed.
+		ok, err = objsync.NewRWMutex(p, bucket, key, 5*time.Second).TryRLock(ctx)
+		require.NoError(t, err)
+		require.True(t, ok)
+
+		ok, err = writer.TryLock(ctx)
+		require.NoError(t, err)
+		require.False(t, ok)
+
+		// New readers are turned away while a writer is waiting.
+		ok, err = objsync.NewRWMutex(p, bucket, key, 5*time.Second).TryRLock(ctx)
+		require.NoError(t, err)
+		require.False(t, ok)
+
+		require.NoError(t, reader.RUnlock(ctx))
+		require.Error(t, reader.RUnlock(ctx))
+	})
+
+	t.Run("PreferReaders", func(t *testing.T) {
+		key := fmt.Sprintf("test-%d.rwlock", time.Now().UnixNano())
+
+		newRWMutex := func() *objsync.RWMutex {
+			return objsync.NewRWMutex(p, bucket, key, 5*time.Second, objsync.WithRWMutexPolicy(objsync.RWMutexPreferReaders))
+		}
+
+		reader, writer := newRWMutex(), newRWMutex()
+
+		ok, err := reader.TryRLock(ctx)
+		require.NoError(t, err)
+		require.True(t, ok)
+
+		ok, err = writer.TryLock(ctx)
+		require.NoError(t, err)
+		require.False(t, ok)
+
+		// New readers are admitted while a writer is waiting.
+		otherReader := newRWMutex()
+
+		ok, err = otherReader.TryRLock(ctx)
+		require.NoError(t, err)
+		require.True(t, ok)
+
+		require.NoError(t, reader.RUnlock(ctx))
+		require.NoError(t, otherReader.RUnlock(ctx))
+
+		ok, err = writer.TryLock(ctx)
+		require.NoError(t, err)
+		require.True(t, ok)
+
+		require.NoError(t, writer.Unlock(ctx))
+	})
+
+	t.Run("Fair", func(t *testing.T) {
+		key := fmt.Sprintf("test-%d.rwlock", time.Now().UnixNano())
+
+		newRWMutex := func() *objsync.RWMutex {
+			return objsync.NewRWMutex(p, bucket, key, 5*time.Second, objsync.WithRWMutexPolicy(objsync.RWMutexFair))
+		}
+
+		reader, writer := newRWMutex(), newRWMutex()
+
+		ok, err := reader.TryRLock(ctx)
+		require.NoError(t, err)
+		require.True(t, ok)
+
+		ok, err = writer.TryLock(ctx)
+		require.NoError(t, err)
+		require.False(t, ok)
+
+		// New readers are turned away while a writer is waiting.
+		waitingReader := newRWMutex()
+
+		ok, err = waitingReader.TryRLock(ctx)
+		require.NoError(t, err)
+		require.False(t, ok)
+
+		require.NoError(t, reader.RUnlock(ctx))
+
+		ok, err = writer.TryLock(ctx)
+		require.NoError(t, err)
+		require.True(t, ok)
+
+		require.NoError(t, writer.Unlock(ctx))
+
+		// But they are admitted before the next writer.
+		otherWriter := newRWMutex()
+
+		ok, err = otherWriter.TryLock(ctx)
+		require.NoError(t, err)
+		require.False(t, ok)
+
+		ok, err = waitingReader.TryRLock(ctx)
+		require.NoError(t, err)
+		require.True(t, ok)
+
+		// Then the next writer waits for them, turning new readers away.
+		ok, err = otherWriter.TryLock(ctx)
+		require.NoError(t, err)
+		require.False(t, ok)
+
+		ok, err = newRWMutex().TryRLock(ctx)
+		require.NoError(t, err)
+		require.False(t, ok)
+
+		require.NoError(t, waitingReader.RUnlock(ctx))
+
+		ok, err = otherWriter.TryLock(ctx)
+		require.NoError(t, err)
+		require.True(t, ok)
+
+		require.NoError(t, otherWriter.Unlock(ctx))
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		key := fmt.Sprintf("test-%d.rwlock", time.Now().UnixNano())
+
+		rw := objsync.NewRWMutex(p, bucket, key, 5*time.Second)
+
+		require.ErrorIs(t, rw.RUnlock(ctx), objsync.ErrNotHeld)
+		require.ErrorIs(t, rw.Unlock(ctx), objsync.ErrNotHeld)
+
+		require.NoError(t, rw.Lock(ctx))
+
+		ctx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+		defer cancel()
+
+		// Giving up reports that the lock was held.
+		err := objsync.NewRWMutex(p, bucket, key, 5*time.Second).RLock(ctx)
+		require.ErrorIs(t, err, objsync.ErrLockHeld)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+
+		require.NoError(t, rw.Unlock(context.Background()))
+	})
+}
@@ -0,0 +1,313 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package schedule runs cron jobs across a fleet of processes, guaranteeing
+// that each tick of a job's schedule is run by at most one process. Every
+// process runs the same set of jobs, and at each tick, the processes race to
+// claim the tick in the job's state object. The state object also records
+// the outcome of recent runs, and accounts for ticks that were skipped.
+package schedule
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/avast/retry-go/v4"
+	"github.com/dpeckett/objsync/provider"
+	"github.com/google/uuid"
+	"github.com/robfig/cron/v3"
+	"golang.org/x/sync/errgroup"
+)
+
+// Config configures the scheduler.
+type Config struct {
+	// Provider is the object storage provider used to store job state.
+	Provider provider.Provider
+	// Bucket is the bucket containing the job state objects.
+	Bucket string
+	// KeyPrefix is prepended to job names to form the keys of their state
+	// objects.
+	KeyPrefix string
+	// Identity is the identity of this process (defaults to a random ID).
+	Identity string
+	// Location is the time zone cron expressions are interpreted in (defaults
+	// to UTC).
+	Location *time.Location
+	// MaxRuntime is the maximum duration of a run, after which its context is
+	// cancelled and it is assumed to have finished (defaults to an hour).
+	MaxRuntime time.Duration
+	// StartingDeadline is how late a tick can be claimed before it is
+	// skipped. Zero means no deadline.
+	StartingDeadline time.Duration
+}
+
+// Job is a scheduled job.
+type Job struct {
+	// Name uniquely identifies the job.
+	Name string
+	// Spec is a standard cron expression (eg. "*/5 * * * *"), or a descriptor
+	// such as "@hourly" or "@every 10m".
+	Spec string
+	// Run is called at each tick of the schedule that is claimed by this
+	// process. A tick is skipped if the previous run of the job is still in
+	// progress (in any process).
+	Run func(ctx context.Context, tick time.Time) error
+}
+
+// Status is the state of a job, as recorded in its state object.
+type Status struct {
+	// LastTick is the most recent tick that was claimed.
+	LastTick *time.Time `json:"lastTick,omitempty"`
+	// LastRunBy is the identity of the process that claimed the last tick.
+	LastRunBy string `json:"lastRunBy,omitempty"`
+	// LastDelay is how long after the last tick it was claimed.
+	LastDelay time.Duration `json:"lastDelay,omitempty"`
+	// LastFinished is when the last run finished.
+	LastFinished *time.Time `json:"lastFinished,omitempty"`
+	// LastError is the error returned by the last run (if any).
+	LastError string `json:"lastError,omitempty"`
+	// RunningUntil is set while a run is in progress, to when it will be
+	// assumed to have finished.
+	RunningUntil *time.Time `json:"runningUntil,omitempty"`
+	// Runs is the number of ticks that have been run.
+	Runs int64 `json:"runs,omitempty"`
+	// Failures is the number of runs that returned an error.
+	Failures int64 `json:"failures,omitempty"`
+	// Skipped is the number of ticks that were not run, because no process
+	// was around to claim them, the previous run was still in progress, or
+	// the starting deadline was exceeded.
+	Skipped int64 `json:"skipped,omitempty"`
+}
+
+// The current schema version of the job state object.
+const schemaVersion = 1
+
+// The JSON content of the job state object.
+type content struct {
+	SchemaVersion int `json:"schemaVersion,omitempty"`
+	Status
+}
+
+// Run runs the given jobs according to their schedules, blocking until the
+// context is cancelled (or a job has an invalid schedule).
+func Run(ctx context.Context, config Config, jobs ...Job) error {
+	if config.Provider == nil {
+		return fmt.Errorf("provider must be specified")
+	}
+
+	if config.Identity == "" {
+		config.Identity = uuid.New().String()
+	}
+
+	if config.Location == nil {
+		config.Location = time.UTC
+	}
+
+	if config.MaxRuntime <= 0 {
+		config.MaxRuntime = time.Hour
+	}
+
+	schedules := make([]cron.Schedule, len(jobs))
+	for i, job := range jobs {
+		if job.Name == "" || job.Run == nil {
+			return fmt.Errorf("job name and run function must be specified")
+		}
+
+		var err error
+		schedules[i], err = cron.ParseStandard(job.Spec)
+		if err != nil {
+			return fmt.Errorf("invalid schedule for job %q: %w", job.Name, err)
+		}
+	}
+
+	g, ctx := errgroup.WithContext(ctx)
+	for i, job := range jobs {
+		g.Go(func() error {
+			runJob(ctx, config, job, schedules[i])
+			return nil
+		})
+	}
+
+	return g.Wait()
+}
+
+// GetStatus returns the state of a job.
+func GetStatus(ctx context.Context, p provider.Provider, bucket, key string) (*Status, error) {
+	var errReadOnly = errors.New("read only")
+
+	var c *content
+	_, err := p.AtomicUpdateObject(ctx, bucket, key, func(_ string, currentData []byte) ([]byte, error) {
+		var err error
+		c, err = decode(currentData)
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, errReadOnly
+	})
+	if err != nil && !errors.Is(err, errReadOnly) {
+		return nil, err
+	}
+
+	return &c.Status, nil
+}
+
+// runJob runs a job at each tick of its schedule, until the context is
+// cancelled.
+func runJob(ctx context.Context, config Config, job Job, schedule cron.Schedule) {
+	key := config.KeyPrefix + job.Name
+
+	tick := schedule.Next(time.Now().In(config.Location))
+	for {
+		timer := time.NewTimer(time.Until(tick))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		// Transient errors result in the tick being left for another process.
+		claimed, runUntil, err := claim(ctx, config, key, schedule, tick)
+		if err == nil && claimed {
+			execute(ctx, config, key, job, tick, runUntil)
+		}
+
+		tick = schedule.Next(time.Now().In(config.Location))
+	}
+}
+
+// claim attempts to claim a tick of a job's schedule, returning whether this
+// process should run it.
+func claim(ctx context.Context, config Config, key string, schedule cron.Schedule, tick time.Time) (bool, time.Time, error) {
+	var claimed bool
+	var runUntil time.Time
+	err := update(ctx, config, key, func(c *content) error {
+		claimed = false
+
+		// Already claimed by another process.
+		if c.LastTick != nil && !tick.After(*c.LastTick) {
+			return errUnchanged
+		}
+
+		// Account for any ticks that no process was around to claim.
+		if c.LastTick != nil {
+			for t := schedule.Next(c.LastTick.In(config.Location)); t.Before(tick); t = schedule.Next(t) {
+				c.Skipped++
+			}
+		}
+
+		now := time.Now()
+		lastTick := tick.UTC()
+		c.LastTick = &lastTick
+
+		if c.RunningUntil != nil && now.Before(*c.RunningUntil) {
+			c.Skipped++
+			return nil
+		}
+
+		delay := now.Sub(tick)
+		if config.StartingDeadline > 0 && delay > config.StartingDeadline {
+			c.Skipped++
+			return nil
+		}
+
+		runUntil = now.Add(config.MaxRuntime).UTC()
+		c.LastRunBy = config.Identity
+		c.LastDelay = delay
+		c.RunningUntil = &runUntil
+		c.Runs++
+		claimed = true
+
+		return nil
+	})
+	if err != nil {
+		return false, time.Time{}, err
+	}
+
+	return claimed, runUntil, nil
+}
+
+// execute runs a claimed tick of a job, and records the outcome.
+func execute(ctx context.Context, config Config, key string, job Job, tick, runUntil time.Time) {
+	runCtx, cancel := context.WithDeadline(ctx, runUntil)
+	runErr := job.Run(runCtx, tick)
+	cancel()
+
+	_ = update(context.WithoutCancel(ctx), config, key, func(c *content) error {
+		// Another run has started since (as we overran).
+		if c.RunningUntil == nil || !c.RunningUntil.Equal(runUntil) {
+			return errUnchanged
+		}
+
+		finished := time.Now().UTC()
+		c.LastFinished = &finished
+		c.RunningUntil = nil
+		c.LastError = ""
+		if runErr != nil {
+			c.LastError = runErr.Error()
+			c.Failures++
+		}
+
+		return nil
+	})
+}
+
+var errUnchanged = errors.New("unchanged")
+
+// update atomically updates a job state object, retrying on conflicts.
+func update(ctx context.Context, config Config, key string, fn func(c *content) error) error {
+	err := retry.Do(
+		func() error {
+			_, err := config.Provider.AtomicUpdateObject(ctx, config.Bucket, key, func(_ string, currentData []byte) ([]byte, error) {
+				c, err := decode(currentData)
+				if err != nil {
+					return nil, err
+				}
+
+				if err := fn(c); err != nil {
+					return nil, err
+				}
+
+				return json.Marshal(c)
+			})
+			if err != nil && !errors.Is(err, provider.ErrConflict) {
+				return retry.Unrecoverable(err)
+			}
+
+			return err
+		},
+		retry.Context(ctx),
+		retry.Attempts(0),
+	)
+	if errors.Is(err, errUnchanged) {
+		return nil
+	}
+
+	return err
+}
+
+// decode decodes a job state object.
+func decode(data []byte) (*content, error) {
+	var c content
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &c); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.SchemaVersion == 0 {
+		c.SchemaVersion = schemaVersion
+	}
+
+	return &c, nil
+}
@@ -0,0 +1,290 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/avast/retry-go/v4"
+	"github.com/dpeckett/objsync/provider"
+	"github.com/google/uuid"
+)
+
+// ErrInvalidWeight is returned when acquiring or releasing a zero or negative
+// weight, or acquiring more weight than the size of the semaphore (which
+// would never succeed).
+var ErrInvalidWeight = errors.New("invalid weight")
+
+// SemaphoreOption is a functional option for configuring a semaphore.
+type SemaphoreOption func(*Semaphore)
+
+// Semaphore is a distributed weighted semaphore. It mirrors the semantics of
+// golang.org/x/sync/semaphore.Weighted, with the exception that waiters are
+// not served in FIFO order.
+type Semaphore struct {
+	provider provider.Provider
+	bucket   string
+	key      string
+	id       string
+	size     int64
+	ttl      time.Duration
+	// The weight currently held by this semaphore instance.
+	mu   sync.Mutex
+	held int64
+}
+
+// The current schema version of the semaphore object.
+const semaphoreSchemaVersion = 1
+
+// The JSON content of the semaphore object.
+type semaphoreContent struct {
+	SchemaVersion int                         `json:"schemaVersion,omitempty"`
+	Size          int64                       `json:"size,omitempty"`
+	Holders       map[string]*semaphoreHolder `json:"holders,omitempty"`
+}
+
+type semaphoreHolder struct {
+	Weight  int64     `json:"weight"`
+	Expires time.Time `json:"expires"`
+}
+
+// NewSemaphore creates a new distributed weighted semaphore with the given
+// maximum combined weight for concurrent access. Permits not renewed (by a
+// subsequent acquisition) within the ttl are automatically released.
+func NewSemaphore(p provider.Provider, bucket, key string, size int64, ttl time.Duration, opts ...SemaphoreOption) *Semaphore {
+	s := &Semaphore{
+		provider: p,
+		bucket:   bucket,
+		key:      key,
+		id:       uuid.New().String(),
+		size:     size,
+		ttl:      ttl,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// Acquire acquires the semaphore with a weight of n, blocking until resources
+// are available or ctx is done. Fails immediately with ErrInvalidWeight if n
+// exceeds the size of the semaphore.
+func (s *Semaphore) Acquire(ctx context.Context, n int64) error {
+	if n <= 0 {
+		return fmt.Errorf("%w: %d", ErrInvalidWeight, n)
+	}
+
+	return retry.Do(
+		func() error {
+			ok, err := s.TryAcquire(ctx, n)
+			if err != nil {
+				return retry.Unrecoverable(err)
+			}
+
+			if ok {
+				return nil
+			}
+
+			return &LockHeldError{}
+		},
+		retry.Context(ctx),
+		retry.Attempts(0),
+		retry.MaxDelay(maxRetryDelay),
+		// Report that the lock was held if we give up.
+		retry.WrapContextErrorWithLastError(true),
+	)
+}
+
+// TryAcquire acquires the semaphore with a weight of n without blocking.
+// On success, returns true. On failure, returns false and leaves the
+// semaphore unchanged.
+func (s *Semaphore) TryAcquire(ctx context.Context, n int64) (bool, error) {
+	if n <= 0 {
+		return false, fmt.Errorf("%w: %d", ErrInvalidWeight, n)
+	}
+
+	var errInsufficientWeight = fmt.Errorf("insufficient weight")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := s.provider.AtomicUpdateObject(ctx, s.bucket, s.key, func(_ string, currentData []byte) ([]byte, error) {
+		content, err := s.decode(currentData)
+		if err != nil {
+			return nil, err
+		}
+
+		holder, ok := content.Holders[s.id]
+		if !ok {
+			holder = &semaphoreHolder{}
+		}
+
+		if n > content.Size {
+			return nil, fmt.Errorf("%w: %d exceeds the size of the semaphore: %d", ErrInvalidWeight, n, content.Size)
+		}
+
+		if content.used()+n > content.Size {
+			return nil, errInsufficientWeight
+		}
+
+		holder.Weight += n
+		holder.Expires = time.Now().Add(s.ttl).UTC()
+		content.Holders[s.id] = holder
+
+		return json.Marshal(content)
+	})
+	if err != nil {
+		if errors.Is(err, errInsufficientWeight) || errors.Is(err, provider.ErrConflict) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	s.held += n
+
+	return true, nil
+}
+
+// Release releases the semaphore with a weight of n.
+func (s *Semaphore) Release(ctx context.Context, n int64) error {
+	if n <= 0 {
+		return fmt.Errorf("%w: %d", ErrInvalidWeight, n)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if n > s.held {
+		return fmt.Errorf("%w: released more than held: %d > %d", ErrNotHeld, n, s.held)
+	}
+
+	_, err := updateObject(ctx, s.provider, s.bucket, s.key, func(_ string, currentData []byte) ([]byte, error) {
+		content, err := s.decode(currentData)
+		if err != nil {
+			return nil, err
+		}
+
+		// Our permits may have already expired.
+		if holder, ok := content.Holders[s.id]; ok {
+			holder.Weight -= n
+			if holder.Weight <= 0 {
+				delete(content.Holders, s.id)
+			}
+		}
+
+		return json.Marshal(content)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.held -= n
+
+	return nil
+}
+
+// ReleaseAll atomically releases all of the weight held by this semaphore.
+func (s *Semaphore) ReleaseAll(ctx context.Context) error {
+	s.mu.Lock()
+	held := s.held
+	s.mu.Unlock()
+
+	if held == 0 {
+		return nil
+	}
+
+	return s.Release(ctx, held)
+}
+
+// Size returns the current maximum combined weight of the semaphore.
+func (s *Semaphore) Size(ctx context.Context) (int64, error) {
+	data, err := readObject(ctx, s.provider, s.bucket, s.key)
+	if err != nil {
+		return -1, err
+	}
+
+	content, err := s.decode(data)
+	if err != nil {
+		return -1, err
+	}
+
+	return content.Size, nil
+}
+
+// Resize changes the maximum combined weight of the semaphore. When shrinking
+// the semaphore, existing holders keep their weight, and new acquisitions will
+// block until enough weight has been released to fit within the new size.
+func (s *Semaphore) Resize(ctx context.Context, size int64) error {
+	if size <= 0 {
+		return fmt.Errorf("invalid semaphore size: %d", size)
+	}
+
+	_, err := updateObject(ctx, s.provider, s.bucket, s.key, func(_ string, currentData []byte) ([]byte, error) {
+		content, err := s.decode(currentData)
+		if err != nil {
+			return nil, err
+		}
+
+		content.Size = size
+
+		return json.Marshal(content)
+	})
+	return err
+}
+
+// decode decodes the semaphore object, pruning any expired holders.
+func (s *Semaphore) decode(data []byte) (*semaphoreContent, error) {
+	var content semaphoreContent
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &content); err != nil {
+			return nil, err
+		}
+	}
+
+	if content.SchemaVersion == 0 {
+		content.SchemaVersion = semaphoreSchemaVersion
+	}
+
+	// The first user of the semaphore gets to decide its size.
+	if content.Size == 0 {
+		content.Size = s.size
+	}
+
+	if content.Holders == nil {
+		content.Holders = make(map[string]*semaphoreHolder)
+	}
+
+	now := time.Now()
+	for id, holder := range content.Holders {
+		if now.After(holder.Expires) {
+			delete(content.Holders, id)
+		}
+	}
+
+	return &content, nil
+}
+
+// used returns the combined weight of all the current holders.
+func (content *semaphoreContent) used() int64 {
+	var used int64
+	for _, holder := range content.Holders {
+		used += holder.Weight
+	}
+
+	return used
+}
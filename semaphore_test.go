@@ -0,0 +1,146 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync_test
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dpeckett/objsync"
+	"github.com/dpeckett/objsync/provider/s3"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestSemaphore(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	key := fmt.Sprintf("test-%d.sem", time.Now().UnixNano())
+
+	ctx := context.Background()
+	p, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	require.NoError(t, err)
+
+	const size = 3
+
+	t.Run("Weighted", func(t *testing.T) {
+		var used int64
+		g, ctx := errgroup.WithContext(ctx)
+		for i := 0; i < 4; i++ {
+			weight := int64(i%2 + 1)
+
+			g.Go(func() error {
+				sem := objsync.NewSemaphore(p, bucket, key, size, 5*time.Second)
+
+				for j := 0; j < 3; j++ {
+					if err := sem.Acquire(ctx, weight); err != nil {
+						return fmt.Errorf("acquire: %w", err)
+					}
+
+					// Verify the combined weight never exceeds the size.
+					if n := atomic.AddInt64(&used, weight); n > size {
+						return fmt.Errorf("semaphore is held with a weight of %d", n)
+					}
+
+					// Simulate some work.
+					time.Sleep(time.Millisecond*10 + time.Duration(rand.Intn(5))*time.Millisecond)
+
+					atomic.AddInt64(&used, -weight)
+
+					if err := sem.Release(ctx, weight); err != nil {
+						return fmt.Errorf("release: %w", err)
+					}
+				}
+
+				return nil
+			})
+		}
+
+		require.NoError(t, g.Wait())
+	})
+
+	t.Run("TryAcquire", func(t *testing.T) {
+		sem := objsync.NewSemaphore(p, bucket, key, size, 5*time.Second)
+
+		ok, err := sem.TryAcquire(ctx, 2)
+		require.NoError(t, err)
+		require.True(t, ok)
+
+		ok, err = objsync.NewSemaphore(p, bucket, key, size, 5*time.Second).TryAcquire(ctx, 2)
+		require.NoError(t, err)
+		require.False(t, ok)
+
+		require.NoError(t, sem.Release(ctx, 2))
+
+		require.ErrorIs(t, sem.Release(ctx, 1), objsync.ErrNotHeld)
+	})
+
+	t.Run("InvalidWeight", func(t *testing.T) {
+		sem := objsync.NewSemaphore(p, bucket, key, size, 5*time.Second)
+
+		for _, n := range []int64{0, -1} {
+			_, err := sem.TryAcquire(ctx, n)
+			require.ErrorIs(t, err, objsync.ErrInvalidWeight)
+
+			require.ErrorIs(t, sem.Acquire(ctx, n), objsync.ErrInvalidWeight)
+			require.ErrorIs(t, sem.Release(ctx, n), objsync.ErrInvalidWeight)
+		}
+
+		// More than the size fails fast, rather than waiting forever.
+		ctx, cancel := context.WithTimeout(ctx, time.Minute)
+		t.Cleanup(cancel)
+
+		start := time.Now()
+		require.ErrorIs(t, sem.Acquire(ctx, size+1), objsync.ErrInvalidWeight)
+		require.Less(t, time.Since(start), 10*time.Second)
+
+		_, err := sem.TryAcquire(ctx, size+1)
+		require.ErrorIs(t, err, objsync.ErrInvalidWeight)
+	})
+
+	t.Run("Resize", func(t *testing.T) {
+		key := fmt.Sprintf("test-%d.sem", time.Now().UnixNano())
+
+		sem := objsync.NewSemaphore(p, bucket, key, size, 5*time.Second)
+
+		require.NoError(t, sem.Acquire(ctx, 1))
+		require.NoError(t, sem.Acquire(ctx, 2))
+
+		// Shrink the semaphore below the weight that is currently held.
+		require.NoError(t, sem.Resize(ctx, 1))
+
+		currentSize, err := sem.Size(ctx)
+		require.NoError(t, err)
+		require.Equal(t, int64(1), currentSize)
+
+		// New acquisitions should wait for the semaphore to drain.
+		other := objsync.NewSemaphore(p, bucket, key, size, 5*time.Second)
+
+		ok, err := other.TryAcquire(ctx, 1)
+		require.NoError(t, err)
+		require.False(t, ok)
+
+		require.NoError(t, sem.ReleaseAll(ctx))
+
+		ok, err = other.TryAcquire(ctx, 1)
+		require.NoError(t, err)
+		require.True(t, ok)
+
+		require.NoError(t, other.ReleaseAll(ctx))
+	})
+}
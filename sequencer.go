@@ -0,0 +1,83 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/dpeckett/objsync/provider"
+)
+
+// SequencerOption is a functional option for configuring a sequencer.
+type SequencerOption func(*Sequencer)
+
+// WithBlockSize sets the number of IDs reserved from the object store at a
+// time (defaults to 1000). Larger blocks mean fewer round trips, but more IDs
+// are skipped when a process exits without using its whole block.
+func WithBlockSize(size int64) SequencerOption {
+	return func(s *Sequencer) {
+		s.blockSize = size
+	}
+}
+
+// Sequencer hands out globally unique, monotonically increasing IDs. IDs are
+// reserved from a shared counter in blocks, so IDs are only monotonic within
+// a single sequencer, and IDs from different processes will interleave.
+type Sequencer struct {
+	counter   *Counter
+	blockSize int64
+	// The current block of reserved IDs.
+	mu   sync.Mutex
+	next int64
+	last int64
+}
+
+// NewSequencer creates a new distributed sequencer, the first ID handed out is
+// 1.
+func NewSequencer(p provider.Provider, bucket, key string, opts ...SequencerOption) *Sequencer {
+	s := &Sequencer{
+		counter:   NewCounter(p, bucket, key),
+		blockSize: 1000,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// Next returns the next ID, reserving a new block of IDs if the current block
+// has been used up.
+func (s *Sequencer) Next(ctx context.Context) (int64, error) {
+	if s.blockSize <= 0 {
+		return -1, fmt.Errorf("invalid block size: %d", s.blockSize)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.next == 0 || s.next > s.last {
+		last, err := s.counter.Add(ctx, s.blockSize)
+		if err != nil {
+			return -1, fmt.Errorf("failed to reserve block: %w", err)
+		}
+
+		s.next = last - s.blockSize + 1
+		s.last = last
+	}
+
+	id := s.next
+	s.next++
+
+	return id, nil
+}
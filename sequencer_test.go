@@ -0,0 +1,73 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dpeckett/objsync"
+	"github.com/dpeckett/objsync/provider/s3"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestSequencer(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	key := fmt.Sprintf("test-%d.seq", time.Now().UnixNano())
+
+	ctx := context.Background()
+	p, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	require.NoError(t, err)
+
+	var mu sync.Mutex
+	seen := make(map[int64]bool)
+
+	g, ctx := errgroup.WithContext(ctx)
+	for i := 0; i < 3; i++ {
+		g.Go(func() error {
+			seq := objsync.NewSequencer(p, bucket, key, objsync.WithBlockSize(10))
+
+			var prev int64
+			for j := 0; j < 25; j++ {
+				id, err := seq.Next(ctx)
+				if err != nil {
+					return fmt.Errorf("next: %w", err)
+				}
+
+				if id <= prev {
+					return fmt.Errorf("id %d is not greater than %d", id, prev)
+				}
+				prev = id
+
+				mu.Lock()
+				if seen[id] {
+					mu.Unlock()
+					return fmt.Errorf("duplicate id %d", id)
+				}
+				seen[id] = true
+				mu.Unlock()
+			}
+
+			return nil
+		})
+	}
+
+	require.NoError(t, g.Wait())
+	require.Len(t, seen, 75)
+}
@@ -0,0 +1,147 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+
+	"github.com/dpeckett/objsync/provider"
+)
+
+// Set is a distributed set of strings (eg. for tracking in-flight job IDs, or
+// claimed resources across a fleet).
+type Set struct {
+	provider provider.Provider
+	bucket   string
+	key      string
+}
+
+// The current schema version of the set object.
+const setSchemaVersion = 1
+
+// The JSON content of the set object.
+type setContent struct {
+	SchemaVersion int `json:"schemaVersion,omitempty"`
+	// The members of the set, in sorted order.
+	Members []string `json:"members,omitempty"`
+}
+
+// NewSet creates a new distributed set.
+func NewSet(p provider.Provider, bucket, key string) *Set {
+	return &Set{
+		provider: p,
+		bucket:   bucket,
+		key:      key,
+	}
+}
+
+// Add adds a member to the set. It returns false if the member was already
+// in the set, so it can be used to claim a resource.
+func (s *Set) Add(ctx context.Context, member string) (bool, error) {
+	return s.update(ctx, func(content *setContent) bool {
+		i, found := slices.BinarySearch(content.Members, member)
+		if found {
+			return false
+		}
+
+		content.Members = slices.Insert(content.Members, i, member)
+
+		return true
+	})
+}
+
+// Remove removes a member from the set. It returns false if the member wasn't
+// in the set.
+func (s *Set) Remove(ctx context.Context, member string) (bool, error) {
+	return s.update(ctx, func(content *setContent) bool {
+		i, found := slices.BinarySearch(content.Members, member)
+		if !found {
+			return false
+		}
+
+		content.Members = slices.Delete(content.Members, i, i+1)
+
+		return true
+	})
+}
+
+// Contains reports whether the given member is in the set.
+func (s *Set) Contains(ctx context.Context, member string) (bool, error) {
+	members, err := s.Members(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	_, found := slices.BinarySearch(members, member)
+
+	return found, nil
+}
+
+// Members returns the members of the set, in sorted order.
+func (s *Set) Members(ctx context.Context) ([]string, error) {
+	data, err := readObject(ctx, s.provider, s.bucket, s.key)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := decodeSetContent(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return content.Members, nil
+}
+
+// update atomically applies fn to the set, only writing the set if fn reports
+// that it was changed.
+func (s *Set) update(ctx context.Context, fn func(content *setContent) bool) (bool, error) {
+	var errUnchanged = errors.New("unchanged")
+
+	_, err := updateObject(ctx, s.provider, s.bucket, s.key, func(_ string, currentData []byte) ([]byte, error) {
+		content, err := decodeSetContent(currentData)
+		if err != nil {
+			return nil, err
+		}
+
+		if !fn(content) {
+			return nil, errUnchanged
+		}
+
+		return json.Marshal(content)
+	})
+	if err != nil {
+		if errors.Is(err, errUnchanged) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	return true, nil
+}
+
+// decodeSetContent decodes the set object.
+func decodeSetContent(data []byte) (*setContent, error) {
+	var content setContent
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &content); err != nil {
+			return nil, err
+		}
+	}
+
+	if content.SchemaVersion == 0 {
+		content.SchemaVersion = setSchemaVersion
+	}
+
+	return &content, nil
+}
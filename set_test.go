@@ -0,0 +1,82 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dpeckett/objsync"
+	"github.com/dpeckett/objsync/provider/s3"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestSet(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	key := fmt.Sprintf("test-%d.set", time.Now().UnixNano())
+
+	ctx := context.Background()
+	p, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	require.NoError(t, err)
+
+	s := objsync.NewSet(p, bucket, key)
+
+	// Only one of the workers can claim each job.
+	var claimed int32
+	g, gctx := errgroup.WithContext(ctx)
+	for i := 0; i < 5; i++ {
+		g.Go(func() error {
+			for _, job := range []string{"b", "a", "c"} {
+				ok, err := s.Add(gctx, job)
+				if err != nil {
+					return err
+				}
+
+				if ok {
+					atomic.AddInt32(&claimed, 1)
+				}
+			}
+
+			return nil
+		})
+	}
+
+	require.NoError(t, g.Wait())
+	require.Equal(t, int32(3), claimed)
+
+	members, err := s.Members(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b", "c"}, members)
+
+	ok, err := s.Remove(ctx, "b")
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	ok, err = s.Remove(ctx, "b")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	ok, err = s.Contains(ctx, "b")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	ok, err = s.Contains(ctx, "c")
+	require.NoError(t, err)
+	require.True(t, ok)
+}
@@ -0,0 +1,120 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+)
+
+// ErrLockTampered is returned when the lock object of a mutex with a signing
+// key (see WithSigningKey) is unsigned, or its signature doesn't match its
+// content.
+var ErrLockTampered = errors.New("lock object has been tampered with")
+
+// WithSigningKey signs the lock object with an HMAC of its content, and
+// rejects lock objects that are unsigned, or whose signature doesn't match
+// (with ErrLockTampered). In buckets with many writers, this stops clients
+// that don't have the key from forging the state of the lock (eg. its fencing
+// token). All the mutexes for a key must use the same signing key.
+//
+// Signatures don't prevent an older signed lock object from being restored,
+// use a fence sidecar (see WithFenceSidecar) to detect that. Existing lock
+// objects (and tampered ones) can be signed by breaking them with the signing
+// key (see WithBreakSigningKey).
+func WithSigningKey(key []byte) MutexOption {
+	return func(mu *Mutex) {
+		mu.signingKey = key
+	}
+}
+
+// encodeSignedMutexContent encodes the mutex object, signing it if a signing
+// key is given.
+func encodeSignedMutexContent(signingKey []byte, bucket, key string, content *mutexContent) ([]byte, error) {
+	// Any existing signature no longer matches the content.
+	content.Signature = ""
+
+	data, err := json.Marshal(content)
+	if err != nil || signingKey == nil {
+		return data, err
+	}
+
+	content.Signature, err = mutexSignature(signingKey, bucket, key, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(content)
+}
+
+// verifyMutexContent verifies the signature of the mutex object, if a signing
+// key is given. Missing lock objects don't need to be signed.
+func verifyMutexContent(signingKey []byte, bucket, key string, data []byte) error {
+	if signingKey == nil || len(data) == 0 {
+		return nil
+	}
+
+	var fields struct {
+		Signature string `json:"signature"`
+	}
+	if err := json.Unmarshal(data, &fields); err != nil || fields.Signature == "" {
+		return ErrLockTampered
+	}
+
+	signature, err := mutexSignature(signingKey, bucket, key, data)
+	if err != nil {
+		return ErrLockTampered
+	}
+
+	if !hmac.Equal([]byte(fields.Signature), []byte(signature)) {
+		return ErrLockTampered
+	}
+
+	return nil
+}
+
+// mutexSignature computes the signature of the mutex object, over the object
+// location and a canonical encoding of every field but the signature (so
+// that it doesn't depend on the order in which clients encode fields).
+func mutexSignature(signingKey []byte, bucket, key string, data []byte) (string, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return "", err
+	}
+	delete(fields, "signature")
+
+	canonical, err := json.Marshal(fields)
+	if err != nil {
+		return "", err
+	}
+
+	mac := hmac.New(sha256.New, signingKey)
+	mac.Write([]byte(bucket + "\x00" + key + "\x00"))
+	mac.Write(canonical)
+
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// decodeContent verifies (see WithSigningKey) and decodes the lock object.
+func (mu *Mutex) decodeContent(data []byte) (*mutexContent, error) {
+	if err := verifyMutexContent(mu.signingKey, mu.bucket, mu.key, data); err != nil {
+		return nil, err
+	}
+
+	return decodeMutexContent(data)
+}
+
+// encodeContent encodes (and signs, see WithSigningKey) the lock object.
+func (mu *Mutex) encodeContent(content *mutexContent) ([]byte, error) {
+	return encodeSignedMutexContent(mu.signingKey, mu.bucket, mu.key, content)
+}
@@ -0,0 +1,102 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dpeckett/objsync"
+	"github.com/dpeckett/objsync/provider/s3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMutexSigning(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	ctx := context.Background()
+	p, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	require.NoError(t, err)
+
+	signingKey := []byte("secret")
+
+	t.Run("Tampered", func(t *testing.T) {
+		key := fmt.Sprintf("test-%d.lock", time.Now().UnixNano())
+
+		mu := objsync.NewMutex(p, bucket, key, objsync.WithSigningKey(signingKey), objsync.WithFenceRegressionCheck())
+
+		var fencingToken int64
+		for i := 0; i < 3; i++ {
+			fencingToken, err = mu.Lock(ctx, time.Minute)
+			require.NoError(t, err)
+			require.NoError(t, mu.Unlock(ctx))
+		}
+
+		data, err := objsync.ReadObject(ctx, p, bucket, key)
+		require.NoError(t, err)
+		require.Contains(t, string(data), `"signature":`)
+
+		// A rogue client resets the fencing token.
+		_, err = p.AtomicUpdateObject(ctx, bucket, key, func(_ string, currentData []byte) ([]byte, error) {
+			var fields map[string]any
+			if err := json.Unmarshal(currentData, &fields); err != nil {
+				return nil, err
+			}
+			fields["fence"] = 0
+
+			return json.Marshal(fields)
+		})
+		require.NoError(t, err)
+
+		_, _, err = mu.TryLock(ctx, time.Minute)
+		require.ErrorIs(t, err, objsync.ErrLockTampered)
+
+		// Breaking the lock with the signing key signs it again.
+		require.NoError(t, objsync.BreakLock(ctx, p, bucket, key, objsync.WithBreakSigningKey(signingKey)))
+
+		// The forged fencing token was kept, which is caught as a regression.
+		_, _, err = mu.TryLock(ctx, time.Minute)
+		require.ErrorIs(t, err, objsync.ErrFenceRegression)
+
+		info, err := mu.GetLockInfo(ctx)
+		require.NoError(t, err)
+		require.Less(t, info.FencingToken, fencingToken)
+	})
+
+	t.Run("Unsigned", func(t *testing.T) {
+		key := fmt.Sprintf("test-%d.lock", time.Now().UnixNano())
+
+		_, err := objsync.NewMutex(p, bucket, key).Lock(ctx, time.Millisecond)
+		require.NoError(t, err)
+
+		_, _, err = objsync.NewMutex(p, bucket, key, objsync.WithSigningKey(signingKey)).TryLock(ctx, time.Minute)
+		require.ErrorIs(t, err, objsync.ErrLockTampered)
+	})
+
+	t.Run("WrongKey", func(t *testing.T) {
+		key := fmt.Sprintf("test-%d.lock", time.Now().UnixNano())
+
+		mu := objsync.NewMutex(p, bucket, key, objsync.WithSigningKey(signingKey))
+
+		_, err := mu.Lock(ctx, time.Minute)
+		require.NoError(t, err)
+		require.NoError(t, mu.Unlock(ctx))
+
+		_, _, err = objsync.NewMutex(p, bucket, key, objsync.WithSigningKey([]byte("other"))).TryLock(ctx, time.Minute)
+		require.ErrorIs(t, err, objsync.ErrLockTampered)
+	})
+}
@@ -0,0 +1,183 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dpeckett/objsync/provider"
+)
+
+// SingleFlightOption is a functional option for configuring a singleflight.
+type SingleFlightOption func(*SingleFlight)
+
+// WithSingleFlightPollInterval sets how often callers waiting on an in-flight
+// call check for its result.
+func WithSingleFlightPollInterval(interval time.Duration) SingleFlightOption {
+	return func(sf *SingleFlight) {
+		sf.pollInterval = interval
+	}
+}
+
+// SingleFlight provides a duplicate call suppression mechanism that works
+// across processes. It is the distributed equivalent of
+// golang.org/x/sync/singleflight.
+type SingleFlight struct {
+	provider     provider.Provider
+	bucket       string
+	ttl          time.Duration
+	pollInterval time.Duration
+}
+
+// The current schema version of the singleflight result object.
+const singleFlightSchemaVersion = 1
+
+// The JSON content of the singleflight result object.
+type singleFlightResult struct {
+	SchemaVersion int    `json:"schemaVersion,omitempty"`
+	Fence         int64  `json:"fence,omitempty"`
+	Value         []byte `json:"value,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+// NewSingleFlight creates a new distributed singleflight. The ttl is the
+// maximum duration a call will be allowed to run for, after which it is
+// assumed to have failed and another caller will execute the function.
+func NewSingleFlight(p provider.Provider, bucket string, ttl time.Duration, opts ...SingleFlightOption) *SingleFlight {
+	sf := &SingleFlight{
+		provider:     p,
+		bucket:       bucket,
+		ttl:          ttl,
+		pollInterval: 100 * time.Millisecond,
+	}
+
+	for _, opt := range opts {
+		opt(sf)
+	}
+
+	return sf
+}
+
+// Do executes and returns the results of the given function, making sure that
+// only one execution is in-flight for a given key across all processes at a
+// time. If a duplicate comes in, the duplicate caller waits for the original
+// to complete and receives the same results (as published to the result
+// object). The return value shared reports whether the result was produced
+// by another caller.
+func (sf *SingleFlight) Do(ctx context.Context, key string, fn func(ctx context.Context) ([]byte, error)) (value []byte, shared bool, err error) {
+	lockKey := key + ".lock"
+	resultKey := key + ".result"
+
+	mu := NewMutex(sf.provider, sf.bucket, lockKey)
+
+	for {
+		ok, fencingToken, err := mu.TryLock(ctx, sf.ttl)
+		if err != nil {
+			return nil, false, err
+		}
+
+		if ok {
+			value, err := sf.execute(ctx, mu, resultKey, fencingToken, fn)
+			return value, false, err
+		}
+
+		result, err := sf.waitForResult(ctx, lockKey, resultKey)
+		if err != nil {
+			return nil, false, err
+		}
+
+		// The in-flight call never published a result, try to take over.
+		if result == nil {
+			continue
+		}
+
+		if result.Error != "" {
+			return result.Value, true, errors.New(result.Error)
+		}
+
+		return result.Value, true, nil
+	}
+}
+
+// execute runs the function and publishes its result.
+func (sf *SingleFlight) execute(ctx context.Context, mu *Mutex, resultKey string, fencingToken int64, fn func(ctx context.Context) ([]byte, error)) ([]byte, error) {
+	defer func() {
+		_ = mu.Unlock(context.WithoutCancel(ctx))
+	}()
+
+	value, fnErr := fn(ctx)
+
+	result := singleFlightResult{
+		SchemaVersion: singleFlightSchemaVersion,
+		Fence:         fencingToken,
+		Value:         value,
+	}
+	if fnErr != nil {
+		result.Error = fnErr.Error()
+	}
+
+	_, err := updateObject(ctx, sf.provider, sf.bucket, resultKey, func(_ string, _ []byte) ([]byte, error) {
+		return json.Marshal(result)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to publish result: %w", err)
+	}
+
+	return value, fnErr
+}
+
+// waitForResult waits for the in-flight call to publish its result. If the
+// in-flight call is abandoned without publishing a result, nil is returned.
+func (sf *SingleFlight) waitForResult(ctx context.Context, lockKey, resultKey string) (*singleFlightResult, error) {
+	data, err := readObject(ctx, sf.provider, sf.bucket, lockKey)
+	if err != nil {
+		return nil, err
+	}
+
+	lock, err := decodeMutexContent(data)
+	if err != nil {
+		return nil, err
+	}
+
+	ticker := time.NewTicker(sf.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		data, err := readObject(ctx, sf.provider, sf.bucket, resultKey)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(data) > 0 {
+			var result singleFlightResult
+			if err := json.Unmarshal(data, &result); err != nil {
+				return nil, err
+			}
+
+			if result.Fence >= lock.Fence {
+				return &result, nil
+			}
+		}
+
+		if lock.Expires == nil || time.Now().After(*lock.Expires) {
+			return nil, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,72 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dpeckett/objsync"
+	"github.com/dpeckett/objsync/provider/s3"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestSingleFlight(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	key := fmt.Sprintf("test-%d", time.Now().UnixNano())
+
+	ctx := context.Background()
+	p, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	require.NoError(t, err)
+
+	var calls, sharedResults int32
+	g, ctx := errgroup.WithContext(ctx)
+	for i := 0; i < 3; i++ {
+		g.Go(func() error {
+			sf := objsync.NewSingleFlight(p, bucket, 10*time.Second)
+
+			value, shared, err := sf.Do(ctx, key, func(ctx context.Context) ([]byte, error) {
+				atomic.AddInt32(&calls, 1)
+
+				// Simulate an expensive computation.
+				time.Sleep(time.Second)
+
+				return []byte("result"), nil
+			})
+			if err != nil {
+				return err
+			}
+
+			if string(value) != "result" {
+				return fmt.Errorf("unexpected result: %q", value)
+			}
+
+			if shared {
+				atomic.AddInt32(&sharedResults, 1)
+			}
+
+			return nil
+		})
+	}
+
+	require.NoError(t, g.Wait())
+
+	require.Equal(t, int32(1), calls)
+	require.Equal(t, int32(2), sharedResults)
+}
@@ -0,0 +1,76 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package stats
+
+import (
+	"context"
+	"log/slog"
+)
+
+// LogHandler is a Handler that logs events to a structured logger, at debug
+// level.
+type LogHandler struct {
+	logger *slog.Logger
+}
+
+// NewLogHandler creates a new Handler that logs events to the given logger.
+// It can be passed to providers (and mutexes) to diagnose eg. why a process
+// is stuck waiting for a lock.
+func NewLogHandler(logger *slog.Logger) *LogHandler {
+	return &LogHandler{logger: logger}
+}
+
+func (h *LogHandler) HandleEvent(ctx context.Context, event Event) {
+	if !h.logger.Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+
+	attrs := []slog.Attr{
+		slog.String("bucket", event.Bucket),
+		slog.String("key", event.Key),
+	}
+
+	if event.OwnerID != "" {
+		attrs = append(attrs, slog.String("ownerID", event.OwnerID))
+	}
+
+	if event.FencingToken != 0 {
+		attrs = append(attrs, slog.Int64("fencingToken", event.FencingToken))
+	}
+
+	if event.Operation != "" {
+		attrs = append(attrs, slog.String("operation", event.Operation))
+	}
+
+	if event.Duration != 0 {
+		attrs = append(attrs, slog.Duration("duration", event.Duration))
+	}
+
+	if event.Err != nil {
+		attrs = append(attrs, slog.Any("error", event.Err))
+	}
+
+	h.logger.LogAttrs(ctx, slog.LevelDebug, "objsync: "+string(event.Type), attrs...)
+}
+
+// multiHandler fans events out to several handlers.
+type multiHandler []Handler
+
+// MultiHandler returns a Handler that passes events to each of the given
+// handlers, in order.
+func MultiHandler(handlers ...Handler) Handler {
+	return multiHandler(handlers)
+}
+
+func (hs multiHandler) HandleEvent(ctx context.Context, event Event) {
+	for _, h := range hs {
+		h.HandleEvent(ctx, event)
+	}
+}
@@ -0,0 +1,125 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package stats defines the structured events emitted by objsync, so that
+// they can be bridged to any telemetry stack (Prometheus, OpenTelemetry,
+// StatsD, etc) without objsync depending on it.
+package stats
+
+import (
+	"context"
+	"time"
+)
+
+// EventType is the type of an event.
+type EventType string
+
+const (
+	// EventAcquireAttempt is emitted when an attempt is made to acquire a lock.
+	EventAcquireAttempt EventType = "acquire_attempt"
+	// EventAcquired is emitted when a lock has been acquired.
+	EventAcquired EventType = "acquired"
+	// EventContended is emitted when an attempt to acquire a lock failed
+	// because the lock is held by someone else.
+	EventContended EventType = "contended"
+	// EventAcquireFailed is emitted when an attempt to acquire a lock failed
+	// due to an error.
+	EventAcquireFailed EventType = "acquire_failed"
+	// EventReleased is emitted when a lock has been released.
+	EventReleased EventType = "released"
+	// EventRenewed is emitted when a hold on a lock has been renewed.
+	EventRenewed EventType = "renewed"
+	// EventLost is emitted when a hold on a lock has been lost.
+	EventLost EventType = "lost"
+	// EventProviderRequest is emitted by providers for every request made to
+	// the underlying object store.
+	EventProviderRequest EventType = "provider_request"
+)
+
+// Event is a structured event emitted by objsync.
+type Event struct {
+	// Type is the type of the event.
+	Type EventType
+	// Bucket is the bucket of the object the event relates to.
+	Bucket string
+	// Key is the key of the object the event relates to.
+	Key string
+	// OwnerID is the owner ID of the lock (if applicable).
+	OwnerID string
+	// FencingToken is the fencing token of the hold (if applicable).
+	FencingToken int64
+	// Operation is the name of the provider operation (for provider events).
+	Operation string
+	// Duration is the duration of the operation, or for released events, how
+	// long the lock was held for.
+	Duration time.Duration
+	// Err is the error that occurred (if any).
+	Err error
+}
+
+// Handler handles events emitted by objsync. Implementations must be safe
+// for concurrent use, and should not block.
+type Handler interface {
+	HandleEvent(ctx context.Context, event Event)
+}
+
+// HandlerFunc is an adapter to allow the use of ordinary functions as event
+// handlers.
+type HandlerFunc func(ctx context.Context, event Event)
+
+func (f HandlerFunc) HandleEvent(ctx context.Context, event Event) {
+	f(ctx, event)
+}
+
+// Hooks is a Handler that dispatches lock events to per-event callbacks,
+// for when only a few events are of interest. Any of the callbacks may be
+// nil.
+type Hooks struct {
+	// OnAcquireAttempt is called when an attempt is made to acquire a lock.
+	OnAcquireAttempt func(ctx context.Context, event Event)
+	// OnAcquired is called when a lock has been acquired.
+	OnAcquired func(ctx context.Context, event Event)
+	// OnContention is called when a lock could not be acquired because it is
+	// held by someone else.
+	OnContention func(ctx context.Context, event Event)
+	// OnAcquireFailed is called when an attempt to acquire a lock failed due
+	// to an error.
+	OnAcquireFailed func(ctx context.Context, event Event)
+	// OnRenewed is called when a hold on a lock has been renewed.
+	OnRenewed func(ctx context.Context, event Event)
+	// OnReleased is called when a lock has been released.
+	OnReleased func(ctx context.Context, event Event)
+	// OnExpired is called when a hold on a lock has been lost (eg. because it
+	// expired without being renewed).
+	OnExpired func(ctx context.Context, event Event)
+}
+
+func (h *Hooks) HandleEvent(ctx context.Context, event Event) {
+	var fn func(ctx context.Context, event Event)
+	switch event.Type {
+	case EventAcquireAttempt:
+		fn = h.OnAcquireAttempt
+	case EventAcquired:
+		fn = h.OnAcquired
+	case EventContended:
+		fn = h.OnContention
+	case EventAcquireFailed:
+		fn = h.OnAcquireFailed
+	case EventRenewed:
+		fn = h.OnRenewed
+	case EventReleased:
+		fn = h.OnReleased
+	case EventLost:
+		fn = h.OnExpired
+	}
+
+	if fn != nil {
+		fn(ctx, event)
+	}
+}
@@ -0,0 +1,229 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dpeckett/objsync/provider"
+)
+
+// SweepOption is a functional option for configuring a sweep.
+type SweepOption func(*sweepOptions)
+
+type sweepOptions struct {
+	minIdle         time.Duration
+	fenceSidecarKey func(key string) string
+	signingKey      []byte
+}
+
+// WithSweepMinIdle sets how long a lock must have been idle (released, or
+// expired) before it is deleted by a sweep (defaults to 24 hours).
+func WithSweepMinIdle(d time.Duration) SweepOption {
+	return func(opts *sweepOptions) {
+		opts.minIdle = d
+	}
+}
+
+// WithSweepFenceSidecar records the fencing token of each swept lock in its
+// fence sidecar object (see WithFenceSidecar), as returned by the given
+// function, before the lock object is deleted.
+func WithSweepFenceSidecar(fn func(key string) string) SweepOption {
+	return func(opts *sweepOptions) {
+		opts.fenceSidecarKey = fn
+	}
+}
+
+// WithSweepSigningKey sets the signing key of the mutexes under the prefix
+// (see WithSigningKey). Lock objects that have been tampered with are left as
+// is. Without it, signed lock objects that don't record when they became idle
+// are left as is, as marking them as idle would invalidate their signature.
+func WithSweepSigningKey(key []byte) SweepOption {
+	return func(opts *sweepOptions) {
+		opts.signingKey = key
+	}
+}
+
+// Sweep garbage collects the lock objects under the given prefix that have
+// been idle for a long time, returning the number of lock objects deleted.
+// Other objects under the prefix are left as is. The provider must support
+// listing and conditionally deleting objects (see provider.Lister and
+// provider.Deleter).
+//
+// Deleting a lock object resets its fencing token, so for fencing tokens to
+// remain monotonic, mutexes for swept keys must use a fence sidecar (see
+// WithSweepFenceSidecar), or time derived fence epochs (see
+// WithTimeFenceEpoch).
+//
+// Locks released by older clients (or held by a lease) don't record when
+// they became idle, such locks are marked as idle by the first sweep that
+// sees them, and deleted by a later sweep.
+func Sweep(ctx context.Context, p provider.Provider, bucket, prefix string, opts ...SweepOption) (int, error) {
+	options := sweepOptions{
+		minIdle: 24 * time.Hour,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	lister, ok := p.(provider.Lister)
+	if !ok {
+		return 0, fmt.Errorf("listing objects: %w", provider.ErrNotSupported)
+	}
+
+	deleter, ok := p.(provider.Deleter)
+	if !ok || !provider.CapabilitiesOf(p).ConditionalDelete {
+		return 0, fmt.Errorf("deleting objects: %w", provider.ErrNotSupported)
+	}
+
+	keys, err := lister.ListObjects(ctx, bucket, prefix)
+	if err != nil {
+		return 0, err
+	}
+
+	var swept int
+	for _, key := range keys {
+		ok, err := sweepLock(ctx, p, deleter, bucket, key, &options)
+		if err != nil {
+			return swept, fmt.Errorf("failed to sweep %q: %w", key, err)
+		}
+
+		if ok {
+			swept++
+		}
+	}
+
+	return swept, nil
+}
+
+// RunSweeper sweeps the lock objects under the given prefix at the given
+// interval (see Sweep), blocking until the context is cancelled. Failed
+// sweeps are retried at the next interval.
+func RunSweeper(ctx context.Context, p provider.Provider, bucket, prefix string, interval time.Duration, opts ...SweepOption) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			_, _ = Sweep(ctx, p, bucket, prefix, opts...)
+		}
+	}
+}
+
+// Sweep garbage collects the idle lock objects in the namespace of the
+// manager (see Sweep).
+func (m *Manager) Sweep(ctx context.Context, opts ...SweepOption) (int, error) {
+	return Sweep(ctx, m.provider, m.bucket, m.prefix, opts...)
+}
+
+// sweepLock deletes the lock object at the given key, if it has been idle for
+// long enough, returning whether it was deleted.
+func sweepLock(ctx context.Context, p provider.Provider, deleter provider.Deleter, bucket, key string, options *sweepOptions) (bool, error) {
+	var errSkip = errors.New("skip")
+	var errDelete = errors.New("delete")
+
+	var etag string
+	var fence int64
+	_, err := updateLockObject(ctx, p, bucket, key, func(reads *lockReads, currentETag string, currentData []byte) ([]byte, error) {
+		content, ok := decodeLockObject(currentData)
+		if !ok || verifyMutexContent(options.signingKey, bucket, key, currentData) != nil {
+			return nil, errSkip
+		}
+
+		now := time.Now()
+		held, err := reads.isHeld(content, now)
+		if err != nil {
+			return nil, err
+		}
+
+		if held {
+			return nil, errSkip
+		}
+
+		var idleSince time.Time
+		switch {
+		case content.Released != nil:
+			idleSince = *content.Released
+		case content.Expires != nil && content.Lease == nil:
+			idleSince = *content.Expires
+		default:
+			// Signed lock objects can't be updated without the signing key.
+			if content.Signature != "" && options.signingKey == nil {
+				return nil, errSkip
+			}
+
+			// We don't know how long the lock has been idle, so start counting.
+			released := now.UTC()
+			content.Released = &released
+
+			return encodeSignedMutexContent(options.signingKey, bucket, key, content)
+		}
+
+		if now.Sub(idleSince) < options.minIdle {
+			return nil, errSkip
+		}
+
+		etag = currentETag
+		fence = content.Fence
+
+		return nil, errDelete
+	})
+	if err == nil || errors.Is(err, errSkip) || errors.Is(err, provider.ErrConflict) {
+		return false, nil
+	}
+
+	if !errors.Is(err, errDelete) {
+		return false, err
+	}
+
+	if options.fenceSidecarKey != nil {
+		if _, err := updateFenceSidecar(ctx, p, bucket, options.fenceSidecarKey(key), fence); err != nil {
+			return false, fmt.Errorf("failed to update fence sidecar: %w", err)
+		}
+	}
+
+	if err := deleter.DeleteObject(ctx, bucket, key, etag); err != nil {
+		// Someone else acquired the lock in the meantime.
+		if errors.Is(err, provider.ErrConflict) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	return true, nil
+}
+
+// decodeLockObject decodes an object, if it looks like a lock object (rather
+// than eg. an audit trail, or the object of another primitive).
+func decodeLockObject(data []byte) (*mutexContent, bool) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, false
+	}
+
+	if _, ok := fields["schemaVersion"]; !ok {
+		return nil, false
+	}
+
+	content, err := decodeMutexContent(data)
+	if err != nil || content.Fence == 0 || len(content.unknownFields) > 0 {
+		return nil, false
+	}
+
+	return content, true
+}
@@ -0,0 +1,79 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dpeckett/objsync"
+	"github.com/dpeckett/objsync/provider/s3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSweep(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	prefix := fmt.Sprintf("test-%d/", time.Now().UnixNano())
+
+	ctx := context.Background()
+	p, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey, s3.WithConditionalDelete())
+	require.NoError(t, err)
+
+	m := objsync.NewManager(p, bucket, objsync.WithManagerPrefix(prefix))
+
+	// Released.
+	released := m.Mutex("released", objsync.WithAuditTrail(prefix+"released.audit"))
+	releasedToken, err := released.Lock(ctx, time.Minute)
+	require.NoError(t, err)
+	require.NoError(t, released.Unlock(ctx))
+
+	// Expired.
+	_, err = m.Mutex("expired").Lock(ctx, 100*time.Millisecond)
+	require.NoError(t, err)
+
+	// Held.
+	held := m.Mutex("held")
+	_, err = held.Lock(ctx, time.Minute)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, held.Unlock(ctx))
+	})
+
+	time.Sleep(200 * time.Millisecond)
+
+	// Not idle for long enough.
+	swept, err := m.Sweep(ctx, objsync.WithSweepMinIdle(time.Hour))
+	require.NoError(t, err)
+	require.Zero(t, swept)
+
+	swept, err = m.Sweep(ctx,
+		objsync.WithSweepMinIdle(100*time.Millisecond),
+		objsync.WithSweepFenceSidecar(func(key string) string { return key + ".fence" }))
+	require.NoError(t, err)
+	require.Equal(t, 2, swept)
+
+	names, err := m.List(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"expired.fence", "held", "released.audit", "released.fence"}, names)
+
+	// Fencing tokens carry on from the sidecar.
+	mu := objsync.NewMutex(p, bucket, prefix+"released", objsync.WithFenceSidecar(prefix+"released.fence"))
+	fencingToken, err := mu.Lock(ctx, time.Minute)
+	require.NoError(t, err)
+	require.Greater(t, fencingToken, releasedToken)
+	require.NoError(t, mu.Unlock(ctx))
+}
@@ -0,0 +1,94 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dpeckett/objsync/provider"
+)
+
+// Throttle suppresses executions of a named action to at most once per
+// interval across all processes.
+type Throttle struct {
+	provider provider.Provider
+	bucket   string
+	key      string
+	interval time.Duration
+}
+
+// The current schema version of the throttle object.
+const throttleSchemaVersion = 1
+
+// The JSON content of the throttle object.
+type throttleContent struct {
+	SchemaVersion int        `json:"schemaVersion,omitempty"`
+	LastRun       *time.Time `json:"lastRun,omitempty"`
+}
+
+// NewThrottle creates a new distributed throttle.
+func NewThrottle(p provider.Provider, bucket, key string, interval time.Duration) *Throttle {
+	return &Throttle{
+		provider: p,
+		bucket:   bucket,
+		key:      key,
+		interval: interval,
+	}
+}
+
+// Allow reports whether the action may be executed now. If it returns true,
+// the current time is recorded as the last execution of the action.
+func (t *Throttle) Allow(ctx context.Context) (bool, error) {
+	var errThrottled = fmt.Errorf("throttled")
+
+	_, err := updateObject(ctx, t.provider, t.bucket, t.key, func(_ string, currentData []byte) ([]byte, error) {
+		var content throttleContent
+		if len(currentData) > 0 {
+			if err := json.Unmarshal(currentData, &content); err != nil {
+				return nil, err
+			}
+		}
+
+		now := time.Now().UTC()
+		if content.LastRun != nil && now.Before(content.LastRun.Add(t.interval)) {
+			return nil, errThrottled
+		}
+
+		content.SchemaVersion = throttleSchemaVersion
+		content.LastRun = &now
+
+		return json.Marshal(content)
+	})
+	if err != nil {
+		if errors.Is(err, errThrottled) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Do executes the function unless the action has already been executed
+// within the interval, reporting whether it was executed. A failed execution
+// still counts towards the interval.
+func (t *Throttle) Do(ctx context.Context, fn func(ctx context.Context) error) (bool, error) {
+	ok, err := t.Allow(ctx)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	return true, fn(ctx)
+}
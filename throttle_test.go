@@ -0,0 +1,68 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dpeckett/objsync"
+	"github.com/dpeckett/objsync/provider/s3"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestThrottle(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	key := fmt.Sprintf("test-%d.throttle", time.Now().UnixNano())
+
+	ctx := context.Background()
+	p, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	require.NoError(t, err)
+
+	const interval = 2 * time.Second
+
+	var executions int32
+	g, gctx := errgroup.WithContext(ctx)
+	for i := 0; i < 3; i++ {
+		g.Go(func() error {
+			throttle := objsync.NewThrottle(p, bucket, key, interval)
+
+			for j := 0; j < 5; j++ {
+				_, err := throttle.Do(gctx, func(ctx context.Context) error {
+					atomic.AddInt32(&executions, 1)
+					return nil
+				})
+				if err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+	}
+
+	require.NoError(t, g.Wait())
+	require.Equal(t, int32(1), executions)
+
+	time.Sleep(interval)
+
+	ok, err := objsync.NewThrottle(p, bucket, key, interval).Allow(ctx)
+	require.NoError(t, err)
+	require.True(t, ok)
+}
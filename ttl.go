@@ -0,0 +1,45 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync
+
+import (
+	"fmt"
+	"time"
+)
+
+// ErrInvalidTTL is returned when a lock is requested with a zero or negative
+// duration, as such a lock would expire immediately.
+var ErrInvalidTTL = fmt.Errorf("invalid ttl")
+
+// WithTTLBounds clamps the duration of locks to the given bounds. A zero
+// bound is not enforced.
+func WithTTLBounds(minTTL, maxTTL time.Duration) MutexOption {
+	return func(mu *Mutex) {
+		mu.minTTL = minTTL
+		mu.maxTTL = maxTTL
+	}
+}
+
+// clampTTL validates the requested ttl and clamps it to the configured bounds.
+func (mu *Mutex) clampTTL(ttl time.Duration) (time.Duration, error) {
+	if ttl <= 0 {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidTTL, ttl)
+	}
+
+	if mu.minTTL > 0 && ttl < mu.minTTL {
+		ttl = mu.minTTL
+	}
+
+	if mu.maxTTL > 0 && ttl > mu.maxTTL {
+		ttl = mu.maxTTL
+	}
+
+	return ttl, nil
+}
@@ -0,0 +1,142 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/avast/retry-go/v4"
+	"github.com/dpeckett/objsync/provider"
+)
+
+// The maximum delay between retries (which otherwise back off exponentially).
+const maxRetryDelay = time.Second
+
+// updateObject atomically updates an object, retrying on write conflicts
+// until the update succeeds or the context is cancelled.
+func updateObject(ctx context.Context, p provider.Provider, bucket, key string, fn provider.UpdateObjectFunc) (string, error) {
+	var etag string
+
+	err := retry.Do(
+		func() error {
+			var err error
+			etag, err = p.AtomicUpdateObject(ctx, bucket, key, fn)
+			if err != nil {
+				if errors.Is(err, provider.ErrConflict) {
+					return err
+				}
+
+				return retry.Unrecoverable(err)
+			}
+
+			return nil
+		},
+		retry.Context(ctx),
+		retry.Attempts(0),
+		retry.MaxDelay(maxRetryDelay),
+	)
+	if err != nil {
+		return "", err
+	}
+
+	return etag, nil
+}
+
+// ReadObject reads the current content of an object (empty if the object
+// doesn't exist) without modifying it, eg. for debugging tools.
+func ReadObject(ctx context.Context, p provider.Provider, bucket, key string) ([]byte, error) {
+	return readObject(ctx, p, bucket, key)
+}
+
+// readObject reads the current content of an object without modifying it.
+func readObject(ctx context.Context, p provider.Provider, bucket, key string) ([]byte, error) {
+	if getter, ok := p.(provider.Getter); ok {
+		data, err := getObject(ctx, getter, bucket, key)
+		if !errors.Is(err, provider.ErrNotSupported) {
+			return data, err
+		}
+	}
+
+	var errReadOnly = errors.New("read only")
+
+	var data []byte
+	_, err := p.AtomicUpdateObject(ctx, bucket, key, func(_ string, currentData []byte) ([]byte, error) {
+		data = currentData
+		return nil, errReadOnly
+	})
+	if err != nil && !errors.Is(err, errReadOnly) {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// headObject reads the current ETag of an object (empty if the object doesn't
+// exist), using the cheapest read the provider supports.
+func headObject(ctx context.Context, p provider.Provider, bucket, key string) (string, error) {
+	if header, ok := p.(provider.Header); ok {
+		etag, err := header.HeadObject(ctx, bucket, key)
+		if !errors.Is(err, provider.ErrNotSupported) {
+			return etag, err
+		}
+	}
+
+	if getter, ok := p.(provider.Getter); ok {
+		_, etag, err := getter.GetObject(ctx, bucket, key)
+		if !errors.Is(err, provider.ErrNotSupported) && !errors.Is(err, provider.ErrConflict) {
+			return etag, err
+		}
+	}
+
+	var errReadOnly = errors.New("read only")
+
+	var etag string
+	_, err := p.AtomicUpdateObject(ctx, bucket, key, func(currentETag string, _ []byte) ([]byte, error) {
+		etag = currentETag
+		return nil, errReadOnly
+	})
+	if err != nil && !errors.Is(err, errReadOnly) {
+		return "", err
+	}
+
+	return etag, nil
+}
+
+// getObject reads an object from a provider that supports direct reads,
+// retrying if the object is replaced mid-read.
+func getObject(ctx context.Context, getter provider.Getter, bucket, key string) ([]byte, error) {
+	var data []byte
+
+	err := retry.Do(
+		func() error {
+			var err error
+			data, _, err = getter.GetObject(ctx, bucket, key)
+			if err != nil {
+				if errors.Is(err, provider.ErrConflict) {
+					return err
+				}
+
+				return retry.Unrecoverable(err)
+			}
+
+			return nil
+		},
+		retry.Context(ctx),
+		retry.Attempts(0),
+		retry.MaxDelay(maxRetryDelay),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
@@ -0,0 +1,96 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/dpeckett/objsync/provider"
+)
+
+// watchObject watches an object for changes (see provider.Watcher). If the
+// provider can't watch objects natively, the ETag of the object is polled at
+// the given interval instead. The channel is closed when ctx is done, or
+// after an error.
+func watchObject(ctx context.Context, p provider.Provider, bucket, key string, pollInterval time.Duration) <-chan provider.Event {
+	events := make(chan provider.Event, 1)
+
+	go func() {
+		defer close(events)
+
+		if watcher, ok := p.(provider.Watcher); ok {
+			for event := range watcher.WatchObject(ctx, bucket, key) {
+				// Wrapped providers might not support watching.
+				if errors.Is(event.Err, provider.ErrNotSupported) {
+					break
+				}
+
+				if !sendEvent(ctx, events, event) || event.Err != nil {
+					return
+				}
+			}
+
+			if ctx.Err() != nil {
+				return
+			}
+		}
+
+		pollObject(ctx, p, bucket, key, pollInterval, events)
+	}()
+
+	return events
+}
+
+// pollObject sends an event whenever the ETag of an object changes, polling
+// it at the given interval, until ctx is done or polling fails.
+func pollObject(ctx context.Context, p provider.Provider, bucket, key string, interval time.Duration, events chan<- provider.Event) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var lastETag string
+	var polled bool
+	for {
+		etag, err := headObject(ctx, p, bucket, key)
+		if err != nil {
+			if ctx.Err() == nil {
+				sendEvent(ctx, events, provider.Event{Err: err})
+			}
+
+			return
+		}
+
+		if !polled || etag != lastETag {
+			if !sendEvent(ctx, events, provider.Event{ETag: etag}) {
+				return
+			}
+
+			lastETag = etag
+			polled = true
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sendEvent sends an event, returning false if ctx is done first.
+func sendEvent(ctx context.Context, events chan<- provider.Event, event provider.Event) bool {
+	select {
+	case events <- event:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,148 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package objsync_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dpeckett/objsync"
+	"github.com/dpeckett/objsync/provider"
+	"github.com/dpeckett/objsync/provider/s3"
+	"github.com/stretchr/testify/require"
+)
+
+// watchingProvider stands in for a provider with native change notifications,
+// by polling the ETag of watched objects.
+type watchingProvider struct {
+	provider.Provider
+	watches atomic.Int32
+}
+
+func (p *watchingProvider) WatchObject(ctx context.Context, bucket, key string) <-chan provider.Event {
+	p.watches.Add(1)
+
+	events := make(chan provider.Event, 1)
+
+	go func() {
+		defer close(events)
+
+		var lastETag string
+		for i := 0; ; i++ {
+			etag, err := p.Provider.(provider.Header).HeadObject(ctx, bucket, key)
+			if err != nil {
+				return
+			}
+
+			if i == 0 || etag != lastETag {
+				select {
+				case events <- provider.Event{ETag: etag}:
+				case <-ctx.Done():
+					return
+				}
+
+				lastETag = etag
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}()
+
+	return events
+}
+
+func TestWatch(t *testing.T) {
+	endpointURL := os.Getenv("AWS_ENDPOINT_URL_S3")
+	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	bucket := os.Getenv("BUCKET")
+
+	ctx := context.Background()
+	s3Provider, err := s3.NewProvider(ctx, endpointURL, "", accessKeyID, secretAccessKey)
+	require.NoError(t, err)
+
+	t.Run("Lock", func(t *testing.T) {
+		p := &watchingProvider{Provider: s3Provider}
+
+		key := fmt.Sprintf("test-%d.lock", time.Now().UnixNano())
+
+		mu := objsync.NewMutex(p, bucket, key)
+		_, err := mu.Lock(ctx, time.Minute)
+		require.NoError(t, err)
+
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			_ = mu.Unlock(ctx)
+		}()
+
+		// The waiter is woken up as soon as the lock is released.
+		lockCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+
+		other := objsync.NewMutex(p, bucket, key)
+		_, err = other.Lock(lockCtx, time.Minute)
+		require.NoError(t, err)
+		require.Equal(t, int32(1), p.watches.Load())
+
+		require.NoError(t, other.Unlock(ctx))
+	})
+
+	t.Run("LockTimeout", func(t *testing.T) {
+		p := &watchingProvider{Provider: s3Provider}
+
+		key := fmt.Sprintf("test-%d.lock", time.Now().UnixNano())
+
+		mu := objsync.NewMutex(p, bucket, key)
+		_, err := mu.Lock(ctx, time.Minute)
+		require.NoError(t, err)
+
+		_, err = objsync.NewMutex(p, bucket, key).LockWithTimeout(ctx, time.Minute, 200*time.Millisecond)
+		require.ErrorIs(t, err, objsync.ErrLockTimeout)
+
+		// The error describes the current holder.
+		var heldErr *objsync.LockHeldError
+		require.ErrorAs(t, err, &heldErr)
+		require.Equal(t, mu.ID(), heldErr.Owner)
+
+		require.NoError(t, mu.Unlock(ctx))
+	})
+
+	t.Run("Cond", func(t *testing.T) {
+		p := &watchingProvider{Provider: s3Provider}
+
+		key := fmt.Sprintf("test-%d.cond", time.Now().UnixNano())
+
+		// Polling is effectively disabled, so the waiter relies on the watch.
+		cond := objsync.NewCond(p, bucket, key, objsync.WithCondPollInterval(time.Hour))
+
+		version, err := cond.Version(ctx)
+		require.NoError(t, err)
+
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			_ = objsync.NewCond(p, bucket, key).Broadcast(ctx)
+		}()
+
+		waitCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+
+		newVersion, err := cond.WaitVersion(waitCtx, version)
+		require.NoError(t, err)
+		require.Greater(t, newVersion, version)
+	})
+}